@@ -388,6 +388,21 @@ func (zc *debugZipContext) collectPerNodeData(
 		return err
 	}
 
+	// Collect the recent scheduler latency results, as served by the sampler.
+	var schedulerLatencyData []byte
+	s = nodePrinter.start("requesting scheduler latency")
+	requestErr = zc.runZipFn(ctx, s,
+		func(ctx context.Context) error {
+			resp, err := zc.status.SchedulerLatency(ctx, &serverpb.SchedulerLatencyRequest{NodeId: id})
+			if err == nil {
+				schedulerLatencyData = resp.Data
+			}
+			return err
+		})
+	if err := zc.z.createRawOrError(s, prefix+"/schedulerlatency.json", schedulerLatencyData, requestErr); err != nil {
+		return err
+	}
+
 	// Collect all relevant heap profiles.
 	if err := zc.collectFileList(ctx, nodePrinter, id, prefix, serverpb.FileType_HEAP); err != nil {
 		return err
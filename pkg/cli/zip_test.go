@@ -15,6 +15,7 @@ import (
 	"bytes"
 	"context"
 	enc_hex "encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
@@ -49,6 +50,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency"
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/assert"
@@ -1049,6 +1051,43 @@ func TestToHex(t *testing.T) {
 	}
 }
 
+// TestZipSchedulerLatency verifies that debug zip includes the recent
+// scheduler latency results of every node.
+func TestZipSchedulerLatency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	dir, cleanupFn := testutils.TempDir(t)
+	defer cleanupFn()
+	c := NewCLITest(TestCLIParams{
+		StoreSpecs: []base.StoreSpec{{
+			Path: dir,
+		}},
+	})
+	defer c.Cleanup()
+
+	_, err := c.RunWithCapture("debug zip --concurrency=1 --cpu-profile-duration=0 " + dir + "/debug.zip")
+	require.NoError(t, err)
+
+	r, err := zip.OpenReader(dir + "/debug.zip")
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+
+	var found bool
+	for _, f := range r.File {
+		if f.Name != "debug/nodes/1/schedulerlatency.json" {
+			continue
+		}
+		found = true
+		rc, err := f.Open()
+		require.NoError(t, err)
+		defer rc.Close()
+		var dump schedulerlatency.DebugDump
+		require.NoError(t, json.NewDecoder(rc).Decode(&dump))
+	}
+	require.True(t, found, "schedulerlatency.json not found in debug zip")
+}
+
 func TestNodeRangeSelection(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -19,9 +19,11 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/server/debug"
 	"github.com/cockroachdb/cockroach/pkg/server/srvtestutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
 )
 
 // debugURL returns the root debug URL.
@@ -83,6 +85,85 @@ func TestAdminDebugMetrics(t *testing.T) {
 	}
 }
 
+// TestAdminDebugSchedulerLatency verifies that the most recent scheduler
+// latency histogram is available via the /debug/scheduler_latency link.
+func TestAdminDebugSchedulerLatency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	s := serverutils.StartServerOnly(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	ts := s.ApplicationLayer()
+
+	testutils.SucceedsSoon(t, func() error {
+		// The endpoint is unavailable until the sampler has observed a full
+		// window.
+		jI, err := srvtestutils.GetJSON(ts, debugURL(ts, "scheduler_latency").String())
+		if err != nil {
+			return err
+		}
+		j := jI.(map[string]interface{})
+		for _, key := range []string{"window_nanos", "elapsed_nanos"} {
+			if v, ok := j[key].(float64); !ok || v <= 0 {
+				t.Errorf("expected positive %s in JSON response, found %v", key, j[key])
+			}
+		}
+		if _, ok := j["at"].(string); !ok {
+			t.Errorf("at not found in JSON response")
+		}
+		percentiles, ok := j["percentiles"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("percentiles not found in JSON response")
+		}
+		for _, key := range []string{"p50_nanos", "p90_nanos", "p99_nanos", "p999_nanos"} {
+			if _, ok := percentiles[key].(float64); !ok {
+				t.Errorf("%s not found in JSON response", key)
+			}
+		}
+		buckets, ok := j["buckets"].([]interface{})
+		if !ok {
+			t.Fatalf("buckets not found in JSON response")
+		}
+		for _, bI := range buckets {
+			b := bI.(map[string]interface{})
+			if c, ok := b["count"].(float64); !ok || c <= 0 {
+				t.Errorf("expected positive count in bucket %v", b)
+			}
+			if _, ok := b["lower_nanos"]; !ok {
+				if _, ok := b["upper_nanos"]; !ok {
+					t.Errorf("expected a bounded bucket, found %v", b)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// TestAdminDebugSchedulerLatencyText verifies that the most recent scheduler
+// latency histogram is available, rendered as text, via the
+// /debug/scheduler_latency/text link.
+func TestAdminDebugSchedulerLatencyText(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	s := serverutils.StartServerOnly(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+
+	ts := s.ApplicationLayer()
+
+	testutils.SucceedsSoon(t, func() error {
+		body, err := srvtestutils.GetText(ts, debugURL(ts, "scheduler_latency/text?width=20").String())
+		if err != nil {
+			return err
+		}
+		// The endpoint is unavailable until the sampler has observed a full
+		// window.
+		if exp := "scheduler latency: "; !bytes.HasPrefix(body, []byte(exp)) {
+			return errors.Newf("expected %s to start with %s", body, exp)
+		}
+		return nil
+	})
+}
+
 // TestAdminDebugPprof verifies that pprof tools are available.
 // via the /debug/pprof/* links.
 func TestAdminDebugPprof(t *testing.T) {
@@ -32,6 +32,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble"
@@ -116,6 +117,13 @@ func setupProcessWideRoutes(
 	// Register the stopper endpoint, which lists all active tasks.
 	mux.HandleFunc("/debug/stopper", authzFunc(stop.HandleDebug))
 
+	// Register the scheduler latency endpoints, which serve the most recent
+	// scheduler latency histogram, as JSON or rendered as text, and the bucket
+	// counts retained over the last hour for the DB console's heatmap.
+	mux.HandleFunc("/debug/scheduler_latency", authzFunc(schedulerlatency.HandleDebug))
+	mux.HandleFunc("/debug/scheduler_latency/text", authzFunc(schedulerlatency.HandleDebugText))
+	mux.HandleFunc("/debug/scheduler_latency/heatmap", authzFunc(schedulerlatency.HandleHeatmap))
+
 	// Set up the vmodule endpoint.
 	mux.HandleFunc("/debug/vmodule", authzFunc(vsrv.vmoduleHandleDebug))
 
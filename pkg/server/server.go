@@ -1803,8 +1803,12 @@ func (s *topLevelServer) PreStart(ctx context.Context) error {
 	// Start measuring the Go scheduler latency.
 	if err := schedulerlatency.StartSampler(
 		workersCtx, s.st, s.stopper, s.sysRegistry, base.DefaultMetricsSampleInterval,
-		// Wire up admission control's scheduler latency listener.
-		s.node.storeCfg.SchedulerLatencyListener,
+		// Wire up admission control's scheduler latency listener, ahead of
+		// any other.
+		schedulerlatency.WithPriority(
+			s.node.storeCfg.SchedulerLatencyListener, schedulerlatency.HighestPriority,
+		),
+		timeutil.DefaultTimeSource{},
 	); err != nil {
 		return err
 	}
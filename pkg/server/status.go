@@ -1532,6 +1532,36 @@ func (s *statusServer) Stacks(
 	return stacksLocal(req)
 }
 
+// SchedulerLatency returns the recent scheduler latency results, and the most
+// recent interval histogram, as JSON.
+func (s *statusServer) SchedulerLatency(
+	ctx context.Context, req *serverpb.SchedulerLatencyRequest,
+) (*serverpb.JSONResponse, error) {
+	ctx = authserver.ForwardSQLIdentityThroughRPCCalls(ctx)
+	ctx = s.AnnotateCtx(ctx)
+
+	if err := s.privilegeChecker.RequireViewClusterMetadataPermission(ctx); err != nil {
+		// NB: not using srverrors.ServerError() here since the priv checker
+		// already returns a proper gRPC error status.
+		return nil, err
+	}
+
+	nodeID, local, err := s.parseNodeID(req.NodeId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	if !local {
+		status, err := s.dialNode(ctx, nodeID)
+		if err != nil {
+			return nil, srverrors.ServerError(ctx, err)
+		}
+		return status.SchedulerLatency(ctx, req)
+	}
+
+	return schedulerLatencyLocal()
+}
+
 func (s *statusServer) processRawGoroutines(
 	_ context.Context, response profDataResponse,
 ) ([]byte, error) {
@@ -13,6 +13,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -27,6 +28,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/server/srverrors"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/util/allstacks"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -124,6 +126,21 @@ func stacksLocal(req *serverpb.StacksRequest) (*serverpb.JSONResponse, error) {
 	return &serverpb.JSONResponse{Data: buf.Bytes()}, nil
 }
 
+// schedulerLatencyLocal retrieves the recent scheduler latency results, and the
+// most recent interval histogram, of the local node, as JSON. This method
+// returns a gRPC error to the caller.
+func schedulerLatencyLocal() (*serverpb.JSONResponse, error) {
+	dump, ok := schedulerlatency.Dump()
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "scheduler latency sampler is not running")
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode scheduler latency results: %s", err)
+	}
+	return &serverpb.JSONResponse{Data: data}, nil
+}
+
 // getLocalFiles retrieves the requested files for the local node. This method
 // returns a gRPC error to the caller.
 func getLocalFiles(
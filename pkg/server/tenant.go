@@ -687,6 +687,7 @@ func (s *SQLServerWrapper) PreStart(ctx context.Context) error {
 	if err := schedulerlatency.StartSampler(
 		workersCtx, s.sqlServer.cfg.Settings, s.stopper, s.sysRegistry, base.DefaultMetricsSampleInterval,
 		nil, /* listener */
+		timeutil.DefaultTimeSource{},
 	); err != nil {
 		return err
 	}
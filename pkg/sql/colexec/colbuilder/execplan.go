@@ -340,13 +340,21 @@ func (r opResult) createDiskBackedSort(
 		// The input is already fully ordered, so there is nothing to sort.
 		return input
 	}
+	opName := opNamePrefix + "sort-all"
+	if limit != 0 {
+		opName = opNamePrefix + "topk-sort"
+	} else if matchLen > 0 {
+		opName = opNamePrefix + "sort-chunks"
+	}
 	totalMemLimit := execinfra.GetWorkMemLimit(flowCtx)
 	spoolMemLimit := totalMemLimit * 4 / 5
 	maxOutputBatchMemSize := totalMemLimit - spoolMemLimit
-	if totalMemLimit == 1 {
-		// If total memory limit is 1, we're likely in a "force disk spill"
-		// scenario, so we'll set all internal limits to 1 too (if we don't,
-		// they will end up as 0 which is treated as "no limit").
+	if totalMemLimit == 1 || execinfra.ForceDiskSpill(flowCtx, string(opName)) {
+		// If total memory limit is 1, or if disk spilling is forced for the
+		// sorter, we'll set all internal limits to 1 too (if we don't, they
+		// will end up as 0 which is treated as "no limit", or as derived from
+		// the working memory limit if ForceDiskSpill is restricted to some
+		// operators).
 		spoolMemLimit = 1
 		maxOutputBatchMemSize = 1
 	}
@@ -354,7 +362,6 @@ func (r opResult) createDiskBackedSort(
 		// There is a limit specified, so we know exactly how many rows the
 		// sorter should output. Use a top K sorter, which uses a heap to avoid
 		// storing more rows than necessary.
-		opName := opNamePrefix + "topk-sort"
 		var topKSorterMemAccount *mon.BoundAccount
 		topKSorterMemAccount, sorterMemMonitorName = args.MonitorRegistry.CreateMemAccountForSpillStrategyWithLimit(
 			ctx, flowCtx, spoolMemLimit, opName, processorID,
@@ -369,7 +376,6 @@ func (r opResult) createDiskBackedSort(
 	} else if matchLen > 0 {
 		// The input is already partially ordered. Use a chunks sorter to avoid
 		// loading all the rows into memory.
-		opName := opNamePrefix + "sort-chunks"
 		accounts := args.MonitorRegistry.CreateUnlimitedMemAccounts(
 			ctx, flowCtx, opName, processorID, 2, /* numAccounts */
 		)
@@ -386,7 +392,6 @@ func (r opResult) createDiskBackedSort(
 	} else {
 		// No optimizations possible. Default to the standard sort operator.
 		var sorterMemAccount *mon.BoundAccount
-		opName := opNamePrefix + "sort-all"
 		sorterMemAccount, sorterMemMonitorName = args.MonitorRegistry.CreateMemAccountForSpillStrategyWithLimit(
 			ctx, flowCtx, spoolMemLimit, opName, processorID,
 		)
@@ -673,6 +678,7 @@ func makeNewHashAggregatorArgs(
 	*colexecagg.NewHashAggregatorArgs,
 	*colexecutils.NewSpillingQueueArgs,
 	redact.RedactableString,
+	error,
 ) {
 	// We will divide the available memory equally between the two usages - the
 	// hash aggregation itself and the input tuples tracking.
@@ -681,10 +687,12 @@ func makeNewHashAggregatorArgs(
 	maxOutputBatchMemSize := totalMemLimit / 10
 	hashAggregationMemLimit := totalMemLimit/2 - maxOutputBatchMemSize
 	inputTuplesTrackingMemLimit := totalMemLimit / 2
-	if totalMemLimit == 1 {
-		// If total memory limit is 1, we're likely in a "force disk spill"
-		// scenario, so we'll set all internal limits to 1 too (if we don't,
-		// they will end up as 0 which is treated as "no limit").
+	if totalMemLimit == 1 || execinfra.ForceDiskSpill(flowCtx, string(opName)) {
+		// If total memory limit is 1, or if disk spilling is forced for the
+		// hash aggregator, we'll set all internal limits to 1 too (if we
+		// don't, they will end up as 0 which is treated as "no limit", or as
+		// derived from the working memory limit if ForceDiskSpill is
+		// restricted to some operators).
 		maxOutputBatchMemSize = 1
 		hashAggregationMemLimit = 1
 		inputTuplesTrackingMemLimit = 1
@@ -692,9 +700,12 @@ func makeNewHashAggregatorArgs(
 	hashAggregatorMemAccount, hashAggregatorMemMonitorName := args.MonitorRegistry.CreateMemAccountForSpillStrategyWithLimit(
 		ctx, flowCtx, hashAggregationMemLimit, opName, args.Spec.ProcessorID,
 	)
-	hashTableMemAccount := args.MonitorRegistry.CreateExtraMemAccountForSpillStrategy(
-		string(hashAggregatorMemMonitorName),
+	hashTableMemAccount, err := args.MonitorRegistry.CreateExtraMemAccountForSpillStrategy(
+		hashAggregatorMemMonitorName,
 	)
+	if err != nil {
+		return nil, nil, "", err
+	}
 	// We need to create five unlimited memory accounts so that each component
 	// could track precisely its own usage. The components are
 	// - the hash aggregator
@@ -722,7 +733,8 @@ func makeNewHashAggregatorArgs(
 			),
 			DiskQueueMemAcc: accounts[4],
 		},
-		hashAggregatorMemMonitorName
+		hashAggregatorMemMonitorName,
+		nil
 }
 
 // NewColOperator creates a new columnar operator according to the given spec.
@@ -1019,9 +1031,11 @@ func NewColOperator(
 					)
 					result.ToClose = append(result.ToClose, result.Root.(colexecop.Closer))
 				} else {
-					newHashAggArgs, sqArgs, hashAggregatorMemMonitorName := makeNewHashAggregatorArgs(
-						ctx, flowCtx, args, opName, newAggArgs, factory,
-					)
+					newHashAggArgs, sqArgs, hashAggregatorMemMonitorName, err :=
+						makeNewHashAggregatorArgs(ctx, flowCtx, args, opName, newAggArgs, factory)
+					if err != nil {
+						return r, err
+					}
 					sqArgs.Types = spec.Input[0].ColumnTypes
 					inMemoryHashAggregator := colexec.NewHashAggregator(
 						ctx, newHashAggArgs, sqArgs,
@@ -1325,9 +1339,12 @@ func NewColOperator(
 			// and another half will be used for tracking the input tuples from
 			// the left input (which is needed to spill to disk when the memory
 			// limit is reached during the aggregation).
-			newHashAggArgs, sqArgs, hashAggregatorMemMonitorName := makeNewHashAggregatorArgs(
+			newHashAggArgs, sqArgs, hashAggregatorMemMonitorName, err := makeNewHashAggregatorArgs(
 				ctx, flowCtx, args, opName, newAggArgs, factory,
 			)
+			if err != nil {
+				return r, err
+			}
 			// Spilling queue is needed for the left input to the hash
 			// group-join.
 			sqArgs.Types = args.Spec.Input[0].ColumnTypes
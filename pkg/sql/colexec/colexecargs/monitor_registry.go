@@ -12,69 +12,698 @@ package colexecargs
 
 import (
 	"context"
+	"math"
+	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/sql/colexecerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra/execreleasable"
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/redact"
 )
 
+// operatorDiskLimit is the default limit of the disk monitors created by
+// CreateDiskAccountWithLimit.
+var operatorDiskLimit = settings.RegisterByteSizeSetting(
+	settings.ApplicationLevel,
+	"sql.distsql.temp_storage.operator_disk_limit",
+	"maximum amount of temporary disk storage in bytes a single operator can use "+
+		"if it's subject to a disk quota",
+	8<<30, /* 8 GiB */
+	settings.PositiveInt,
+)
+
 // MonitorRegistry instantiates and keeps track of the memory monitoring
 // infrastructure in the vectorized engine.
+//
+// It is safe for concurrent use, so that the operator chains of different
+// processors can be planned concurrently. The zero value is ready for use.
 type MonitorRegistry struct {
-	accounts []*mon.BoundAccount
-	monitors []*mon.BytesMonitor
+	mu struct {
+		syncutil.Mutex
+		accounts []*mon.BoundAccount
+		// accountMonitors tracks, index for index with accounts, the monitors
+		// the accounts are bound to, nil for those not created by the
+		// registry.
+		accountMonitors []*mon.BytesMonitor
+		monitors        []*mon.BytesMonitor
+		// byName indexes monitors by name. If several monitors share a name
+		// (which AssertInvariants disallows among the open monitors, although
+		// a closed monitor's name can be reused, see
+		// CreateUnlimitedMemAccountsWithName), the most recently registered
+		// one is indexed.
+		byName map[redact.RedactableString]*mon.BytesMonitor
+		// info describes the monitors, index for index.
+		info []monitorInfo
+		// numRegisteredMonitors is the number of monitors registered since the
+		// last Reset, including those detached, which the generated monitor
+		// names rely on for their uniqueness.
+		numRegisteredMonitors int
+		// deterministicNaming, if set, makes the generated monitor names rely
+		// on nameOrdinals rather than on numRegisteredMonitors for their
+		// uniqueness.
+		deterministicNaming bool
+		// nameOrdinals tracks, in the deterministic naming mode, the number of
+		// monitors named after each prefix (the operator name and the
+		// processor ID, if any) since the last Reset, including those
+		// detached.
+		nameOrdinals map[string]int
+		// nameBuf is reused to generate the monitor names.
+		nameBuf []byte
+		// numClosedAccounts and numClosedMonitors track the prefixes of
+		// accounts and monitors that have already been closed by Close, so
+		// that the components registered concurrently with (or after) Close
+		// can be closed by a subsequent call.
+		numClosedAccounts int
+		numClosedMonitors int
+		// strictLeakCheck, if set, makes Close check that the accounts have
+		// been released. It can only be set in test builds.
+		strictLeakCheck bool
+		// onBudgetExceeded, if set, is installed into the limited monitors
+		// created.
+		onBudgetExceeded func(monitorName redact.RedactableString, requested, limit int64)
+		// unlimitedAggregateLimit, if positive, is the limit of
+		// unlimitedAggregate.
+		unlimitedAggregateLimit int64
+		// unlimitedAggregate, if set, is the parent of the unlimited monitors
+		// created since SetUnlimitedAggregateLimit was called. It's created with
+		// the first of them, and isn't registered along with the other
+		// monitors, so that it's stopped after them.
+		unlimitedAggregate *mon.BytesMonitor
+		// memGauge and diskGauge, if set, reflect the bytes allocated by the
+		// memory and the disk monitors, respectively; the contribution of the
+		// registry to them is the sum of monitorInfo.reported of the monitors
+		// not yet closed.
+		memGauge, diskGauge *metric.Gauge
+		// reclaimable tracks, for the accounts registered with
+		// MarkReclaimable, the bytes of their usage that their operators no
+		// longer reference.
+		reclaimable map[*mon.BoundAccount]int64
+	}
+	// lastMetricsUpdate is the time, in nanoseconds since the epoch, of the
+	// last update of the gauges by MaybeUpdateMetrics.
+	lastMetricsUpdate atomic.Int64
+}
+
+// SetOnBudgetExceeded sets the callback invoked when an allocation is denied
+// because of the limit of one of the limited monitors created by the registry
+// afterwards (the monitors for spill strategies, with a strict limit, and with
+// a disk quota), at most once per monitor, so that the disk spillers retrying
+// don't invoke it repeatedly. The limits of 1 byte due to ForceDiskSpill don't
+// invoke it. The callback is invoked with the monitor's mutex held, so it must
+// not call into the monitor. It's unset by Reset.
+func (r *MonitorRegistry) SetOnBudgetExceeded(
+	fn func(monitorName redact.RedactableString, requested, limit int64),
+) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.onBudgetExceeded = fn
+}
+
+// SetDeterministicNaming makes the names of the monitors created afterwards
+// independent of the order in which the operators are planned: rather than the
+// number of monitors registered with the registry, the names end with the
+// number of monitors previously named after the same operator and processor
+// ID. This allows the monitors of a query to be correlated across retries, or
+// across the two sides of a mirrored execution, e.g. when diffing debug output.
+// It must be called before any monitor is created, and it's unset by Reset.
+func (r *MonitorRegistry) SetDeterministicNaming() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mu.numRegisteredMonitors > 0 {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"deterministic naming set after %d monitors were created", r.mu.numRegisteredMonitors,
+		))
+	}
+	r.mu.deterministicNaming = true
+	if r.mu.nameOrdinals == nil {
+		r.mu.nameOrdinals = make(map[string]int)
+	}
+}
+
+// SetUsageGauges sets the gauges to reflect the bytes allocated, in total, by
+// the memory and the disk monitors, respectively, created by the registry. The
+// gauges are shared by the registries of all the flows on the node, each
+// registry adding its contribution to them. They are updated by UpdateMetrics
+// (see MaybeUpdateMetrics), and the contribution of the monitors is removed
+// when they are closed. It must be called before any component is created, and
+// it's unset by Reset.
+func (r *MonitorRegistry) SetUsageGauges(memGauge, diskGauge *metric.Gauge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.memGauge, r.mu.diskGauge = memGauge, diskGauge
+}
+
+// UpdateMetrics updates the gauges set by SetUsageGauges, if any, with the
+// current usage of the monitors not yet closed. It scans all of them, so it's
+// meant to be called on a coarse cadence, see MaybeUpdateMetrics.
+func (r *MonitorRegistry) UpdateMetrics() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updateMetricsLocked(r.mu.numClosedMonitors, len(r.mu.monitors))
+}
+
+// metricsUpdateInterval is the minimum interval between the updates of the
+// gauges by MaybeUpdateMetrics.
+const metricsUpdateInterval = time.Second
+
+// MaybeUpdateMetrics calls UpdateMetrics unless the gauges have been updated by
+// MaybeUpdateMetrics within the last metricsUpdateInterval. It's cheap
+// otherwise, so it's meant to be called by the roots of the flow at batch
+// boundaries.
+func (r *MonitorRegistry) MaybeUpdateMetrics() {
+	now := timeutil.Now().UnixNano()
+	last := r.lastMetricsUpdate.Load()
+	if now-last < int64(metricsUpdateInterval) {
+		return
+	}
+	// Only one of the roots calling concurrently updates the gauges.
+	if r.lastMetricsUpdate.CompareAndSwap(last, now) {
+		r.UpdateMetrics()
+	}
+}
+
+// updateMetricsLocked updates the gauges set by SetUsageGauges, if any, by the
+// change of the usage of the monitors in [start, end) since it was last
+// reported.
+func (r *MonitorRegistry) updateMetricsLocked(start, end int) {
+	if r.mu.memGauge == nil && r.mu.diskGauge == nil {
+		return
+	}
+	var memDelta, diskDelta int64
+	for i := start; i < end; i++ {
+		used := r.mu.monitors[i].AllocBytes()
+		if r.mu.info[i].kind == DiskMonitor {
+			diskDelta += used - r.mu.info[i].reported
+		} else {
+			memDelta += used - r.mu.info[i].reported
+		}
+		r.mu.info[i].reported = used
+	}
+	r.incGaugesLocked(memDelta, diskDelta)
+}
+
+// releaseMetricsLocked removes from the gauges set by SetUsageGauges, if any,
+// the usage reported for the monitors in [start, end), which are being closed
+// or detached.
+func (r *MonitorRegistry) releaseMetricsLocked(start, end int) {
+	var memDelta, diskDelta int64
+	for i := start; i < end; i++ {
+		if r.mu.info[i].kind == DiskMonitor {
+			diskDelta -= r.mu.info[i].reported
+		} else {
+			memDelta -= r.mu.info[i].reported
+		}
+		r.mu.info[i].reported = 0
+	}
+	r.incGaugesLocked(memDelta, diskDelta)
+}
+
+// incGaugesLocked adds the given deltas to the gauges set by SetUsageGauges, if
+// any.
+func (r *MonitorRegistry) incGaugesLocked(memDelta, diskDelta int64) {
+	if r.mu.memGauge != nil && memDelta != 0 {
+		r.mu.memGauge.Inc(memDelta)
+	}
+	if r.mu.diskGauge != nil && diskDelta != 0 {
+		r.mu.diskGauge.Inc(diskDelta)
+	}
+}
+
+// SetUnlimitedAggregateLimit caps the memory allocated, in total, by the
+// unlimited monitors (see CreateUnlimitedMemAccounts) created by the registry
+// afterwards: they are created as children of a single monitor (named after the
+// flow) with the given limit, created with the first of them, rather than of
+// flowCtx.Mon. A non-positive limit disables the cap for the monitors created
+// afterwards. It must not be called again once the monitor with the limit has
+// been created (until Close). It's unset by Reset.
+func (r *MonitorRegistry) SetUnlimitedAggregateLimit(limit int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mu.unlimitedAggregate != nil {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"aggregate limit of the unlimited monitors set once they were created",
+		))
+	}
+	r.mu.unlimitedAggregateLimit = limit
+}
+
+// unlimitedParentLocked returns the parent of an unlimited monitor to be
+// created, creating the aggregate monitor if the aggregate limit is set.
+func (r *MonitorRegistry) unlimitedParentLocked(
+	ctx context.Context, flowCtx *execinfra.FlowCtx,
+) *mon.BytesMonitor {
+	if r.mu.unlimitedAggregateLimit <= 0 {
+		return flowCtx.Mon
+	}
+	if r.mu.unlimitedAggregate == nil {
+		name := "flow " + redact.RedactableString(flowCtx.ID.Short()) + "-unlimited"
+		r.mu.unlimitedAggregate = mon.NewMonitorInheritWithLimit(
+			name, r.mu.unlimitedAggregateLimit, flowCtx.Mon, false, /* longLiving */
+		)
+		r.mu.unlimitedAggregate.StartNoReserved(ctx, flowCtx.Mon)
+	}
+	return r.mu.unlimitedAggregate
 }
 
-// GetMonitors returns all the monitors from the registry.
+// watchLimitLocked installs the callback set by SetOnBudgetExceeded, if any,
+// into the limited monitor with the given name.
+func (r *MonitorRegistry) watchLimitLocked(name redact.RedactableString, m *mon.BytesMonitor) {
+	fn := r.mu.onBudgetExceeded
+	if fn == nil {
+		return
+	}
+	// fired is protected by the monitor's mutex, held throughout the callback.
+	var fired bool
+	m.SetOnLimitExceeded(func(requested, limit int64) {
+		if !fired {
+			fired = true
+			fn(name, requested, limit)
+		}
+	})
+}
+
+// EnableStrictLeakCheck makes Close check that all the accounts have been
+// released before closing them, and report an assertion failure naming those
+// that haven't been. It's a no-op outside of test builds. It's reset by
+// Reset.
+func (r *MonitorRegistry) EnableStrictLeakCheck() {
+	if !buildutil.CrdbTestBuild {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.strictLeakCheck = true
+}
+
+// GetMonitors returns all the monitors from the registry. The returned slice
+// must not be modified, and it is only valid until Reset is called.
 func (r *MonitorRegistry) GetMonitors() []*mon.BytesMonitor {
-	return r.monitors
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.monitors[:len(r.mu.monitors):len(r.mu.monitors)]
 }
 
 // NewStreamingMemAccount creates a new memory account bound to the monitor in
 // flowCtx.
 func (r *MonitorRegistry) NewStreamingMemAccount(flowCtx *execinfra.FlowCtx) *mon.BoundAccount {
 	streamingMemAccount := flowCtx.Mon.MakeBoundAccount()
-	r.accounts = append(r.accounts, &streamingMemAccount)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addAccountLocked(&streamingMemAccount, nil /* monitor */)
 	return &streamingMemAccount
 }
 
-// getMemMonitorName returns a unique (for this MonitorRegistry) memory monitor
-// name.
-func (r *MonitorRegistry) getMemMonitorName(
+// addAccountLocked registers the account, bound to the given monitor created by
+// the registry (nil if it's bound to another one).
+func (r *MonitorRegistry) addAccountLocked(acc *mon.BoundAccount, monitor *mon.BytesMonitor) {
+	r.mu.accounts = append(r.mu.accounts, acc)
+	r.mu.accountMonitors = append(r.mu.accountMonitors, monitor)
+}
+
+// MonitorKind is the kind of a monitor created by MonitorRegistry.
+type MonitorKind int
+
+const (
+	// LimitedMemoryMonitor is a memory monitor with a limit, for a spill
+	// strategy or a strict one.
+	LimitedMemoryMonitor MonitorKind = iota
+	// UnlimitedMemoryMonitor is a memory monitor without a limit of its own.
+	UnlimitedMemoryMonitor
+	// DiskMonitor is a disk monitor, with or without a limit.
+	DiskMonitor
+)
+
+// MonitorMetadata describes a monitor created by MonitorRegistry, so that
+// the consumers don't have to parse its name.
+type MonitorMetadata struct {
+	// OpName and ProcessorID identify the operator the monitor was created
+	// for. They're unset for the monitors whose names were provided by the
+	// caller (CreateUnlimitedMemAccountsWithName, CreateUnlimitedMemAccountsWithParent,
+	// and CreateDiskAccounts).
+	OpName      redact.RedactableString
+	ProcessorID int32
+	Kind        MonitorKind
+	// CreationIndex is the number of monitors registered with the registry
+	// that created the monitor before it. It orders the monitors by creation
+	// in either naming mode, whereas the generated names only end with it
+	// unless SetDeterministicNaming is used.
+	CreationIndex int
+}
+
+// operator identifies the operator a monitor was created for.
+type operator struct {
+	opName      redact.RedactableString
+	processorID int32
+}
+
+// monitorInfo describes a monitor created by the registry.
+type monitorInfo struct {
+	// parent is the name of the parent of the monitor.
+	parent redact.RedactableString
+	// parentMon is the parent of the monitor, and flowParent the monitor of
+	// the flow it's expected to be created under: flowCtx.DiskMonitor for the
+	// disk monitors and flowCtx.Mon for the memory ones, unless the unlimited
+	// ones are created under the aggregate monitor (or the parent given to
+	// CreateUnlimitedMemAccountsWithParent), recorded at their creation.
+	parentMon, flowParent *mon.BytesMonitor
+	// spill is set for the monitors created for spill strategies.
+	spill bool
+	kind  MonitorKind
+	// creationIndex is the number of monitors registered before the monitor
+	// by the registry that created it.
+	creationIndex int
+	// op is the operator the monitor was created for, if any (the zero value
+	// if the name of the monitor was provided by the caller).
+	op operator
+	// peak is the high-water mark of the bytes allocated by the monitor,
+	// captured by Close before stopping it.
+	peak int64
+	// reported is the usage of the monitor last added to the gauges set by
+	// SetUsageGauges, if any.
+	reported int64
+}
+
+// addMonitorLocked registers the monitor of the given kind with the given name
+// and parent, created for the given operator. If the
+// InjectMonitorAllocationFailure testing knob selects the monitor, the growth
+// of its accounts is failed accordingly (see injectAllocationFailure).
+func (r *MonitorRegistry) addMonitorLocked(
+	flowCtx *execinfra.FlowCtx,
+	name redact.RedactableString,
+	m *mon.BytesMonitor,
+	parent *mon.BytesMonitor,
+	kind MonitorKind,
+	op operator,
+) {
+	if cfg := flowCtx.Cfg; cfg != nil && cfg.TestingKnobs.InjectMonitorAllocationFailure != nil {
+		knob := cfg.TestingKnobs.InjectMonitorAllocationFailure
+		if knob.MonitorNameRegexp.MatchString(string(name)) {
+			injectAllocationFailure(m, knob.AfterNGrows)
+		}
+	}
+	flowParent := flowCtx.Mon
+	if kind == DiskMonitor {
+		flowParent = flowCtx.DiskMonitor
+	}
+	r.registerMonitorLocked(name, m, monitorInfo{
+		parent:        redact.RedactableString(parent.Name()),
+		parentMon:     parent,
+		flowParent:    flowParent,
+		kind:          kind,
+		creationIndex: r.mu.numRegisteredMonitors,
+		op:            op,
+	})
+}
+
+// injectAllocationFailure makes the growth of an account bound to the given
+// monitor that follows afterNGrows successful ones (across all the accounts)
+// fail with a budget exceeded error attributed to the monitor. The accounts
+// are handed out by the registry as they are, so the growths are intercepted
+// on their way to the monitor: it's made to be reached by every growth, and to
+// deny the one selected.
+func injectAllocationFailure(m *mon.BytesMonitor, afterNGrows int) {
+	// The denial callback is serialized by the monitor's mutex.
+	var grows int
+	m.TestingDenyReservations(func(int64) bool {
+		grows++
+		return grows == afterNGrows+1
+	})
+}
+
+// registerMonitorLocked registers the monitor with the given name and
+// description.
+func (r *MonitorRegistry) registerMonitorLocked(
+	name redact.RedactableString, m *mon.BytesMonitor, info monitorInfo,
+) {
+	r.mu.monitors = append(r.mu.monitors, m)
+	r.mu.info = append(r.mu.info, info)
+	r.mu.numRegisteredMonitors++
+	if r.mu.byName == nil {
+		r.mu.byName = make(map[redact.RedactableString]*mon.BytesMonitor)
+	}
+	r.mu.byName[name] = m
+}
+
+// isClosedLocked returns whether the given registered monitor has already been
+// closed by Close or CloseForProcessor.
+func (r *MonitorRegistry) isClosedLocked(m *mon.BytesMonitor) bool {
+	for _, closed := range r.mu.monitors[:r.mu.numClosedMonitors] {
+		if closed == m {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMonitorByName returns the monitor with the given name that was created by
+// the registry, or nil if there's none.
+func (r *MonitorRegistry) GetMonitorByName(name redact.RedactableString) *mon.BytesMonitor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.byName[name]
+}
+
+// Metadata returns the metadata of the given monitor, or false if it wasn't
+// created by the registry (or has been detached). The metadata survive Close,
+// until Reset.
+func (r *MonitorRegistry) Metadata(m *mon.BytesMonitor) (MonitorMetadata, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, registered := range r.mu.monitors {
+		if registered == m {
+			info := r.mu.info[i]
+			return MonitorMetadata{
+				OpName:        info.op.opName,
+				ProcessorID:   info.op.processorID,
+				Kind:          info.kind,
+				CreationIndex: info.creationIndex,
+			}, true
+		}
+	}
+	return MonitorMetadata{}, false
+}
+
+// MonitorUsage describes the current usage of a monitor created by the
+// MonitorRegistry.
+type MonitorUsage struct {
+	Name redact.RedactableString
+	// Allocated is the number of bytes currently allocated by the accounts
+	// bound to the monitor.
+	Allocated int64
+	// Limit is the limit of the monitor, or zero if it's unlimited.
+	Limit int64
+	// Resource is the resource (memory or disk) tracked by the monitor.
+	Resource mon.Resource
+}
+
+// UsageSnapshot returns the current usage of every monitor created by the
+// registry, in the order they were created (except that those closed by
+// CloseForProcessor come before those still open). Only a single slice is allocated,
+// regardless of the number of accounts. It may be called after the accounts
+// (or the monitors) are closed, in which case the usage of the closed
+// monitors is zero.
+func (r *MonitorRegistry) UsageSnapshot() []MonitorUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage := make([]MonitorUsage, len(r.mu.monitors))
+	for i, m := range r.mu.monitors {
+		usage[i] = MonitorUsage{
+			Name:      redact.RedactableString(m.Name()),
+			Allocated: m.AllocBytes(),
+			Resource:  m.Resource(),
+		}
+		// Unlimited monitors are created with the maximum limit.
+		if limit := m.Limit(); limit != math.MaxInt64 {
+			usage[i].Limit = limit
+		}
+	}
+	return usage
+}
+
+// TotalAllocated returns the number of bytes currently allocated by the
+// memory monitors created by the registry. The disk monitors aren't included.
+func (r *MonitorRegistry) TotalAllocated() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total int64
+	for _, m := range r.mu.monitors {
+		if m.Resource() == mon.MemoryResource {
+			total += m.AllocBytes()
+		}
+	}
+	return total
+}
+
+// PeakUsage returns the high-water marks of the bytes allocated by the memory
+// and the disk monitors created for the operator with the given name and
+// processor ID, summed over the monitors. The monitors whose names were
+// provided by the caller (CreateUnlimitedMemAccountsWithName and
+// CreateDiskAccounts) aren't attributed to any operator. The peaks survive
+// Close, until Reset.
+func (r *MonitorRegistry) PeakUsage(
+	opName redact.RedactableString, processorID int32,
+) (memPeak, diskPeak int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op := operator{opName: opName, processorID: processorID}
+	for i, m := range r.mu.monitors {
+		if r.mu.info[i].op != op {
+			continue
+		}
+		peak := r.mu.info[i].peak
+		if i >= r.mu.numClosedMonitors {
+			peak = m.MaximumBytes()
+		}
+		if r.mu.info[i].kind == DiskMonitor {
+			diskPeak += peak
+		} else {
+			memPeak += peak
+		}
+	}
+	return memPeak, diskPeak
+}
+
+// getMemMonitorNameLocked returns a unique (for this MonitorRegistry) memory
+// monitor name. The uniqueness relies on the number of monitors registered (or,
+// in the deterministic naming mode, named after the same prefix), so r.mu must
+// be held until the monitor with the returned name is registered.
+func (r *MonitorRegistry) getMemMonitorNameLocked(
 	opName redact.RedactableString, processorID int32, suffix redact.RedactableString,
 ) redact.RedactableString {
-	return opName + "-" + redact.RedactableString(strconv.Itoa(int(processorID))) + "-" +
-		suffix + "-" + redact.RedactableString(strconv.Itoa(len(r.monitors)))
+	// The name is generated into a reused buffer so that only the final string
+	// is allocated.
+	buf := append(r.mu.nameBuf[:0], opName...)
+	buf = append(buf, '-')
+	buf = strconv.AppendInt(buf, int64(processorID), 10)
+	return r.finishMonitorNameLocked(buf, suffix)
+}
+
+// finishMonitorNameLocked appends the suffix and the number of monitors
+// registered (or, in the deterministic naming mode, the ordinal of the monitor
+// among those named after the same prefix) to the prefix of a monitor name in
+// buf, which must be based on r.mu.nameBuf, and returns the resulting name.
+func (r *MonitorRegistry) finishMonitorNameLocked(
+	buf []byte, suffix redact.RedactableString,
+) redact.RedactableString {
+	prefixLen := len(buf)
+	buf = append(buf, '-')
+	buf = append(buf, suffix...)
+	buf = append(buf, '-')
+	if !r.mu.deterministicNaming {
+		buf = strconv.AppendInt(buf, int64(r.mu.numRegisteredMonitors), 10)
+	} else {
+		prefix := string(buf[:prefixLen])
+		ordinal := r.mu.nameOrdinals[prefix]
+		// The ordinals taken over by Merge may collide with the names of the
+		// monitors of the other registry if it didn't use the same mode, so
+		// the names already registered are skipped.
+		for n := len(buf); ; ordinal++ {
+			buf = strconv.AppendInt(buf[:n], int64(ordinal), 10)
+			if _, ok := r.mu.byName[redact.RedactableString(buf)]; !ok {
+				break
+			}
+		}
+		r.mu.nameOrdinals[prefix] = ordinal + 1
+	}
+	r.mu.nameBuf = buf
+	return redact.RedactableString(buf)
 }
 
 // CreateMemAccountForSpillStrategy instantiates a memory monitor and a memory
 // account to be used with a buffering colexecop.Operator that can fall back to
 // disk. The default memory limit is used, if flowCtx.Cfg.ForceDiskSpill is
-// used, this will be 1. The receiver is updated to have references to both
-// objects. Memory monitor name is also returned.
+// used (and, if ForceDiskSpillOpNameRegexp is set, matches opName), this will
+// be 1. The monitor is only started (see mon.BytesMonitor.StartLazily) on the
+// first growth of the account, its name being reserved right away. The
+// receiver is updated to have references to both objects. Memory monitor name
+// is also returned.
 func (r *MonitorRegistry) CreateMemAccountForSpillStrategy(
 	ctx context.Context,
 	flowCtx *execinfra.FlowCtx,
 	opName redact.RedactableString,
 	processorID int32,
 ) (*mon.BoundAccount, redact.RedactableString) {
-	monitorName := r.getMemMonitorName(opName, processorID, "limited" /* suffix */)
-	bufferingOpMemMonitor := execinfra.NewLimitedMonitor(
-		ctx, flowCtx.Mon, flowCtx, monitorName,
+	accounts, monitorName := r.CreateMemAccountsForSpillStrategy(
+		ctx, flowCtx, opName, processorID, 1, /* numAccounts */
 	)
-	r.monitors = append(r.monitors, bufferingOpMemMonitor)
-	bufferingMemAccount := bufferingOpMemMonitor.MakeBoundAccount()
-	r.accounts = append(r.accounts, &bufferingMemAccount)
-	return &bufferingMemAccount, monitorName
+	return accounts[0], monitorName
+}
+
+// CreateMemAccountAndMonitorForSpillStrategy is the same as
+// CreateMemAccountForSpillStrategy except that the monitor is returned too, so
+// that the caller can create child monitors and accounts under it (e.g. for
+// separate accounting of a hash table), which count toward its limit. The
+// monitor remains owned by the registry, which stops it in Close (or
+// CloseForProcessor): the child monitors must be stopped, and the accounts
+// bound to the monitor by the caller closed, before that, which is asserted in
+// test builds.
+func (r *MonitorRegistry) CreateMemAccountAndMonitorForSpillStrategy(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	opName redact.RedactableString,
+	processorID int32,
+) (*mon.BoundAccount, *mon.BytesMonitor, redact.RedactableString) {
+	acc, monitorName := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, opName, processorID)
+	return acc, acc.Monitor(), monitorName
+}
+
+// CreateMemAccountsForSpillStrategy is similar to
+// CreateMemAccountForSpillStrategy with the only difference that a number of
+// memory accounts is bound to the monitor, so that the limit applies to their
+// sum. Memory monitor name is also returned.
+func (r *MonitorRegistry) CreateMemAccountsForSpillStrategy(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	opName redact.RedactableString,
+	processorID int32,
+	numAccounts int,
+) ([]*mon.BoundAccount, redact.RedactableString) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "limited" /* suffix */)
+	limit := execinfra.GetWorkMemLimit(flowCtx)
+	forceDiskSpill := execinfra.ForceDiskSpill(flowCtx, string(opName))
+	if forceDiskSpill {
+		// The working memory limit isn't forced to 1 if ForceDiskSpill is
+		// restricted to some operators.
+		limit = 1
+	}
+	bufferingOpMemMonitor := mon.NewMonitorInheritWithLimit(
+		monitorName, limit, flowCtx.Mon, false, /* longLiving */
+	)
+	// Many buffering operators never buffer anything (e.g. if their input is
+	// empty), so the monitor is only started once it's used.
+	bufferingOpMemMonitor.StartLazily(flowCtx.Mon)
+	if !forceDiskSpill {
+		r.watchLimitLocked(monitorName, bufferingOpMemMonitor)
+	}
+	op := operator{opName: opName, processorID: processorID}
+	r.addMonitorLocked(
+		flowCtx, monitorName, bufferingOpMemMonitor, flowCtx.Mon, LimitedMemoryMonitor, op,
+	)
+	r.mu.info[len(r.mu.info)-1].spill = true
+	return r.makeAccountsLocked(bufferingOpMemMonitor, numAccounts), monitorName
 }
 
 // CreateMemAccountForSpillStrategyWithLimit is the same as
 // CreateMemAccountForSpillStrategy except that it takes in a custom limit
-// instead of using the number obtained via execinfra.GetWorkMemLimit. Memory
-// monitor name is also returned.
+// instead of using the number obtained via execinfra.GetWorkMemLimit. If
+// ForceDiskSpill applies to opName, the limit must be 1, unless
+// ForceDiskSpillOpNameRegexp is set (the limit being derived from the working
+// memory limit, which isn't forced then), in which case it's overridden to 1.
+// Memory monitor name is also returned.
 func (r *MonitorRegistry) CreateMemAccountForSpillStrategyWithLimit(
 	ctx context.Context,
 	flowCtx *execinfra.FlowCtx,
@@ -82,46 +711,165 @@ func (r *MonitorRegistry) CreateMemAccountForSpillStrategyWithLimit(
 	opName redact.RedactableString,
 	processorID int32,
 ) (*mon.BoundAccount, redact.RedactableString) {
-	if flowCtx.Cfg.TestingKnobs.ForceDiskSpill {
-		if limit != 1 {
+	forceDiskSpill := execinfra.ForceDiskSpill(flowCtx, string(opName))
+	if forceDiskSpill {
+		if flowCtx.Cfg.TestingKnobs.ForceDiskSpillOpNameRegexp != nil {
+			limit = 1
+		} else if limit != 1 {
 			colexecerror.InternalError(errors.AssertionFailedf(
 				"expected limit of 1 when forcing disk spilling, got %d", limit,
 			))
 		}
 	}
-	monitorName := r.getMemMonitorName(opName, processorID, "limited" /* suffix */)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "limited" /* suffix */)
 	bufferingOpMemMonitor := mon.NewMonitorInheritWithLimit(monitorName, limit, flowCtx.Mon, false /* longLiving */)
 	bufferingOpMemMonitor.StartNoReserved(ctx, flowCtx.Mon)
-	r.monitors = append(r.monitors, bufferingOpMemMonitor)
+	if !forceDiskSpill {
+		r.watchLimitLocked(monitorName, bufferingOpMemMonitor)
+	}
+	op := operator{opName: opName, processorID: processorID}
+	r.addMonitorLocked(
+		flowCtx, monitorName, bufferingOpMemMonitor, flowCtx.Mon, LimitedMemoryMonitor, op,
+	)
+	r.mu.info[len(r.mu.info)-1].spill = true
 	bufferingMemAccount := bufferingOpMemMonitor.MakeBoundAccount()
-	r.accounts = append(r.accounts, &bufferingMemAccount)
+	r.addAccountLocked(&bufferingMemAccount, bufferingOpMemMonitor)
 	return &bufferingMemAccount, monitorName
 }
 
+// CreateMemAccountAndMonitorForSpillStrategyWithLimit is the same as
+// CreateMemAccountForSpillStrategyWithLimit except that the monitor is returned
+// too, with the same ownership as in CreateMemAccountAndMonitorForSpillStrategy.
+func (r *MonitorRegistry) CreateMemAccountAndMonitorForSpillStrategyWithLimit(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	limit int64,
+	opName redact.RedactableString,
+	processorID int32,
+) (*mon.BoundAccount, *mon.BytesMonitor, redact.RedactableString) {
+	acc, monitorName := r.CreateMemAccountForSpillStrategyWithLimit(
+		ctx, flowCtx, limit, opName, processorID,
+	)
+	return acc, acc.Monitor(), monitorName
+}
+
+// CreateMemAccountForStrictLimit instantiates a memory monitor with the given
+// limit and a memory account bound to it, to be used with a buffering
+// colexecop.Operator that cannot fall back to disk, so that the query errors
+// out once the limit is exceeded. Unlike with
+// CreateMemAccountForSpillStrategyWithLimit, the limit isn't affected by
+// flowCtx.Cfg.TestingKnobs.ForceDiskSpill since there's no disk to spill to.
+// The monitor name, which is also returned, is never that of a monitor created
+// for a spill strategy, so the "memory budget exceeded" errors it produces
+// aren't caught by the disk spillers. The limit must be positive.
+func (r *MonitorRegistry) CreateMemAccountForStrictLimit(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	limit int64,
+	opName redact.RedactableString,
+	processorID int32,
+) (*mon.BoundAccount, redact.RedactableString) {
+	if limit <= 0 {
+		colexecerror.InternalError(errors.AssertionFailedf("expected positive limit, got %d", limit))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "strict" /* suffix */)
+	strictMemMonitor := mon.NewMonitorInheritWithLimit(monitorName, limit, flowCtx.Mon, false /* longLiving */)
+	strictMemMonitor.StartNoReserved(ctx, flowCtx.Mon)
+	r.watchLimitLocked(monitorName, strictMemMonitor)
+	op := operator{opName: opName, processorID: processorID}
+	r.addMonitorLocked(
+		flowCtx, monitorName, strictMemMonitor, flowCtx.Mon, LimitedMemoryMonitor, op,
+	)
+	strictMemAccount := strictMemMonitor.MakeBoundAccount()
+	r.addAccountLocked(&strictMemAccount, strictMemMonitor)
+	return &strictMemAccount, monitorName
+}
+
+// CreateMemAccountWithReservation instantiates an unlimited memory monitor
+// started with the given number of bytes reserved up front from flowCtx.Mon,
+// and a memory account bound to it. It's to be used by the operators that know
+// at planning time roughly how much memory they will need, so that the query
+// fails to be planned, rather than fails midway, if that much memory isn't
+// available: an error is returned if the reservation can't be made, in which
+// case nothing is registered. The allocations are first deducted from the
+// reservation, and the reservation is returned to flowCtx.Mon when the
+// monitor is closed, whether it's been used or not.
+func (r *MonitorRegistry) CreateMemAccountWithReservation(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	opName redact.RedactableString,
+	processorID int32,
+	reservation int64,
+) (*mon.BoundAccount, error) {
+	if reservation < 0 {
+		return nil, errors.AssertionFailedf("expected non-negative reservation, got %d", reservation)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "reserved" /* suffix */)
+	reserved := flowCtx.Mon.MakeBoundAccount()
+	if err := reserved.Grow(ctx, reservation); err != nil {
+		return nil, errors.Wrapf(err, "reserving memory for %s", monitorName)
+	}
+	reservedMemMonitor := mon.NewMonitorInheritWithLimit(
+		monitorName, 0 /* limit */, flowCtx.Mon, false, /* longLiving */
+	)
+	reservedMemMonitor.Start(ctx, flowCtx.Mon, &reserved)
+	op := operator{opName: opName, processorID: processorID}
+	r.addMonitorLocked(
+		flowCtx, monitorName, reservedMemMonitor, flowCtx.Mon, UnlimitedMemoryMonitor, op,
+	)
+	reservedMemAccount := reservedMemMonitor.MakeBoundAccount()
+	r.addAccountLocked(&reservedMemAccount, reservedMemMonitor)
+	return &reservedMemAccount, nil
+}
+
 // CreateExtraMemAccountForSpillStrategy can be used to derive another memory
 // account that is bound to the memory monitor specified by the monitorName. It
 // is expected that such a monitor with a such name was already created by the
-// MonitorRegistry. If no such monitor is found, then nil is returned.
+// MonitorRegistry (only a monitor with exactly that name qualifies). If no such
+// monitor is found, an assertion failure listing the names of the registered
+// monitors is returned, or raised via colexecerror.InternalError in test
+// builds, so that the mistake is loud.
 func (r *MonitorRegistry) CreateExtraMemAccountForSpillStrategy(
-	monitorName string,
-) *mon.BoundAccount {
-	// Iterate backwards since most likely that we want to create an account
-	// bound to the most recently created monitor.
-	for i := len(r.monitors) - 1; i >= 0; i-- {
-		if r.monitors[i].Name() == monitorName {
-			bufferingMemAccount := r.monitors[i].MakeBoundAccount()
-			r.accounts = append(r.accounts, &bufferingMemAccount)
-			return &bufferingMemAccount
+	monitorName redact.RedactableString,
+) (*mon.BoundAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.mu.byName[monitorName]
+	if !ok {
+		var names redact.StringBuilder
+		for i, registered := range r.mu.monitors {
+			if i > 0 {
+				names.SafeString(", ")
+			}
+			names.Print(redact.RedactableString(registered.Name()))
 		}
+		err := errors.AssertionFailedf(
+			"no monitor named %s to create an extra account for, registered monitors: [%s]",
+			monitorName, names.RedactableString(),
+		)
+		if buildutil.CrdbTestBuild {
+			colexecerror.InternalError(err)
+		}
+		return nil, err
 	}
-	return nil
+	bufferingMemAccount := m.MakeBoundAccount()
+	r.addAccountLocked(&bufferingMemAccount, m)
+	return &bufferingMemAccount, nil
 }
 
 // CreateUnlimitedMemAccounts instantiates an unlimited memory monitor (with a
 // unique monitor name) and a number of memory accounts bound to it. The
 // receiver is updated to have references to all objects. Note that the returned
 // accounts are only "unlimited" in that they do not have a hard limit that they
-// enforce, but a limit might be enforced by a root monitor.
+// enforce, but a limit might be enforced by a root monitor. Like the monitors
+// for the spill strategies, the monitor is only started on the first growth of
+// one of the accounts.
 //
 // Note that the memory monitor name is not returned (unlike above) because no
 // caller actually needs it.
@@ -132,8 +880,12 @@ func (r *MonitorRegistry) CreateUnlimitedMemAccounts(
 	processorID int32,
 	numAccounts int,
 ) []*mon.BoundAccount {
-	monitorName := r.getMemMonitorName(opName, processorID, "unlimited" /* suffix */)
-	_, accounts := r.createUnlimitedMemAccounts(ctx, flowCtx, monitorName, numAccounts)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "unlimited" /* suffix */)
+	op := operator{opName: opName, processorID: processorID}
+	parent := r.unlimitedParentLocked(ctx, flowCtx)
+	_, accounts := r.createUnlimitedMemAccountsLocked(flowCtx, parent, monitorName, op, numAccounts)
 	return accounts
 }
 
@@ -149,29 +901,94 @@ func (r *MonitorRegistry) CreateUnlimitedMemAccount(
 }
 
 // CreateUnlimitedMemAccountsWithName is similar to CreateUnlimitedMemAccounts
-// with the only difference that the monitor name is provided by the caller.
+// with the only difference that the monitor name is provided by the caller. If
+// the registry already has the monitor with that name (because the method was
+// called with the same name before), the accounts are bound to that monitor
+// rather than to a new one, unless it has already been closed, in which case a
+// new monitor with the same name is created.
 func (r *MonitorRegistry) CreateUnlimitedMemAccountsWithName(
 	ctx context.Context, flowCtx *execinfra.FlowCtx, name redact.RedactableString, numAccounts int,
 ) (*mon.BytesMonitor, []*mon.BoundAccount) {
-	return r.createUnlimitedMemAccounts(ctx, flowCtx, name+"-unlimited", numAccounts)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := name + "-unlimited"
+	if m, ok := r.mu.byName[monitorName]; ok && !r.isClosedLocked(m) {
+		if m.Resource() != mon.MemoryResource {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"monitor named %q doesn't track memory", monitorName,
+			))
+		}
+		return m, r.makeAccountsLocked(m, numAccounts)
+	}
+	parent := r.unlimitedParentLocked(ctx, flowCtx)
+	return r.createUnlimitedMemAccountsLocked(
+		flowCtx, parent, monitorName, operator{}, numAccounts,
+	)
 }
 
-func (r *MonitorRegistry) createUnlimitedMemAccounts(
+// CreateUnlimitedMemAccountsWithParent is similar to CreateUnlimitedMemAccounts
+// with the difference that the unlimited monitor is created as a child of the
+// given memory monitor rather than of flowCtx.Mon (and regardless of
+// SetUnlimitedAggregateLimit), so that e.g. the flow-scoped bookkeeping
+// allocations don't count toward the budget of the query. The monitor is named
+// uniquely after the given name, and it is closed by Close like the others.
+func (r *MonitorRegistry) CreateUnlimitedMemAccountsWithParent(
 	ctx context.Context,
 	flowCtx *execinfra.FlowCtx,
+	parent *mon.BytesMonitor,
+	name redact.RedactableString,
+	numAccounts int,
+) []*mon.BoundAccount {
+	if parent == nil || parent.Resource() != mon.MemoryResource {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"expected a memory monitor as the parent of %s", name,
+		))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := append(r.mu.nameBuf[:0], name...)
+	monitorName := r.finishMonitorNameLocked(buf, "unlimited" /* suffix */)
+	_, accounts := r.createUnlimitedMemAccountsLocked(
+		flowCtx, parent, monitorName, operator{}, numAccounts,
+	)
+	return accounts
+}
+
+// createUnlimitedMemAccountsLocked creates the unlimited monitor with the given
+// name as a child of parent, and the given number of accounts bound to it. The
+// monitor is expected to be created under parent rather than flowCtx.Mon.
+func (r *MonitorRegistry) createUnlimitedMemAccountsLocked(
+	flowCtx *execinfra.FlowCtx,
+	parent *mon.BytesMonitor,
 	monitorName redact.RedactableString,
+	op operator,
 	numAccounts int,
 ) (*mon.BytesMonitor, []*mon.BoundAccount) {
-	bufferingOpUnlimitedMemMonitor := execinfra.NewMonitor(
-		ctx, flowCtx.Mon, monitorName,
+	bufferingOpUnlimitedMemMonitor := mon.NewMonitorInheritWithLimit(
+		monitorName, 0 /* limit */, parent, false, /* longLiving */
 	)
-	r.monitors = append(r.monitors, bufferingOpUnlimitedMemMonitor)
-	oldLen := len(r.accounts)
+	// The monitor is only started once it's used, like the ones for the spill
+	// strategies.
+	bufferingOpUnlimitedMemMonitor.StartLazily(parent)
+	r.addMonitorLocked(
+		flowCtx, monitorName, bufferingOpUnlimitedMemMonitor, parent, UnlimitedMemoryMonitor, op,
+	)
+	r.mu.info[len(r.mu.info)-1].flowParent = parent
+	return bufferingOpUnlimitedMemMonitor, r.makeAccountsLocked(bufferingOpUnlimitedMemMonitor, numAccounts)
+}
+
+// makeAccountsLocked creates and registers the given number of accounts bound
+// to the monitor. The returned slice aliases the registry's, capped so that it
+// can't be appended to in place.
+func (r *MonitorRegistry) makeAccountsLocked(
+	monitor *mon.BytesMonitor, numAccounts int,
+) []*mon.BoundAccount {
+	oldLen := len(r.mu.accounts)
 	for i := 0; i < numAccounts; i++ {
-		acc := bufferingOpUnlimitedMemMonitor.MakeBoundAccount()
-		r.accounts = append(r.accounts, &acc)
+		acc := monitor.MakeBoundAccount()
+		r.addAccountLocked(&acc, monitor)
 	}
-	return bufferingOpUnlimitedMemMonitor, r.accounts[oldLen:len(r.accounts)]
+	return r.mu.accounts[oldLen:len(r.mu.accounts):len(r.mu.accounts)]
 }
 
 // CreateDiskMonitor instantiates an unlimited disk monitor.
@@ -181,9 +998,23 @@ func (r *MonitorRegistry) CreateDiskMonitor(
 	opName redact.RedactableString,
 	processorID int32,
 ) *mon.BytesMonitor {
-	monitorName := r.getMemMonitorName(opName, processorID, "disk" /* suffix */)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.createDiskMonitorLocked(ctx, flowCtx, opName, processorID)
+}
+
+func (r *MonitorRegistry) createDiskMonitorLocked(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	opName redact.RedactableString,
+	processorID int32,
+) *mon.BytesMonitor {
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "disk" /* suffix */)
 	opDiskMonitor := execinfra.NewMonitor(ctx, flowCtx.DiskMonitor, monitorName)
-	r.monitors = append(r.monitors, opDiskMonitor)
+	op := operator{opName: opName, processorID: processorID}
+	r.addMonitorLocked(
+		flowCtx, monitorName, opDiskMonitor, flowCtx.DiskMonitor, DiskMonitor, op,
+	)
 	return opDiskMonitor
 }
 
@@ -198,9 +1029,46 @@ func (r *MonitorRegistry) CreateDiskAccount(
 	opName redact.RedactableString,
 	processorID int32,
 ) *mon.BoundAccount {
-	opDiskMonitor := r.CreateDiskMonitor(ctx, flowCtx, opName, processorID)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	opDiskMonitor := r.createDiskMonitorLocked(ctx, flowCtx, opName, processorID)
 	opDiskAccount := opDiskMonitor.MakeBoundAccount()
-	r.accounts = append(r.accounts, &opDiskAccount)
+	r.addAccountLocked(&opDiskAccount, opDiskMonitor)
+	return &opDiskAccount
+}
+
+// CreateDiskAccountWithLimit instantiates a disk monitor with the given limit
+// and a disk account bound to it, to be used for disk spilling infrastructure
+// in vectorized engine by an operator subject to a disk quota. Unless it's
+// positive, the limit is determined by the
+// sql.distsql.temp_storage.operator_disk_limit cluster setting. The limit isn't
+// affected by flowCtx.Cfg.TestingKnobs.ForceDiskSpill. The "disk budget
+// exceeded" errors produced once it's exceeded name the monitor, and thus the
+// operator.
+func (r *MonitorRegistry) CreateDiskAccountWithLimit(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	limit int64,
+	opName redact.RedactableString,
+	processorID int32,
+) *mon.BoundAccount {
+	if limit <= 0 {
+		limit = operatorDiskLimit.Get(&flowCtx.Cfg.Settings.SV)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	monitorName := r.getMemMonitorNameLocked(opName, processorID, "disk-limited" /* suffix */)
+	opDiskMonitor := mon.NewMonitorInheritWithLimit(
+		monitorName, limit, flowCtx.DiskMonitor, false, /* longLiving */
+	)
+	opDiskMonitor.StartNoReserved(ctx, flowCtx.DiskMonitor)
+	r.watchLimitLocked(monitorName, opDiskMonitor)
+	op := operator{opName: opName, processorID: processorID}
+	r.addMonitorLocked(
+		flowCtx, monitorName, opDiskMonitor, flowCtx.DiskMonitor, DiskMonitor, op,
+	)
+	opDiskAccount := opDiskMonitor.MakeBoundAccount()
+	r.addAccountLocked(&opDiskAccount, opDiskMonitor)
 	return &opDiskAccount
 }
 
@@ -209,49 +1077,602 @@ func (r *MonitorRegistry) CreateDiskAccount(
 func (r *MonitorRegistry) CreateDiskAccounts(
 	ctx context.Context, flowCtx *execinfra.FlowCtx, name redact.RedactableString, numAccounts int,
 ) (*mon.BytesMonitor, []*mon.BoundAccount) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	diskMonitor := execinfra.NewMonitor(ctx, flowCtx.DiskMonitor, name)
-	r.monitors = append(r.monitors, diskMonitor)
-	oldLen := len(r.accounts)
-	for i := 0; i < numAccounts; i++ {
-		diskAcc := diskMonitor.MakeBoundAccount()
-		r.accounts = append(r.accounts, &diskAcc)
+	r.addMonitorLocked(flowCtx, name, diskMonitor, flowCtx.DiskMonitor, DiskMonitor, operator{})
+	return diskMonitor, r.makeAccountsLocked(diskMonitor, numAccounts)
+}
+
+// Merge moves all the components registered with other into the receiver,
+// leaving other empty, so that closing the receiver closes them (and closing
+// other is a no-op). The monitors of either registry can then be looked up by
+// name in the receiver. other must not have been closed, and the names of the
+// monitors of the two registries must not collide, which is asserted.
+//
+// Merge must not be called concurrently with other.Merge(r).
+func (r *MonitorRegistry) Merge(other *MonitorRegistry) {
+	if r == other {
+		colexecerror.InternalError(errors.AssertionFailedf("merging a monitor registry into itself"))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	if other.mu.numClosedAccounts > 0 || other.mu.numClosedMonitors > 0 {
+		colexecerror.InternalError(errors.AssertionFailedf("merging a closed monitor registry"))
+	}
+	if r.mu.unlimitedAggregate != nil && other.mu.unlimitedAggregate != nil {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"both of the merged registries have an aggregate monitor for the unlimited ones",
+		))
+	}
+	for _, m := range other.mu.monitors {
+		if _, ok := r.mu.byName[redact.RedactableString(m.Name())]; ok {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"monitor named %q registered with both of the merged registries", m.Name(),
+			))
+		}
+	}
+	// The contribution of other to its gauges, if any, is taken over by r.
+	other.releaseMetricsLocked(0 /* start */, len(other.mu.monitors))
+	numMonitors, numRegisteredMonitors := len(r.mu.monitors), r.mu.numRegisteredMonitors
+	for i, m := range other.mu.monitors {
+		r.registerMonitorLocked(redact.RedactableString(m.Name()), m, other.mu.info[i])
+	}
+	// The names generated by either registry stay unique, including those
+	// of the monitors detached from other.
+	r.mu.numRegisteredMonitors = numRegisteredMonitors + other.mu.numRegisteredMonitors
+	for prefix, ordinal := range other.mu.nameOrdinals {
+		if r.mu.nameOrdinals == nil {
+			r.mu.nameOrdinals = make(map[string]int)
+		}
+		if ordinal > r.mu.nameOrdinals[prefix] {
+			r.mu.nameOrdinals[prefix] = ordinal
+		}
+	}
+	r.mu.accounts = append(r.mu.accounts, other.mu.accounts...)
+	r.mu.accountMonitors = append(r.mu.accountMonitors, other.mu.accountMonitors...)
+	for acc, reclaimable := range other.mu.reclaimable {
+		if r.mu.reclaimable == nil {
+			r.mu.reclaimable = make(map[*mon.BoundAccount]int64)
+		}
+		r.mu.reclaimable[acc] = reclaimable
+	}
+	if other.mu.unlimitedAggregate != nil {
+		r.mu.unlimitedAggregate = other.mu.unlimitedAggregate
+	}
+	other.clearLocked()
+	r.updateMetricsLocked(numMonitors, len(r.mu.monitors))
+}
+
+// Detach removes the monitor with the given name, created by the registry, and
+// all the accounts bound to it from the registry, which won't close them: the
+// caller becomes responsible for closing the accounts and stopping the
+// monitor. It returns false if there's no such monitor, or it has already been
+// closed. A detached unlimited monitor created under the aggregate limit (see
+// SetUnlimitedAggregateLimit) must be stopped before the registry is closed.
+//
+// The slices previously returned by the registry aren't modified.
+func (r *MonitorRegistry) Detach(
+	monitorName redact.RedactableString,
+) (*mon.BytesMonitor, []*mon.BoundAccount, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.mu.byName[monitorName]
+	if !ok {
+		return nil, nil, false
+	}
+	idx := -1
+	for i := r.mu.numClosedMonitors; i < len(r.mu.monitors); i++ {
+		if r.mu.monitors[i] == m {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, nil, false
+	}
+	r.releaseMetricsLocked(idx, idx+1)
+	// New slices are allocated, rather than the existing ones being compacted
+	// in place, since the callers might still hold slices aliasing them (see
+	// GetMonitors and makeAccountsLocked).
+	monitors := make([]*mon.BytesMonitor, 0, len(r.mu.monitors)-1)
+	monitors = append(append(monitors, r.mu.monitors[:idx]...), r.mu.monitors[idx+1:]...)
+	info := make([]monitorInfo, 0, len(r.mu.info)-1)
+	info = append(append(info, r.mu.info[:idx]...), r.mu.info[idx+1:]...)
+	var detached []*mon.BoundAccount
+	accounts := make([]*mon.BoundAccount, 0, len(r.mu.accounts))
+	accountMonitors := make([]*mon.BytesMonitor, 0, len(r.mu.accounts))
+	numClosedAccounts := r.mu.numClosedAccounts
+	for i, acc := range r.mu.accounts {
+		if r.mu.accountMonitors[i] != m {
+			accounts = append(accounts, acc)
+			accountMonitors = append(accountMonitors, r.mu.accountMonitors[i])
+			continue
+		}
+		detached = append(detached, acc)
+		delete(r.mu.reclaimable, acc)
+		if i < r.mu.numClosedAccounts {
+			numClosedAccounts--
+		}
 	}
-	return diskMonitor, r.accounts[oldLen:len(r.accounts)]
+	r.mu.monitors, r.mu.info = monitors, info
+	r.mu.accounts, r.mu.accountMonitors = accounts, accountMonitors
+	r.mu.numClosedAccounts = numClosedAccounts
+	delete(r.mu.byName, monitorName)
+	return m, detached, true
 }
 
 // AssertInvariants confirms that all invariants are maintained by
-// MonitorRegistry.
+// MonitorRegistry. It's meant to be used in test builds only.
 func (r *MonitorRegistry) AssertInvariants() {
-	// Check that all memory monitor names are unique (colexec.diskSpillerBase
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.mu.info) != len(r.mu.monitors) {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"%d monitors described, expected %d", len(r.mu.info), len(r.mu.monitors),
+		))
+	}
+	// Check that no monitor is registered twice, which would make Close stop
+	// it twice.
+	registered := make(map[*mon.BytesMonitor]struct{}, len(r.mu.monitors))
+	for _, m := range r.mu.monitors {
+		if _, seen := registered[m]; seen {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"monitor %q registered twice", m.Name(),
+			))
+		}
+		registered[m] = struct{}{}
+	}
+	// Check that all open monitor names are unique (colexec.diskSpillerBase
 	// relies on this in order to catch "memory budget exceeded" errors only
-	// from "its own" component).
-	names := make(map[string]struct{}, len(r.monitors))
-	for _, m := range r.monitors {
-		if _, seen := names[m.Name()]; seen {
-			colexecerror.InternalError(errors.AssertionFailedf("monitor named %q encountered twice", m.Name()))
+	// from "its own" component), and that the open monitors are indexed by
+	// their names. The names of closed monitors can be reused.
+	names := make(map[string]struct{}, len(r.mu.monitors))
+	for i, m := range r.mu.monitors {
+		if i >= r.mu.numClosedMonitors {
+			if _, seen := names[m.Name()]; seen {
+				colexecerror.InternalError(errors.AssertionFailedf(
+					"monitor named %q encountered twice", m.Name(),
+				))
+			}
+			names[m.Name()] = struct{}{}
+			if indexed := r.mu.byName[redact.RedactableString(m.Name())]; indexed != m {
+				colexecerror.InternalError(errors.AssertionFailedf(
+					"monitor named %q isn't indexed by name", m.Name(),
+				))
+			}
+		}
+		// Check that the monitor is created under the monitor of the flow of
+		// its kind (or the aggregate monitor of the unlimited ones), so that
+		// e.g. disk usage isn't accounted for as memory usage.
+		info := r.mu.info[i]
+		if info.parentMon != info.flowParent {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"monitor %q created under %q, expected %q",
+				m.Name(), info.parentMon.Name(), info.flowParent.Name(),
+			))
+		}
+	}
+	if len(r.mu.accountMonitors) != len(r.mu.accounts) {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"%d account monitors tracked, expected %d", len(r.mu.accountMonitors), len(r.mu.accounts),
+		))
+	}
+	// Check that the accounts are bound to registered monitors, so that Close
+	// doesn't stop a monitor before all its accounts are closed.
+	for _, m := range r.mu.accountMonitors {
+		if _, ok := registered[m]; m != nil && !ok {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"account bound to unregistered monitor %q", m.Name(),
+			))
+		}
+	}
+	for name, m := range r.mu.byName {
+		if _, ok := registered[m]; !ok || redact.RedactableString(m.Name()) != name {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"monitor %q indexed by name %q isn't registered under it", m.Name(), name,
+			))
+		}
+	}
+}
+
+var _ redact.SafeFormatter = (*MonitorRegistry)(nil)
+
+// SafeFormat implements the redact.SafeFormatter interface. It describes, on
+// a single line, the monitors created by the registry grouped by the operators
+// they were created for, identified by the operator name and the processor ID
+// (in the order of the first monitor of each operator),
+// with the name, the limit, the current allocation, and the parent of each as
+// well as the number of accounts bound to it. The monitors whose names were
+// provided by the caller are grouped as "unattributed". Stopped monitors are
+// described as well, their allocations being zero.
+func (r *MonitorRegistry) SafeFormat(w redact.SafePrinter, _ rune) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	numAccounts := make(map[*mon.BytesMonitor]int, len(r.mu.monitors))
+	for _, m := range r.mu.accountMonitors {
+		if m != nil {
+			numAccounts[m]++
+		}
+	}
+	order := make([]int, len(r.mu.monitors))
+	groups := make(map[operator]int)
+	for i := range order {
+		order[i] = i
+		if op := r.mu.info[i].op; groups[op] == 0 {
+			groups[op] = len(groups) + 1
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return groups[r.mu.info[order[i]].op] < groups[r.mu.info[order[j]].op]
+	})
+	w.SafeString("monitor registry:")
+	if len(order) == 0 {
+		w.SafeString(" empty")
+		return
+	}
+	for k, i := range order {
+		m, info := r.mu.monitors[i], r.mu.info[i]
+		if k == 0 || info.op != r.mu.info[order[k-1]].op {
+			if k > 0 {
+				w.SafeString(";")
+			}
+			if info.op.opName == "" {
+				w.SafeString(" unattributed:")
+			} else {
+				w.Printf(" %s-%d:", info.op.opName, info.op.processorID)
+			}
+		}
+		w.Printf(" {%s (", redact.RedactableString(m.Name()))
+		if info.kind == DiskMonitor {
+			w.SafeString("disk, ")
 		}
-		names[m.Name()] = struct{}{}
+		// Unlimited monitors are created with the maximum limit.
+		if limit := m.Limit(); limit == math.MaxInt64 {
+			w.SafeString("unlimited")
+		} else {
+			w.Printf("limit %s", humanizeutil.IBytes(limit))
+		}
+		w.Printf(", allocated %s, parent %s, accounts %d)}",
+			humanizeutil.IBytes(m.AllocBytes()), info.parent, numAccounts[m])
 	}
 }
 
-// Close closes all components in the registry.
+// String implements the fmt.Stringer interface.
+func (r *MonitorRegistry) String() string {
+	return redact.StringWithoutMarkers(r)
+}
+
+// Close closes all components in the registry, including the monitors never
+// started because none of their accounts was grown. The components registered
+// concurrently with (or after) Close aren't closed by it; they are closed by
+// the next call.
+//
+// If EnableStrictLeakCheck was called, the accounts that haven't been released
+// are reported, all at once, by an assertion failure raised once all the
+// components are closed.
 func (r *MonitorRegistry) Close(ctx context.Context) {
-	for i := range r.accounts {
-		r.accounts[i].Close(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var leakErr error
+	if buildutil.CrdbTestBuild && r.mu.strictLeakCheck {
+		leakErr = r.checkLeaksLocked(r.mu.numClosedAccounts, len(r.mu.accounts))
+	}
+	for _, acc := range r.mu.accounts[r.mu.numClosedAccounts:] {
+		acc.Close(ctx)
+		delete(r.mu.reclaimable, acc)
+	}
+	if buildutil.CrdbTestBuild {
+		numMonitors := len(r.mu.monitors)
+		if err := r.checkSpillMonitorsLocked(r.mu.numClosedMonitors, numMonitors); err != nil {
+			colexecerror.InternalError(err)
+		}
+	}
+	for i, m := range r.mu.monitors[r.mu.numClosedMonitors:] {
+		r.mu.info[r.mu.numClosedMonitors+i].peak = m.MaximumBytes()
+		m.Stop(ctx)
+	}
+	// The aggregate monitor of the unlimited ones is stopped after them.
+	if r.mu.unlimitedAggregate != nil {
+		r.mu.unlimitedAggregate.Stop(ctx)
+		r.mu.unlimitedAggregate = nil
+	}
+	// With all the monitors closed, this removes the contribution of the
+	// registry to the gauges.
+	r.releaseMetricsLocked(r.mu.numClosedMonitors, len(r.mu.monitors))
+	r.mu.numClosedAccounts, r.mu.numClosedMonitors = len(r.mu.accounts), len(r.mu.monitors)
+	if leakErr != nil {
+		colexecerror.InternalError(leakErr)
+	}
+}
+
+// MarkReclaimable registers the given number of bytes of the usage of the
+// account, created by the registry, as reclaimable: the operator owning the
+// account no longer references the memory they account for, so TrimIdle may
+// release them. The bytes marked accumulate, and they must not be shrunk by the
+// operator afterwards (clearing or closing the account is fine), since they
+// are now released by TrimIdle.
+func (r *MonitorRegistry) MarkReclaimable(acc *mon.BoundAccount, bytes int64) {
+	if bytes <= 0 {
+		colexecerror.InternalError(errors.AssertionFailedf(
+			"expected positive number of reclaimable bytes, got %d", bytes,
+		))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if buildutil.CrdbTestBuild {
+		var found bool
+		for _, registered := range r.mu.accounts[r.mu.numClosedAccounts:] {
+			if registered == acc {
+				found = true
+				break
+			}
+		}
+		if !found {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"marking bytes of an account not registered as reclaimable",
+			))
+		}
+	}
+	if r.mu.reclaimable == nil {
+		r.mu.reclaimable = make(map[*mon.BoundAccount]int64)
+	}
+	r.mu.reclaimable[acc] += bytes
+}
+
+// TrimIdle shrinks the accounts with bytes marked as reclaimable (see
+// MarkReclaimable) whose usage exceeds the threshold, down to the threshold but
+// by no more than the bytes marked, so that the operators that buffered a lot
+// early and stream afterwards don't hold onto their peak usage until Close. The
+// accounts bound to the monitors created for spill strategies are left alone,
+// their usage being bounded by the limits of the monitors already. It's meant
+// to be called between batches (e.g. when the root monitor reports pressure),
+// not concurrently with the use of the accounts.
+func (r *MonitorRegistry) TrimIdle(ctx context.Context, threshold int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.mu.reclaimable) == 0 {
+		return
+	}
+	spill := make(map[*mon.BytesMonitor]struct{})
+	for i := r.mu.numClosedMonitors; i < len(r.mu.monitors); i++ {
+		if r.mu.info[i].spill {
+			spill[r.mu.monitors[i]] = struct{}{}
+		}
+	}
+	for acc, reclaimable := range r.mu.reclaimable {
+		if _, ok := spill[acc.Monitor()]; ok {
+			continue
+		}
+		excess := acc.Used() - threshold
+		if excess <= 0 {
+			continue
+		}
+		toShrink := min(reclaimable, excess)
+		acc.Shrink(ctx, toShrink)
+		if reclaimable -= toShrink; reclaimable == 0 {
+			delete(r.mu.reclaimable, acc)
+		} else {
+			r.mu.reclaimable[acc] = reclaimable
+		}
+	}
+	r.updateMetricsLocked(r.mu.numClosedMonitors, len(r.mu.monitors))
+}
+
+// CloseForProcessor closes the components in the registry created for the
+// operators of the processor with the given ID, leaving the others intact, so
+// that a processor torn down early (e.g. once a local limit is satisfied)
+// doesn't hold onto its memory until the whole flow finishes. The components
+// already closed are skipped, so calling it again for the same processor is a
+// no-op, and Close doesn't close them again. The monitors whose names were
+// provided by the caller and the accounts created by NewStreamingMemAccount
+// aren't attributed to any processor, so they are only closed by Close. Like
+// with Close, the peaks of the monitors survive until Reset.
+//
+// If EnableStrictLeakCheck was called, the accounts closed that haven't been
+// released are reported like by Close.
+func (r *MonitorRegistry) CloseForProcessor(ctx context.Context, processorID int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	closing := make(map[*mon.BytesMonitor]struct{})
+	for i := r.mu.numClosedMonitors; i < len(r.mu.monitors); i++ {
+		if op := r.mu.info[i].op; op.opName != "" && op.processorID == processorID {
+			closing[r.mu.monitors[i]] = struct{}{}
+		}
+	}
+	if len(closing) == 0 {
+		return
+	}
+	// The components to close are moved right after those already closed, so
+	// that they join the closed prefixes once closed. New slices are
+	// allocated, rather than the existing ones being reordered in place, since
+	// the callers might still hold slices aliasing them (see GetMonitors and
+	// makeAccountsLocked).
+	monitors := make([]*mon.BytesMonitor, r.mu.numClosedMonitors, len(r.mu.monitors))
+	copy(monitors, r.mu.monitors)
+	info := make([]monitorInfo, r.mu.numClosedMonitors, len(r.mu.info))
+	copy(info, r.mu.info)
+	accounts := make([]*mon.BoundAccount, r.mu.numClosedAccounts, len(r.mu.accounts))
+	copy(accounts, r.mu.accounts)
+	accountMonitors := make([]*mon.BytesMonitor, r.mu.numClosedAccounts, len(r.mu.accounts))
+	copy(accountMonitors, r.mu.accountMonitors)
+	var numClosingAccounts int
+	for _, toClose := range []bool{true, false} {
+		for i := r.mu.numClosedMonitors; i < len(r.mu.monitors); i++ {
+			if _, ok := closing[r.mu.monitors[i]]; ok == toClose {
+				monitors = append(monitors, r.mu.monitors[i])
+				info = append(info, r.mu.info[i])
+			}
+		}
+		for i := r.mu.numClosedAccounts; i < len(r.mu.accounts); i++ {
+			if _, ok := closing[r.mu.accountMonitors[i]]; ok == toClose {
+				accounts = append(accounts, r.mu.accounts[i])
+				accountMonitors = append(accountMonitors, r.mu.accountMonitors[i])
+				if toClose {
+					numClosingAccounts++
+				}
+			}
+		}
+	}
+	r.mu.monitors, r.mu.info = monitors, info
+	r.mu.accounts, r.mu.accountMonitors = accounts, accountMonitors
+	accountsEnd := r.mu.numClosedAccounts + numClosingAccounts
+	monitorsEnd := r.mu.numClosedMonitors + len(closing)
+	var leakErr error
+	if buildutil.CrdbTestBuild && r.mu.strictLeakCheck {
+		leakErr = r.checkLeaksLocked(r.mu.numClosedAccounts, accountsEnd)
+	}
+	for _, acc := range r.mu.accounts[r.mu.numClosedAccounts:accountsEnd] {
+		acc.Close(ctx)
+		delete(r.mu.reclaimable, acc)
+	}
+	if buildutil.CrdbTestBuild {
+		if err := r.checkSpillMonitorsLocked(r.mu.numClosedMonitors, monitorsEnd); err != nil {
+			colexecerror.InternalError(err)
+		}
+	}
+	for i := r.mu.numClosedMonitors; i < monitorsEnd; i++ {
+		r.mu.info[i].peak = r.mu.monitors[i].MaximumBytes()
+		r.mu.monitors[i].Stop(ctx)
+	}
+	r.releaseMetricsLocked(r.mu.numClosedMonitors, monitorsEnd)
+	r.mu.numClosedAccounts, r.mu.numClosedMonitors = accountsEnd, monitorsEnd
+	if leakErr != nil {
+		colexecerror.InternalError(leakErr)
+	}
+}
+
+// checkSpillMonitorsLocked returns an assertion failure if one of the monitors
+// for spill strategies among those in [start, end), which are about to be
+// stopped, still has child monitors or accounts created by the caller (see
+// CreateMemAccountAndMonitorForSpillStrategy) that haven't been stopped or
+// closed, or nil if there's none. The accounts registered with the registry
+// must have been closed already.
+func (r *MonitorRegistry) checkSpillMonitorsLocked(start, end int) error {
+	for i := start; i < end; i++ {
+		if !r.mu.info[i].spill {
+			continue
+		}
+		m := r.mu.monitors[i]
+		var numChildren int
+		_ = m.TraverseTree(func(state mon.MonitorState) error {
+			if state.Level > 0 {
+				numChildren++
+			}
+			return nil
+		})
+		if numChildren > 0 {
+			return errors.AssertionFailedf(
+				"monitor %q stopped with %d child monitors not stopped", m.Name(), numChildren,
+			)
+		}
+		if allocated := m.AllocBytes(); allocated != 0 {
+			return errors.AssertionFailedf(
+				"monitor %q stopped with %d bytes allocated by accounts not closed",
+				m.Name(), allocated,
+			)
+		}
+	}
+	return nil
+}
+
+// checkLeaksLocked returns an assertion failure naming the accounts, among
+// those in [start, end), that haven't been released, or nil if there are none.
+func (r *MonitorRegistry) checkLeaksLocked(start, end int) error {
+	var leaks redact.StringBuilder
+	var numLeaks int
+	for i := start; i < end; i++ {
+		acc := r.mu.accounts[i]
+		if used := acc.Used(); used != 0 {
+			if numLeaks > 0 {
+				leaks.SafeString("; ")
+			}
+			leaks.Printf("account %d bound to monitor %s: %d bytes", i, acc.Monitor().Name(), used)
+			numLeaks++
+		}
 	}
-	for i := range r.monitors {
-		r.monitors[i].Stop(ctx)
+	if numLeaks == 0 {
+		return nil
 	}
+	return errors.AssertionFailedf("%d accounts not released: %s", numLeaks, leaks.RedactableString())
 }
 
-// Reset prepares the registry for reuse.
+// Reset prepares the registry for reuse. The components registered must have
+// been closed. The references to them are unset (rather than only the slices
+// being truncated), so that a registry kept around for reuse doesn't keep the
+// monitors and accounts of a prior flow alive.
 func (r *MonitorRegistry) Reset() {
-	for i := range r.accounts {
-		r.accounts[i] = nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clearLocked()
+	r.mu.strictLeakCheck = false
+	r.mu.deterministicNaming = false
+	r.mu.onBudgetExceeded = nil
+	r.mu.unlimitedAggregateLimit = 0
+	r.mu.memGauge, r.mu.diskGauge = nil, nil
+	r.lastMetricsUpdate.Store(0)
+}
+
+var _ execreleasable.Releasable = &MonitorRegistry{}
+
+var monitorRegistryPool = sync.Pool{
+	New: func() interface{} {
+		return &MonitorRegistry{}
+	},
+}
+
+// GetMonitorRegistry returns a new MonitorRegistry. It must be released with
+// Release once closed.
+func GetMonitorRegistry() *MonitorRegistry {
+	return monitorRegistryPool.Get().(*MonitorRegistry)
+}
+
+// Release implements the execinfra.Releasable interface. The registry is reset
+// and returned to the pool, so it must not be used afterwards. In test builds,
+// it's asserted that all the components registered have been closed.
+func (r *MonitorRegistry) Release() {
+	if buildutil.CrdbTestBuild {
+		r.mu.Lock()
+		numUnclosedAccounts := len(r.mu.accounts) - r.mu.numClosedAccounts
+		numUnclosedMonitors := len(r.mu.monitors) - r.mu.numClosedMonitors
+		r.mu.Unlock()
+		if numUnclosedAccounts != 0 || numUnclosedMonitors != 0 {
+			colexecerror.InternalError(errors.AssertionFailedf(
+				"registry released with %d accounts and %d monitors not closed",
+				numUnclosedAccounts, numUnclosedMonitors,
+			))
+		}
+	}
+	r.Reset()
+	monitorRegistryPool.Put(r)
+}
+
+// clearLocked forgets all the components registered, without closing them.
+func (r *MonitorRegistry) clearLocked() {
+	for i := range r.mu.accounts {
+		r.mu.accounts[i] = nil
+		r.mu.accountMonitors[i] = nil
+	}
+	for i := range r.mu.monitors {
+		r.mu.monitors[i] = nil
+		r.mu.info[i] = monitorInfo{}
+	}
+	for name := range r.mu.byName {
+		delete(r.mu.byName, name)
+	}
+	for acc := range r.mu.reclaimable {
+		delete(r.mu.reclaimable, acc)
 	}
-	for i := range r.monitors {
-		r.monitors[i] = nil
+	for prefix := range r.mu.nameOrdinals {
+		delete(r.mu.nameOrdinals, prefix)
 	}
-	r.accounts = r.accounts[:0]
-	r.monitors = r.monitors[:0]
+	r.mu.accounts = r.mu.accounts[:0]
+	r.mu.accountMonitors = r.mu.accountMonitors[:0]
+	r.mu.monitors = r.mu.monitors[:0]
+	r.mu.info = r.mu.info[:0]
+	r.mu.numClosedAccounts, r.mu.numClosedMonitors = 0, 0
+	r.mu.numRegisteredMonitors = 0
+	r.mu.unlimitedAggregate = nil
 }
@@ -0,0 +1,2022 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package colexecargs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/col/coldata"
+	"github.com/cockroachdb/cockroach/pkg/col/coldatatestutils"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/colcontainer"
+	"github.com/cockroachdb/cockroach/pkg/sql/colexecerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/colmem"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlerrors"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/testutils/colcontainerutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/redact"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFlowCtx returns a flow context using testing cluster settings, an eval
+// context's monitor and a testing disk monitor, along with a function stopping
+// the monitors.
+func newTestFlowCtx(ctx context.Context) (*execinfra.FlowCtx, func()) {
+	st := cluster.MakeTestingClusterSettings()
+	evalCtx := eval.MakeTestingEvalContext(st)
+	diskMonitor := execinfra.NewTestDiskMonitor(ctx, st)
+	flowCtx := &execinfra.FlowCtx{
+		EvalCtx:     &evalCtx,
+		Mon:         evalCtx.TestingMon,
+		Cfg:         &execinfra.ServerConfig{Settings: st},
+		DiskMonitor: diskMonitor,
+	}
+	return flowCtx, func() {
+		diskMonitor.Stop(ctx)
+		evalCtx.Stop(ctx)
+	}
+}
+
+// TestMonitorRegistryConcurrentUse verifies that the components can be
+// registered concurrently, with unique monitor names, and that Close releases
+// all of them, including when called concurrently with the registration.
+func TestMonitorRegistryConcurrentUse(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	diskMonitor := flowCtx.DiskMonitor
+
+	const numGoroutines = 8
+	// register registers components of every kind from numGoroutines
+	// goroutines, all of which use the same operator name and processor ID
+	// for the monitors named by the registry. If grow is set, every account is
+	// grown.
+	register := func(r *MonitorRegistry, grow bool) {
+		var wg sync.WaitGroup
+		wg.Add(numGoroutines)
+		for i := 0; i < numGoroutines; i++ {
+			go func(i int) {
+				defer wg.Done()
+				const opName = redact.RedactableString("op")
+				limitedAcc, name := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, opName, 1 /* processorID */)
+				limitedAccWithLimit, _ := r.CreateMemAccountForSpillStrategyWithLimit(
+					ctx, flowCtx, 1<<20 /* limit */, opName, 1, /* processorID */
+				)
+				extraAcc, err := r.CreateExtraMemAccountForSpillStrategy(name)
+				require.NoError(t, err)
+				accounts := append([]*mon.BoundAccount{
+					limitedAcc,
+					limitedAccWithLimit,
+					extraAcc,
+					r.NewStreamingMemAccount(flowCtx),
+					r.CreateUnlimitedMemAccount(ctx, flowCtx, opName, 1 /* processorID */),
+					r.CreateDiskAccount(ctx, flowCtx, opName, 1 /* processorID */),
+				}, r.CreateUnlimitedMemAccounts(ctx, flowCtx, opName, 1 /* processorID */, 2 /* numAccounts */)...)
+				// The callers naming the monitors are responsible for the names'
+				// uniqueness.
+				routerName := redact.RedactableString(fmt.Sprintf("hash-router-%d", i))
+				_, withName := r.CreateUnlimitedMemAccountsWithName(ctx, flowCtx, routerName, 2 /* numAccounts */)
+				accounts = append(accounts, withName...)
+				_, diskAccounts := r.CreateDiskAccounts(ctx, flowCtx, routerName, 2 /* numAccounts */)
+				accounts = append(accounts, diskAccounts...)
+				r.CreateDiskMonitor(ctx, flowCtx, opName, 1 /* processorID */)
+				if grow {
+					for _, acc := range accounts {
+						require.NoError(t, acc.Grow(ctx, 10))
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	t.Run("registration", func(t *testing.T) {
+		memAllocated, diskAllocated := flowCtx.Mon.AllocBytes(), diskMonitor.AllocBytes()
+		var r MonitorRegistry
+		register(&r, true /* grow */)
+		// The names generated for the monitors are unique.
+		require.Len(t, r.GetMonitors(), numGoroutines*8)
+		r.AssertInvariants()
+		require.Greater(t, flowCtx.Mon.AllocBytes(), memAllocated)
+		require.Greater(t, diskMonitor.AllocBytes(), diskAllocated)
+
+		// Nothing is leaked once the registry is closed.
+		r.Close(ctx)
+		require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+		require.Equal(t, diskAllocated, diskMonitor.AllocBytes())
+		r.Reset()
+		require.Empty(t, r.GetMonitors())
+	})
+
+	t.Run("concurrent close", func(t *testing.T) {
+		var r MonitorRegistry
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			register(&r, false /* grow */)
+		}()
+		r.Close(ctx)
+		wg.Wait()
+		// The components registered after Close are closed by the next call,
+		// and those closed aren't closed again.
+		r.Close(ctx)
+		r.Close(ctx)
+		require.Equal(t, len(r.mu.accounts), r.mu.numClosedAccounts)
+		require.Equal(t, len(r.mu.monitors), r.mu.numClosedMonitors)
+		r.Reset()
+	})
+}
+
+// TestMonitorRegistryGetMonitorByName verifies that the monitors are looked up
+// by name, through Reset.
+func TestMonitorRegistryGetMonitorByName(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	require.Nil(t, r.GetMonitorByName("sorter-1-limited-0"))
+	_, limitedName := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "sorter", 1, /* processorID */
+	)
+	require.Equal(t, redact.RedactableString("sorter-1-limited-0"), limitedName)
+	r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	routerMonitor, _ := r.CreateUnlimitedMemAccountsWithName(ctx, flowCtx, "hash-router", 1 /* numAccounts */)
+	for _, m := range r.GetMonitors() {
+		require.Same(t, m, r.GetMonitorByName(redact.RedactableString(m.Name())))
+	}
+	require.Same(t, routerMonitor, r.GetMonitorByName("hash-router-unlimited"))
+	require.Nil(t, r.GetMonitorByName("hash-router"))
+	_, err := r.CreateExtraMemAccountForSpillStrategy(limitedName)
+	require.NoError(t, err)
+	_, err = createExtraMemAccount(&r, "sorter-1-limited-1")
+	require.Error(t, err)
+	r.AssertInvariants()
+
+	r.Close(ctx)
+	r.Reset()
+	require.Nil(t, r.GetMonitorByName(limitedName))
+	_, err = createExtraMemAccount(&r, limitedName)
+	require.Error(t, err)
+	// Once reused, the names are generated afresh.
+	_, reusedName := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 2 /* processorID */)
+	require.Equal(t, redact.RedactableString("sorter-2-limited-0"), reusedName)
+	r.AssertInvariants()
+	r.Close(ctx)
+}
+
+// TestMonitorRegistryDeterministicNaming verifies that, in the deterministic
+// naming mode, the same operators are assigned the same monitor names
+// regardless of the order in which they are planned, unlike by default.
+func TestMonitorRegistryDeterministicNaming(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	// Each operator creates its monitors in the same order, as it would
+	// when planned.
+	monitorName := func(acc *mon.BoundAccount) redact.RedactableString {
+		return redact.RedactableString(acc.Monitor().Name())
+	}
+	ops := map[string]func(r *MonitorRegistry) []redact.RedactableString{
+		"sorter": func(r *MonitorRegistry) []redact.RedactableString {
+			_, name := r.CreateMemAccountForSpillStrategy(
+				ctx, flowCtx, "sorter", 1, /* processorID */
+			)
+			diskAcc := r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+			return []redact.RedactableString{name, monitorName(diskAcc)}
+		},
+		"hash-joiner": func(r *MonitorRegistry) []redact.RedactableString {
+			_, name := r.CreateMemAccountForSpillStrategy(
+				ctx, flowCtx, "hash-joiner", 2, /* processorID */
+			)
+			unlimitedAcc := r.CreateUnlimitedMemAccount(
+				ctx, flowCtx, "hash-joiner", 2, /* processorID */
+			)
+			diskAcc := r.CreateDiskAccount(ctx, flowCtx, "hash-joiner", 2 /* processorID */)
+			return []redact.RedactableString{name, monitorName(unlimitedAcc), monitorName(diskAcc)}
+		},
+		"hash-router": func(r *MonitorRegistry) []redact.RedactableString {
+			m, _ := r.CreateUnlimitedMemAccountsWithName(
+				ctx, flowCtx, "hash-router", 1, /* numAccounts */
+			)
+			return []redact.RedactableString{redact.RedactableString(m.Name())}
+		},
+	}
+	plan := func(deterministic bool, order ...string) map[string][]redact.RedactableString {
+		var r MonitorRegistry
+		defer r.Close(ctx)
+		if deterministic {
+			r.SetDeterministicNaming()
+		}
+		names := make(map[string][]redact.RedactableString)
+		for _, op := range order {
+			names[op] = ops[op](&r)
+			// The names can still be looked up, and the disk spillers can
+			// still be given them.
+			_, err := createExtraMemAccount(&r, names[op][0])
+			require.NoError(t, err)
+		}
+		r.AssertInvariants()
+		return names
+	}
+	order := []string{"sorter", "hash-joiner", "hash-router"}
+	reversed := []string{"hash-router", "hash-joiner", "sorter"}
+
+	names := plan(true /* deterministic */, order...)
+	require.Equal(t, names, plan(true /* deterministic */, reversed...))
+	require.Equal(t, map[string][]redact.RedactableString{
+		"sorter": {"sorter-1-limited-0", "sorter-1-disk-1"},
+		"hash-joiner": {
+			"hash-joiner-2-limited-0", "hash-joiner-2-unlimited-1", "hash-joiner-2-disk-2",
+		},
+		"hash-router": {"hash-router-unlimited-0"},
+	}, names)
+	require.NotEqual(t,
+		plan(false /* deterministic */, order...), plan(false /* deterministic */, reversed...),
+	)
+
+	t.Run("uniqueness", func(t *testing.T) {
+		var r, other MonitorRegistry
+		defer r.Close(ctx)
+		r.SetDeterministicNaming()
+		other.SetDeterministicNaming()
+		for i := 0; i < 3; i++ {
+			r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		}
+		_, name := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		require.Equal(t, redact.RedactableString("sorter-1-limited-3"), name)
+		// The ordinals of the detached monitors aren't reused.
+		m, accounts, ok := r.Detach(name)
+		require.True(t, ok)
+		for _, acc := range accounts {
+			acc.Close(ctx)
+		}
+		m.Stop(ctx)
+		_, name = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		require.Equal(t, redact.RedactableString("sorter-1-limited-4"), name)
+		// Neither are those of a merged registry.
+		other.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+		for i := 0; i < 6; i++ {
+			other.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 2 /* processorID */)
+		}
+		r.Merge(&other)
+		_, name = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		require.Equal(t, redact.RedactableString("sorter-1-limited-5"), name)
+		_, name = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 2 /* processorID */)
+		require.Equal(t, redact.RedactableString("sorter-2-limited-6"), name)
+		r.AssertInvariants()
+
+		// The mode is unset by Reset, and it can't be set once monitors
+		// have been created.
+		r.Close(ctx)
+		r.Reset()
+		_, name = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		require.Equal(t, redact.RedactableString("sorter-1-limited-0"), name)
+		_, name = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		require.Equal(t, redact.RedactableString("sorter-1-limited-1"), name)
+		require.Error(t, colexecerror.CatchVectorizedRuntimeError(r.SetDeterministicNaming))
+	})
+}
+
+// createExtraMemAccount is like CreateExtraMemAccountForSpillStrategy, but it
+// also returns the error that is raised instead in test builds.
+func createExtraMemAccount(
+	r *MonitorRegistry, monitorName redact.RedactableString,
+) (acc *mon.BoundAccount, err error) {
+	if panicErr := colexecerror.CatchVectorizedRuntimeError(func() {
+		acc, err = r.CreateExtraMemAccountForSpillStrategy(monitorName)
+	}); panicErr != nil {
+		return nil, panicErr
+	}
+	return acc, err
+}
+
+// TestMonitorRegistryCreateExtraMemAccount verifies that an extra account is
+// only created for a monitor with exactly the given name, and that the error
+// returned otherwise lists the registered monitors.
+func TestMonitorRegistryCreateExtraMemAccount(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	_, err := createExtraMemAccount(&r, "sorter-1-limited-0")
+	require.True(t, errors.IsAssertionFailure(err))
+
+	limitedAcc, name := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "sorter", 1, /* processorID */
+	)
+	require.Equal(t, redact.RedactableString("sorter-1-limited-0"), name)
+	r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	extraAcc, err := createExtraMemAccount(&r, name)
+	require.NoError(t, err)
+	require.Same(t, limitedAcc.Monitor(), extraAcc.Monitor())
+
+	// Neither the prefixes of the name nor the names extending it qualify.
+	for _, monitorName := range []redact.RedactableString{
+		"sorter", "sorter-1", "sorter-1-limited", "sorter-1-limited-", "sorter-1-limited-01",
+	} {
+		t.Run(string(monitorName), func(t *testing.T) {
+			acc, err := createExtraMemAccount(&r, monitorName)
+			require.Nil(t, acc)
+			require.True(t, errors.IsAssertionFailure(err))
+			require.Contains(t, err.Error(), "sorter-1-limited-0, sorter-1-unlimited-1")
+		})
+	}
+	r.AssertInvariants()
+}
+
+// TestMonitorRegistryUsageSnapshot verifies the usage reported for limited,
+// unlimited and disk monitors, including once some of the accounts are closed.
+func TestMonitorRegistryUsageSnapshot(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	require.Empty(t, r.UsageSnapshot())
+	require.Zero(t, r.TotalAllocated())
+
+	limitedAcc, limitedName := r.CreateMemAccountForSpillStrategyWithLimit(
+		ctx, flowCtx, 1<<20 /* limit */, "sorter", 1, /* processorID */
+	)
+	unlimitedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	diskAcc := r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	// The monitors allocate in chunks, so the accounts are grown by multiples
+	// of their size for the usage to be exact.
+	chunk := mon.DefaultPoolAllocationSize
+	require.NoError(t, limitedAcc.Grow(ctx, chunk))
+	require.NoError(t, unlimitedAcc.Grow(ctx, 2*chunk))
+	require.NoError(t, diskAcc.Grow(ctx, 3*chunk))
+
+	require.Equal(t, []MonitorUsage{
+		{Name: limitedName, Allocated: chunk, Limit: 1 << 20, Resource: mon.MemoryResource},
+		{Name: "sorter-1-unlimited-1", Allocated: 2 * chunk, Resource: mon.MemoryResource},
+		{Name: "sorter-1-disk-2", Allocated: 3 * chunk, Resource: mon.DiskResource},
+	}, r.UsageSnapshot())
+	// The disk usage isn't included.
+	require.Equal(t, 3*chunk, r.TotalAllocated())
+
+	// The usage of the monitors whose accounts are closed drops accordingly.
+	unlimitedAcc.Close(ctx)
+	diskAcc.Close(ctx)
+	usage := r.UsageSnapshot()
+	require.Len(t, usage, 3)
+	require.Equal(t, chunk, usage[0].Allocated)
+	require.Zero(t, usage[1].Allocated)
+	require.Zero(t, usage[2].Allocated)
+	require.Equal(t, chunk, r.TotalAllocated())
+
+	// As does that of all of them once the registry is closed.
+	r.Close(ctx)
+	for _, u := range r.UsageSnapshot() {
+		require.Zero(t, u.Allocated)
+	}
+	require.Zero(t, r.TotalAllocated())
+}
+
+// TestMonitorRegistryPeakUsage verifies that the peak usage of an operator is
+// aggregated over its monitors, is the maximum rather than the final usage, and
+// survives Close until Reset.
+func TestMonitorRegistryPeakUsage(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	limitedAcc, _ := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "hash-joiner", 1 /* processorID */)
+	unlimitedAccs := r.CreateUnlimitedMemAccounts(
+		ctx, flowCtx, "hash-joiner", 1 /* processorID */, 2, /* numAccounts */
+	)
+	diskAcc := r.CreateDiskAccount(ctx, flowCtx, "hash-joiner", 1 /* processorID */)
+	// Neither another operator nor the callers naming the monitors count
+	// towards it.
+	otherAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "hash-joiner", 2 /* processorID */)
+	_, namedAccs := r.CreateUnlimitedMemAccountsWithName(ctx, flowCtx, "hash-joiner-1", 1 /* numAccounts */)
+	for _, acc := range []*mon.BoundAccount{otherAcc, namedAccs[0]} {
+		require.NoError(t, acc.Grow(ctx, mon.DefaultPoolAllocationSize))
+	}
+
+	// The monitors allocate in chunks, so the accounts are grown by multiples
+	// of their size for the usage to be exact.
+	chunk := mon.DefaultPoolAllocationSize
+	require.NoError(t, limitedAcc.Grow(ctx, 10*chunk))
+	limitedAcc.Shrink(ctx, 6*chunk)
+	require.NoError(t, limitedAcc.Grow(ctx, 2*chunk))
+	// The two accounts share the monitor, and peak together.
+	require.NoError(t, unlimitedAccs[0].Grow(ctx, 5*chunk))
+	require.NoError(t, unlimitedAccs[1].Grow(ctx, 3*chunk))
+	unlimitedAccs[0].Shrink(ctx, 5*chunk)
+	require.NoError(t, diskAcc.Grow(ctx, 7*chunk))
+	diskAcc.Clear(ctx)
+	require.NoError(t, diskAcc.Grow(ctx, chunk))
+
+	expMemPeak, expDiskPeak := (10+8)*chunk, 7*chunk
+	memPeak, diskPeak := r.PeakUsage("hash-joiner", 1 /* processorID */)
+	require.Equal(t, expMemPeak, memPeak)
+	require.Equal(t, expDiskPeak, diskPeak)
+	memPeak, diskPeak = r.PeakUsage("hash-joiner", 3 /* processorID */)
+	require.Zero(t, memPeak)
+	require.Zero(t, diskPeak)
+
+	r.Close(ctx)
+	memPeak, diskPeak = r.PeakUsage("hash-joiner", 1 /* processorID */)
+	require.Equal(t, expMemPeak, memPeak)
+	require.Equal(t, expDiskPeak, diskPeak)
+	r.AssertInvariants()
+
+	r.Reset()
+	memPeak, diskPeak = r.PeakUsage("hash-joiner", 1 /* processorID */)
+	require.Zero(t, memPeak)
+	require.Zero(t, diskPeak)
+}
+
+// TestMonitorRegistryStrictLeakCheck verifies that, if the strict leak check is
+// enabled, Close reports the accounts that haven't been released, naming the
+// monitors they're bound to, and still closes all components.
+func TestMonitorRegistryStrictLeakCheck(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	skip.UnderNonTestBuild(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	memAllocated := flowCtx.Mon.AllocBytes()
+
+	// register creates two accounts, only the first of which is released.
+	register := func(r *MonitorRegistry) {
+		released := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+		leaked := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 2 /* processorID */)
+		require.NoError(t, released.Grow(ctx, 10))
+		require.NoError(t, leaked.Grow(ctx, 10))
+		released.Shrink(ctx, 10)
+	}
+
+	var r MonitorRegistry
+	// Without the strict check, the accounts are silently released.
+	register(&r)
+	require.NoError(t, colexecerror.CatchVectorizedRuntimeError(func() { r.Close(ctx) }))
+	r.Reset()
+
+	r.EnableStrictLeakCheck()
+	register(&r)
+	err := colexecerror.CatchVectorizedRuntimeError(func() { r.Close(ctx) })
+	require.Error(t, err)
+	require.True(t, errors.HasAssertionFailure(err))
+	require.Contains(t, err.Error(), "1 accounts not released")
+	require.Contains(t, err.Error(), "account 1 bound to monitor sorter-2-unlimited-1: 10 bytes")
+	require.NotContains(t, err.Error(), "sorter-1-unlimited-0")
+	require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+
+	// The check is disabled by Reset.
+	r.Reset()
+	register(&r)
+	require.NoError(t, colexecerror.CatchVectorizedRuntimeError(func() { r.Close(ctx) }))
+	r.Reset()
+}
+
+// TestMonitorRegistryMerge verifies that merging registries moves all the
+// components into the receiver, where the monitors from either can be looked
+// up, and that the collisions of monitor names are caught.
+func TestMonitorRegistryMerge(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	diskMonitor := flowCtx.DiskMonitor
+	memAllocated, diskAllocated := flowCtx.Mon.AllocBytes(), diskMonitor.AllocBytes()
+
+	t.Run("empty", func(t *testing.T) {
+		var r, empty MonitorRegistry
+		acc, name := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		r.Merge(&empty)
+		require.Len(t, r.GetMonitors(), 1)
+		require.NotNil(t, r.GetMonitorByName(name))
+		r.AssertInvariants()
+		require.NoError(t, acc.Grow(ctx, 10))
+		r.Close(ctx)
+		require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+	})
+
+	t.Run("into empty", func(t *testing.T) {
+		var r, other MonitorRegistry
+		acc, name := other.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		diskAcc := other.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+		r.Merge(&other)
+		require.Len(t, r.GetMonitors(), 2)
+		require.Empty(t, other.GetMonitors())
+		require.Nil(t, other.GetMonitorByName(name))
+		_, err := r.CreateExtraMemAccountForSpillStrategy(name)
+		require.NoError(t, err)
+		r.AssertInvariants()
+		require.NoError(t, acc.Grow(ctx, 10))
+		require.NoError(t, diskAcc.Grow(ctx, 10))
+		// Closing other is a no-op, and closing the receiver releases
+		// everything.
+		other.Close(ctx)
+		require.Greater(t, flowCtx.Mon.AllocBytes(), memAllocated)
+		r.Close(ctx)
+		require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+		require.Equal(t, diskAllocated, diskMonitor.AllocBytes())
+	})
+
+	t.Run("both", func(t *testing.T) {
+		var r, other MonitorRegistry
+		_, parentName := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		_, childName := other.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 2 /* processorID */)
+		other.CreateUnlimitedMemAccountsWithName(ctx, flowCtx, "hash-router", 2 /* numAccounts */)
+		r.Merge(&other)
+		require.Len(t, r.GetMonitors(), 3)
+		for _, name := range []redact.RedactableString{parentName, childName, "hash-router-unlimited"} {
+			require.NotNil(t, r.GetMonitorByName(name))
+		}
+		for _, name := range []redact.RedactableString{parentName, childName} {
+			_, err := r.CreateExtraMemAccountForSpillStrategy(name)
+			require.NoError(t, err)
+		}
+		// The monitors created afterwards are still named uniquely.
+		r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 2 /* processorID */)
+		r.AssertInvariants()
+		other.AssertInvariants()
+		r.Close(ctx)
+		require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		var r, other MonitorRegistry
+		_, name := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		_, otherName := other.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+		require.Equal(t, name, otherName)
+		err := colexecerror.CatchVectorizedRuntimeError(func() { r.Merge(&other) })
+		require.Error(t, err)
+		require.True(t, errors.HasAssertionFailure(err))
+		require.Contains(t, err.Error(), "sorter-1-limited-0")
+		// Neither registry was modified.
+		require.Len(t, r.GetMonitors(), 1)
+		require.Len(t, other.GetMonitors(), 1)
+		r.Close(ctx)
+		other.Close(ctx)
+	})
+}
+
+// TestMonitorRegistryDetach verifies that a detached monitor and its accounts
+// are left alone by Close, and remain usable until the caller releases them.
+func TestMonitorRegistryDetach(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	memAllocated := flowCtx.Mon.AllocBytes()
+
+	var r MonitorRegistry
+	_, name := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+	extraAcc, err := r.CreateExtraMemAccountForSpillStrategy(name)
+	require.NoError(t, err)
+	streamingAcc := r.NewStreamingMemAccount(flowCtx)
+	otherAccs := r.CreateUnlimitedMemAccounts(
+		ctx, flowCtx, "hash-joiner", 2 /* processorID */, 2, /* numAccounts */
+	)
+	monitors := r.GetMonitors()
+
+	_, _, ok := r.Detach("sorter-1-limited-1")
+	require.False(t, ok)
+	m, accounts, ok := r.Detach(name)
+	require.True(t, ok)
+	require.Equal(t, string(name), m.Name())
+	require.Len(t, accounts, 2)
+	require.Same(t, extraAcc, accounts[1])
+	for _, acc := range accounts {
+		require.Same(t, m, acc.Monitor())
+	}
+	require.Nil(t, r.GetMonitorByName(name))
+	_, err = createExtraMemAccount(&r, name)
+	require.Error(t, err)
+	_, _, ok = r.Detach(name)
+	require.False(t, ok)
+	// The slices returned previously are left intact.
+	require.Len(t, monitors, 2)
+	require.Same(t, m, monitors[0])
+	require.Len(t, r.GetMonitors(), 1)
+	// The names generated afterwards don't collide with the remaining ones.
+	_, newName := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "hash-joiner", 2 /* processorID */)
+	require.Equal(t, redact.RedactableString("hash-joiner-2-limited-2"), newName)
+	r.AssertInvariants()
+
+	for _, acc := range append([]*mon.BoundAccount{streamingAcc}, otherAccs...) {
+		require.NoError(t, acc.Grow(ctx, 10))
+	}
+	r.Close(ctx)
+	_, _, ok = r.Detach(newName)
+	require.False(t, ok)
+	r.Reset()
+
+	// The detached accounts are still usable.
+	chunk := mon.DefaultPoolAllocationSize
+	for _, acc := range accounts {
+		require.NoError(t, acc.Grow(ctx, chunk))
+	}
+	require.Equal(t, 2*chunk, m.AllocBytes())
+	require.Equal(t, memAllocated+2*chunk, flowCtx.Mon.AllocBytes())
+	for _, acc := range accounts {
+		acc.Close(ctx)
+	}
+	m.Stop(ctx)
+	require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+}
+
+// TestMonitorRegistryStrictLimit verifies that the accounts with a strict
+// limit enforce it, regardless of the ForceDiskSpill testing knob, and that
+// the errors they produce name their own monitors.
+func TestMonitorRegistryStrictLimit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	evalCtx := eval.MakeTestingEvalContext(st)
+	defer evalCtx.Stop(ctx)
+
+	chunk := mon.DefaultPoolAllocationSize
+	limit := 2 * chunk
+	for _, forceDiskSpill := range []bool{false, true} {
+		t.Run(fmt.Sprintf("forceDiskSpill=%t", forceDiskSpill), func(t *testing.T) {
+			flowCtx := &execinfra.FlowCtx{
+				EvalCtx: &evalCtx,
+				Mon:     evalCtx.TestingMon,
+				Cfg: &execinfra.ServerConfig{
+					Settings:     st,
+					TestingKnobs: execinfra.TestingKnobs{ForceDiskSpill: forceDiskSpill},
+				},
+			}
+			var r MonitorRegistry
+			defer r.Close(ctx)
+			spillAcc, spillName := r.CreateMemAccountForSpillStrategy(
+				ctx, flowCtx, "window", 1, /* processorID */
+			)
+			strictAcc, strictName := r.CreateMemAccountForStrictLimit(
+				ctx, flowCtx, limit, "window", 1, /* processorID */
+			)
+			require.Equal(t, redact.RedactableString("window-1-strict-1"), strictName)
+			require.Equal(t, limit, r.GetMonitorByName(strictName).Limit())
+			r.AssertInvariants()
+
+			// Only the spill strategy account is limited to a single byte.
+			if err := spillAcc.Grow(ctx, 2); forceDiskSpill {
+				require.True(t, sqlerrors.IsOutOfMemoryError(err))
+				require.Contains(t, err.Error(), string(spillName))
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, strictAcc.Grow(ctx, limit))
+			err := strictAcc.Grow(ctx, 1)
+			require.True(t, sqlerrors.IsOutOfMemoryError(err))
+			require.Contains(t, err.Error(), string(strictName))
+			// The spill strategy's monitor name isn't a part of it.
+			require.NotContains(t, err.Error(), string(spillName))
+		})
+	}
+
+	// The limit must be positive.
+	flowCtx := &execinfra.FlowCtx{
+		EvalCtx: &evalCtx,
+		Mon:     evalCtx.TestingMon,
+		Cfg:     &execinfra.ServerConfig{Settings: st},
+	}
+	var r MonitorRegistry
+	require.Error(t, colexecerror.CatchVectorizedRuntimeError(func() {
+		r.CreateMemAccountForStrictLimit(ctx, flowCtx, 0 /* limit */, "window", 1 /* processorID */)
+	}))
+	require.Empty(t, r.GetMonitors())
+}
+
+// TestMonitorRegistryDiskLimit verifies that the disk accounts with a limit
+// enforce it, regardless of the ForceDiskSpill testing knob, that the limit
+// defaults to sql.distsql.temp_storage.operator_disk_limit, and that spilling
+// past it produces an error identifying the operator.
+func TestMonitorRegistryDiskLimit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	st := flowCtx.Cfg.Settings
+	flowCtx.Cfg.TestingKnobs = execinfra.TestingKnobs{ForceDiskSpill: true}
+	queueCfg, cleanupQueueCfg := colcontainerutils.NewTestingDiskQueueCfg(t, true /* inMem */)
+	defer cleanupQueueCfg()
+	// Ensure that each batch is written to a separate file.
+	queueCfg.MaxFileSizeBytes = 1
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	const limit = 4 << 10 /* 4 KiB */
+	diskAcc := r.CreateDiskAccountWithLimit(ctx, flowCtx, limit, "hash-joiner", 1 /* processorID */)
+	operatorDiskLimit.Override(ctx, &st.SV, 1<<20)
+	defaultDiskAcc := r.CreateDiskAccountWithLimit(
+		ctx, flowCtx, 0 /* limit */, "hash-joiner", 2, /* processorID */
+	)
+	require.Equal(t, int64(limit), diskAcc.Monitor().Limit())
+	require.Equal(t, int64(1<<20), defaultDiskAcc.Monitor().Limit())
+	require.Equal(t, mon.DiskResource, diskAcc.Monitor().Resource())
+	r.AssertInvariants()
+
+	memAcc := r.NewStreamingMemAccount(flowCtx)
+	allocator := colmem.NewAllocator(ctx, memAcc, coldata.StandardColumnFactory)
+	rng, _ := randutil.NewTestRand()
+	batch := coldatatestutils.RandomBatch(
+		allocator, coldatatestutils.RandomVecArgs{Rand: rng}, types.OneIntCol,
+		coldata.BatchSize(), coldata.BatchSize(),
+	)
+	q, err := colcontainer.NewDiskQueue(ctx, types.OneIntCol, queueCfg, diskAcc, memAcc)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, q.Close(ctx)) }()
+	for i := 0; err == nil; i++ {
+		require.Less(t, i, 100, "the disk limit wasn't enforced")
+		err = q.Enqueue(ctx, batch)
+	}
+	require.Equal(t, pgcode.DiskFull, pgerror.GetPGCode(err))
+	require.Contains(t, err.Error(), "hash-joiner-1-disk-limited-0")
+	require.Contains(t, err.Error(), "disk budget exceeded")
+}
+
+// TestMonitorRegistrySpillStrategyAccounts verifies that the accounts bound to
+// the same spill strategy monitor share its limit, including when
+// ForceDiskSpill is set.
+func TestMonitorRegistrySpillStrategyAccounts(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	evalCtx := eval.MakeTestingEvalContext(st)
+	defer evalCtx.Stop(ctx)
+	memAllocated := evalCtx.TestingMon.AllocBytes()
+
+	chunk := mon.DefaultPoolAllocationSize
+	for _, forceDiskSpill := range []bool{false, true} {
+		t.Run(fmt.Sprintf("forceDiskSpill=%t", forceDiskSpill), func(t *testing.T) {
+			knobs := execinfra.TestingKnobs{ForceDiskSpill: forceDiskSpill}
+			if !forceDiskSpill {
+				knobs.MemoryLimitBytes = 3 * chunk
+			}
+			flowCtx := &execinfra.FlowCtx{
+				EvalCtx: &evalCtx,
+				Mon:     evalCtx.TestingMon,
+				Cfg:     &execinfra.ServerConfig{Settings: st, TestingKnobs: knobs},
+			}
+			for grown := 0; grown < 3; grown++ {
+				var r MonitorRegistry
+				accounts, name := r.CreateMemAccountsForSpillStrategy(
+					ctx, flowCtx, "hash-joiner", 1 /* processorID */, 3, /* numAccounts */
+				)
+				require.Len(t, accounts, 3)
+				require.Equal(t, redact.RedactableString("hash-joiner-1-limited-0"), name)
+				for _, acc := range accounts {
+					require.Same(t, r.GetMonitorByName(name), acc.Monitor())
+				}
+				r.AssertInvariants()
+				if forceDiskSpill {
+					require.Equal(t, int64(1), r.GetMonitorByName(name).Limit())
+				} else {
+					// Each account takes up a third of the limit, and the
+					// account grown exceeds it.
+					for i, acc := range accounts {
+						if i != grown {
+							require.NoError(t, acc.Grow(ctx, chunk))
+						}
+					}
+					require.NoError(t, accounts[grown].Grow(ctx, chunk))
+				}
+				err := accounts[grown].Grow(ctx, 1)
+				require.True(t, sqlerrors.IsOutOfMemoryError(err))
+				require.Contains(t, err.Error(), string(name))
+				// All the accounts are closed with the registry.
+				r.Close(ctx)
+				require.Equal(t, memAllocated, evalCtx.TestingMon.AllocBytes())
+			}
+		})
+	}
+}
+
+// TestMonitorRegistryUnlimitedMemAccountsWithName verifies that the monitor
+// named by the caller is created once, until it's closed or the registry is
+// reset, with the accounts requested afterwards bound to it.
+func TestMonitorRegistryUnlimitedMemAccountsWithName(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	memAllocated := flowCtx.Mon.AllocBytes()
+
+	var r MonitorRegistry
+	m, accounts := r.CreateUnlimitedMemAccountsWithName(
+		ctx, flowCtx, "hash-router-1", 2, /* numAccounts */
+	)
+	require.Equal(t, "hash-router-1-unlimited", m.Name())
+	require.Len(t, accounts, 2)
+	reused, moreAccounts := r.CreateUnlimitedMemAccountsWithName(
+		ctx, flowCtx, "hash-router-1", 3, /* numAccounts */
+	)
+	require.Same(t, m, reused)
+	require.Len(t, moreAccounts, 3)
+	other, _ := r.CreateUnlimitedMemAccountsWithName(
+		ctx, flowCtx, "hash-router-2", 1, /* numAccounts */
+	)
+	require.NotSame(t, m, other)
+	require.Len(t, r.GetMonitors(), 2)
+	r.AssertInvariants()
+	for _, acc := range append(accounts, moreAccounts...) {
+		require.Same(t, m, acc.Monitor())
+		require.NoError(t, acc.Grow(ctx, 10))
+	}
+	r.Close(ctx)
+	require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+
+	// Once closed, the monitor isn't reused, and the accounts are bound to a
+	// new one with the same name instead.
+	reopened, reopenedAccounts := r.CreateUnlimitedMemAccountsWithName(
+		ctx, flowCtx, "hash-router-1", 1, /* numAccounts */
+	)
+	require.NotSame(t, m, reopened)
+	require.Equal(t, "hash-router-1-unlimited", reopened.Name())
+	require.Same(t, reopened, r.GetMonitorByName("hash-router-1-unlimited"))
+	require.Same(t, reopened, reopenedAccounts[0].Monitor())
+	require.NoError(t, reopenedAccounts[0].Grow(ctx, 10))
+	r.AssertInvariants()
+	r.Close(ctx)
+	require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+
+	// Once reset, the monitor is created afresh.
+	r.Reset()
+	fresh, _ := r.CreateUnlimitedMemAccountsWithName(
+		ctx, flowCtx, "hash-router-1", 1, /* numAccounts */
+	)
+	require.NotSame(t, m, fresh)
+	require.Equal(t, "hash-router-1-unlimited", fresh.Name())
+	require.Len(t, r.GetMonitors(), 1)
+	r.AssertInvariants()
+	r.Close(ctx)
+}
+
+// TestMonitorRegistryOnBudgetExceeded verifies that the callback is invoked
+// once per limited monitor created after it's set, except for those limited
+// by ForceDiskSpill.
+func TestMonitorRegistryOnBudgetExceeded(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	evalCtx := eval.MakeTestingEvalContext(st)
+	defer evalCtx.Stop(ctx)
+	newFlowCtx := func(knobs execinfra.TestingKnobs) *execinfra.FlowCtx {
+		return &execinfra.FlowCtx{
+			EvalCtx: &evalCtx,
+			Mon:     evalCtx.TestingMon,
+			Cfg:     &execinfra.ServerConfig{Settings: st, TestingKnobs: knobs},
+		}
+	}
+	chunk := mon.DefaultPoolAllocationSize
+	flowCtx := newFlowCtx(execinfra.TestingKnobs{MemoryLimitBytes: chunk})
+	forceDiskSpillFlowCtx := newFlowCtx(execinfra.TestingKnobs{ForceDiskSpill: true})
+
+	type exceeded struct {
+		name             redact.RedactableString
+		requested, limit int64
+	}
+	var calls []exceeded
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	unwatchedAcc, _ := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+	r.SetOnBudgetExceeded(func(name redact.RedactableString, requested, limit int64) {
+		calls = append(calls, exceeded{name: name, requested: requested, limit: limit})
+	})
+	acc, name := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 2 /* processorID */)
+	strictAcc, strictName := r.CreateMemAccountForStrictLimit(
+		ctx, flowCtx, chunk /* limit */, "window", 3, /* processorID */
+	)
+	forcedAcc, _ := r.CreateMemAccountForSpillStrategy(
+		ctx, forceDiskSpillFlowCtx, "sorter", 4, /* processorID */
+	)
+	for _, account := range []*mon.BoundAccount{unwatchedAcc, acc, strictAcc, forcedAcc} {
+		// The accounts are grown past the limit twice, as a disk spiller
+		// retrying would.
+		for i := 0; i < 2; i++ {
+			require.True(t, sqlerrors.IsOutOfMemoryError(account.Grow(ctx, 2*chunk)))
+		}
+	}
+	require.Equal(t, []exceeded{
+		{name: name, requested: 2 * chunk, limit: chunk},
+		{name: strictName, requested: 2 * chunk, limit: chunk},
+	}, calls)
+
+	// The callback is unset by Reset.
+	r.Close(ctx)
+	r.Reset()
+	calls = nil
+	acc, _ = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+	require.Error(t, acc.Grow(ctx, 2*chunk))
+	require.Empty(t, calls)
+}
+
+// BenchmarkMonitorRegistryLookup compares looking up the monitors of a registry
+// with 500 of them by name against scanning them, as
+// CreateExtraMemAccountForSpillStrategy previously did.
+func BenchmarkMonitorRegistryLookup(b *testing.B) {
+	defer log.Scope(b).Close(b)
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	const numMonitors = 500
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	names := make([]redact.RedactableString, numMonitors)
+	for i := range names {
+		_, names[i] = r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "op", int32(i))
+	}
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			name := string(names[i%numMonitors])
+			monitors := r.GetMonitors()
+			for j := len(monitors) - 1; j >= 0; j-- {
+				if monitors[j].Name() == name {
+					break
+				}
+			}
+		}
+	})
+	b.Run("by name", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if r.GetMonitorByName(names[i%numMonitors]) == nil {
+				b.Fatal("monitor not found")
+			}
+		}
+	})
+}
+
+// TestMonitorRegistrySafeFormat verifies the description of a registry with a
+// monitor of each kind, before and after it's closed.
+func TestMonitorRegistrySafeFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	require.Equal(t, "monitor registry: empty", r.String())
+
+	limitedAcc, _ := r.CreateMemAccountForSpillStrategyWithLimit(
+		ctx, flowCtx, 1<<20 /* limit */, "sorter", 1, /* processorID */
+	)
+	unlimitedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	diskAcc := r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	r.CreateUnlimitedMemAccountsWithName(ctx, flowCtx, "hash-router-1", 2 /* numAccounts */)
+	r.CreateMemAccountForStrictLimit(
+		ctx, flowCtx, 1<<20 /* limit */, "hash-joiner", 2, /* processorID */
+	)
+	r.CreateDiskAccountWithLimit(ctx, flowCtx, 4<<10 /* limit */, "hash-joiner", 2 /* processorID */)
+	r.CreateDiskAccounts(ctx, flowCtx, "spilled-queue", 1 /* numAccounts */)
+	// The monitors of the same operator are grouped even if they weren't
+	// created consecutively, apart from those of other processors.
+	r.CreateDiskMonitor(ctx, flowCtx, "sorter", 1 /* processorID */)
+	r.CreateDiskMonitor(ctx, flowCtx, "sorter", 3 /* processorID */)
+	chunk := mon.DefaultPoolAllocationSize
+	require.NoError(t, limitedAcc.Grow(ctx, chunk))
+	require.NoError(t, unlimitedAcc.Grow(ctx, 2*chunk))
+	require.NoError(t, diskAcc.Grow(ctx, 3*chunk))
+
+	const expected = "monitor registry:" +
+		" sorter-1:" +
+		" {sorter-1-limited-0 (limit 1.0 MiB, allocated %[1]s, parent test-monitor, accounts 1)}" +
+		" {sorter-1-unlimited-1 (unlimited, allocated %[2]s, parent test-monitor, accounts 1)}" +
+		" {sorter-1-disk-2 (disk, unlimited, allocated %[3]s, parent test-disk, accounts 1)}" +
+		" {sorter-1-disk-7 (disk, unlimited, allocated 0 B, parent test-disk, accounts 0)};" +
+		" unattributed:" +
+		" {hash-router-1-unlimited (unlimited, allocated 0 B, parent test-monitor, accounts 2)}" +
+		" {spilled-queue (disk, unlimited, allocated 0 B, parent test-disk, accounts 1)};" +
+		" hash-joiner-2:" +
+		" {hash-joiner-2-strict-4 (limit 1.0 MiB, allocated 0 B, parent test-monitor, accounts 1)}" +
+		" {hash-joiner-2-disk-limited-5" +
+		" (disk, limit 4.0 KiB, allocated 0 B, parent test-disk, accounts 1)};" +
+		" sorter-3:" +
+		" {sorter-3-disk-8 (disk, unlimited, allocated 0 B, parent test-disk, accounts 0)}"
+	require.Equal(t, fmt.Sprintf(expected, "10 KiB", "20 KiB", "30 KiB"), r.String())
+	// Nothing is redacted.
+	require.Equal(t, r.String(), string(redact.Sprint(&r).Redact()))
+
+	// The stopped monitors are described as well.
+	r.Close(ctx)
+	require.Equal(t, fmt.Sprintf(expected, "0 B", "0 B", "0 B"), r.String())
+}
+
+// TestMonitorRegistryInjectAllocationFailure verifies that the growth of the
+// accounts bound to the monitors selected by the InjectMonitorAllocationFailure
+// testing knob fails after the configured number of successful ones, with an
+// error naming the monitor, for each kind of account.
+func TestMonitorRegistryInjectAllocationFailure(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	flowCtx.Cfg.TestingKnobs = execinfra.TestingKnobs{
+		InjectMonitorAllocationFailure: &execinfra.InjectMonitorAllocationFailure{
+			MonitorNameRegexp: regexp.MustCompile("^sorter-1-"),
+			AfterNGrows:       2,
+		},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		create  func(r *MonitorRegistry) *mon.BoundAccount
+		expCode pgcode.Code
+	}{
+		{
+			name: "spill strategy",
+			create: func(r *MonitorRegistry) *mon.BoundAccount {
+				acc, _ := r.CreateMemAccountForSpillStrategyWithLimit(
+					ctx, flowCtx, 1<<20 /* limit */, "sorter", 1, /* processorID */
+				)
+				return acc
+			},
+			expCode: pgcode.OutOfMemory,
+		},
+		{
+			name: "unlimited",
+			create: func(r *MonitorRegistry) *mon.BoundAccount {
+				return r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+			},
+			expCode: pgcode.OutOfMemory,
+		},
+		{
+			name: "disk",
+			create: func(r *MonitorRegistry) *mon.BoundAccount {
+				return r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+			},
+			expCode: pgcode.DiskFull,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r MonitorRegistry
+			defer r.Close(ctx)
+			acc := tc.create(&r)
+			// The monitors of other operators aren't affected.
+			otherAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 2 /* processorID */)
+			for i := 0; i < 5; i++ {
+				require.NoError(t, otherAcc.Grow(ctx, 1))
+			}
+
+			require.NoError(t, acc.Grow(ctx, 1))
+			require.NoError(t, acc.Grow(ctx, 1))
+			err := acc.Grow(ctx, 1)
+			require.Error(t, err)
+			require.Equal(t, tc.expCode, pgerror.GetPGCode(err))
+			require.Contains(t, err.Error(), acc.Monitor().Name()+": ")
+			require.Equal(t, int64(2), acc.Used())
+			// Only the third growth is failed.
+			require.NoError(t, acc.Grow(ctx, 1))
+		})
+	}
+}
+
+// TestMonitorRegistryForceDiskSpillOpNameRegexp verifies that ForceDiskSpill
+// can be restricted to some operators, the others keeping their normal limit.
+func TestMonitorRegistryForceDiskSpillOpNameRegexp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	flowCtx.Cfg.TestingKnobs = execinfra.TestingKnobs{
+		ForceDiskSpill:             true,
+		ForceDiskSpillOpNameRegexp: regexp.MustCompile("^sort"),
+	}
+	require.True(t, execinfra.ForceDiskSpill(flowCtx, "sort-all"))
+	require.False(t, execinfra.ForceDiskSpill(flowCtx, "hash-joiner"))
+	workMemLimit := execinfra.GetWorkMemLimit(flowCtx)
+	require.NotEqual(t, int64(1), workMemLimit)
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	sorterAcc, _ := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "sort-all", 1, /* processorID */
+	)
+	hashJoinerAcc, _ := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "hash-joiner", 2, /* processorID */
+	)
+	// The custom limit of the operators forced to spill is overridden.
+	sortChunksAcc, _ := r.CreateMemAccountForSpillStrategyWithLimit(
+		ctx, flowCtx, 1<<20 /* limit */, "sort-chunks", 3, /* processorID */
+	)
+	hashAggregatorAcc, _ := r.CreateMemAccountForSpillStrategyWithLimit(
+		ctx, flowCtx, 1<<20 /* limit */, "hash-aggregator", 4, /* processorID */
+	)
+	require.Equal(t, int64(1), sorterAcc.Monitor().Limit())
+	require.Equal(t, workMemLimit, hashJoinerAcc.Monitor().Limit())
+	require.Equal(t, int64(1), sortChunksAcc.Monitor().Limit())
+	require.Equal(t, int64(1<<20), hashAggregatorAcc.Monitor().Limit())
+
+	// Only the sorters spill.
+	for _, acc := range []*mon.BoundAccount{sorterAcc, sortChunksAcc} {
+		err := acc.Grow(ctx, 1)
+		require.True(t, sqlerrors.IsOutOfMemoryError(err))
+		require.Contains(t, err.Error(), acc.Monitor().Name())
+	}
+	for _, acc := range []*mon.BoundAccount{hashJoinerAcc, hashAggregatorAcc} {
+		require.NoError(t, acc.Grow(ctx, 1))
+	}
+}
+
+// TestMonitorRegistryRelease verifies that the released registries don't keep
+// the components of prior flows alive, and that releasing a registry before
+// closing it is caught in test builds.
+func TestMonitorRegistryRelease(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	skip.UnderNonTestBuild(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	r := GetMonitorRegistry()
+	r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+	err := colexecerror.CatchVectorizedRuntimeError(r.Release)
+	require.True(t, errors.HasAssertionFailure(err))
+	require.Contains(t, err.Error(), "released with 2 accounts and 2 monitors not closed")
+
+	r.Close(ctx)
+	accounts := r.mu.accounts[:cap(r.mu.accounts)]
+	monitors := r.mu.monitors[:cap(r.mu.monitors)]
+	info := r.mu.info[:cap(r.mu.info)]
+	r.Release()
+	for _, acc := range accounts {
+		require.Nil(t, acc)
+	}
+	for _, m := range monitors {
+		require.Nil(t, m)
+	}
+	for i := range info {
+		require.Nil(t, info[i].parentMon)
+		require.Nil(t, info[i].flowParent)
+	}
+}
+
+// BenchmarkMonitorRegistrySetup measures the allocations of the monitoring
+// infrastructure of a flow with a few operators, with a fresh registry for
+// each flow and with one obtained from the pool.
+func BenchmarkMonitorRegistrySetup(b *testing.B) {
+	defer log.Scope(b).Close(b)
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	const numOperators = 4
+	setup := func(r *MonitorRegistry) {
+		for i := int32(0); i < numOperators; i++ {
+			r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", i)
+			r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", i)
+			r.NewStreamingMemAccount(flowCtx)
+		}
+		r.Close(ctx)
+	}
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			setup(&MonitorRegistry{})
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := GetMonitorRegistry()
+			setup(r)
+			r.Release()
+		}
+	})
+}
+
+// TestMonitorRegistryLazyStart verifies that the monitors for the spill
+// strategies and the unlimited ones are only started (and registered with
+// flowCtx.Mon) once one of their accounts is grown, while their names are
+// reserved right away.
+func TestMonitorRegistryLazyStart(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	memAllocated := flowCtx.Mon.AllocBytes()
+	// children returns the names of the monitors created by the registry
+	// among the descendants of flowCtx.Mon.
+	children := func() []string {
+		var names []string
+		require.NoError(t, flowCtx.Mon.TraverseTree(func(s mon.MonitorState) error {
+			if strings.HasPrefix(s.Name, "sorter-") {
+				names = append(names, s.Name)
+			}
+			return nil
+		}))
+		return names
+	}
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	_, limitedName := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "sorter", 1, /* processorID */
+	)
+	unlimitedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 2 /* processorID */)
+	require.Empty(t, children())
+	require.Equal(t, execinfra.GetWorkMemLimit(flowCtx), r.GetMonitorByName(limitedName).Limit())
+	r.AssertInvariants()
+
+	extraAcc, err := r.CreateExtraMemAccountForSpillStrategy(limitedName)
+	require.NoError(t, err)
+	require.NoError(t, extraAcc.Grow(ctx, 1))
+	require.NoError(t, unlimitedAcc.Grow(ctx, 1))
+	require.ElementsMatch(t, []string{string(limitedName), "sorter-1-unlimited-1"}, children())
+	r.AssertInvariants()
+
+	// The monitors that were never started are closed as well.
+	r.Close(ctx)
+	require.Empty(t, children())
+	require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+}
+
+// BenchmarkMonitorRegistryLazyStart measures the setup of the monitoring
+// infrastructure of a plan with many operators that never buffer anything.
+func BenchmarkMonitorRegistryLazyStart(b *testing.B) {
+	defer log.Scope(b).Close(b)
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	const numOperators = 50
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := GetMonitorRegistry()
+		for op := int32(0); op < numOperators; op++ {
+			r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", op)
+			r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", op)
+		}
+		r.Close(ctx)
+		r.Release()
+	}
+}
+
+// TestMonitorRegistryReservation verifies that the memory reserved for an
+// account is taken from flowCtx.Mon up front, used before any further
+// allocation, and returned on Close whether it's been used or not, and that a
+// reservation that can't be made results in an error.
+func TestMonitorRegistryReservation(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	evalCtx := eval.MakeTestingEvalContext(st)
+	defer evalCtx.Stop(ctx)
+	chunk := mon.DefaultPoolAllocationSize
+	flowMon := mon.NewMonitorInheritWithLimit(
+		"flow", 10*chunk /* limit */, evalCtx.TestingMon, false, /* longLiving */
+	)
+	flowMon.StartNoReserved(ctx, evalCtx.TestingMon)
+	defer flowMon.Stop(ctx)
+	flowCtx := &execinfra.FlowCtx{
+		EvalCtx: &evalCtx,
+		Mon:     flowMon,
+		Cfg:     &execinfra.ServerConfig{Settings: st},
+	}
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	acc, err := r.CreateMemAccountWithReservation(
+		ctx, flowCtx, "scan", 1 /* processorID */, 3*chunk, /* reservation */
+	)
+	require.NoError(t, err)
+	unusedAcc, err := r.CreateMemAccountWithReservation(
+		ctx, flowCtx, "scan", 2 /* processorID */, 2*chunk, /* reservation */
+	)
+	require.NoError(t, err)
+	require.Zero(t, unusedAcc.Used())
+	require.Equal(t, 5*chunk, flowMon.AllocBytes())
+
+	// The allocations within the reservation don't take more from flowCtx.Mon,
+	// while those beyond it do (by as much as each reserves).
+	require.NoError(t, acc.Grow(ctx, 2*chunk))
+	require.Equal(t, 5*chunk, flowMon.AllocBytes())
+	require.NoError(t, acc.Grow(ctx, 2*chunk))
+	require.Equal(t, 7*chunk, flowMon.AllocBytes())
+
+	// The remaining budget of flowCtx.Mon is 3 chunks.
+	_, err = r.CreateMemAccountWithReservation(
+		ctx, flowCtx, "scan", 3 /* processorID */, 5*chunk, /* reservation */
+	)
+	require.True(t, sqlerrors.IsOutOfMemoryError(err))
+	require.Contains(t, err.Error(), "reserving memory for scan-3-reserved-2")
+	require.Len(t, r.GetMonitors(), 2)
+	require.Equal(t, 7*chunk, flowMon.AllocBytes())
+	r.AssertInvariants()
+
+	// Both reservations are returned on Close.
+	r.Close(ctx)
+	require.Zero(t, flowMon.AllocBytes())
+}
+
+// TestMonitorRegistryUnlimitedAggregateLimit verifies that the unlimited
+// accounts created once the aggregate limit is set are subject to it jointly,
+// and that the aggregate monitor is stopped on Close.
+func TestMonitorRegistryUnlimitedAggregateLimit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	memAllocated := flowCtx.Mon.AllocBytes()
+	chunk := mon.DefaultPoolAllocationSize
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	// The accounts created before the limit is set aren't subject to it.
+	uncappedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	r.SetUnlimitedAggregateLimit(3 * chunk)
+	accounts := r.CreateUnlimitedMemAccounts(
+		ctx, flowCtx, "hash-joiner", 2 /* processorID */, 1, /* numAccounts */
+	)
+	accounts = append(accounts, r.CreateUnlimitedMemAccount(
+		ctx, flowCtx, "hash-joiner", 3, /* processorID */
+	))
+	// Each of the accounts has its own monitor, under the aggregate one.
+	require.NotSame(t, accounts[0].Monitor(), accounts[1].Monitor())
+	r.AssertInvariants()
+
+	require.NoError(t, uncappedAcc.Grow(ctx, 5*chunk))
+	require.NoError(t, accounts[0].Grow(ctx, 2*chunk))
+	err := accounts[1].Grow(ctx, 2*chunk)
+	require.True(t, sqlerrors.IsOutOfMemoryError(err))
+	aggregateName := "flow " + flowCtx.ID.Short() + "-unlimited"
+	require.Contains(t, err.Error(), aggregateName+": memory budget exceeded")
+	require.NoError(t, accounts[1].Grow(ctx, chunk))
+
+	r.Close(ctx)
+	require.Equal(t, memAllocated, flowCtx.Mon.AllocBytes())
+	// The monitors created under the aggregate one remain valid once it's
+	// stopped.
+	r.AssertInvariants()
+
+	// Once reset, the accounts aren't subject to the limit.
+	r.Reset()
+	acc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "hash-joiner", 2 /* processorID */)
+	require.NoError(t, acc.Grow(ctx, 5*chunk))
+	r.Close(ctx)
+}
+
+// TestMonitorRegistryMonitorNames verifies the format of the generated monitor
+// names, which the disk spillers rely on, and that generating a name doesn't
+// affect those generated before.
+func TestMonitorRegistryMonitorNames(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	var names []redact.RedactableString
+	for i := 0; i < 12; i++ {
+		_, name := r.CreateMemAccountForSpillStrategy(
+			ctx, flowCtx, "hash-joiner", 1234, /* processorID */
+		)
+		names = append(names, name)
+	}
+	r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 7 /* processorID */)
+	for i, name := range names {
+		expected := fmt.Sprintf("hash-joiner-1234-limited-%d", i)
+		require.Equal(t, redact.RedactableString(expected), name)
+	}
+	require.NotNil(t, r.GetMonitorByName("sorter-7-unlimited-12"))
+}
+
+// BenchmarkMonitorRegistryCreateAccounts measures the allocations of creating
+// the spill strategy accounts of a flow with many operators.
+func BenchmarkMonitorRegistryCreateAccounts(b *testing.B) {
+	defer log.Scope(b).Close(b)
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	const numAccounts = 100
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := GetMonitorRegistry()
+		for op := int32(0); op < numAccounts; op++ {
+			r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "hash-joiner", op)
+		}
+		r.Close(ctx)
+		r.Release()
+	}
+}
+
+// TestMonitorRegistryAssertInvariants verifies that AssertInvariants catches
+// the accounts bound to unregistered monitors, the monitors created under the
+// wrong monitor of the flow, and the monitors registered twice.
+func TestMonitorRegistryAssertInvariants(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	for _, tc := range []struct {
+		name string
+		// violate breaks an invariant of the registry, with its mutex held.
+		violate  func(r *MonitorRegistry)
+		expected string
+	}{
+		{
+			name: "account bound to unregistered monitor",
+			violate: func(r *MonitorRegistry) {
+				m := execinfra.NewMonitor(ctx, flowCtx.Mon, "bad-account")
+				acc := m.MakeBoundAccount()
+				r.addAccountLocked(&acc, m)
+			},
+			expected: `account bound to unregistered monitor "bad-account"`,
+		},
+		{
+			name: "disk monitor created under the memory monitor",
+			violate: func(r *MonitorRegistry) {
+				m := execinfra.NewMonitor(ctx, flowCtx.Mon, "bad-disk")
+				r.addMonitorLocked(flowCtx, "bad-disk", m, flowCtx.Mon, DiskMonitor, operator{})
+			},
+			expected: `monitor "bad-disk" created under "test-monitor", expected "test-disk"`,
+		},
+		{
+			name: "memory monitor created under the disk monitor",
+			violate: func(r *MonitorRegistry) {
+				m := execinfra.NewMonitor(ctx, flowCtx.DiskMonitor, "bad-mem")
+				r.addMonitorLocked(
+					flowCtx, "bad-mem", m, flowCtx.DiskMonitor, UnlimitedMemoryMonitor, operator{},
+				)
+			},
+			expected: `monitor "bad-mem" created under "test-disk", expected "test-monitor"`,
+		},
+		{
+			name: "monitor registered twice",
+			violate: func(r *MonitorRegistry) {
+				m := r.mu.monitors[0]
+				r.registerMonitorLocked(redact.RedactableString(m.Name()), m, r.mu.info[0])
+			},
+			expected: `monitor "sorter-1-limited-0" registered twice`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r MonitorRegistry
+			r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+			r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+			r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+			r.AssertInvariants()
+
+			r.mu.Lock()
+			numMonitors, numAccounts := len(r.mu.monitors), len(r.mu.accounts)
+			tc.violate(&r)
+			r.mu.Unlock()
+			err := colexecerror.CatchVectorizedRuntimeError(r.AssertInvariants)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expected)
+
+			// Undo the violation so that the registry can be closed.
+			r.mu.Lock()
+			for _, m := range r.mu.monitors[numMonitors:] {
+				if m != r.mu.monitors[0] {
+					m.Stop(ctx)
+					delete(r.mu.byName, redact.RedactableString(m.Name()))
+				}
+			}
+			for _, m := range r.mu.accountMonitors[numAccounts:] {
+				m.Stop(ctx)
+			}
+			r.mu.monitors, r.mu.info = r.mu.monitors[:numMonitors], r.mu.info[:numMonitors]
+			r.mu.accounts = r.mu.accounts[:numAccounts]
+			r.mu.accountMonitors = r.mu.accountMonitors[:numAccounts]
+			r.mu.Unlock()
+			r.Close(ctx)
+		})
+	}
+}
+
+// TestMonitorRegistryCloseForProcessor verifies that CloseForProcessor closes
+// only the components created for the given processor, releasing their memory
+// right away, and that neither calling it again nor Close closes them again.
+func TestMonitorRegistryCloseForProcessor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	diskMonitor := flowCtx.DiskMonitor
+	chunk := mon.DefaultPoolAllocationSize
+	memBase, diskBase := flowCtx.Mon.AllocBytes(), diskMonitor.AllocBytes()
+
+	var r MonitorRegistry
+	limitedAcc, limitedName := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "sorter", 1, /* processorID */
+	)
+	unlimitedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	diskAcc := r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	otherAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "hash-joiner", 2 /* processorID */)
+	_, namedAccs := r.CreateUnlimitedMemAccountsWithName(
+		ctx, flowCtx, "hash-router", 1, /* numAccounts */
+	)
+	streamingAcc := r.NewStreamingMemAccount(flowCtx)
+	require.NoError(t, limitedAcc.Grow(ctx, 2*chunk))
+	require.NoError(t, unlimitedAcc.Grow(ctx, 3*chunk))
+	require.NoError(t, diskAcc.Grow(ctx, 4*chunk))
+	require.NoError(t, otherAcc.Grow(ctx, chunk))
+	require.NoError(t, namedAccs[0].Grow(ctx, chunk))
+	require.NoError(t, streamingAcc.Grow(ctx, chunk))
+	require.Equal(t, memBase+8*chunk, flowCtx.Mon.AllocBytes())
+	require.Equal(t, diskBase+4*chunk, diskMonitor.AllocBytes())
+
+	// The usage of the processor is released right away.
+	r.CloseForProcessor(ctx, 1 /* processorID */)
+	require.Equal(t, memBase+3*chunk, flowCtx.Mon.AllocBytes())
+	require.Equal(t, diskBase, diskMonitor.AllocBytes())
+	require.Zero(t, limitedAcc.Used())
+	// The peaks survive.
+	memPeak, diskPeak := r.PeakUsage("sorter", 1 /* processorID */)
+	require.Equal(t, 5*chunk, memPeak)
+	require.Equal(t, 4*chunk, diskPeak)
+	r.AssertInvariants()
+
+	// The other components are left intact.
+	require.NoError(t, otherAcc.Grow(ctx, chunk))
+	require.Equal(t, memBase+4*chunk, flowCtx.Mon.AllocBytes())
+	// Closing the processor again, or one without components, is a no-op.
+	r.CloseForProcessor(ctx, 1 /* processorID */)
+	r.CloseForProcessor(ctx, 3 /* processorID */)
+	require.Equal(t, memBase+4*chunk, flowCtx.Mon.AllocBytes())
+	// The components closed aren't detached.
+	_, _, ok := r.Detach(limitedName)
+	require.False(t, ok)
+
+	// Close closes the rest, and only the rest.
+	r.Close(ctx)
+	require.Equal(t, memBase, flowCtx.Mon.AllocBytes())
+	memPeak, diskPeak = r.PeakUsage("hash-joiner", 2 /* processorID */)
+	require.Equal(t, 2*chunk, memPeak)
+	require.Zero(t, diskPeak)
+	r.AssertInvariants()
+}
+
+// TestMonitorRegistryUsageGauges verifies that the usage gauges reflect the
+// usage of the monitors of the registries sharing them, and that they return to
+// zero once the registries are closed, regardless of the order the accounts
+// are closed in.
+func TestMonitorRegistryUsageGauges(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	registry := metric.NewRegistry()
+	memGauge := metric.NewGauge(metric.Metadata{Name: "mem"})
+	diskGauge := metric.NewGauge(metric.Metadata{Name: "disk"})
+	registry.AddMetric(memGauge)
+	registry.AddMetric(diskGauge)
+	chunk := mon.DefaultPoolAllocationSize
+
+	var r, other MonitorRegistry
+	r.SetUsageGauges(memGauge, diskGauge)
+	other.SetUsageGauges(memGauge, diskGauge)
+	limitedAcc, _ := r.CreateMemAccountForSpillStrategy(ctx, flowCtx, "sorter", 1 /* processorID */)
+	unlimitedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	diskAcc := r.CreateDiskAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	otherAcc := other.CreateUnlimitedMemAccount(ctx, flowCtx, "hash-joiner", 2 /* processorID */)
+	require.NoError(t, limitedAcc.Grow(ctx, 2*chunk))
+	require.NoError(t, unlimitedAcc.Grow(ctx, 3*chunk))
+	require.NoError(t, diskAcc.Grow(ctx, 4*chunk))
+	require.NoError(t, otherAcc.Grow(ctx, chunk))
+	// The gauges are only updated on a coarse cadence.
+	require.Zero(t, memGauge.Value())
+	r.UpdateMetrics()
+	other.UpdateMetrics()
+	require.Equal(t, 6*chunk, memGauge.Value())
+	require.Equal(t, 4*chunk, diskGauge.Value())
+	// MaybeUpdateMetrics doesn't update them again within the interval.
+	r.MaybeUpdateMetrics()
+	require.NoError(t, unlimitedAcc.Grow(ctx, chunk))
+	r.MaybeUpdateMetrics()
+	require.Equal(t, 6*chunk, memGauge.Value())
+	r.UpdateMetrics()
+	require.Equal(t, 7*chunk, memGauge.Value())
+
+	// The accounts are closed in a different order than they were created,
+	// some of them before the registry is closed.
+	unlimitedAcc.Close(ctx)
+	r.UpdateMetrics()
+	require.Equal(t, 3*chunk, memGauge.Value())
+	diskAcc.Shrink(ctx, chunk)
+	r.Close(ctx)
+	require.Equal(t, chunk, memGauge.Value())
+	require.Zero(t, diskGauge.Value())
+	// Updating the gauges once closed is a no-op.
+	r.UpdateMetrics()
+	require.Equal(t, chunk, memGauge.Value())
+	other.Close(ctx)
+	require.Zero(t, memGauge.Value())
+	require.Zero(t, diskGauge.Value())
+
+	// Without the gauges, updating the metrics is free.
+	r.Reset()
+	r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	require.Zero(t, testing.AllocsPerRun(10, r.UpdateMetrics))
+	r.Close(ctx)
+	require.Zero(t, memGauge.Value())
+}
+
+// TestMonitorRegistryUnlimitedMemAccountsWithParent verifies that the growth of
+// the accounts created with a custom parent is charged to that parent rather
+// than to the monitor of the flow, and that their monitor is registered.
+func TestMonitorRegistryUnlimitedMemAccountsWithParent(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	st := flowCtx.Cfg.Settings
+	flowUnlimitedMon := mon.NewUnlimitedMonitor(ctx, mon.Options{
+		Name:     "flow-unlimited",
+		Settings: st,
+	})
+	defer flowUnlimitedMon.Stop(ctx)
+	chunk := mon.DefaultPoolAllocationSize
+	memBase := flowCtx.Mon.AllocBytes()
+
+	var r MonitorRegistry
+	// Even under the aggregate limit of the unlimited monitors, the given
+	// parent is used.
+	r.SetUnlimitedAggregateLimit(chunk)
+	accs := r.CreateUnlimitedMemAccountsWithParent(
+		ctx, flowCtx, flowUnlimitedMon, "columnarizer", 2, /* numAccounts */
+	)
+	otherAccs := r.CreateUnlimitedMemAccountsWithParent(
+		ctx, flowCtx, flowUnlimitedMon, "columnarizer", 1, /* numAccounts */
+	)
+	require.Len(t, accs, 2)
+	require.NoError(t, accs[0].Grow(ctx, 2*chunk))
+	require.NoError(t, accs[1].Grow(ctx, 3*chunk))
+	require.NoError(t, otherAccs[0].Grow(ctx, chunk))
+	require.Equal(t, 6*chunk, flowUnlimitedMon.AllocBytes())
+	require.Equal(t, memBase, flowCtx.Mon.AllocBytes())
+
+	// The monitors are named uniquely, and registered.
+	require.Same(t, accs[0].Monitor(), r.GetMonitorByName("columnarizer-unlimited-0"))
+	require.Same(t, otherAccs[0].Monitor(), r.GetMonitorByName("columnarizer-unlimited-1"))
+	r.AssertInvariants()
+
+	r.Close(ctx)
+	require.Zero(t, flowUnlimitedMon.AllocBytes())
+	require.Nil(t, flowUnlimitedMon.TraverseTree(func(state mon.MonitorState) error {
+		if state.Level > 0 {
+			return errors.Newf("monitor %s not stopped", state.Name)
+		}
+		return nil
+	}))
+}
+
+// TestMonitorRegistryTrimIdle verifies that TrimIdle releases the bytes marked
+// as reclaimable of the accounts above the threshold, and only those, leaving
+// the other accounts and the ones for spill strategies alone.
+func TestMonitorRegistryTrimIdle(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	chunk := mon.DefaultPoolAllocationSize
+	memBase := flowCtx.Mon.AllocBytes()
+
+	var r MonitorRegistry
+	defer r.Close(ctx)
+	// The monitors keep some of the bytes released, so the accounts are grown
+	// by enough for the amounts released to reach the parent.
+	markedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 1 /* processorID */)
+	smallAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 2 /* processorID */)
+	unmarkedAcc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 3 /* processorID */)
+	spillAcc, _ := r.CreateMemAccountForSpillStrategy(
+		ctx, flowCtx, "hash-joiner", 4, /* processorID */
+	)
+	require.NoError(t, markedAcc.Grow(ctx, 30*chunk))
+	require.NoError(t, smallAcc.Grow(ctx, 2*chunk))
+	require.NoError(t, unmarkedAcc.Grow(ctx, 30*chunk))
+	require.NoError(t, spillAcc.Grow(ctx, 30*chunk))
+	require.Equal(t, memBase+92*chunk, flowCtx.Mon.AllocBytes())
+
+	// Nothing is trimmed until bytes are marked.
+	r.TrimIdle(ctx, 0 /* threshold */)
+	require.Equal(t, memBase+92*chunk, flowCtx.Mon.AllocBytes())
+
+	r.MarkReclaimable(markedAcc, 20*chunk)
+	r.MarkReclaimable(markedAcc, 5*chunk)
+	r.MarkReclaimable(smallAcc, 2*chunk)
+	r.MarkReclaimable(spillAcc, 30*chunk)
+	// The marked account is trimmed by the bytes marked, which keeps it above
+	// the threshold. The small account is below the threshold.
+	r.TrimIdle(ctx, 2*chunk /* threshold */)
+	require.Equal(t, 5*chunk, markedAcc.Used())
+	require.Equal(t, 2*chunk, smallAcc.Used())
+	require.Equal(t, 30*chunk, unmarkedAcc.Used())
+	require.Equal(t, 30*chunk, spillAcc.Used())
+	require.Equal(t, memBase+67*chunk, flowCtx.Mon.AllocBytes())
+
+	// The bytes marked are only released once.
+	r.TrimIdle(ctx, 0 /* threshold */)
+	require.Equal(t, 5*chunk, markedAcc.Used())
+	require.Zero(t, smallAcc.Used())
+	require.Equal(t, 30*chunk, unmarkedAcc.Used())
+	require.Equal(t, 30*chunk, spillAcc.Used())
+}
+
+// TestMonitorRegistryMetadata verifies that every creation path records the
+// metadata of the monitor created, and that they survive Close until Reset.
+func TestMonitorRegistryMetadata(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+
+	var r MonitorRegistry
+	for i, tc := range []struct {
+		name string
+		// create creates a monitor with the registry, returning it.
+		create   func() *mon.BytesMonitor
+		expected MonitorMetadata
+	}{
+		{
+			name: "spill strategy",
+			create: func() *mon.BytesMonitor {
+				acc, _ := r.CreateMemAccountForSpillStrategy(
+					ctx, flowCtx, "sorter", 1, /* processorID */
+				)
+				return acc.Monitor()
+			},
+			expected: MonitorMetadata{OpName: "sorter", ProcessorID: 1, Kind: LimitedMemoryMonitor},
+		},
+		{
+			name: "spill strategy accounts",
+			create: func() *mon.BytesMonitor {
+				accs, _ := r.CreateMemAccountsForSpillStrategy(
+					ctx, flowCtx, "hash-joiner", 2 /* processorID */, 2, /* numAccounts */
+				)
+				return accs[0].Monitor()
+			},
+			expected: MonitorMetadata{
+				OpName: "hash-joiner", ProcessorID: 2, Kind: LimitedMemoryMonitor,
+			},
+		},
+		{
+			name: "spill strategy with limit",
+			create: func() *mon.BytesMonitor {
+				acc, _ := r.CreateMemAccountForSpillStrategyWithLimit(
+					ctx, flowCtx, 1<<20 /* limit */, "sorter", 3, /* processorID */
+				)
+				return acc.Monitor()
+			},
+			expected: MonitorMetadata{OpName: "sorter", ProcessorID: 3, Kind: LimitedMemoryMonitor},
+		},
+		{
+			name: "strict limit",
+			create: func() *mon.BytesMonitor {
+				acc, _ := r.CreateMemAccountForStrictLimit(
+					ctx, flowCtx, 1<<20 /* limit */, "top-k", 4, /* processorID */
+				)
+				return acc.Monitor()
+			},
+			expected: MonitorMetadata{OpName: "top-k", ProcessorID: 4, Kind: LimitedMemoryMonitor},
+		},
+		{
+			name: "reservation",
+			create: func() *mon.BytesMonitor {
+				acc, err := r.CreateMemAccountWithReservation(
+					ctx, flowCtx, "hash-aggregator", 5 /* processorID */, 0, /* reservation */
+				)
+				require.NoError(t, err)
+				return acc.Monitor()
+			},
+			expected: MonitorMetadata{
+				OpName: "hash-aggregator", ProcessorID: 5, Kind: UnlimitedMemoryMonitor,
+			},
+		},
+		{
+			name: "unlimited",
+			create: func() *mon.BytesMonitor {
+				acc := r.CreateUnlimitedMemAccount(ctx, flowCtx, "sorter", 6 /* processorID */)
+				return acc.Monitor()
+			},
+			expected: MonitorMetadata{
+				OpName: "sorter", ProcessorID: 6, Kind: UnlimitedMemoryMonitor,
+			},
+		},
+		{
+			name: "unlimited with name",
+			create: func() *mon.BytesMonitor {
+				m, _ := r.CreateUnlimitedMemAccountsWithName(
+					ctx, flowCtx, "hash-router", 1, /* numAccounts */
+				)
+				return m
+			},
+			expected: MonitorMetadata{Kind: UnlimitedMemoryMonitor},
+		},
+		{
+			name: "unlimited with parent",
+			create: func() *mon.BytesMonitor {
+				accs := r.CreateUnlimitedMemAccountsWithParent(
+					ctx, flowCtx, flowCtx.Mon, "columnarizer", 1, /* numAccounts */
+				)
+				return accs[0].Monitor()
+			},
+			expected: MonitorMetadata{Kind: UnlimitedMemoryMonitor},
+		},
+		{
+			name: "disk monitor",
+			create: func() *mon.BytesMonitor {
+				return r.CreateDiskMonitor(ctx, flowCtx, "sorter", 7 /* processorID */)
+			},
+			expected: MonitorMetadata{OpName: "sorter", ProcessorID: 7, Kind: DiskMonitor},
+		},
+		{
+			name: "disk account",
+			create: func() *mon.BytesMonitor {
+				return r.CreateDiskAccount(ctx, flowCtx, "sorter", 8 /* processorID */).Monitor()
+			},
+			expected: MonitorMetadata{OpName: "sorter", ProcessorID: 8, Kind: DiskMonitor},
+		},
+		{
+			name: "disk account with limit",
+			create: func() *mon.BytesMonitor {
+				acc := r.CreateDiskAccountWithLimit(
+					ctx, flowCtx, 1<<20 /* limit */, "sorter", 9, /* processorID */
+				)
+				return acc.Monitor()
+			},
+			expected: MonitorMetadata{OpName: "sorter", ProcessorID: 9, Kind: DiskMonitor},
+		},
+		{
+			name: "disk accounts",
+			create: func() *mon.BytesMonitor {
+				m, _ := r.CreateDiskAccounts(ctx, flowCtx, "hash-router-disk", 1 /* numAccounts */)
+				return m
+			},
+			expected: MonitorMetadata{Kind: DiskMonitor},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := tc.create()
+			tc.expected.CreationIndex = i
+			metadata, ok := r.Metadata(m)
+			require.True(t, ok)
+			require.Equal(t, tc.expected, metadata)
+		})
+	}
+	r.AssertInvariants()
+
+	// Only the monitors created by the registry have metadata.
+	_, ok := r.Metadata(flowCtx.Mon)
+	require.False(t, ok)
+	// The metadata survive Close, until Reset.
+	monitors := r.GetMonitors()
+	r.Close(ctx)
+	for i, m := range monitors {
+		metadata, ok := r.Metadata(m)
+		require.True(t, ok)
+		require.Equal(t, i, metadata.CreationIndex)
+	}
+	r.Reset()
+	for _, m := range monitors {
+		_, ok := r.Metadata(m)
+		require.False(t, ok)
+	}
+}
+
+// TestMonitorRegistrySpillStrategyMonitor verifies that the allocations of the
+// child accounts created under the monitor for a spill strategy count toward
+// its limit as well as their own, and that the children must be closed before
+// the monitor is stopped by the registry.
+func TestMonitorRegistrySpillStrategyMonitor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	flowCtx, cleanup := newTestFlowCtx(ctx)
+	defer cleanup()
+	chunk := mon.DefaultPoolAllocationSize
+
+	var r MonitorRegistry
+	acc, m, name := r.CreateMemAccountAndMonitorForSpillStrategyWithLimit(
+		ctx, flowCtx, 10*chunk /* limit */, "hash-aggregator", 1, /* processorID */
+	)
+	require.Same(t, m, acc.Monitor())
+	require.Same(t, m, r.GetMonitorByName(name))
+	_, lazyM, lazyName := r.CreateMemAccountAndMonitorForSpillStrategy(
+		ctx, flowCtx, "hash-aggregator", 2, /* processorID */
+	)
+	require.Same(t, lazyM, r.GetMonitorByName(lazyName))
+
+	child := mon.NewMonitorInheritWithLimit("hash-table", 4*chunk, m, false /* longLiving */)
+	child.StartNoReserved(ctx, m)
+	childAcc := child.MakeBoundAccount()
+	// The child is bounded by its own limit.
+	require.NoError(t, childAcc.Grow(ctx, 4*chunk))
+	err := childAcc.Grow(ctx, chunk)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "hash-table: memory budget exceeded")
+	// Its allocations count toward the limit of the monitor.
+	require.NoError(t, acc.Grow(ctx, 6*chunk))
+	err = acc.Grow(ctx, chunk)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), string(name)+": memory budget exceeded")
+
+	if buildutil.CrdbTestBuild {
+		// The child must be stopped before the registry is closed.
+		err = colexecerror.CatchVectorizedRuntimeError(func() { r.Close(ctx) })
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "1 child monitors not stopped")
+	}
+	childAcc.Close(ctx)
+	child.Stop(ctx)
+	r.Close(ctx)
+}
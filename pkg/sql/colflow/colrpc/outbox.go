@@ -77,6 +77,9 @@ type Outbox struct {
 	// added into the span as Structured payload and returned to the gateway as
 	// execinfrapb.ProducerMetadata.
 	getStats func(context.Context) []*execinfrapb.ComponentStats
+	// updateMetrics, if set, is called after each batch is sent, see
+	// SetUpdateMetrics.
+	updateMetrics func()
 
 	// A copy of Run's caller ctx, with no StreamID tag.
 	// Used to pass a clean context to the input.Next.
@@ -121,6 +124,13 @@ func NewOutbox(
 	return o, nil
 }
 
+// SetUpdateMetrics sets the function called by the Outbox after each batch it
+// sends (e.g. to refresh the usage gauges of the flow, see
+// colexecargs.MonitorRegistry.MaybeUpdateMetrics).
+func (o *Outbox) SetUpdateMetrics(updateMetrics func()) {
+	o.updateMetrics = updateMetrics
+}
+
 func (o *Outbox) close(ctx context.Context) {
 	o.scratch.buf = nil
 	o.scratch.msg = nil
@@ -307,6 +317,9 @@ func (o *Outbox) sendBatches(
 				flowinfra.HandleStreamErr(ctx, "Send (batches)", err, flowCtxCancel, outboxCtxCancel)
 				return
 			}
+			if o.updateMetrics != nil {
+				o.updateMetrics()
+			}
 		}
 	})
 	return terminatedGracefully, errToSend
@@ -42,6 +42,11 @@ type FlowCoordinator struct {
 
 	// cancelFlow cancels the context of the flow.
 	cancelFlow context.CancelFunc
+
+	// updateMetrics, if set, is called every coldata.BatchSize() rows, see
+	// SetUpdateMetrics.
+	updateMetrics func()
+	numRows       int
 }
 
 var flowCoordinatorPool = sync.Pool{
@@ -87,6 +92,13 @@ var _ execopnode.OpNode = &FlowCoordinator{}
 var _ execinfra.Processor = &FlowCoordinator{}
 var _ execreleasable.Releasable = &FlowCoordinator{}
 
+// SetUpdateMetrics sets the function called by the FlowCoordinator every
+// coldata.BatchSize() rows it returns (e.g. to refresh the usage gauges of the
+// flow, see colexecargs.MonitorRegistry.MaybeUpdateMetrics).
+func (f *FlowCoordinator) SetUpdateMetrics(updateMetrics func()) {
+	f.updateMetrics = updateMetrics
+}
+
 // ChildCount is part of the execopnode.OpNode interface.
 func (f *FlowCoordinator) ChildCount(verbose bool) int {
 	return 1
@@ -129,6 +141,12 @@ func (f *FlowCoordinator) next() (rowenc.EncDatumRow, *execinfrapb.ProducerMetad
 			return nil, meta
 		}
 		if row != nil {
+			if f.updateMetrics != nil {
+				if f.numRows++; f.numRows >= coldata.BatchSize() {
+					f.numRows = 0
+					f.updateMetrics()
+				}
+			}
 			return row, nil
 		}
 		// Both row and meta are nil, so we transition to draining.
@@ -202,6 +220,10 @@ type BatchFlowCoordinator struct {
 
 	// cancelFlow cancels the context of the flow.
 	cancelFlow context.CancelFunc
+
+	// updateMetrics, if set, is called after each batch is pushed, see
+	// SetUpdateMetrics.
+	updateMetrics func()
 }
 
 var batchFlowCoordinatorPool = sync.Pool{
@@ -236,6 +258,13 @@ func NewBatchFlowCoordinator(
 var _ execopnode.OpNode = &BatchFlowCoordinator{}
 var _ execreleasable.Releasable = &BatchFlowCoordinator{}
 
+// SetUpdateMetrics sets the function called by the BatchFlowCoordinator after
+// each batch it pushes (e.g. to refresh the usage gauges of the flow, see
+// colexecargs.MonitorRegistry.MaybeUpdateMetrics).
+func (f *BatchFlowCoordinator) SetUpdateMetrics(updateMetrics func()) {
+	f.updateMetrics = updateMetrics
+}
+
 func (f *BatchFlowCoordinator) init(ctx context.Context) error {
 	return colexecerror.CatchVectorizedRuntimeError(func() {
 		f.input.Root.Init(ctx)
@@ -295,6 +324,9 @@ func (f *BatchFlowCoordinator) Run(ctx context.Context) {
 		case execinfra.ConsumerClosed:
 			return
 		}
+		if f.updateMetrics != nil {
+			f.updateMetrics()
+		}
 	}
 
 	// Collect the stats and get the trace if necessary.
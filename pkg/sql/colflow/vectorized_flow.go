@@ -612,7 +612,9 @@ type vectorizedFlowCreator struct {
 	// pools during the flow cleanup.
 	releasables []execreleasable.Releasable
 
-	monitorRegistry colexecargs.MonitorRegistry
+	// monitorRegistry is obtained from its pool for each flow and released
+	// along with the creator.
+	monitorRegistry *colexecargs.MonitorRegistry
 	diskQueueCfg    colcontainer.DiskQueueCfg
 	fdSemaphore     semaphore.Semaphore
 }
@@ -652,10 +654,18 @@ func newVectorizedFlowCreator(
 		opChains:          creator.opChains,
 		recordingStats:    recordingStats,
 		releasables:       creator.releasables,
-		monitorRegistry:   creator.monitorRegistry,
+		monitorRegistry:   colexecargs.GetMonitorRegistry(),
 		diskQueueCfg:      diskQueueCfg,
 		fdSemaphore:       fdSemaphore,
 	}
+	if cfg := flowBase.Cfg; cfg != nil && cfg.TestingKnobs.StrictMonitorRegistryLeakCheck {
+		creator.monitorRegistry.EnableStrictLeakCheck()
+	}
+	if cfg := flowBase.Cfg; cfg != nil && cfg.Metrics != nil {
+		creator.monitorRegistry.SetUsageGauges(
+			cfg.Metrics.VecCurBytesCount, cfg.Metrics.VecCurDiskBytesCount,
+		)
+	}
 	if componentCreator == nil {
 		// On the main code path, use the embedded component creator.
 		creator.remoteComponentCreator = creator.rcCreator
@@ -701,17 +711,16 @@ func (s *vectorizedFlowCreator) Release() {
 	for i := range s.releasables {
 		s.releasables[i] = nil
 	}
-	s.monitorRegistry.Reset()
+	s.monitorRegistry.Release()
 	*s = vectorizedFlowCreator{
 		streamIDToInputOp: s.streamIDToInputOp,
 		streamIDToSpecIdx: s.streamIDToSpecIdx,
 		// procIdxQueue is a slice of ints, so it's ok to just slice up to 0 to
 		// prime it for reuse.
-		procIdxQueue:    s.procIdxQueue[:0],
-		opChains:        s.opChains[:0],
-		closers:         s.closers[:0],
-		releasables:     s.releasables[:0],
-		monitorRegistry: s.monitorRegistry,
+		procIdxQueue: s.procIdxQueue[:0],
+		opChains:     s.opChains[:0],
+		closers:      s.closers[:0],
+		releasables:  s.releasables[:0],
 	}
 	vectorizedFlowCreatorPool.Put(s)
 }
@@ -738,6 +747,16 @@ func (s *vectorizedFlowCreator) addFlowCoordinator(f *FlowCoordinator) {
 	_ = s.f.SetProcessorsAndOutputs(s.processors[:1], s.outputs[:1])
 }
 
+// updateMetricsFn returns the function to be called by the roots of the flow at
+// batch boundaries to refresh the usage gauges of the monitor registry, nil if
+// the flow doesn't export them.
+func (s *vectorizedFlowCreator) updateMetricsFn() func() {
+	if cfg := s.f.Cfg; cfg == nil || cfg.Metrics == nil {
+		return nil
+	}
+	return s.monitorRegistry.MaybeUpdateMetrics
+}
+
 // setupRemoteOutputStream sets up a colrpc.Outbox that will operate according
 // to the given execinfrapb.StreamEndpointSpec. It will also drain all
 // MetadataSources in op.
@@ -760,6 +779,9 @@ func (s *vectorizedFlowCreator) setupRemoteOutputStream(
 	if err != nil {
 		return nil, err
 	}
+	if updateMetrics := s.updateMetricsFn(); updateMetrics != nil {
+		outbox.SetUpdateMetrics(updateMetrics)
+	}
 
 	s.numOutboxes++
 	run := func(ctx context.Context, flowCtxCancel context.CancelFunc) {
@@ -1090,6 +1112,9 @@ func (s *vectorizedFlowCreator) setupOutput(
 				s.f.GetBatchSyncFlowConsumer(),
 				s.f.GetCancelFlowFn(),
 			)
+			if updateMetrics := s.updateMetricsFn(); updateMetrics != nil {
+				s.batchFlowCoordinator.SetUpdateMetrics(updateMetrics)
+			}
 			// The flow coordinator is a root of its operator chain.
 			s.opChains = append(s.opChains, s.batchFlowCoordinator)
 			s.releasables = append(s.releasables, s.batchFlowCoordinator)
@@ -1113,6 +1138,9 @@ func (s *vectorizedFlowCreator) setupOutput(
 				input,
 				s.f.GetCancelFlowFn(),
 			)
+			if updateMetrics := s.updateMetricsFn(); updateMetrics != nil {
+				f.SetUpdateMetrics(updateMetrics)
+			}
 			// The flow coordinator is a root of its operator chain.
 			s.opChains = append(s.opChains, f)
 			// NOTE: we don't append f to s.releasables because addFlowCoordinator
@@ -1187,7 +1215,7 @@ func (s *vectorizedFlowCreator) setupFlow(
 				FDSemaphore:          s.fdSemaphore,
 				SemaCtx:              s.semaCtx,
 				Factory:              factory,
-				MonitorRegistry:      &s.monitorRegistry,
+				MonitorRegistry:      s.monitorRegistry,
 				TypeResolver:         &s.typeResolver,
 			}
 			numOldMonitors := len(s.monitorRegistry.GetMonitors())
@@ -262,6 +262,93 @@ func TestDrainOnlyInputDAG(t *testing.T) {
 	require.True(t, outboxCreated)
 }
 
+// BenchmarkVectorizedFlowSetup measures the setup of a small vectorized flow
+// (an inbox feeding an outbox through a noop) along with its cleanup and the
+// release of its creator, with the monitor registry of the flow obtained from
+// its pool (pooled) and, for comparison, allocated for every flow (unpooled).
+func BenchmarkVectorizedFlowSetup(b *testing.B) {
+	defer leaktest.AfterTest(b)()
+	defer log.Scope(b).Close(b)
+
+	st := cluster.MakeTestingClusterSettings()
+	evalCtx := eval.MakeTestingEvalContext(st)
+	ctx := context.Background()
+	defer evalCtx.Stop(ctx)
+
+	remoteStreams := []execinfrapb.StreamEndpointSpec{{Type: execinfrapb.StreamEndpointSpec_REMOTE}}
+	procs := []execinfrapb.ProcessorSpec{{
+		Input: []execinfrapb.InputSyncSpec{{
+			Streams:     remoteStreams,
+			ColumnTypes: intCols(1),
+		}},
+		Core: execinfrapb.ProcessorCoreUnion{Noop: &execinfrapb.NoopCoreSpec{}},
+		Output: []execinfrapb.OutputRouterSpec{{
+			Type:    execinfrapb.OutputRouterSpec_PASS_THROUGH,
+			Streams: remoteStreams,
+		}},
+		ResultTypes: intCols(1),
+	}}
+	componentCreator := callbackRemoteComponentCreator{
+		newOutboxFn: func(
+			allocator *colmem.Allocator,
+			converterMemAcc *mon.BoundAccount,
+			input colexecargs.OpWithMetaInfo,
+			typs []*types.T,
+		) (*colrpc.Outbox, error) {
+			return colrpc.NewOutbox(
+				&execinfra.FlowCtx{Gateway: false}, 0, /* processorID */
+				allocator, converterMemAcc, input, typs, nil, /* getStats */
+			)
+		},
+		newInboxFn: func(
+			allocator *colmem.Allocator, typs []*types.T, streamID execinfrapb.StreamID,
+		) (*colrpc.Inbox, error) {
+			return colrpc.NewInbox(allocator, typs, streamID)
+		},
+	}
+
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				flowBase := flowinfra.NewFlowBase(
+					execinfra.FlowCtx{
+						Cfg:     &execinfra.ServerConfig{Settings: st},
+						EvalCtx: &evalCtx,
+						Mon:     evalCtx.TestingMon,
+						NodeID:  base.TestingIDContainer,
+					},
+					nil,                     /* sp */
+					nil,                     /* flowReg */
+					&execinfra.RowChannel{}, /* rowSyncFlowConsumer */
+					nil,                     /* batchSyncFlowConsumer */
+					nil,                     /* localProcessors */
+					nil,                     /* localVectorSources */
+					nil,                     /* onFlowCleanupEnd */
+					"",                      /* statementSQL */
+				)
+				vfc := newVectorizedFlowCreator(
+					flowBase, componentCreator, false, /* recordingStats */
+					colcontainer.DiskQueueCfg{}, nil, /* fdSemaphore */
+				)
+				if !pooled {
+					// Allocate a registry for this flow, bypassing the pool.
+					vfc.monitorRegistry = &colexecargs.MonitorRegistry{}
+				}
+				if _, _, err := vfc.setupFlow(ctx, procs, flowinfra.FuseNormally); err != nil {
+					b.Fatal(err)
+				}
+				vfc.cleanup(ctx)
+				vfc.Release()
+			}
+		})
+	}
+}
+
 // TestVectorizedFlowTempDirectory tests a flow's interactions with the
 // temporary directory that will be used when spilling execution. Refer to
 // subtests for a more thorough explanation.
@@ -98,6 +98,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
@@ -228,6 +229,7 @@ var crdbInternal = virtualSchema{
 		catconstants.CrdbInternalPCRStreamSpansTableID:              crdbInternalPCRStreamSpansTable,
 		catconstants.CrdbInternalPCRStreamCheckpointsTableID:        crdbInternalPCRStreamCheckpointsTable,
 		catconstants.CrdbInternalLDRProcessorTableID:                crdbInternalLDRProcessorTable,
+		catconstants.CrdbInternalNodeSchedulerLatencyTableID:        crdbInternalNodeSchedulerLatencyTable,
 	},
 	validWithNoDatabaseContext: true,
 }
@@ -9292,3 +9294,46 @@ CREATE TABLE crdb_internal.logical_replication_node_processors (
 		return nil
 	},
 }
+
+var crdbInternalNodeSchedulerLatencyTable = virtualSchemaTable{
+	comment: `scheduler latency over the most recent sampling window (RAM, local node only)`,
+	schema: `
+CREATE TABLE crdb_internal.node_scheduler_latency (
+  node_id         INT NOT NULL,
+  p50             INTERVAL,
+  p99             INTERVAL,
+  window_duration INTERVAL,
+  sampled_at      TIMESTAMPTZ
+)`,
+	populate: func(ctx context.Context, p *planner, _ catalog.DatabaseDescriptor, addRow func(...tree.Datum) error) error {
+		if err := p.CheckPrivilege(ctx, syntheticprivilege.GlobalPrivilegeObject, privilege.VIEWCLUSTERMETADATA); err != nil {
+			return err
+		}
+		nodeID, _ := p.ExecCfg().NodeInfo.NodeID.OptionalNodeID() // zero if not available
+		// The values are NULL if the sampler isn't running or is yet to
+		// observe a full window. sampled_at is when the window ended, to detect
+		// staleness.
+		p50, p99, windowDuration, sampledAt := tree.DNull, tree.DNull, tree.DNull, tree.DNull
+		if snap, ok := schedulerlatency.Latest(); ok {
+			interval := func(d time.Duration) tree.Datum {
+				return tree.NewDInterval(
+					duration.MakeDuration(d.Nanoseconds(), 0 /* days */, 0 /* months */),
+					types.DefaultIntervalTypeMetadata,
+				)
+			}
+			p50, p99, windowDuration = interval(snap.P50), interval(snap.P99), interval(snap.Elapsed)
+			ts, err := tree.MakeDTimestampTZ(snap.At, time.Microsecond)
+			if err != nil {
+				return err
+			}
+			sampledAt = ts
+		}
+		return addRow(
+			tree.NewDInt(tree.DInt(nodeID)),
+			p50,
+			p99,
+			windowDuration,
+			sampledAt,
+		)
+	},
+}
@@ -30,6 +30,8 @@ type DistSQLMetrics struct {
 	VecOpenFDs                  *metric.Gauge
 	CurDiskBytesCount           *metric.Gauge
 	MaxDiskBytesHist            metric.IHistogram
+	VecCurBytesCount            *metric.Gauge
+	VecCurDiskBytesCount        *metric.Gauge
 	QueriesSpilled              *metric.Counter
 	SpilledBytesWritten         *metric.Counter
 	SpilledBytesRead            *metric.Counter
@@ -109,6 +111,18 @@ var (
 		Measurement: "Disk",
 		Unit:        metric.Unit_BYTES,
 	}
+	metaVecMemCurBytes = metric.Metadata{
+		Name:        "sql.mem.distsql.vec.current",
+		Help:        "Current memory usage of the monitors of vectorized operators for distsql",
+		Measurement: "Memory",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaVecDiskCurBytes = metric.Metadata{
+		Name:        "sql.disk.distsql.vec.current",
+		Help:        "Current disk usage of the monitors of vectorized operators for distsql",
+		Measurement: "Disk",
+		Unit:        metric.Unit_BYTES,
+	}
 	metaQueriesSpilled = metric.Metadata{
 		Name:        "sql.distsql.queries.spilled",
 		Help:        "Number of queries that have spilled to disk",
@@ -170,6 +184,8 @@ func MakeDistSQLMetrics(histogramWindow time.Duration) DistSQLMetrics {
 			MaxVal:       log10int64times1000,
 			SigFigs:      3,
 			BucketConfig: metric.MemoryUsage64MBBuckets}),
+		VecCurBytesCount:            metric.NewGauge(metaVecMemCurBytes),
+		VecCurDiskBytesCount:        metric.NewGauge(metaVecDiskCurBytes),
 		QueriesSpilled:              metric.NewCounter(metaQueriesSpilled),
 		SpilledBytesWritten:         metric.NewCounter(metaSpilledBytesWritten),
 		SpilledBytesRead:            metric.NewCounter(metaSpilledBytesRead),
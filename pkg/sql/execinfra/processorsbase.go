@@ -964,8 +964,9 @@ func NewMonitor(
 // limited memory monitor with the given name and start it. The returned monitor
 // must be closed. The limit is determined by SessionData.WorkMemLimit (stored
 // inside of the flowCtx) but overridden to 1 if
-// ServerConfig.TestingKnobs.ForceDiskSpill is set or
-// ServerConfig.TestingKnobs.MemoryLimitBytes if not.
+// ServerConfig.TestingKnobs.ForceDiskSpill is set (without
+// ForceDiskSpillOpNameRegexp) or ServerConfig.TestingKnobs.MemoryLimitBytes if
+// not.
 func NewLimitedMonitor(
 	ctx context.Context, parent *mon.BytesMonitor, flowCtx *FlowCtx, name redact.RedactableString,
 ) *mon.BytesMonitor {
@@ -14,6 +14,7 @@ package execinfra
 
 import (
 	"context"
+	"regexp"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
@@ -255,6 +256,14 @@ type TestingKnobs struct {
 	// Cannot be set together with MemoryLimitBytes.
 	ForceDiskSpill bool
 
+	// ForceDiskSpillOpNameRegexp, if set along with ForceDiskSpill, restricts
+	// it to the vectorized operators whose names (as given to the
+	// colexecargs.MonitorRegistry when creating their spill strategy memory
+	// accounts) match. The other operators, as well as the row-based
+	// processors, use their normal memory limit. It's compiled by the test
+	// setting it, so that a bad pattern fails the test rather than the flows.
+	ForceDiskSpillOpNameRegexp *regexp.Regexp
+
 	// MemoryLimitBytes specifies a maximum amount of working memory that a
 	// processor that supports falling back to disk can use. Must be >= 1 to
 	// enable. This is a more fine-grained knob than ForceDiskSpill when the
@@ -270,6 +279,18 @@ type TestingKnobs struct {
 	// vectorized engine.
 	VecFDsToAcquire int
 
+	// StrictMonitorRegistryLeakCheck, if set, makes the vectorized flows check,
+	// in test builds, that all memory and disk accounts created through their
+	// colexecargs.MonitorRegistry have been released by the time the flow is
+	// cleaned up, and report those that haven't been.
+	StrictMonitorRegistryLeakCheck bool
+
+	// InjectMonitorAllocationFailure, if set, makes the memory and disk
+	// accounts created through the colexecargs.MonitorRegistry of the
+	// vectorized flows fail a growth, as if the limit of their monitor had
+	// been reached.
+	InjectMonitorAllocationFailure *InjectMonitorAllocationFailure
+
 	// TableReaderBatchBytesLimit, if not 0, overrides the limit that the
 	// TableReader will set on the size of results it wants to get for individual
 	// requests.
@@ -332,17 +353,33 @@ type TestingKnobs struct {
 // ModuleTestingKnobs is part of the base.ModuleTestingKnobs interface.
 func (*TestingKnobs) ModuleTestingKnobs() {}
 
+// InjectMonitorAllocationFailure describes the growth failed by the
+// InjectMonitorAllocationFailure testing knob.
+type InjectMonitorAllocationFailure struct {
+	// MonitorNameRegexp selects the monitors created by the MonitorRegistry
+	// whose accounts fail a growth. It's compiled by the test setting it, so
+	// that a bad pattern fails the test rather than the flows.
+	MonitorNameRegexp *regexp.Regexp
+	// AfterNGrows is the number of growths (of the accounts bound to each of
+	// the selected monitors) that succeed before the one that fails. The
+	// later growths succeed as well.
+	AfterNGrows int
+}
+
 // DefaultMemoryLimit is the default value of
 // sql.distsql.temp_storage.workmem cluster setting.
 const DefaultMemoryLimit = 64 << 20 /* 64 MiB */
 
 // GetWorkMemLimit returns the number of bytes determining the amount of RAM
-// available to a single processor or operator.
+// available to a single processor or operator. It's 1 if
+// ServerConfig.TestingKnobs.ForceDiskSpill is set, unless it's restricted to
+// some operators by ForceDiskSpillOpNameRegexp.
 func GetWorkMemLimit(flowCtx *FlowCtx) int64 {
 	if flowCtx.Cfg.TestingKnobs.ForceDiskSpill && flowCtx.Cfg.TestingKnobs.MemoryLimitBytes != 0 {
 		panic(errors.AssertionFailedf("both ForceDiskSpill and MemoryLimitBytes set"))
 	}
-	if flowCtx.Cfg.TestingKnobs.ForceDiskSpill {
+	knobs := &flowCtx.Cfg.TestingKnobs
+	if knobs.ForceDiskSpill && knobs.ForceDiskSpillOpNameRegexp == nil {
 		return 1
 	}
 	if flowCtx.Cfg.TestingKnobs.MemoryLimitBytes != 0 {
@@ -355,6 +392,18 @@ func GetWorkMemLimit(flowCtx *FlowCtx) int64 {
 	return flowCtx.EvalCtx.SessionData().WorkMemLimit
 }
 
+// ForceDiskSpill returns whether the operator with the given name must fall
+// back to disk immediately, per ServerConfig.TestingKnobs.ForceDiskSpill and
+// ForceDiskSpillOpNameRegexp.
+func ForceDiskSpill(flowCtx *FlowCtx, opName string) bool {
+	knobs := &flowCtx.Cfg.TestingKnobs
+	if !knobs.ForceDiskSpill {
+		return false
+	}
+	re := knobs.ForceDiskSpillOpNameRegexp
+	return re == nil || re.MatchString(opName)
+}
+
 // GetRowMetrics returns the proper rowinfra.Metrics for either internal or user
 // queries.
 func (flowCtx *FlowCtx) GetRowMetrics() *rowinfra.Metrics {
@@ -88,6 +88,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/pretty"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeofday"
 	"github.com/cockroachdb/cockroach/pkg/util/timetz"
@@ -5147,6 +5148,24 @@ value if you rely on the HLC for accuracy.`,
 		},
 	),
 
+	"crdb_internal.scheduler_latency": makeBuiltin(
+		tree.FunctionProperties{Category: builtinconstants.CategorySystemInfo},
+		tree.Overload{
+			Types:      tree.ParamTypes{{Name: "percentile", Typ: types.String}},
+			ReturnType: tree.FixedReturnType(types.Interval),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				snap, ok := schedulerlatency.Latest()
+				return schedulerLatencyPercentile(string(tree.MustBeDString(args[0])), snap, ok)
+			},
+			Info: "Returns the given percentile (one of p50, p90, p99, p99.9 or max) of the Go " +
+				"scheduler latency observed by this node over its most recent window " +
+				"(scheduler_latency.sample_duration), or NULL if there's none: if the sampler " +
+				"isn't running, is yet to observe a full window, or if the window was idle. " +
+				"It reads the value last computed by the sampler, and is cheap to call.",
+			Volatility: volatility.Volatile,
+		},
+	),
+
 	"crdb_internal.cluster_name": makeBuiltin(
 		tree.FunctionProperties{Category: builtinconstants.CategorySystemInfo},
 		tree.Overload{
@@ -11867,3 +11886,33 @@ func makeTimestampStatementBuiltinOverload(withOutputTZ bool, withInputTZ bool)
 		Volatility: vol,
 	}
 }
+
+// schedulerLatencyPercentile returns the percentile of the given name, one of
+// p50, p90, p99, p99.9 or max, of the given scheduler latency snapshot as an
+// interval, or NULL if there's no snapshot (!ok) or the window it was computed
+// over was idle, having no percentiles.
+func schedulerLatencyPercentile(
+	name string, snap schedulerlatency.SampleSnapshot, ok bool,
+) (tree.Datum, error) {
+	var d time.Duration
+	switch strings.ToLower(name) {
+	case "p50":
+		d = snap.P50
+	case "p90":
+		d = snap.P90
+	case "p99":
+		d = snap.P99
+	case "p99.9":
+		d = snap.P999
+	case "max":
+		d = snap.Max
+	default:
+		return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+			"unknown scheduler latency percentile %q; expected one of p50, p90, p99, p99.9, max", name)
+	}
+	if !ok || snap.Idle {
+		return tree.DNull, nil
+	}
+	return tree.NewDInterval(duration.MakeDuration(d.Nanoseconds(), 0 /* days */, 0 /* months */),
+		types.DefaultIntervalTypeMetadata), nil
+}
@@ -25,6 +25,8 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinconstants"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins/builtinsregistry"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
@@ -36,6 +38,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/duration"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -980,6 +983,42 @@ func TestBitmaskOrAndXor(t *testing.T) {
 	}
 }
 
+// TestSchedulerLatencyPercentile verifies the percentiles read off a scheduler
+// latency snapshot, and that there are none without one, or over idle windows.
+func TestSchedulerLatencyPercentile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	snap := schedulerlatency.SampleSnapshot{
+		P50:  time.Millisecond,
+		P90:  2 * time.Millisecond,
+		P99:  3 * time.Millisecond,
+		P999: 4 * time.Millisecond,
+		Max:  5 * time.Millisecond,
+	}
+	for name, expected := range map[string]time.Duration{
+		"p50":   time.Millisecond,
+		"p90":   2 * time.Millisecond,
+		"p99":   3 * time.Millisecond,
+		"P99":   3 * time.Millisecond,
+		"p99.9": 4 * time.Millisecond,
+		"max":   5 * time.Millisecond,
+	} {
+		d, err := schedulerLatencyPercentile(name, snap, true /* ok */)
+		require.NoError(t, err)
+		require.Equal(t, expected.Nanoseconds(), tree.MustBeDInterval(d).Nanos(), name)
+
+		d, err = schedulerLatencyPercentile(name, schedulerlatency.SampleSnapshot{}, false /* ok */)
+		require.NoError(t, err)
+		require.Equal(t, tree.DNull, d)
+		d, err = schedulerLatencyPercentile(name, schedulerlatency.SampleSnapshot{Idle: true}, true /* ok */)
+		require.NoError(t, err)
+		require.Equal(t, tree.DNull, d)
+	}
+	// Unknown names are rejected regardless.
+	_, err := schedulerLatencyPercentile("p42", schedulerlatency.SampleSnapshot{}, false /* ok */)
+	require.Error(t, err)
+	require.Equal(t, pgcode.InvalidParameterValue, pgerror.GetPGCode(err))
+}
+
 func BenchmarkGenerateID(b *testing.B) {
 	defer log.Scope(b).Close(b)
 
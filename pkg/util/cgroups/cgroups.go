@@ -40,6 +40,7 @@ const (
 	cgroupV1CPUPeriodFilename    = "cpu.cfs_period_us"
 	cgroupV1CPUSysUsageFilename  = "cpuacct.usage_sys"
 	cgroupV1CPUUserUsageFilename = "cpuacct.usage_user"
+	cgroupV1CPUStatFilename      = "cpu.stat"
 	cgroupV2CPUMaxFilename       = "cpu.max"
 	cgroupV2CPUStatFilename      = "cpu.stat"
 
@@ -50,6 +51,11 @@ const (
 	// key for # of bytes of file-backed memory on inactive LRU list in cgroupv2
 	cgroupV2MemInactiveFileUsageStatKey = "inactive_file"
 	cgroupV1MemLimitStatKey             = "hierarchical_memory_limit"
+	// keys for the CPU throttling counters in cgroup v1 and v2
+	cpuStatPeriodsKey           = "nr_periods"
+	cpuStatThrottledPeriodsKey  = "nr_throttled"
+	cgroupV1CPUThrottledTimeKey = "throttled_time"
+	cgroupV2CPUThrottledTimeKey = "throttled_usec"
 )
 
 // GetMemoryLimit attempts to retrieve the cgroup memory limit for the current
@@ -704,6 +710,135 @@ func getCgroupCPU(root string) (CPUUsage, error) {
 	return res, nil
 }
 
+// CPUThrottling are the cumulative CPU throttling counters of a cgroup: the
+// CFS bandwidth controller throttles its tasks whenever they exhaust the
+// cgroup's quota within an enforcement period, until the next one.
+type CPUThrottling struct {
+	// Periods is the number of enforcement periods elapsed, and
+	// ThrottledPeriods the number of those the cgroup was throttled in.
+	Periods, ThrottledPeriods uint64
+	// ThrottledTime is the total time the cgroup was throttled for. In
+	// nanoseconds.
+	ThrottledTime uint64
+}
+
+// CPUThrottlingReader reads the CPU throttling counters of the current cgroup.
+// The cgroup's cpu.stat file is located once, when the reader is constructed,
+// for the counters to be read cheaply and often.
+type CPUThrottlingReader struct {
+	statFilePath string
+	version      int
+}
+
+// NewCPUThrottlingReader returns a reader of the CPU throttling counters of
+// the current cgroup, or an error if there's no cgroup with a CPU controller
+// to read them from (as is the case outside of Linux).
+func NewCPUThrottlingReader() (*CPUThrottlingReader, error) {
+	return newCPUThrottlingReader("/")
+}
+
+// Helper function for NewCPUThrottlingReader. Root is always "/", except in
+// tests.
+func newCPUThrottlingReader(root string) (*CPUThrottlingReader, error) {
+	path, err := detectCntrlPath(filepath.Join(root, "/proc/self/cgroup"), "cpu,cpuacct")
+	if err != nil {
+		return nil, err
+	}
+
+	// No CPU controller detected
+	if path == "" {
+		return nil, errors.New("no cpu controller detected")
+	}
+
+	versionedMounts, err := getCgroupDetails(filepath.Join(root, "/proc/self/mountinfo"), path, "cpu,cpuacct")
+	if err != nil {
+		return nil, err
+	}
+
+	// Look up against V2 first, if mounted. Fall back to V1.
+	var candidates []*CPUThrottlingReader
+	for i := len(versionedMounts) - 1; i >= 0; i-- {
+		switch m := versionedMounts[i]; m.version {
+		case 1:
+			candidates = append(candidates, &CPUThrottlingReader{
+				statFilePath: filepath.Join(root, m.mount, cgroupV1CPUStatFilename),
+				version:      1,
+			})
+		case 2:
+			candidates = append(candidates, &CPUThrottlingReader{
+				statFilePath: filepath.Join(root, m.mount, path, cgroupV2CPUStatFilename),
+				version:      2,
+			})
+		}
+	}
+	for _, r := range candidates {
+		if _, err = r.Read(); err == nil {
+			return r, nil
+		}
+	}
+	if err == nil {
+		err = errors.AssertionFailedf("unexpected cgroup versions %v", versionedMounts)
+	}
+	return nil, err
+}
+
+// Read reads the CPU throttling counters of the cgroup.
+func (r *CPUThrottlingReader) Read() (CPUThrottling, error) {
+	return detectCPUThrottling(r.statFilePath, r.version)
+}
+
+// detectCPUThrottling reads the CPU throttling counters from the given
+// cpu.stat file of the given cgroup version. Throttled time is reported in
+// nanoseconds in cgroup v1, and in microseconds in v2. The counters are only
+// present if the CPU controller is enabled for the cgroup.
+func detectCPUThrottling(statFilePath string, cgVersion int) (res CPUThrottling, err error) {
+	stat, err := os.Open(statFilePath)
+	if err != nil {
+		return CPUThrottling{}, errors.Wrapf(err, "can't read cpu throttling from cgroup v%d at %s", cgVersion, statFilePath)
+	}
+	defer func() {
+		err = errors.CombineErrors(err, stat.Close())
+	}()
+
+	throttledTimeKey, throttledTimeUnit := cgroupV1CPUThrottledTimeKey, uint64(1)
+	if cgVersion == 2 {
+		throttledTimeKey, throttledTimeUnit = cgroupV2CPUThrottledTimeKey, 1000
+	}
+	var found int
+	scanner := bufio.NewScanner(stat)
+	for scanner.Scan() {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) != 2 {
+			continue
+		}
+		var v *uint64
+		switch key := string(fields[0]); key {
+		case cpuStatPeriodsKey:
+			v = &res.Periods
+		case cpuStatThrottledPeriodsKey:
+			v = &res.ThrottledPeriods
+		case throttledTimeKey:
+			v = &res.ThrottledTime
+		default:
+			continue
+		}
+		*v, err = strconv.ParseUint(string(fields[1]), 10, 64)
+		if err != nil {
+			return CPUThrottling{}, errors.Wrapf(err, "can't read cpu throttling %s from cgroup v%d at %s",
+				fields[0], cgVersion, statFilePath)
+		}
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return CPUThrottling{}, errors.Wrapf(err, "can't read cpu throttling from cgroup v%d at %s", cgVersion, statFilePath)
+	}
+	if found != 3 {
+		return CPUThrottling{}, errors.Newf("no cpu throttling counters in cgroup v%d at %s", cgVersion, statFilePath)
+	}
+	res.ThrottledTime *= throttledTimeUnit
+	return res, nil
+}
+
 // AdjustMaxProcs sets GOMAXPROCS (if not overridden by env variables) to be
 // the CPU limit of the current cgroup, if running inside a cgroup with a cpu
 // limit lower than system.NumCPU(). This is preferable to letting it fall back
@@ -581,6 +581,108 @@ func TestCgroupsGetCPU(t *testing.T) {
 	}
 }
 
+func TestCgroupsGetCPUThrottling(t *testing.T) {
+	const v2Path = "/sys/fs/cgroup/machine.slice/libpod-f1c6b44c0d61f273952b8daecf154cee1be2d503b7e9184ebf7fcaf48e139810.scope"
+	for _, tc := range []struct {
+		name       string
+		paths      map[string]string
+		errMsg     string
+		throttling CPUThrottling
+	}{
+		{
+			name:   "fails to find cgroup version when cgroup file is not present",
+			errMsg: "failed to read cpu,cpuacct cgroup from cgroups file:",
+		},
+		{
+			name: "doesn't detect throttling for cgroup v1 without cpu controller",
+			paths: map[string]string{
+				"/proc/self/cgroup":    v1CgroupWithoutCPUController,
+				"/proc/self/mountinfo": v1MountsWithoutCPUController,
+			},
+			errMsg: "no cpu controller detected",
+		},
+		{
+			name: "fails when the stat file is missing for cgroup v1",
+			paths: map[string]string{
+				"/proc/self/cgroup":    v1CgroupWithCPUController,
+				"/proc/self/mountinfo": v1MountsWithCPUController,
+			},
+			errMsg: "can't read cpu throttling from cgroup v1",
+		},
+		{
+			name: "fetches the cpu throttling for cgroup v1",
+			paths: map[string]string{
+				"/proc/self/cgroup":                   v1CgroupWithCPUController,
+				"/proc/self/mountinfo":                v1MountsWithCPUController,
+				"/sys/fs/cgroup/cpu,cpuacct/cpu.stat": "nr_periods 100\nnr_throttled 10\nthrottled_time 123456789\n",
+			},
+			throttling: CPUThrottling{Periods: 100, ThrottledPeriods: 10, ThrottledTime: 123456789},
+		},
+		{
+			name: "fetches the cpu throttling for cgroup v1 (mixed version mounts)",
+			paths: map[string]string{
+				"/proc/self/cgroup":                   v1CgroupWithCPUController,
+				"/proc/self/mountinfo":                mixedMounts,
+				"/sys/fs/cgroup/cpu,cpuacct/cpu.stat": "nr_periods 100\nnr_throttled 10\nthrottled_time 123456789\n",
+			},
+			throttling: CPUThrottling{Periods: 100, ThrottledPeriods: 10, ThrottledTime: 123456789},
+		},
+		{
+			name: "fetches the cpu throttling for cgroup v2",
+			paths: map[string]string{
+				"/proc/self/cgroup":    v2CgroupWithMemoryController,
+				"/proc/self/mountinfo": v2Mounts,
+				v2Path + "/cpu.stat": "usage_usec 300\nuser_usec 100\nsystem_usec 200\n" +
+					"nr_periods 100\nnr_throttled 10\nthrottled_usec 123456\n",
+			},
+			throttling: CPUThrottling{Periods: 100, ThrottledPeriods: 10, ThrottledTime: 123456000},
+		},
+		{
+			name: "fails without the throttling counters for cgroup v2",
+			paths: map[string]string{
+				"/proc/self/cgroup":    v2CgroupWithMemoryController,
+				"/proc/self/mountinfo": v2Mounts,
+				v2Path + "/cpu.stat":   "user_usec 100\nsystem_usec 200",
+			},
+			errMsg: "no cpu throttling counters in cgroup v2",
+		},
+		{
+			name: "fails when unable to parse the throttling counters for cgroup v2",
+			paths: map[string]string{
+				"/proc/self/cgroup":    v2CgroupWithMemoryController,
+				"/proc/self/mountinfo": v2Mounts,
+				v2Path + "/cpu.stat":   "nr_periods 100\nnr_throttled foo\nthrottled_usec 123456\n",
+			},
+			errMsg: "can't read cpu throttling nr_throttled from cgroup v2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := createFiles(t, tc.paths)
+
+			r, err := newCPUThrottlingReader(dir)
+			require.True(t, testutils.IsError(err, tc.errMsg),
+				"%v %v", err, tc.errMsg)
+			if tc.errMsg != "" {
+				return
+			}
+			throttling, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, tc.throttling, throttling)
+
+			// The stat file is re-read every time.
+			for path := range tc.paths {
+				if filepath.Base(path) == "cpu.stat" {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, path),
+						[]byte("nr_periods 200\nnr_throttled 20\nthrottled_usec 1\nthrottled_time 1000\n"), 0755))
+				}
+			}
+			throttling, err = r.Read()
+			require.NoError(t, err)
+			require.Equal(t, CPUThrottling{Periods: 200, ThrottledPeriods: 20, ThrottledTime: 1000}, throttling)
+		})
+	}
+}
+
 func createFiles(t *testing.T, paths map[string]string) (dir string) {
 	dir = t.TempDir()
 
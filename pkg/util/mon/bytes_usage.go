@@ -238,6 +238,17 @@ type BytesMonitor struct {
 		// NB: this field doesn't need mutex protection but is inside of mu
 		// struct in order to reduce the struct size.
 		longLiving bool
+
+		// lazy indicates whether this monitor was started with StartLazily
+		// and hasn't yet reserved any bytes, in which case it isn't registered
+		// with its pool yet.
+		lazy bool
+
+		// hooks, if set, are consulted on the slow path of the reservations
+		// (see SetOnLimitExceeded and TestingDenyReservations). They're kept
+		// out of line, so that the monitors without any (most of them) only
+		// pay for the pointer.
+		hooks *monitorHooks
 	}
 
 	// parentMu encompasses the fields that must be accessed while holding the
@@ -283,8 +294,8 @@ type BytesMonitor struct {
 
 const (
 	// Consult with SQL Queries before increasing these values.
-	expectedMonitorSize     = 160
-	expectedMonitorSizeRace = 168
+	expectedMonitorSize     = 168
+	expectedMonitorSizeRace = 176
 	expectedAccountSize     = 24
 )
 
@@ -536,23 +547,52 @@ func (mm *BytesMonitor) Start(ctx context.Context, pool *BytesMonitor, reserved
 			poolname)
 	}
 
-	var effectiveLimit int64
+	mm.registerWithPool(pool)
+	mm.limit = mm.effectiveLimit(pool, reserved)
+}
+
+// StartLazily is the same as StartNoReserved, except that the monitor is only
+// started once bytes are first reserved from it, so that a monitor that ends
+// up unused doesn't get registered with the pool. The limit of the monitor is
+// determined right away.
+func (mm *BytesMonitor) StartLazily(pool *BytesMonitor) {
+	if mm.mu.curBudget.mon != nil {
+		panic(errors.AssertionFailedf("%s: already started with pool %s", mm.name, mm.mu.curBudget.mon.name))
+	}
+	mm.reserved = &noReserved
+	mm.limit = mm.effectiveLimit(pool, &noReserved)
 	if pool != nil {
-		// mm.settings can be nil in tests in which case we use the default
-		// value of enableMonitorTreeTrackingSetting cluster setting (true).
-		if enableMonitorTreeTrackingEnvVar && (mm.settings == nil || enableMonitorTreeTrackingSetting.Get(&mm.settings.SV)) {
-			// If we have a "parent" monitor, then register mm as its child by
-			// making it the head of the doubly-linked list.
-			func() {
-				pool.mu.Lock()
-				defer pool.mu.Unlock()
-				if s := pool.mu.head; s != nil {
-					s.parentMu.prevSibling = mm
-					mm.parentMu.nextSibling = s
-				}
-				pool.mu.head = mm
-			}()
+		mm.mu.curBudget = pool.MakeBoundAccount()
+		mm.mu.lazy = true
+	}
+}
+
+// registerWithPool registers the monitor as a child of the given pool, if
+// tracking the monitor tree is enabled.
+func (mm *BytesMonitor) registerWithPool(pool *BytesMonitor) {
+	if pool == nil {
+		return
+	}
+	// mm.settings can be nil in tests in which case we use the default
+	// value of enableMonitorTreeTrackingSetting cluster setting (true).
+	if enableMonitorTreeTrackingEnvVar && (mm.settings == nil || enableMonitorTreeTrackingSetting.Get(&mm.settings.SV)) {
+		// If we have a "parent" monitor, then register mm as its child by
+		// making it the head of the doubly-linked list.
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		if s := pool.mu.head; s != nil {
+			s.parentMu.prevSibling = mm
+			mm.parentMu.nextSibling = s
 		}
+		pool.mu.head = mm
+	}
+}
+
+// effectiveLimit returns the limit of the monitor started with the given pool
+// and reserved budget.
+func (mm *BytesMonitor) effectiveLimit(pool *BytesMonitor, reserved *BoundAccount) int64 {
+	var effectiveLimit int64
+	if pool != nil {
 		effectiveLimit = pool.limit
 	}
 
@@ -570,7 +610,7 @@ func (mm *BytesMonitor) Start(ctx context.Context, pool *BytesMonitor, reserved
 	if effectiveLimit > mm.configLimit {
 		effectiveLimit = mm.configLimit
 	}
-	mm.limit = effectiveLimit
+	return effectiveLimit
 }
 
 // NewUnlimitedMonitor creates a new monitor and starts the monitor in
@@ -608,6 +648,52 @@ func (mm *BytesMonitor) Limit() int64 {
 	return mm.limit
 }
 
+// monitorHooks are the callbacks installed into a monitor, kept out of line
+// since few monitors have any.
+type monitorHooks struct {
+	// testing holds the hooks only available in test builds. It's empty, and
+	// placed first so as not to be padded, otherwise.
+	testing testingHooks
+	// onLimitExceeded, if set, is invoked whenever an allocation is denied
+	// because of the local limit of the monitor.
+	onLimitExceeded func(requested, limit int64)
+}
+
+// hooksLocked returns the hooks of the monitor, allocating them if needed.
+func (mm *BytesMonitor) hooksLocked() *monitorHooks {
+	if mm.mu.hooks == nil {
+		mm.mu.hooks = &monitorHooks{}
+	}
+	return mm.mu.hooks
+}
+
+// TestingDenyReservations makes the monitor consult deny, with the number of
+// bytes requested, whenever it reserves bytes (for the accounts bound to it or
+// for its child monitors), the reservation being denied with a budget exceeded
+// error, as if the limit of the monitor had been reached, if deny returns
+// true. So that every growth of the accounts reaches it, the monitor is made
+// to reserve exactly the bytes requested rather than whole blocks. deny is
+// invoked with the monitor's mutex held, so it must not call into the monitor.
+// It must be called before any account is bound to the monitor, and it can only
+// be called in test builds.
+func (mm *BytesMonitor) TestingDenyReservations(deny func(requested int64) bool) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.hooksLocked().testing.setDeny(deny)
+	mm.poolAllocationSize = 1
+}
+
+// SetOnLimitExceeded sets the callback invoked with the number of bytes
+// requested and the limit of the monitor whenever an allocation is denied
+// because of the limit (as opposed to that of an ancestor). The callback is
+// invoked with the monitor's mutex held, so it must not call into the
+// monitor.
+func (mm *BytesMonitor) SetOnLimitExceeded(fn func(requested, limit int64)) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.hooksLocked().onLimitExceeded = fn
+}
+
 // MarkLongLiving marks the monitor as a long-living. Such monitors are allowed
 // to not be stopped because their lifetime matches the server's lifetime.
 func (mm *BytesMonitor) MarkLongLiving() {
@@ -640,6 +726,10 @@ func (mm *BytesMonitor) doStop(ctx context.Context, check bool) {
 	mm.mu.Lock()
 	defer mm.mu.Unlock()
 	mm.mu.stopped = true
+	// A monitor started lazily that hasn't reserved any bytes is stopped
+	// without having been registered with its pool.
+	registered := !mm.mu.lazy
+	mm.mu.lazy = false
 	if buildutil.CrdbTestBuild {
 		// We expect that all short-living descendants of this monitor have been
 		// stopped.
@@ -686,7 +776,7 @@ func (mm *BytesMonitor) doStop(ctx context.Context, check bool) {
 		mm.mu.maxBytesHist.RecordValue(val)
 	}
 
-	if parent := mm.mu.curBudget.mon; parent != nil {
+	if parent := mm.mu.curBudget.mon; parent != nil && registered {
 		// If we have a "parent" monitor, then unregister mm from the list of
 		// the parent's children.
 		func() {
@@ -1183,10 +1273,23 @@ func (mm *BytesMonitor) makeBudgetExceededError(minExtra int64) error {
 func (mm *BytesMonitor) reserveBytes(ctx context.Context, x int64) error {
 	mm.mu.Lock()
 	defer mm.mu.Unlock()
+	if mm.mu.lazy {
+		// This is the first reservation of the monitor started lazily.
+		// NB: locking the pool while holding mm.mu is allowed (see the
+		// comment on mm.mu.head).
+		mm.mu.lazy = false
+		mm.registerWithPool(mm.mu.curBudget.mon)
+	}
+	if h := mm.mu.hooks; h != nil && h.testing.denies(x) {
+		return mm.makeBudgetExceededError(x)
+	}
 	// Check the local limit first. NB: The condition is written in this manner
 	// so that it handles overflow correctly. Consider what happens if
 	// x==math.MaxInt64. mm.limit-x will be a large negative number.
 	if mm.mu.curAllocated > mm.limit-x {
+		if h := mm.mu.hooks; h != nil && h.onLimitExceeded != nil {
+			h.onLimitExceeded(x, mm.limit)
+		}
 		return mm.makeBudgetExceededError(x)
 	}
 	// Check whether we need to request an increase of our budget.
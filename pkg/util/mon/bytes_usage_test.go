@@ -701,3 +701,121 @@ func TestLimit(t *testing.T) {
 	require.Equal(t, int64(1123), m2.Limit())
 	m2.Stop(ctx)
 }
+
+func TestOnLimitExceeded(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+
+	parent := NewMonitor(Options{
+		Name:      "parent",
+		Increment: 1,
+		Settings:  st,
+	})
+	parent.Start(ctx, nil, NewStandaloneBudget(100))
+	defer parent.Stop(ctx)
+	m := NewMonitorInheritWithLimit("child", 10 /* limit */, parent, false /* longLiving */)
+	m.StartNoReserved(ctx, parent)
+	defer m.Stop(ctx)
+
+	type exceeded struct{ requested, limit int64 }
+	var calls []exceeded
+	m.SetOnLimitExceeded(func(requested, limit int64) {
+		calls = append(calls, exceeded{requested: requested, limit: limit})
+	})
+	acc := m.MakeBoundAccount()
+	defer acc.Close(ctx)
+	require.NoError(t, acc.Grow(ctx, 10))
+	require.Error(t, acc.Grow(ctx, 5))
+	require.Equal(t, []exceeded{{requested: 5, limit: 10}}, calls)
+
+	// The callback of a monitor isn't invoked if the limit of an ancestor is
+	// exceeded.
+	unlimited := NewMonitorInheritWithLimit("unlimited", 0 /* limit */, m, false /* longLiving */)
+	unlimited.StartNoReserved(ctx, m)
+	defer unlimited.Stop(ctx)
+	unlimited.SetOnLimitExceeded(func(int64, int64) {
+		t.Fatal("unexpected callback")
+	})
+	unlimitedAcc := unlimited.MakeBoundAccount()
+	defer unlimitedAcc.Close(ctx)
+	require.Error(t, unlimitedAcc.Grow(ctx, 200))
+}
+
+func TestDenyReservations(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+
+	m := NewMonitor(Options{
+		Name:     "test",
+		Settings: st,
+	})
+	m.Start(ctx, nil, NewStandaloneBudget(100))
+	defer m.Stop(ctx)
+	var requested []int64
+	m.TestingDenyReservations(func(x int64) bool {
+		requested = append(requested, x)
+		return len(requested) == 3
+	})
+
+	acc1, acc2 := m.MakeBoundAccount(), m.MakeBoundAccount()
+	defer acc1.Close(ctx)
+	defer acc2.Close(ctx)
+	// Every growth reaches the monitor, with the exact bytes requested.
+	require.NoError(t, acc1.Grow(ctx, 1))
+	require.NoError(t, acc2.Grow(ctx, 2))
+	err := acc1.Grow(ctx, 3)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "test: memory budget exceeded")
+	require.Equal(t, int64(1), acc1.Used())
+	require.NoError(t, acc1.Grow(ctx, 4))
+	require.Equal(t, []int64{1, 2, 3, 4}, requested)
+	require.Equal(t, int64(7), m.AllocBytes())
+}
+
+func TestStartLazily(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+
+	parent := NewMonitor(Options{
+		Name:      "parent",
+		Increment: 1,
+		Settings:  st,
+	})
+	parent.Start(ctx, nil, NewStandaloneBudget(100))
+	defer parent.Stop(ctx)
+	children := func() []string {
+		var names []string
+		require.NoError(t, parent.TraverseTree(func(s MonitorState) error {
+			if s.Level > 0 {
+				names = append(names, s.Name)
+			}
+			return nil
+		}))
+		return names
+	}
+
+	// The limit is determined right away, but the child isn't registered with
+	// the parent until bytes are reserved.
+	used := NewMonitorInheritWithLimit("used", 0 /* limit */, parent, false /* longLiving */)
+	used.StartLazily(parent)
+	require.Equal(t, int64(100), used.Limit())
+	require.Empty(t, children())
+	acc := used.MakeBoundAccount()
+	require.NoError(t, acc.Grow(ctx, 10))
+	require.Equal(t, []string{"used"}, children())
+	require.Equal(t, int64(10), parent.AllocBytes())
+	require.Error(t, acc.Grow(ctx, 100))
+	acc.Close(ctx)
+	used.Stop(ctx)
+	require.Empty(t, children())
+	require.Zero(t, parent.AllocBytes())
+
+	// A child that's never used can be stopped, after which it can't be.
+	unused := NewMonitorInheritWithLimit("unused", 0 /* limit */, parent, false /* longLiving */)
+	unused.StartLazily(parent)
+	unused.Stop(ctx)
+	require.Empty(t, children())
+	unusedAcc := unused.MakeBoundAccount()
+	require.Error(t, unusedAcc.Grow(ctx, 10))
+	require.Zero(t, parent.AllocBytes())
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+//go:build !crdb_test || crdb_test_off
+// +build !crdb_test crdb_test_off
+
+package mon
+
+import "github.com/cockroachdb/errors"
+
+// testingHooks are the hooks of a monitor only available in test builds; they
+// take no space otherwise.
+type testingHooks struct{}
+
+func (*testingHooks) setDeny(func(requested int64) bool) {
+	panic(errors.AssertionFailedf("TestingDenyReservations is only supported in test builds"))
+}
+
+// denies returns whether the reservation of the given number of bytes is
+// denied, which it never is outside of test builds.
+//
+//gcassert:inline
+func (*testingHooks) denies(int64) bool {
+	return false
+}
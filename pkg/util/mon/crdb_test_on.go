@@ -0,0 +1,31 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+//go:build crdb_test && !crdb_test_off
+// +build crdb_test,!crdb_test_off
+
+package mon
+
+// testingHooks are the hooks of a monitor only available in test builds.
+type testingHooks struct {
+	// deny, if set, is consulted before every reservation, which is denied if
+	// it returns true (see TestingDenyReservations).
+	deny func(requested int64) bool
+}
+
+func (h *testingHooks) setDeny(deny func(requested int64) bool) {
+	h.deny = deny
+}
+
+// denies returns whether the reservation of the given number of bytes is
+// denied.
+func (h *testingHooks) denies(requested int64) bool {
+	return h.deny != nil && h.deny(requested)
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/redact"
+)
+
+var (
+	metaSamplerClampedSettings = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.clamped_settings",
+		Help:        "Number of times the scheduler latency sampler clamped the configured sample duration, it being too short or too long for the sample period in effect",
+		Measurement: "Clamps",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSamplerEmptyWindows = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.empty_windows",
+		Help:        "Number of full windows over which the scheduler latency sampler observed no goroutine scheduling events at all",
+		Measurement: "Windows",
+		Unit:        metric.Unit_COUNT,
+	}
+)
+
+// anomalyLogInterval is the minimum interval between two log lines summarizing
+// the occurrences of a given kind of anomaly.
+const anomalyLogInterval = time.Hour
+
+// anomalyKind enumerates the sampler's silent failure modes: none of them
+// errors out, but each degrades what's delivered in a way worth knowing about.
+type anomalyKind int
+
+const (
+	// anomalyRebaseline is when the sampler discards its window, having observed
+	// a decreasing histogram, a gap between ticks, or a change in GOMAXPROCS.
+	anomalyRebaseline anomalyKind = iota
+	// anomalyClampedSetting is when the sample duration in effect isn't the
+	// configured one, it being too short or too long for the sample period.
+	anomalyClampedSetting
+	// anomalySkippedTick is when a tick is skipped, the sampler having fallen
+	// behind by more than a sample period.
+	anomalySkippedTick
+	// anomalyEmptyWindow is when a full window observed no scheduling events at
+	// all, idle or not.
+	anomalyEmptyWindow
+	numAnomalyKinds
+)
+
+func (k anomalyKind) String() string { return redact.StringWithoutMarkers(k) }
+
+// SafeFormat implements the redact.SafeFormatter interface.
+func (k anomalyKind) SafeFormat(p redact.SafePrinter, _ rune) {
+	switch k {
+	case anomalyRebaseline:
+		p.SafeString("re-baselines")
+	case anomalyClampedSetting:
+		p.SafeString("clamped settings")
+	case anomalySkippedTick:
+		p.SafeString("skipped ticks")
+	case anomalyEmptyWindow:
+		p.SafeString("empty windows")
+	default:
+		p.Printf("anomaly(%d)", redact.SafeInt(k))
+	}
+}
+
+// SamplerAnomalies counts the occurrences of the sampler's anomalies since it
+// started; see SampleSnapshot.Anomalies.
+type SamplerAnomalies struct {
+	// Rebaselines is the number of times the sampler discarded its window, see
+	// go.scheduler_latency.sampler.rebaselines.
+	Rebaselines int64
+	// ClampedSettings is the number of times the sample duration was clamped,
+	// see go.scheduler_latency.sampler.clamped_settings.
+	ClampedSettings int64
+	// SkippedTicks is the number of ticks skipped, see
+	// go.scheduler_latency.sampler.skipped_ticks.
+	SkippedTicks int64
+	// EmptyWindows is the number of full windows without any scheduling events,
+	// see go.scheduler_latency.sampler.empty_windows.
+	EmptyWindows int64
+}
+
+// anomalyCounter returns the counter of the given kind of anomaly.
+func (m *samplerMetrics) anomalyCounter(kind anomalyKind) *metric.Counter {
+	switch kind {
+	case anomalyRebaseline:
+		return m.Rebaselines
+	case anomalyClampedSetting:
+		return m.ClampedSettings
+	case anomalySkippedTick:
+		return m.SkippedTicks
+	case anomalyEmptyWindow:
+		return m.EmptyWindows
+	default:
+		panic(redact.Safe(kind))
+	}
+}
+
+// anomalies returns the counts of the sampler's anomalies.
+func (s *sampler) anomalies() SamplerAnomalies {
+	return SamplerAnomalies{
+		Rebaselines:     s.metrics.Rebaselines.Count(),
+		ClampedSettings: s.metrics.ClampedSettings.Count(),
+		SkippedTicks:    s.metrics.SkippedTicks.Count(),
+		EmptyWindows:    s.metrics.EmptyWindows.Count(),
+	}
+}
+
+// anomalyLog rate-limits the log lines summarizing the occurrences of a kind
+// of anomaly to one every anomalyLogInterval. It's updated atomically, for
+// anomalies to be recorded with or without the sampler's lock held.
+type anomalyLog struct {
+	// next is when the next summary may be logged, in nanoseconds since the
+	// Unix epoch; zero logs the first occurrence right away.
+	next atomic.Int64
+	// reported is the count of occurrences as of the last summary logged.
+	reported atomic.Int64
+}
+
+// shouldReport is provided the cumulative count of occurrences at the given
+// time; it returns the number of occurrences since the last summary, and true,
+// if one should be logged.
+func (l *anomalyLog) shouldReport(now time.Time, total int64) (n int64, ok bool) {
+	next := l.next.Load()
+	if now.UnixNano() < next {
+		return 0, false
+	}
+	if !l.next.CompareAndSwap(next, now.Add(anomalyLogInterval).UnixNano()) {
+		return 0, false // another occurrence got to log it
+	}
+	return total - l.reported.Swap(total), true
+}
+
+// recordAnomaly counts an occurrence of the given kind of anomaly observed at
+// the given time, logging a summary of the occurrences since the last one if
+// none was logged over the past anomalyLogInterval.
+func (s *sampler) recordAnomaly(ctx context.Context, kind anomalyKind, now time.Time) {
+	c := s.metrics.anomalyCounter(kind)
+	c.Inc(1)
+	total := c.Count()
+	if n, ok := s.anomalyLogs[kind].shouldReport(now, total); ok {
+		log.Infof(ctx, "scheduler latency sampler: %d %s since the last report (%d in total); "+
+			"reported at most once every %s", n, kind, total, anomalyLogInterval)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSamplerAnomalies verifies that every kind of anomaly, injected, is
+// counted, and that the counts are exported and published to Latest.
+func TestSamplerAnomalies(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T) *testSampler {
+		return newTestSampler(t, time.Second, 2*time.Second, busySample())
+	}
+
+	t.Run("rebaseline", func(t *testing.T) {
+		s := setup(t)
+		for i := 0; i < 3; i++ {
+			s.tick(ctx)
+		}
+		require.Zero(t, s.anomalies())
+		s.advance(ctx, rebaselineGapMultiple*time.Second*2)
+		for i := 0; i < 2; i++ {
+			s.tick(ctx)
+		}
+		require.Equal(t, SamplerAnomalies{Rebaselines: 1}, s.anomalies())
+		snap, ok := Latest()
+		require.True(t, ok)
+		require.Equal(t, s.anomalies(), snap.Anomalies)
+	})
+
+	t.Run("clamped setting", func(t *testing.T) {
+		s := setup(t)
+		// The sample duration is validated against the period in the settings
+		// watcher, clamping it if too short.
+		st := s.mu.st
+		getPeriod := s.watchSettings(ctx, st, func(time.Duration) {}, nil /* active */)
+		require.Equal(t, samplePeriod.Default(), getPeriod())
+		require.Zero(t, s.metrics.ClampedSettings.Count())
+		sampleDuration.Override(ctx, &st.SV, samplePeriod.Default())
+		require.Equal(t, int64(1), s.metrics.ClampedSettings.Count())
+		require.Equal(t, minSamplesPerWindow*samplePeriod.Default(), s.mu.duration)
+		// As is the duration against a period overridden to a longer one.
+		sampleDuration.Override(ctx, &st.SV, 2*samplePeriod.Default())
+		s.mu.Lock()
+		s.mu.override = periodOverride{period: 2 * samplePeriod.Default(), until: timeutil.Unix(1, 0)}
+		s.applyPeriodLocked()
+		// Re-applying the same period and duration doesn't count again.
+		s.applyPeriodLocked()
+		s.mu.Unlock()
+		require.Equal(t, 4*samplePeriod.Default(), s.mu.duration)
+		require.Equal(t, SamplerAnomalies{ClampedSettings: 2}, s.anomalies())
+	})
+
+	t.Run("skipped tick", func(t *testing.T) {
+		s := setup(t)
+		scheduled := timeutil.Unix(0, 0)
+		require.False(t, s.maybeSkipTick(ctx, scheduled, scheduled.Add(time.Second), time.Second))
+		require.True(t, s.maybeSkipTick(ctx, scheduled, scheduled.Add(2*time.Second), time.Second))
+		require.Equal(t, SamplerAnomalies{SkippedTicks: 1}, s.anomalies())
+	})
+
+	t.Run("empty window", func(t *testing.T) {
+		s := setup(t)
+		// No events are observed at all; the third and fourth ticks complete
+		// full windows, idle ones.
+		cumulative := &metrics.Float64Histogram{
+			Counts:  []uint64{0, 0},
+			Buckets: []float64{0, 0.001, 0.002},
+		}
+		s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+		var listener sampleListener
+		s.addListener(&listener)
+		for i := 0; i < 4; i++ {
+			s.tick(ctx)
+		}
+		require.Equal(t, SamplerAnomalies{EmptyWindows: 2}, s.anomalies())
+		require.Len(t, listener.samples, 2)
+		require.True(t, listener.samples[1].Idle)
+
+		// Without idle detection, the windows are skipped instead; they're
+		// counted all the same.
+		idleWindowMinEvents.Override(ctx, &s.mu.st.SV, 0)
+		s.tick(ctx)
+		require.Len(t, listener.samples, 2)
+		require.Equal(t, SamplerAnomalies{EmptyWindows: 3}, s.anomalies())
+
+		// Windows observing events aren't.
+		cumulative.Counts[0] += 100
+		s.tick(ctx)
+		s.tick(ctx)
+		require.Equal(t, SamplerAnomalies{EmptyWindows: 3}, s.anomalies())
+		require.Equal(t, int64(3), s.metrics.EmptyWindows.Count())
+	})
+}
+
+// TestAnomalyLogRateLimited verifies that occurrences of an anomaly are
+// summarized at most once every anomalyLogInterval, with the counts since the
+// previous summary.
+func TestAnomalyLogRateLimited(t *testing.T) {
+	var l anomalyLog
+	start := timeutil.Unix(0, 0)
+	// The first occurrence is reported right away.
+	n, ok := l.shouldReport(start, 1)
+	require.True(t, ok)
+	require.Equal(t, int64(1), n)
+	// Those over the following interval aren't.
+	for i := int64(2); i <= 10; i++ {
+		_, ok = l.shouldReport(start.Add(time.Duration(i-1)*anomalyLogInterval/10), i)
+		require.False(t, ok)
+	}
+	// Until it elapses, summarizing them all.
+	now := start.Add(anomalyLogInterval)
+	n, ok = l.shouldReport(now, 11)
+	require.True(t, ok)
+	require.Equal(t, int64(10), n)
+	_, ok = l.shouldReport(now, 12)
+	require.False(t, ok)
+	// Occurrences long after are reported right away.
+	n, ok = l.shouldReport(now.Add(10*anomalyLogInterval), 13)
+	require.True(t, ok)
+	require.Equal(t, int64(2), n)
+}
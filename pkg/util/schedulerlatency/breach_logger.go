@@ -0,0 +1,130 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/redact"
+)
+
+// logThreshold is the p99 scheduler latency above which we log a warning, if
+// sustained for logConsecutiveTicks samples.
+var logThreshold = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.log.threshold",
+	"p99 scheduler latency above which a warning is logged, if sustained for "+
+		"scheduler_latency.log.consecutive_ticks samples (0 disables logging)",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var logConsecutiveTicks = settings.RegisterIntSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.log.consecutive_ticks",
+	"number of consecutive samples with p99 scheduler latency above "+
+		"scheduler_latency.log.threshold before a warning is logged",
+	10,
+	settings.PositiveInt,
+)
+
+// breachLogBuckets is the number of (non-empty) histogram buckets, starting
+// from the highest, included in the warning.
+const breachLogBuckets = 5
+
+// breachLogger decides when to log a warning about sustained high scheduler
+// latencies. A warning is logged after the p99 exceeds the threshold for a
+// number of consecutive ticks, after which further warnings are suppressed
+// until the p99 drops back below the threshold (hysteresis). Warnings are
+// additionally rate-limited in case the condition flaps.
+type breachLogger struct {
+	consecutive int64 // number of consecutive ticks above the threshold
+	logged      bool  // whether we've logged the ongoing breach
+	every       log.EveryN
+}
+
+func makeBreachLogger() breachLogger {
+	return breachLogger{every: log.Every(time.Minute)}
+}
+
+// observe is provided the p99 of every window; it returns true if a warning
+// should be logged.
+func (b *breachLogger) observe(p99, threshold time.Duration, ticks int64) bool {
+	if threshold == 0 || p99 <= threshold {
+		b.consecutive, b.logged = 0, false // the condition has cleared
+		return false
+	}
+	b.consecutive++
+	if b.logged || b.consecutive < ticks {
+		return false
+	}
+	if !b.every.ShouldLog() {
+		return false // we'll try again next tick
+	}
+	b.logged = true
+	return true
+}
+
+// maybeLogBreachLocked logs a warning to the HEALTH channel if the p99
+// scheduler latency has been above scheduler_latency.log.threshold for
+// scheduler_latency.log.consecutive_ticks samples.
+func (s *sampler) maybeLogBreachLocked(ctx context.Context, p50, p99, window time.Duration) {
+	threshold := logThreshold.Get(&s.mu.st.SV)
+	ticks := logConsecutiveTicks.Get(&s.mu.st.SV)
+	if !s.mu.breachLogger.observe(p99, threshold, ticks) {
+		return
+	}
+
+	h := s.mu.lastIntervalHistogram
+	log.Health.Warningf(ctx,
+		"p99 scheduler latency (%s) above %s for %d consecutive samples; p50=%s window=%s buckets=%s",
+		p99, threshold, ticks, p50, window, renderTopBuckets(h, breachLogBuckets))
+}
+
+// renderTopBuckets renders the n highest non-empty buckets of the given
+// histogram in a compact form, for example:
+//
+//	[1.048576ms, 1.081344ms):3 [770.048µs, 1.048576ms):12
+func renderTopBuckets(h *metrics.Float64Histogram, n int) redact.RedactableString {
+	var buf redact.StringBuilder
+	for i := len(h.Counts) - 1; i >= 0 && n > 0; i-- {
+		if h.Counts[i] == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.SafeRune(' ')
+		}
+		buf.Printf("[%s, %s):%d",
+			renderBucketBoundary(h.Buckets[i]), renderBucketBoundary(h.Buckets[i+1]), h.Counts[i])
+		n--
+	}
+	if buf.Len() == 0 {
+		return "none"
+	}
+	return buf.RedactableString()
+}
+
+// renderBucketBoundary renders a (second-denominated) bucket boundary as a
+// duration.
+func renderBucketBoundary(b float64) redact.SafeString {
+	if math.IsInf(b, -1) {
+		return "-Inf"
+	}
+	if math.IsInf(b, +1) {
+		return "+Inf"
+	}
+	return redact.SafeString(SecondsToDuration(b).String())
+}
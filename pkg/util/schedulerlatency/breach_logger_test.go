@@ -0,0 +1,131 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/redact"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreachLoggerHysteresis(t *testing.T) {
+	const threshold, ticks = time.Millisecond, 3
+	high, low := 2*time.Millisecond, 500*time.Microsecond
+
+	b := makeBreachLogger()
+	for i, tc := range []struct {
+		p99    time.Duration
+		expLog bool
+	}{
+		{high, false},
+		{high, false},
+		{high, true}, // third consecutive breach
+		{high, false},
+		{high, false}, // suppressed until the condition clears
+		{low, false},  // clears the condition
+		{high, false},
+		{high, false},
+		{high, false}, // rate-limited
+	} {
+		require.Equalf(t, tc.expLog, b.observe(tc.p99, threshold, ticks), "tick %d", i)
+	}
+
+	// Without rate-limiting, we'd log the second breach.
+	b = makeBreachLogger()
+	b.every = log.Every(0)
+	for i, tc := range []struct {
+		p99    time.Duration
+		expLog bool
+	}{
+		{high, false},
+		{low, false}, // not enough consecutive ticks
+		{high, false},
+		{high, false},
+		{high, true},
+		{low, false},
+		{high, false},
+		{high, false},
+		{high, true},
+	} {
+		require.Equalf(t, tc.expLog, b.observe(tc.p99, threshold, ticks), "tick %d", i)
+	}
+
+	// No logging if disabled.
+	b = makeBreachLogger()
+	for i := 0; i < 2*ticks; i++ {
+		require.False(t, b.observe(time.Hour, 0 /* threshold */, ticks))
+	}
+}
+
+// TestSamplerBreachLogging drives the sampler with injected histograms,
+// crossing and clearing the logging threshold.
+func TestSamplerBreachLogging(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	logThreshold.Override(ctx, &st.SV, time.Millisecond)
+	logConsecutiveTicks.Override(ctx, &st.SV, 2)
+
+	// Buckets: [0, 500µs), [500µs, 2ms), [2ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.0005, 0.002, math.Inf(+1)},
+	}
+	s := newSampler(st, time.Second, time.Second)
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+	tick := func(slow bool) {
+		if slow {
+			cumulative.Counts[1] += 100
+		} else {
+			cumulative.Counts[0] += 100
+		}
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Second)
+	}
+	state := func() (int64, bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.breachLogger.consecutive, s.mu.breachLogger.logged
+	}
+
+	tick(false) // nothing to compare against yet
+	tick(true)
+	consecutive, logged := state()
+	require.Equal(t, int64(1), consecutive)
+	require.False(t, logged)
+
+	tick(true)
+	consecutive, logged = state()
+	require.Equal(t, int64(2), consecutive)
+	require.True(t, logged)
+
+	tick(false)
+	consecutive, logged = state()
+	require.Equal(t, int64(0), consecutive)
+	require.False(t, logged)
+}
+
+func TestRenderTopBuckets(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{1, 0, 5, 7, 0, 2},
+		Buckets: []float64{math.Inf(-1), 0, 0.000001, 0.001, 0.002, 0.004, math.Inf(+1)},
+	}
+	require.Equal(t, redact.RedactableString("[4ms, +Inf):2 [1ms, 2ms):7"), renderTopBuckets(h, 2))
+	require.Equal(t, redact.RedactableString("[4ms, +Inf):2 [1ms, 2ms):7 [1µs, 1ms):5 [-Inf, 0s):1"),
+		renderTopBuckets(h, 10))
+	require.Equal(t, redact.RedactableString("none"),
+		renderTopBuckets(&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, 2))
+}
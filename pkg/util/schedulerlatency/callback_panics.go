@@ -0,0 +1,93 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+var maxCallbackPanics = settings.RegisterIntSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.callback_panics.max",
+	"number of consecutive panics after which a scheduler latency callback is unregistered",
+	3,
+	settings.PositiveInt,
+)
+
+var metaCallbackPanics = metric.Metadata{
+	Name:        "go.scheduler_latency.sampler.callback_panics",
+	Help:        "Number of panics recovered from while invoking scheduler latency callbacks",
+	Measurement: "Panics",
+	Unit:        metric.Unit_COUNT,
+}
+
+// panicTracker counts the consecutive panics of a callback.
+type panicTracker struct {
+	consecutive int64
+}
+
+// record whether the callback panicked when last invoked, returning true if it
+// has now done so max times in a row and is to be unregistered.
+func (p *panicTracker) record(panicked bool, max int64) (unregister bool) {
+	if !panicked {
+		p.consecutive = 0
+		return false
+	}
+	p.consecutive++
+	return p.consecutive >= max
+}
+
+// invokeCallbackLocked invokes the named callback through the given function,
+// recovering from any panic within it: the panic is logged and counted, and
+// true returned. Only the callback is protected; panics in the sampler's own
+// code aren't recovered from.
+func (s *sampler) invokeCallbackLocked(ctx context.Context, name string, invoke func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			s.metrics.CallbackPanics.Inc(1)
+			log.Errorf(ctx, "scheduler latency callback %s panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	invoke()
+	return false
+}
+
+// deliverLocked invokes, through the given function, the registered callbacks
+// that are due a delivery at the given time, recovering from their panics and
+// evicting those having panicked too many times in a row.
+func (r *callbackRegistry[CB]) deliverLocked(
+	ctx context.Context, s *sampler, at time.Time, fn func(CB),
+) {
+	maxPanics := maxCallbackPanics.Get(&s.mu.st.SV)
+	for _, cb := range r.snapshot() {
+		if !cb.throttle.ready(at) {
+			continue
+		}
+		panicked := s.invokeCallbackLocked(ctx, cb.name, func() { cb.invoke(fn) })
+		if cb.panics.record(panicked, maxPanics) {
+			logEviction(ctx, cb.name, maxPanics)
+			r.evict(cb.id)
+		}
+	}
+}
+
+// logEviction logs the unregistering of the named callback, having panicked the
+// given number of times in a row.
+func logEviction(ctx context.Context, name string, panics int64) {
+	log.Warningf(ctx, "unregistering scheduler latency callback %s after %d consecutive panics", name, panics)
+}
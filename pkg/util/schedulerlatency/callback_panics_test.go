@@ -0,0 +1,94 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallbackPanics verifies that panicking callbacks don't take down the
+// sampler, which keeps ticking and invoking the other callbacks, and that
+// callbacks panicking repeatedly are unregistered.
+func TestCallbackPanics(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, busySample())
+	st := s.st
+	tick := func() {
+		s.tick(ctx)
+	}
+
+	// A listener that panics every time, one that panics every other time, and
+	// one that doesn't.
+	var panicking, flaky panickingListener
+	flaky.every = 2
+	var healthy sampleListener
+	s.addListener(&panicking)
+	s.addListener(&flaky)
+	s.addListener(&healthy)
+
+	var mutexWaitCalls int
+	id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) {
+		mutexWaitCalls++
+		panic("mutex wait callback")
+	}, 0 /* minInterval */)
+	defer UnregisterMutexWaitCallback(id)
+
+	tick() // nothing to compare against yet
+	for i := 1; i <= 3; i++ {
+		tick()
+		require.Len(t, healthy.samples, i)
+		require.Equal(t, i, panicking.calls)
+		require.Equal(t, i, mutexWaitCalls)
+	}
+	// The consistently panicking callbacks were unregistered after 3 panics;
+	// the flaky one wasn't, never having panicked twice in a row.
+	require.Equal(t, int64(3+3+1), s.metrics.CallbackPanics.Count())
+	tick()
+	require.Len(t, healthy.samples, 4)
+	require.Equal(t, 3, panicking.calls)
+	require.Equal(t, 3, mutexWaitCalls)
+	require.Equal(t, 4, flaky.calls)
+	require.Equal(t, int64(3+3+2), s.metrics.CallbackPanics.Count())
+	require.Len(t, mutexWaitCallbacks.snapshot(), 0)
+
+	// The threshold is configurable.
+	maxCallbackPanics.Override(ctx, &st.SV, 1)
+	tick()
+	tick()
+	require.Equal(t, 6, flaky.calls) // panicked on its 6th call, and was unregistered
+	require.Len(t, healthy.samples, 6)
+	s.mu.Lock()
+	require.Len(t, s.mu.listeners, 1)
+	s.mu.Unlock()
+
+	// Owners can still remove what was unregistered on their behalf; the
+	// deferred UnregisterMutexWaitCallback doesn't panic either.
+	s.removeListener(&panicking)
+	s.removeListener(&flaky)
+}
+
+// panickingListener is a listener that panics every so many calls (every call,
+// by default).
+type panickingListener struct {
+	calls int
+	every int
+}
+
+func (l *panickingListener) SchedulerLatency(time.Duration, time.Duration) {
+	l.calls++
+	if l.every <= 1 || l.calls%l.every == 0 {
+		panic("listener")
+	}
+}
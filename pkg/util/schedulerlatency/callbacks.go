@@ -10,10 +10,475 @@
 
 package schedulerlatency
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
 
 type LatencyObserver interface {
 	// SchedulerLatency is provided the current value of the scheduler's p99 latency and the
 	// period over which the measurement applies.
 	SchedulerLatency(p99 time.Duration, period time.Duration)
 }
+
+// SampleObserver is a LatencyObserver that's provided the full Sample. Listeners
+// that implement it are invoked through SchedulerLatencySample instead of
+// SchedulerLatency.
+type SampleObserver interface {
+	LatencyObserver
+	// SchedulerLatencySample is provided the scheduler latency observed over
+	// the most recent window, which may be provisional (see
+	// Sample.Provisional).
+	SchedulerLatencySample(Sample)
+}
+
+// Sample is the scheduler latency observed over a window.
+type Sample struct {
+	// P99 is the p99 scheduler latency over the window.
+	P99 time.Duration
+	// P99Slope is the change in P99 per second, fitted over the most recent
+	// deliveries (scheduler_latency.trend.deliveries). It's negative when the
+	// latency is falling, letting consumers damp their response. It's zero
+	// until there are at least two deliveries since the sampler last started
+	// or re-baselined.
+	P99Slope time.Duration
+	// P99EWMA is an exponentially weighted moving average of P99, weighing the
+	// most recent window by scheduler_latency.ewma.alpha. Like P99Slope, it
+	// starts afresh when the sampler starts or re-baselines.
+	P99EWMA time.Duration
+	// P99RollingMax is the maximum P99 delivered over the trailing
+	// scheduler_latency.rolling_max.horizon, a less noisy indicator of spikes
+	// than any single P99. It's zero if the horizon is zero.
+	P99RollingMax time.Duration
+	// StdDev is the standard deviation of the scheduler latency over the
+	// window, approximated from the bucket midpoints of the window's histogram
+	// (see histogramutil.StdDev; it's off by at most half the width of the
+	// widest non-empty bucket, and excludes the latencies beyond the runtime's
+	// highest bucket). Unlike the percentiles, it tells steady latency apart
+	// from alternating calm and spikes. It's zero if the window is Idle.
+	StdDev time.Duration
+	// Events is the number of goroutine scheduling events observed over the
+	// window, from which the percentiles are computed; the fewer there are,
+	// the less meaningful the percentiles. See Idle.
+	Events uint64
+	// EventsPerSecond is the rate of Events over Elapsed, a proxy for scheduler
+	// churn (roughly, goroutine wakeups per second) that helps interpret the
+	// percentiles. It's computed over idle windows too, and is zero if no
+	// events were observed or no time elapsed. Over Gapped windows, it's
+	// averaged over the whole of Elapsed.
+	EventsPerSecond float64
+	// Period is the nominal duration between consecutive samples
+	// (scheduler_latency.sample_period, or derived from GOMAXPROCS if that's
+	// zero).
+	Period time.Duration
+	// At is when the latest sample in the window was taken.
+	At time.Time
+	// Elapsed is the time elapsed between the oldest and latest samples in the
+	// window. Ticks are delayed by GC pauses or CPU starvation, exactly the
+	// scenarios being measured, so this can be larger than the nominal window
+	// (scheduler_latency.sample_duration); computing rates should use it
+	// instead. It also differs from the nominal window, by up to half a
+	// Period, when the duration isn't a multiple of the period: the window
+	// spans whichever number of periods covers the time nearest it.
+	Elapsed time.Duration
+	// Provisional is set if the window is partial: after the sampler starts or
+	// re-baselines, and until it observes a full window, samples are delivered
+	// over the (at least two) samples taken so far, whose span is Elapsed.
+	// They're noisier, and consumers can choose to ignore them. P99Slope and
+	// P99EWMA are zero, and the values aren't reflected in Latest or the
+	// exported metrics.
+	Provisional bool
+	// Idle is set if there were fewer Events than
+	// scheduler_latency.idle_window.min_events, too few for the percentiles to
+	// mean anything: there's no data, and P99 and P99Slope are zero. P99EWMA
+	// and P99RollingMax hold their values (the latter still ages out), and so
+	// do their gauges, while the windowed quantile gauges report zero. Like
+	// provisional samples, idle samples are only delivered to SampleObservers.
+	Idle bool
+	// Gapped is set if Elapsed exceeds the nominal span of the window by more
+	// than scheduler_latency.gapped_window.factor: ticks were delayed far
+	// beyond the sample period, and the percentiles blend in a much longer
+	// interval than intended. The sample is delivered nonetheless; consumers
+	// can choose to discount it.
+	Gapped bool
+	// Final is set on the last sample delivered as the sampler stops: its
+	// stopper quiescing or, for the sampler started through StartSampler, once
+	// every caller's has. It's computed over the time since the previous tick,
+	// a partial window whose span is Elapsed, so that the latencies observed
+	// last (say, while a node drains) aren't discarded. It's delivered to
+	// SampleObservers only, regardless of their minimum delivery interval,
+	// and over the sampler's own window. P99Slope, P99EWMA, P99RollingMax and
+	// Percentiles are zero, and the values aren't reflected in Latest or the
+	// exported metrics; consumers that don't care can ignore it.
+	Final bool
+	// GOMAXPROCS is the value of GOMAXPROCS over the window, or zero if
+	// unknown. The sampler re-baselines when it changes, so the window never
+	// spans a change.
+	GOMAXPROCS int
+	// LatencyRatio normalizes P99 into a load signal, comparable across
+	// machine sizes and scheduling rates:
+	//
+	//	LatencyRatio = P99 * (Events / Elapsed) / GOMAXPROCS
+	//
+	// Events / Elapsed is the rate at which goroutines were scheduled; by
+	// Little's law, that rate times the time they waited is the number of
+	// goroutines waiting to be scheduled. Weighed by the p99 wait rather than
+	// the mean, and divided among the Ps, it's the tail of the run queue length
+	// per P: near zero when goroutines are scheduled promptly, and 1 or more
+	// when they're routinely queued behind busy Ps. It's zero if the window is
+	// Idle, if GOMAXPROCS is unknown, or if no time elapsed; consumers can
+	// recompute it from the other fields.
+	LatencyRatio float64
+	// TailRatio is the ratio of the p99 scheduler latency to the p50 over the
+	// window, telling general overload (a high p99 with a high p50, for a
+	// ratio near 1) apart from sporadic stalls (a high p99 with a tiny p50, for
+	// a high ratio). It's at least 1 when defined; it's zero, the ratio being
+	// absent, if the window is Idle or its p50 is zero.
+	TailRatio float64
+	// DistributionShift is the total variation distance between the
+	// distribution of the scheduler latency over the window and over the
+	// previous full one, re-binned by powers of two: the fraction of the
+	// events that would have to move for the two to match. It ranges from 0
+	// (unchanged) to 1 (disjoint), and tells apart a meaningful shift from
+	// noise moving a single percentile, so that controllers can gate their
+	// reactions on it. It's only set if HasDistributionShift is, which it
+	// isn't for provisional, idle and final samples, nor for the first full
+	// window after the sampler starts or re-baselines, or one following a
+	// window that observed no events. Like P99Slope, it's computed over the
+	// sampler's own windows.
+	DistributionShift float64
+	// HasDistributionShift is set if DistributionShift is, the score being
+	// absent rather than zero otherwise.
+	HasDistributionShift bool
+	// CPUUtilization is the fraction of the CPU time available to the runtime
+	// (GOMAXPROCS times Elapsed, as estimated by the runtime) that was spent
+	// running user code, the GC, or the scavenger, as opposed to idling, over the
+	// window. The same P99 means something different at 30% utilization than
+	// at 95%. It's zero if it can't be computed, which doesn't affect the
+	// other fields: if the runtime doesn't support the CPU class metrics, or if
+	// any of them decreased over the window.
+	CPUUtilization float64
+	// Percentiles are the latencies at SamplerOptions.Percentiles, in the same
+	// order, for samplers constructed using NewSampler. They're nil for idle
+	// and provisional samples, for listeners that requested their own windows,
+	// and for the sampler started through StartSampler.
+	Percentiles []time.Duration
+	// Window is the configuration of the window the sample was computed over:
+	// the sampler's own, or the listener's if it requested its own.
+	Window WindowConfig
+	// Reconfigured is set if the window was discarded as the sample period or
+	// duration in effect changed (settings changes, a period derived from
+	// GOMAXPROCS, or SetTemporaryPeriod). It's set on the provisional samples
+	// delivered afterwards, whose windows match neither the previous
+	// configuration nor the new one, and cleared from the first full window
+	// of the new configuration on.
+	Reconfigured bool
+	// Previous is the most recent full window previously delivered to the
+	// listener, for consumers to compute the change since without keeping
+	// state of their own. Provisional, idle and final samples aren't recorded
+	// as such (they're delivered their predecessor nonetheless), and samples
+	// suppressed by a minimum delivery interval or delta suppression weren't
+	// delivered. It's nil, the previous value being absent rather than zero,
+	// until the listener is delivered a full window after the sampler starts
+	// or re-baselines.
+	Previous *PreviousSample
+}
+
+// WithMinDeliveryInterval wraps the given listener for it to be invoked at most
+// once every interval, instead of every tick, for consumers that don't need
+// every sample (metrics exporters, loggers). When it is invoked, it's provided
+// the most recent window. A zero interval returns the listener as is, to be
+// invoked every tick.
+func WithMinDeliveryInterval(listener LatencyObserver, interval time.Duration) LatencyObserver {
+	if listener == nil || interval == 0 {
+		return listener
+	}
+	return &intervalListener{LatencyObserver: listener, interval: interval}
+}
+
+// intervalListener is a listener with a minimum delivery interval; see
+// WithMinDeliveryInterval.
+type intervalListener struct {
+	LatencyObserver
+	interval time.Duration
+}
+
+const (
+	// HighestPriority is the priority admission-control-critical listeners,
+	// such as the CPU granter's, should be added with: the latency signal is
+	// then delivered to them ahead of slower consumers (metrics exporters,
+	// loggers) within each tick.
+	HighestPriority = math.MinInt8
+	// DefaultPriority is the priority of listeners not wrapped using
+	// WithPriority.
+	DefaultPriority = 0
+)
+
+// WithPriority wraps the given listener for it to be invoked in order of the
+// given priority: within each tick, listeners with lower priorities are invoked
+// first, and ties are invoked in the order the listeners were added. It can be
+// combined with WithMinDeliveryInterval.
+func WithPriority(listener LatencyObserver, priority int) LatencyObserver {
+	if listener == nil {
+		return nil
+	}
+	return &priorityListener{LatencyObserver: listener, priority: priority}
+}
+
+// priorityListener is a listener with a priority; see WithPriority.
+type priorityListener struct {
+	LatencyObserver
+	priority int
+}
+
+// WithWindowDuration wraps the given listener for it to be delivered the
+// scheduler latency observed over a window of the given duration, instead of
+// scheduler_latency.sample_duration, for consumers that want more (or less)
+// smoothing than others. The duration is rounded to the nearest multiple of
+// scheduler_latency.sample_period, and capped by
+// scheduler_latency.listener_window.max_duration; the sampler retains samples
+// for the longest window requested. Until its window fills up, the listener is
+// delivered a provisional one (see Sample.Provisional). P99Slope, P99EWMA and
+// P99RollingMax are still derived from the sampler's own windows. A zero
+// duration returns the listener as is. It can be combined with
+// WithMinDeliveryInterval and WithPriority.
+func WithWindowDuration(listener LatencyObserver, duration time.Duration) LatencyObserver {
+	if listener == nil || duration == 0 {
+		return listener
+	}
+	return &windowListener{LatencyObserver: listener, duration: duration}
+}
+
+// windowListener is a listener with its own window duration; see
+// WithWindowDuration.
+type windowListener struct {
+	LatencyObserver
+	duration time.Duration
+}
+
+// deliveryThrottle tracks the last delivery to a callback registered with a
+// minimum delivery interval, to decide whether it's due another one.
+type deliveryThrottle struct {
+	interval time.Duration
+	last     time.Time // zero if yet to be delivered to
+}
+
+// ready returns true, recording a delivery, if the callback is due one at the
+// given time.
+func (t *deliveryThrottle) ready(at time.Time) bool {
+	if t.interval > 0 && !t.last.IsZero() && at.Sub(t.last) < t.interval {
+		return false
+	}
+	t.last = at
+	return true
+}
+
+// observe invokes the given listener with the given sample, shimming it through
+// the legacy interface if needed.
+func observe(listener LatencyObserver, s Sample) {
+	if o, ok := listener.(SampleObserver); ok {
+		o.SchedulerLatencySample(s)
+		return
+	}
+	listener.SchedulerLatency(s.P99, s.Period)
+}
+
+// MutexWaitCallback is provided the total time goroutines spent blocked on a
+// sync.Mutex or sync.RWMutex over the most recent window, and the time elapsed
+// over that window (see Sample.Elapsed).
+type MutexWaitCallback func(wait time.Duration, elapsed time.Duration)
+
+// RegisterMutexWaitCallback registers a callback to be run with the observed
+// mutex wait time every scheduler_latency.sample_period, or at most once every
+// minInterval if that's longer (zero runs it every tick).
+func RegisterMutexWaitCallback(cb MutexWaitCallback, minInterval time.Duration) (id int64) {
+	return mutexWaitCallbacks.register(cb, minInterval)
+}
+
+// UnregisterMutexWaitCallback unregisters a callback registered through
+// RegisterMutexWaitCallback. Once it returns, the callback isn't run again; it
+// waits for an ongoing run, if any, so it mustn't be called from within the
+// callback itself.
+func UnregisterMutexWaitCallback(id int64) {
+	mutexWaitCallbacks.unregister(id)
+}
+
+// GCPauseCallback is provided the p99 of GC stop-the-world pauses over the most
+// recent window, and the time elapsed over that window (see Sample.Elapsed).
+type GCPauseCallback func(p99 time.Duration, elapsed time.Duration)
+
+// RegisterGCPauseCallback registers a callback to be run with the observed GC
+// pause p99 every scheduler_latency.sample_period, or at most once every
+// minInterval if that's longer (zero runs it every tick). Callbacks are only
+// run if scheduler_latency.gc_pauses.enabled is set.
+func RegisterGCPauseCallback(cb GCPauseCallback, minInterval time.Duration) (id int64) {
+	return gcPauseCallbacks.register(cb, minInterval)
+}
+
+// UnregisterGCPauseCallback unregisters a callback registered through
+// RegisterGCPauseCallback. Like UnregisterMutexWaitCallback, it mustn't be
+// called from within the callback itself.
+func UnregisterGCPauseCallback(id int64) {
+	gcPauseCallbacks.unregister(id)
+}
+
+var (
+	mutexWaitCallbacks = callbackRegistry[MutexWaitCallback]{kind: "mutex wait"}
+	gcPauseCallbacks   = callbackRegistry[GCPauseCallback]{kind: "GC pause"}
+)
+
+// callbackRegistry is a process-wide registry of callbacks run by the sampler.
+// The callbacks registered are kept in an immutable slice, replaced whenever
+// one is (un)registered, for the sampler to load it every tick without locking.
+type callbackRegistry[CB any] struct {
+	kind      string // the kind of callbacks registered, used to name them
+	callbacks atomic.Pointer[[]*registeredCallback[CB]]
+	mu        struct {
+		// Mutex serializes (un)registering callbacks, not loading them.
+		syncutil.Mutex
+		nextID int64
+		// evicted contains the IDs of callbacks unregistered by the sampler
+		// after repeatedly panicking, but yet to be unregistered by their
+		// owners.
+		evicted map[int64]struct{}
+	}
+}
+
+type registeredCallback[CB any] struct {
+	cb   CB
+	id   int64  // used to uniquely identify a registered callback; used when unregistering
+	name string // used when logging panics
+	// throttle and panics are only accessed by the (process-wide) sampler,
+	// under its lock.
+	throttle deliveryThrottle
+	panics   panicTracker
+	// mu is held while the callback is invoked, for unregistering it to wait
+	// out an ongoing invocation: it's never invoked once removed is set.
+	mu struct {
+		syncutil.Mutex
+		removed bool
+	}
+}
+
+// invoke runs the given function with the callback, unless it's been
+// unregistered.
+func (c *registeredCallback[CB]) invoke(fn func(CB)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.mu.removed {
+		fn(c.cb)
+	}
+}
+
+// remove marks the callback as unregistered, waiting for an ongoing
+// invocation, if any, to return.
+func (c *registeredCallback[CB]) remove() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.removed = true
+}
+
+func (r *callbackRegistry[CB]) register(cb CB, minInterval time.Duration) (id int64) {
+	return r.registerNamed(cb, minInterval, funcName(cb))
+}
+
+// registerNamed is like register, naming the callback as given in logs.
+func (r *callbackRegistry[CB]) registerNamed(
+	cb CB, minInterval time.Duration, name string,
+) (id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id = r.mu.nextID
+	r.mu.nextID++
+	oldCBs := r.snapshot()
+	newCBs := make([]*registeredCallback[CB], len(oldCBs), len(oldCBs)+1)
+	copy(newCBs, oldCBs)
+	newCBs = append(newCBs, &registeredCallback[CB]{
+		cb:       cb,
+		id:       id,
+		name:     fmt.Sprintf("%s callback %d (%s)", r.kind, id, name),
+		throttle: deliveryThrottle{interval: minInterval},
+	})
+	r.callbacks.Store(&newCBs)
+	return id
+}
+
+func (r *callbackRegistry[CB]) unregister(id int64) {
+	r.mu.Lock()
+	if _, ok := r.mu.evicted[id]; ok {
+		delete(r.mu.evicted, id)
+		r.mu.Unlock()
+		return
+	}
+	c := r.removeLocked(id)
+	r.mu.Unlock()
+	if c == nil {
+		panic(errors.AssertionFailedf("unexpected unregister of %s callback %d", r.kind, id))
+	}
+	// Outside of the registry's lock, for an ongoing invocation to be able to
+	// (un)register other callbacks.
+	c.remove()
+}
+
+// evict unregisters the given callback on behalf of its owner, who can still
+// unregister it subsequently. It's a no-op if the owner already has.
+func (r *callbackRegistry[CB]) evict(id int64) {
+	r.mu.Lock()
+	c := r.removeLocked(id)
+	if c == nil {
+		r.mu.Unlock()
+		return
+	}
+	if r.mu.evicted == nil {
+		r.mu.evicted = make(map[int64]struct{})
+	}
+	r.mu.evicted[id] = struct{}{}
+	r.mu.Unlock()
+	c.remove()
+}
+
+// removeLocked removes the given callback from the slice of registered ones,
+// returning it, or nil if it isn't registered.
+func (r *callbackRegistry[CB]) removeLocked(id int64) *registeredCallback[CB] {
+	oldCBs := r.snapshot()
+	var removed *registeredCallback[CB]
+	newCBs := make([]*registeredCallback[CB], 0, len(oldCBs))
+	for _, c := range oldCBs {
+		if c.id == id {
+			removed = c
+			continue
+		}
+		newCBs = append(newCBs, c)
+	}
+	if removed == nil {
+		return nil
+	}
+	r.callbacks.Store(&newCBs)
+	return removed
+}
+
+// snapshot returns the currently registered callbacks, with a single atomic
+// load. The returned slice is never mutated ((un)registering creates a new
+// one), so it's safe to range over it while callbacks are (un)registered.
+// Callbacks unregistered since are skipped by invoke.
+func (r *callbackRegistry[CB]) snapshot() []*registeredCallback[CB] {
+	if cbs := r.callbacks.Load(); cbs != nil {
+		return *cbs
+	}
+	return nil
+}
+
+// funcName returns the name of the given function, for logging.
+func funcName(fn any) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallbackRegistryConcurrency (un)registers callbacks concurrently with
+// ticks, verifying that they're run while registered, and never once
+// unregistering them has returned. It's most useful under the race detector.
+func TestCallbackRegistryConcurrency(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, busySample())
+
+	var done atomic.Bool
+	var ticks sync.WaitGroup
+	ticks.Add(1)
+	go func() {
+		defer ticks.Done()
+		for !done.Load() {
+			s.tick(ctx)
+		}
+	}()
+
+	const workers, iterations = 8, 200
+	var runs atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				var unregistered atomic.Bool
+				id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) {
+					if unregistered.Load() {
+						t.Error("callback run after being unregistered")
+					}
+					runs.Add(1)
+				}, 0 /* minInterval */)
+				// Give the callback a chance to run every so often.
+				if i%10 == 0 {
+					for start := runs.Load(); runs.Load() == start; {
+						time.Sleep(time.Millisecond)
+					}
+				}
+				UnregisterMutexWaitCallback(id)
+				unregistered.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	done.Store(true)
+	ticks.Wait()
+	require.Positive(t, runs.Load())
+	require.Empty(t, mutexWaitCallbacks.snapshot())
+}
+
+// TestCallbackRegistryReentrancy verifies that callbacks can (un)register
+// other callbacks while being run.
+func TestCallbackRegistryReentrancy(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, busySample())
+	tick := func() {
+		s.tick(ctx)
+	}
+
+	var otherRuns int
+	other := func(time.Duration, time.Duration) { otherRuns++ }
+	otherID := int64(-1)
+	id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) {
+		if otherID < 0 {
+			otherID = RegisterMutexWaitCallback(other, 0 /* minInterval */)
+		} else {
+			UnregisterMutexWaitCallback(otherID)
+			otherID = -1
+		}
+	}, 0 /* minInterval */)
+	defer UnregisterMutexWaitCallback(id)
+
+	tick() // nothing to compare against yet
+	// The callback registered mid-tick isn't run on that tick, the snapshot
+	// having been loaded already.
+	tick()
+	require.Len(t, mutexWaitCallbacks.snapshot(), 2)
+	require.Zero(t, otherRuns)
+	// It's skipped once unregistered by the first one, even though the
+	// snapshot loaded for the tick still contains it.
+	tick()
+	require.Zero(t, otherRuns)
+	require.Len(t, mutexWaitCallbacks.snapshot(), 1)
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/cgroups"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// cgroupThrottlingEnabled controls the cgroup CPU throttling sampler. Like the
+// GC pause sampler, it piggybacks on the scheduler latency sampler: the
+// throttling counters are read every tick, and windowed over the same
+// scheduler_latency.sample_{period,duration}.
+var cgroupThrottlingEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.cgroup_throttling.enabled",
+	"when set, the time the process's cgroup was CPU throttled is computed over every "+
+		"scheduler_latency.sample_duration, and exported; it has no effect outside of a cgroup "+
+		"with a CPU controller",
+	false,
+)
+
+var metaCgroupThrottled = metric.Metadata{
+	Name:        "go.cgroup_cpu_throttled",
+	Help:        "Time the process's cgroup was CPU throttled over the last scheduler_latency.sample_duration (if scheduler_latency.cgroup_throttling.enabled is set)",
+	Measurement: "Nanoseconds",
+	Unit:        metric.Unit_NANOSECONDS,
+}
+
+// cgroupThrottlingMetric names the cgroup CPU throttling counter; it's not a
+// runtime/metrics metric.
+const cgroupThrottlingMetric = "cgroup cpu.stat throttled time"
+
+// CgroupThrottlingCallback is provided the time the process's cgroup was CPU
+// throttled over the most recent window, and the time elapsed over that window
+// (see Sample.Elapsed). Throttling stalls every goroutine at once, which the
+// Go scheduler doesn't observe as latency: a high scheduler latency with
+// little throttling means the runtime is overloaded, while throttling means
+// the cgroup's CPU quota is exhausted.
+type CgroupThrottlingCallback func(throttled time.Duration, elapsed time.Duration)
+
+// RegisterCgroupThrottlingCallback registers a callback to be run with the
+// observed cgroup CPU throttling every scheduler_latency.sample_period, or at
+// most once every minInterval if that's longer (zero runs it every tick).
+// Callbacks are only run if scheduler_latency.cgroup_throttling.enabled is
+// set, and if the process runs in a cgroup with a CPU controller.
+func RegisterCgroupThrottlingCallback(
+	cb CgroupThrottlingCallback, minInterval time.Duration,
+) (id int64) {
+	return cgroupThrottlingCallbacks.register(cb, minInterval)
+}
+
+// UnregisterCgroupThrottlingCallback unregisters a callback registered through
+// RegisterCgroupThrottlingCallback. Like UnregisterMutexWaitCallback, it
+// mustn't be called from within the callback itself.
+func UnregisterCgroupThrottlingCallback(id int64) {
+	cgroupThrottlingCallbacks.unregister(id)
+}
+
+var cgroupThrottlingCallbacks = callbackRegistry[CgroupThrottlingCallback]{kind: "cgroup throttling"}
+
+// cpuThrottlingReader reads the cumulative CPU throttling counters of a
+// cgroup; see cgroups.CPUThrottlingReader.
+type cpuThrottlingReader interface {
+	Read() (cgroups.CPUThrottling, error)
+}
+
+// cgroupThrottlingSampler reads the cgroup CPU throttling counters for the
+// runtime sampler. The cgroup is located the first time the counters are read,
+// not at all unless scheduler_latency.cgroup_throttling.enabled is set; if
+// there's none (outside of Linux, or of a cgroup with a CPU controller), the
+// counters are never read, and their window never fills up. It's guarded by
+// the sampler's lock.
+type cgroupThrottlingSampler struct {
+	// newReader constructs the reader; it's cgroups.NewCPUThrottlingReader
+	// unless overridden in tests.
+	newReader func() (cpuThrottlingReader, error)
+	// reader is the reader constructed, if constructing it succeeded, once
+	// initialized is set.
+	reader      cpuThrottlingReader
+	initialized bool
+}
+
+func makeCgroupThrottlingSampler() cgroupThrottlingSampler {
+	return cgroupThrottlingSampler{
+		newReader: func() (cpuThrottlingReader, error) {
+			return cgroups.NewCPUThrottlingReader()
+		},
+	}
+}
+
+// read reads the cumulative throttled time, in seconds, or the zero value if
+// there's no cgroup to read it from or reading it failed.
+func (c *cgroupThrottlingSampler) read() runtimeValue {
+	if !c.initialized {
+		c.initialized = true
+		if r, err := c.newReader(); err == nil {
+			c.reader = r
+		}
+	}
+	if c.reader == nil {
+		return runtimeValue{}
+	}
+	t, err := c.reader.Read()
+	if err != nil {
+		return runtimeValue{}
+	}
+	return runtimeValue{counter: float64(t.ThrottledTime) / float64(time.Second), ok: true}
+}
+
+// cgroupThrottlingRuntimeMetric describes the cgroup throttled time counter to
+// the runtime sampler. It's windowed independently of the scheduler latencies
+// (it's only read if enabled), and the increase over every full window is
+// exported and delivered to the cgroup throttling callbacks.
+func (s *sampler) cgroupThrottlingRuntimeMetric() *runtimeMetric {
+	return &runtimeMetric{
+		name:        cgroupThrottlingMetric,
+		kind:        counterMetric,
+		enabled:     cgroupThrottlingEnabled,
+		independent: true,
+		summarize:   windowIncrease,
+		gauge:       s.metrics.CgroupThrottled,
+		deliver:     s.invokeCgroupThrottlingCallbacksLocked,
+		// The sample function is invoked with s.mu held.
+		read: func() runtimeValue { return s.mu.cgroupThrottling.read() },
+	}
+}
+
+// invokeCgroupThrottlingCallbacksLocked invokes the cgroup throttling callbacks
+// that are due a delivery.
+func (s *sampler) invokeCgroupThrottlingCallbacksLocked(
+	ctx context.Context, throttled, elapsed time.Duration, at time.Time,
+) {
+	cgroupThrottlingCallbacks.deliverLocked(ctx, s, at, func(f CgroupThrottlingCallback) {
+		f(throttled, elapsed)
+	})
+}
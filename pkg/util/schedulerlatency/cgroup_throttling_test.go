@@ -0,0 +1,137 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/cgroups"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeThrottlingReader is a cpuThrottlingReader over scripted counters.
+type fakeThrottlingReader struct {
+	throttled time.Duration // cumulative
+	err       error
+	reads     int
+}
+
+func (r *fakeThrottlingReader) Read() (cgroups.CPUThrottling, error) {
+	r.reads++
+	if r.err != nil {
+		return cgroups.CPUThrottling{}, r.err
+	}
+	return cgroups.CPUThrottling{ThrottledTime: uint64(r.throttled)}, nil
+}
+
+// TestCgroupThrottling verifies that the time the cgroup was CPU throttled is
+// windowed like the scheduler latencies, exported, and delivered to the
+// callbacks registered, if enabled.
+func TestCgroupThrottling(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	st := s.st
+	reader := &fakeThrottlingReader{}
+	var constructed int
+	s.mu.cgroupThrottling.newReader = func() (cpuThrottlingReader, error) {
+		constructed++
+		return reader, nil
+	}
+	// The cgroup is throttled for 100ms every tick. The independently windowed
+	// metrics are read through the runtime sampler, alongside the injected
+	// latencies.
+	latencies := busySample()
+	s.sample = func() runtimeSample {
+		reader.throttled += 100 * time.Millisecond
+		res := latencies()
+		res.windowed = s.mu.runtime.sample(&s.mu.st.SV).windowed
+		return res
+	}
+	type delivery struct{ throttled, elapsed time.Duration }
+	var deliveries []delivery
+	id := RegisterCgroupThrottlingCallback(func(throttled, elapsed time.Duration) {
+		deliveries = append(deliveries, delivery{throttled, elapsed})
+	}, 0 /* minInterval */)
+	defer UnregisterCgroupThrottlingCallback(id)
+	tick := func(n int) {
+		for i := 0; i < n; i++ {
+			s.tick(ctx)
+		}
+	}
+
+	// It isn't read at all unless enabled.
+	tick(4)
+	require.Zero(t, constructed)
+	require.Zero(t, reader.reads)
+	require.Empty(t, deliveries)
+
+	cgroupThrottlingEnabled.Override(ctx, &st.SV, true)
+	tick(2)
+	require.Equal(t, 1, constructed)
+	require.Empty(t, deliveries) // the window is yet to fill up
+	tick(2)
+	// Over every window of two seconds, the cgroup was throttled for 200ms.
+	require.Equal(t, []delivery{
+		{200 * time.Millisecond, 2 * time.Second},
+		{200 * time.Millisecond, 2 * time.Second},
+	}, deliveries)
+	require.Equal(t, (200 * time.Millisecond).Nanoseconds(), s.metrics.CgroupThrottled.Value())
+	require.Equal(t, 1, constructed) // the cgroup is only located once
+
+	// Failing to read the counters re-baselines the window, and clears the
+	// gauge.
+	reader.err = errors.New("boom")
+	tick(2)
+	require.Len(t, deliveries, 2)
+	require.Zero(t, s.metrics.CgroupThrottled.Value())
+	reader.err = nil
+	tick(3)
+	require.Len(t, deliveries, 3)
+
+	// Disabling it re-baselines the window, and clears the gauge.
+	cgroupThrottlingEnabled.Override(ctx, &st.SV, false)
+	reads := reader.reads
+	tick(3)
+	require.Equal(t, reads, reader.reads)
+	require.Len(t, deliveries, 3)
+	require.Zero(t, s.metrics.CgroupThrottled.Value())
+}
+
+// TestCgroupThrottlingNoCgroup verifies that sampling the cgroup throttling
+// cleanly no-ops if there's no cgroup to read it from.
+func TestCgroupThrottlingNoCgroup(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	st := s.st
+	cgroupThrottlingEnabled.Override(ctx, &st.SV, true)
+	var constructed int
+	s.mu.cgroupThrottling.newReader = func() (cpuThrottlingReader, error) {
+		constructed++
+		return nil, errors.New("no cpu controller detected")
+	}
+	var delivered bool
+	id := RegisterCgroupThrottlingCallback(func(time.Duration, time.Duration) {
+		delivered = true
+	}, 0 /* minInterval */)
+	defer UnregisterCgroupThrottlingCallback(id)
+	var listener sampleListener
+	s.addListener(&listener)
+	for i := 0; i < 5; i++ {
+		s.tick(ctx)
+	}
+	require.Equal(t, 1, constructed)
+	require.False(t, delivered)
+	require.Zero(t, s.metrics.CgroupThrottled.Value())
+	require.NotEmpty(t, listener.samples) // the latencies are sampled regardless
+}
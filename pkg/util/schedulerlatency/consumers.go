@@ -0,0 +1,70 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+// hasConsumersLocked returns true if anything consumes what the sampler
+// computes: a listener, a callback or subscription registered with the
+// package, metrics registered by a caller, or an enabled exporter (the heatmap,
+// the breach logger, or the snapshot logger). The overload monitor attached to
+// the shared sampler only counts if scheduler_latency.overload.threshold is
+// set. Readers of Latest and the debug endpoints can't be told apart from
+// nobody, and don't count.
+//
+// It's checked every tick, under the sampler's lock; a consumer registered
+// concurrently with a tick is observed on the next one at the latest.
+func (s *sampler) hasConsumersLocked() bool {
+	sv := &s.mu.st.SV
+	for _, l := range s.mu.listeners {
+		if _, ok := l.target.(*overloadMonitor); !ok || overloadThreshold.Get(sv) > 0 {
+			return true
+		}
+	}
+	if s.standalone {
+		return false // none of the below apply
+	}
+	return len(s.mu.registrations) > 0 ||
+		len(mutexWaitCallbacks.snapshot()) > 0 ||
+		len(gcPauseCallbacks.snapshot()) > 0 ||
+		len(overloadCallbacks.snapshot()) > 0 ||
+		len(cgroupThrottlingCallbacks.snapshot()) > 0 ||
+		len(subscriptions.snapshot()) > 0 ||
+		heatmapEnabled.Get(sv) ||
+		logThreshold.Get(sv) > 0 ||
+		snapshotLogInterval.Get(sv) > 0
+}
+
+// pauseLocked pauses the sampler, it having no consumers: it stops sampling the
+// runtime metrics, discarding the samples and values retained, until the first
+// tick to observe a consumer again, whose sample is the new baseline. It's a
+// no-op if the sampler is already paused.
+func (s *sampler) pauseLocked(ctx context.Context) {
+	if s.mu.paused {
+		return
+	}
+	log.Infof(ctx, "no scheduler latency consumers, pausing the sampler")
+	s.mu.paused = true
+	s.resetWindowLocked()
+	// The interval spanning the pause isn't aggregated either.
+	s.mu.latestCumulative = nil
+	s.mu.heatmap.clear()
+	s.mu.trend.reset()
+	s.mu.ewma.reset()
+	s.mu.overload.reset() // there's nobody to deliver the transition to
+	if !s.standalone {
+		latest.Store(nil) // we're yet to observe a full window once resumed
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSamplerPausesWithoutConsumers verifies that the sampler stops sampling
+// while it has no consumers, discarding what it retained, and resumes afresh
+// once it has one, through every kind of consumer.
+func TestSamplerPausesWithoutConsumers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	st := s.st
+	heatmapEnabled.Override(ctx, &st.SV, false)
+	sample := busySample()
+	var sampled int
+	s.sample = func() runtimeSample {
+		sampled++
+		return sample()
+	}
+	tick := func() {
+		s.tick(ctx)
+	}
+	// requirePaused ticks, requiring the sampler to pause (or stay paused),
+	// having discarded everything retained.
+	requirePaused := func() {
+		t.Helper()
+		prev := sampled
+		tick()
+		require.True(t, s.mu.paused)
+		require.Equal(t, prev, sampled)
+		require.Zero(t, s.mu.ringBuffer.Len())
+		require.Nil(t, s.mu.latestCumulative)
+		require.Nil(t, s.mu.lastInterval)
+		require.Nil(t, latest.Load())
+	}
+	// requireActive ticks thrice, requiring the sampler to resume (or stay
+	// active), re-baselining if it resumed.
+	requireActive := func() {
+		t.Helper()
+		paused := s.mu.paused
+		prev := sampled
+		for i := 0; i < 3; i++ {
+			tick()
+		}
+		require.False(t, s.mu.paused)
+		require.Equal(t, prev+3, sampled)
+		if paused {
+			// The first sample is the baseline, the second yields a
+			// provisional window, and the third a full one.
+			require.Equal(t, 2*time.Second, s.mu.lastWindow.elapsed)
+		}
+		require.NotNil(t, latest.Load())
+	}
+
+	requirePaused()
+	requirePaused()
+	require.Zero(t, s.metrics.Ticks.Count())
+
+	t.Run("listener", func(t *testing.T) {
+		var listener sampleListener
+		s.addListener(&listener)
+		requireActive()
+		require.Len(t, listener.samples, 1)
+		s.removeListener(&listener)
+		requirePaused()
+	})
+
+	t.Run("overload monitor", func(t *testing.T) {
+		// The overload monitor only counts once its events are enabled.
+		m := newOverloadMonitor(ctx, st)
+		s.addListener(m)
+		requirePaused()
+		overloadThreshold.Override(ctx, &st.SV, time.Millisecond)
+		requireActive()
+		overloadThreshold.Override(ctx, &st.SV, 0)
+		requirePaused()
+		s.removeListener(m)
+	})
+
+	t.Run("callbacks", func(t *testing.T) {
+		var invoked int
+		id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) { invoked++ }, 0 /* minInterval */)
+		requireActive()
+		require.Equal(t, 1, invoked)
+		UnregisterMutexWaitCallback(id)
+		requirePaused()
+
+		id = RegisterGCPauseCallback(func(time.Duration, time.Duration) {}, 0 /* minInterval */)
+		requireActive()
+		UnregisterGCPauseCallback(id)
+		requirePaused()
+
+		id = RegisterOverloadCallback(func(bool) {})
+		requireActive()
+		UnregisterOverloadCallback(id)
+		requirePaused()
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		a := &attachment{}
+		s.registerMetrics(a, metric.NewRegistry())
+		requireActive()
+		s.unregisterMetrics(a)
+		requirePaused()
+		// A caller passing no registry has no metrics exported.
+		s.registerMetrics(a, nil /* registry */)
+		requirePaused()
+	})
+
+	t.Run("exporters", func(t *testing.T) {
+		heatmapEnabled.Override(ctx, &st.SV, true)
+		requireActive()
+		heatmapEnabled.Override(ctx, &st.SV, false)
+		requirePaused()
+
+		logThreshold.Override(ctx, &st.SV, time.Millisecond)
+		requireActive()
+		logThreshold.Override(ctx, &st.SV, 0)
+		requirePaused()
+
+		snapshotLogInterval.Override(ctx, &st.SV, time.Minute)
+		requireActive()
+		snapshotLogInterval.Override(ctx, &st.SV, 0)
+		requirePaused()
+	})
+}
+
+// TestSamplerPauseConcurrentRegistration stresses ticks racing with callbacks
+// being (un)registered, pausing and resuming the sampler, for the race
+// detector to catch unsynchronized transitions. A callback registered
+// throughout is delivered to on every tick once the window fills up.
+func TestSamplerPauseConcurrentRegistration(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, busySample())
+	st := s.st
+	heatmapEnabled.Override(ctx, &st.SV, false)
+
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) {}, 0 /* minInterval */)
+			UnregisterMutexWaitCallback(id)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		s.tick(ctx)
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	var invoked atomic.Int64
+	id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) { invoked.Add(1) }, 0 /* minInterval */)
+	defer UnregisterMutexWaitCallback(id)
+	for i := 0; i < 5; i++ {
+		s.tick(ctx)
+	}
+	// Having possibly just resumed, the first two ticks at most are spent
+	// re-baselining.
+	require.GreaterOrEqual(t, invoked.Load(), int64(3))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.False(t, s.mu.paused)
+}
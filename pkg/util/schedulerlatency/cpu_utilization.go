@@ -0,0 +1,95 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import "github.com/cockroachdb/cockroach/pkg/util/metric"
+
+var metaCPUUtilization = metric.Metadata{
+	Name:        "go.scheduler_latency.cpu_utilization",
+	Help:        "Fraction of the CPU time available to the Go runtime (GOMAXPROCS) spent running user, GC, and scavenger code over the last scheduler_latency.sample_duration, as opposed to idling",
+	Measurement: "CPU Time",
+	Unit:        metric.Unit_PERCENT,
+}
+
+// cpuClass is a CPU class tracked by the sampler, as estimated by the runtime.
+type cpuClass int
+
+const (
+	cpuUser cpuClass = iota
+	cpuGC
+	cpuScavenge
+	cpuIdle
+	numCPUClasses
+)
+
+// cpuClassMetrics are the runtime/metrics counters of the CPU classes, in
+// cpu-seconds.
+var cpuClassMetrics = [numCPUClasses]string{
+	cpuUser:     "/cpu/classes/user:cpu-seconds",
+	cpuGC:       "/cpu/classes/gc/total:cpu-seconds",
+	cpuScavenge: "/cpu/classes/scavenge/total:cpu-seconds",
+	cpuIdle:     "/cpu/classes/idle:cpu-seconds",
+}
+
+// cpuClassOf returns the CPU class of the given runtime/metrics name, or false
+// if it isn't one of cpuClassMetrics.
+func cpuClassOf(name string) (cpuClass, bool) {
+	for c, n := range cpuClassMetrics {
+		if n == name {
+			return cpuClass(c), true
+		}
+	}
+	return 0, false
+}
+
+// cpuClassesRuntimeMetrics returns the descriptors of the CPU class counters,
+// read alongside the scheduler latencies and windowed like them.
+func cpuClassesRuntimeMetrics() []*runtimeMetric {
+	ms := make([]*runtimeMetric, numCPUClasses)
+	for c := range cpuClassMetrics {
+		ms[c] = &runtimeMetric{name: cpuClassMetrics[c], kind: counterMetric}
+	}
+	return ms
+}
+
+// cpuClasses is a cumulative sample of the CPU class counters.
+type cpuClasses struct {
+	seconds [numCPUClasses]float64
+	// ok is set if all the counters were read, which they aren't if the
+	// runtime doesn't support them.
+	ok bool
+}
+
+// cpuUtilization returns the fraction of the CPU time available to the runtime
+// that was spent busy (running user code, the GC, or the scavenger) rather than
+// idle, over the window between the given cumulative samples. It's zero if it
+// can't be computed: if either sample lacks the counters, if no CPU time
+// elapsed, or if any counter decreased, say from wrapping around. That only
+// invalidates the utilization, not the scheduler latencies over the same
+// window.
+func cpuUtilization(latest, oldest cpuClasses) float64 {
+	if !latest.ok || !oldest.ok {
+		return 0
+	}
+	var delta [numCPUClasses]float64
+	for c := range delta {
+		delta[c] = latest.seconds[c] - oldest.seconds[c]
+		if delta[c] < 0 {
+			return 0
+		}
+	}
+	busy := delta[cpuUser] + delta[cpuGC] + delta[cpuScavenge]
+	total := busy + delta[cpuIdle]
+	if total <= 0 {
+		return 0
+	}
+	return busy / total
+}
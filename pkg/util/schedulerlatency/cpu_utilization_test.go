@@ -0,0 +1,161 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// cpuSample returns a cumulative sample of the CPU class counters.
+func cpuSample(user, gc, scavenge, idle float64) cpuClasses {
+	return cpuClasses{seconds: [numCPUClasses]float64{user, gc, scavenge, idle}, ok: true}
+}
+
+// TestCPUUtilization verifies the CPU utilization computed between cumulative
+// samples of the CPU class counters.
+func TestCPUUtilization(t *testing.T) {
+	oldest := cpuSample(10, 2, 1, 7)
+	for _, tc := range []struct {
+		name   string
+		latest cpuClasses
+		exp    float64
+	}{
+		{name: "busy", latest: cpuSample(10+18, 2+0.5, 1+0.5, 7+1), exp: 0.95},
+		{name: "mostly idle", latest: cpuSample(10+3, 2, 1, 7+7), exp: 0.3},
+		// The GC and scavenger count as busy too.
+		{name: "gc and scavenge", latest: cpuSample(10, 2+2, 1+1, 7+1), exp: 0.75},
+		{name: "fully idle", latest: cpuSample(10, 2, 1, 7+4), exp: 0},
+		{name: "fully busy", latest: cpuSample(10+4, 2, 1, 7), exp: 1},
+		// It's zero if no CPU time elapsed, if any counter decreased, or if any
+		// wasn't read.
+		{name: "no time", latest: oldest},
+		{name: "wraparound", latest: cpuSample(10+20, 2, 1, 6)},
+		{name: "unsupported", latest: cpuClasses{seconds: [numCPUClasses]float64{20, 2, 1, 8}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.InDelta(t, tc.exp, cpuUtilization(tc.latest, oldest), 1e-9)
+		})
+	}
+	require.Zero(t, cpuUtilization(cpuSample(20, 2, 1, 8), cpuClasses{}))
+}
+
+// TestCPUUtilizationWindow verifies that a window over which the CPU class
+// counters decreased has no utilization, but still has its latencies.
+func TestCPUUtilizationWindow(t *testing.T) {
+	start := timeutil.Unix(0, 0)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	sample := func(fast, slow uint64, at time.Duration, cpu cpuClasses) runtimeSample {
+		return runtimeSample{
+			latencies: &metrics.Float64Histogram{
+				Counts:  []uint64{fast, slow},
+				Buckets: []float64{0, 0.001, 0.002},
+			},
+			cpu: cpu,
+			at:  start.Add(at),
+		}
+	}
+	oldest := sample(0, 0, 0, cpuSample(10, 0, 0, 10))
+	w, _, ok := computeWindow(sample(90, 10, time.Second, cpuSample(11, 0, 0, 13)),
+		oldest, 1 /* samples */, time.Second, 0 /* minEvents */)
+	require.True(t, ok)
+	require.Equal(t, 1900*time.Microsecond, w.p99)
+	require.InDelta(t, 0.25, w.cpuUtilization, 1e-9)
+
+	w, _, ok = computeWindow(sample(90, 10, time.Second, cpuSample(1, 0, 0, 13)),
+		oldest, 1 /* samples */, time.Second, 0 /* minEvents */)
+	require.True(t, ok)
+	require.Equal(t, 1900*time.Microsecond, w.p99)
+	require.Equal(t, uint64(100), w.events)
+	require.Zero(t, w.cpuUtilization)
+}
+
+// TestCPUUtilizationDelivered verifies that the CPU utilization is delivered to
+// listeners and exported, using injected counters, and that a decrease
+// invalidates the utilization of just the windows spanning it.
+func TestCPUUtilizationDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	// Every tick, 4 Ps are busy for the given fraction of a second, with a
+	// tenth of that spent on the GC.
+	cpu := cpuSample(0, 0, 0, 0)
+	busy := 0.5
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		cpu.seconds[cpuUser] += 4 * busy * 0.9
+		cpu.seconds[cpuGC] += 4 * busy * 0.1
+		cpu.seconds[cpuIdle] += 4 * (1 - busy)
+		return runtimeSample{latencies: clone(cumulative), cpu: cpu, gomaxprocs: 4}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func() Sample {
+		t.Helper()
+		s.tick(ctx)
+		sample := listener.samples[len(listener.samples)-1]
+		require.Equal(t, 1900*time.Microsecond, sample.P99)
+		require.Equal(t, sample.CPUUtilization, s.metrics.CPUUtilization.Value())
+		return sample
+	}
+
+	for i := 0; i < 2; i++ {
+		s.tick(ctx)
+	}
+	require.Len(t, listener.provisional, 1)
+	require.InDelta(t, 0.5, listener.provisional[0].CPUUtilization, 1e-9)
+	require.InDelta(t, 0.5, tick().CPUUtilization, 1e-9)
+	busy = 0.9
+	require.InDelta(t, 0.7, tick().CPUUtilization, 1e-9) // half at 0.5, half at 0.9
+	require.InDelta(t, 0.9, tick().CPUUtilization, 1e-9)
+
+	// The counters going backwards invalidates the utilization of the windows
+	// spanning the decrease, until it falls out of them.
+	cpu.seconds[cpuIdle] -= 100
+	require.Zero(t, tick().CPUUtilization)
+	require.Zero(t, tick().CPUUtilization)
+	require.InDelta(t, 0.9, tick().CPUUtilization, 1e-9)
+	require.Zero(t, s.metrics.Rebaselines.Count())
+
+	// As does the runtime not supporting them.
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		return runtimeSample{latencies: clone(cumulative), gomaxprocs: 4}
+	}
+	require.Zero(t, tick().CPUUtilization)
+}
+
+// TestCPUClassesRead verifies that the CPU class counters are read from the
+// runtime, alongside the scheduler latencies.
+func TestCPUClassesRead(t *testing.T) {
+	st := cluster.MakeTestingClusterSettings()
+	r := makeRuntimeSampler(nil /* pool */, 1, append([]*runtimeMetric{
+		{name: schedLatenciesMetric, kind: histogramMetric},
+	}, cpuClassesRuntimeMetrics()...)...)
+	res := r.sample(&st.SV)
+	require.True(t, res.cpu.ok)
+	for c, seconds := range res.cpu.seconds {
+		require.GreaterOrEqual(t, seconds, float64(0), cpuClassMetrics[c])
+	}
+	require.NotNil(t, res.latencies)
+}
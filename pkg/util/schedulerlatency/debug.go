@@ -0,0 +1,236 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"time"
+)
+
+// maxDebugBuckets bounds the number of buckets served by HandleDebug. The
+// interval histogram is already in the coarse layout, which is well within
+// this bound; it's a safeguard in case that changes.
+const maxDebugBuckets = 128
+
+// maxDebugResults bounds the number of recent results retained for Dump: a
+// minute's worth at the default sample period.
+const maxDebugResults = 600
+
+// DebugDump is the JSON representation of the sampler's recent history,
+// returned by Dump and included in debug.zip.
+type DebugDump struct {
+	// Results are the results computed over the most recent full windows,
+	// oldest first, up to maxDebugResults.
+	Results []DebugResult `json:"results"`
+	// Histogram is the most recent interval histogram, if a full window has
+	// been observed.
+	Histogram *DebugHistogram `json:"histogram,omitempty"`
+}
+
+// DebugResult is the result computed over a full window, included in
+// DebugDump.
+type DebugResult struct {
+	// At is when the latest sample in the window was taken.
+	At time.Time `json:"at"`
+	// Elapsed is the time elapsed over the window; see Sample.Elapsed.
+	Elapsed time.Duration `json:"elapsed_nanos"`
+	P50     time.Duration `json:"p50_nanos"`
+	P99     time.Duration `json:"p99_nanos"`
+	// Max is the upper bound of the highest non-empty bucket of the window's
+	// interval histogram, or its lower bound if unbounded.
+	Max time.Duration `json:"max_nanos"`
+	// Events is the number of goroutine scheduling events observed over the
+	// window; see Sample.Events.
+	Events uint64 `json:"events"`
+	// Idle is set if the window observed too few events for percentiles to be
+	// computed over it, in which case they're zero; see Sample.Idle.
+	Idle bool `json:"idle"`
+	// Final is set if the result is the partial window since the previous
+	// tick, computed as the sampler stopped; see Sample.Final.
+	Final bool `json:"final,omitempty"`
+}
+
+// Dump returns the recent results of the running sampler, and its most recent
+// interval histogram, for inclusion in debug.zip. It returns false if there's
+// no sampler running. Its size is bounded by maxDebugResults and
+// maxDebugBuckets; the results are copied under the sampler's lock, and
+// nothing else is computed under it.
+func Dump() (DebugDump, bool) {
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return DebugDump{}, false
+	}
+	return s.dump(), true
+}
+
+// dump returns the sampler's recent results and interval histogram.
+func (s *sampler) dump() DebugDump {
+	s.mu.Lock()
+	n := s.mu.debugResults.Len()
+	res := DebugDump{Results: make([]DebugResult, n)}
+	for i := 0; i < n; i++ {
+		res.Results[n-1-i] = s.mu.debugResults.Get(i)
+	}
+	s.mu.Unlock()
+	if h, ok := s.debugHistogram(); ok {
+		res.Histogram = &h
+	}
+	return res
+}
+
+// recordDebugResultLocked retains the result computed over the given full
+// window, and its interval histogram, evicting the oldest one retained if
+// there are maxDebugResults already.
+func (s *sampler) recordDebugResultLocked(w window, interval *metrics.Float64Histogram) {
+	if s.mu.debugResults.Len() == maxDebugResults {
+		s.mu.debugResults.RemoveLast()
+	}
+	s.mu.debugResults.AddFirst(DebugResult{
+		At:      w.at,
+		Elapsed: w.elapsed,
+		P50:     w.p50,
+		P99:     w.p99,
+		Max:     histogramMax(interval),
+		Events:  w.events,
+		Idle:    w.idle,
+		Final:   w.final,
+	})
+}
+
+// DebugHistogram is the JSON representation of the most recent interval
+// histogram, served by HandleDebug.
+type DebugHistogram struct {
+	// Window is the nominal duration of the window
+	// (scheduler_latency.sample_duration).
+	Window time.Duration `json:"window_nanos"`
+	// Elapsed is the time elapsed over the window; see Sample.Elapsed.
+	Elapsed time.Duration `json:"elapsed_nanos"`
+	// At is when the latest sample in the window was taken.
+	At time.Time `json:"at"`
+	// Idle is set if the histogram has too few events for percentiles to be
+	// computed over it, in which case they're zero; see Sample.Idle.
+	Idle bool `json:"idle"`
+	// Percentiles are derived from the histogram, as computed by the sampler.
+	Percentiles DebugPercentiles `json:"percentiles"`
+	// Buckets are the histogram's non-empty buckets, in increasing order.
+	Buckets []DebugBucket `json:"buckets"`
+}
+
+// DebugPercentiles are the percentiles included in DebugHistogram.
+type DebugPercentiles struct {
+	P50  time.Duration `json:"p50_nanos"`
+	P90  time.Duration `json:"p90_nanos"`
+	P99  time.Duration `json:"p99_nanos"`
+	P999 time.Duration `json:"p999_nanos"`
+}
+
+// DebugBucket is a single histogram bucket, counting latencies in
+// [Lower, Upper). Unbounded boundaries are omitted.
+type DebugBucket struct {
+	Lower *time.Duration `json:"lower_nanos,omitempty"`
+	Upper *time.Duration `json:"upper_nanos,omitempty"`
+	Count uint64         `json:"count"`
+}
+
+// HandleDebug serves the most recent interval histogram as JSON. It's sourced
+// from the running sampler, and responds with http.StatusServiceUnavailable if
+// there isn't one or it hasn't observed a full window yet.
+func HandleDebug(w http.ResponseWriter, r *http.Request) {
+	h, ok := debugHistogramOrError(w)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(h); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// debugHistogramOrError returns the most recent interval histogram of the
+// running sampler, or responds with http.StatusServiceUnavailable and returns
+// false if there's none.
+func debugHistogramOrError(w http.ResponseWriter) (DebugHistogram, bool) {
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		http.Error(w, "scheduler latency sampler is not running", http.StatusServiceUnavailable)
+		return DebugHistogram{}, false
+	}
+	h, ok := s.debugHistogram()
+	if !ok {
+		http.Error(w, "scheduler latency sampler is yet to observe a full window", http.StatusServiceUnavailable)
+		return DebugHistogram{}, false
+	}
+	return h, true
+}
+
+// debugHistogram returns the most recent interval histogram in its JSON
+// representation, or false if a full window is yet to be observed.
+func (s *sampler) debugHistogram() (DebugHistogram, bool) {
+	s.mu.Lock()
+	h, w := s.mu.lastIntervalHistogram, s.mu.lastWindow
+	s.mu.Unlock()
+	if h == nil {
+		return DebugHistogram{}, false
+	}
+	// Interval histograms are never mutated once computed, so it's safe to
+	// read h without holding the lock.
+	return makeDebugHistogram(h, w), true
+}
+
+// makeDebugHistogram returns the JSON representation of the given interval
+// histogram, computed over the given window. It's re-binned into the coarse
+// buckets if it has more than maxDebugBuckets.
+func makeDebugHistogram(h *metrics.Float64Histogram, w window) DebugHistogram {
+	if len(h.Counts) > maxDebugBuckets {
+		h = rebin(h, coarseBuckets())
+	}
+
+	res := DebugHistogram{
+		Window:  w.duration,
+		Elapsed: w.elapsed,
+		At:      w.at,
+		Idle:    w.idle,
+		Percentiles: DebugPercentiles{
+			P50:  w.p50,
+			P90:  w.p90,
+			P99:  w.p99,
+			P999: w.p999,
+		},
+		Buckets: []DebugBucket{},
+	}
+	boundary := func(b float64) *time.Duration {
+		if math.IsInf(b, 0) {
+			return nil
+		}
+		d := SecondsToDuration(b)
+		return &d
+	}
+	for i := range h.Counts {
+		if h.Counts[i] == 0 {
+			continue
+		}
+		res.Buckets = append(res.Buckets, DebugBucket{
+			Lower: boundary(h.Buckets[i]),
+			Upper: boundary(h.Buckets[i+1]),
+			Count: h.Counts[i],
+		})
+	}
+	return res
+}
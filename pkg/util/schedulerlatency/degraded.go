@@ -0,0 +1,82 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/errors"
+)
+
+var metaSamplerDegraded = metric.Metadata{
+	Name:        "go.scheduler_latency.sampler.degraded",
+	Help:        "Set to 1 if the scheduler latency sampler is degraded, the Go runtime not exporting " + schedLatenciesMetric + " as a histogram, in which case nothing is sampled",
+	Measurement: "Degraded",
+	Unit:        metric.Unit_COUNT,
+}
+
+// checkRuntimeMetrics returns an error if the scheduler latency histogram isn't
+// among the given descriptions of the metrics supported by the runtime (see
+// metrics.All), or isn't a histogram.
+func checkRuntimeMetrics(descs []metrics.Description) error {
+	for _, d := range descs {
+		if d.Name != schedLatenciesMetric {
+			continue
+		}
+		if d.Kind != metrics.KindFloat64Histogram {
+			return errors.Newf("runtime metric %s is of unexpected kind %d", schedLatenciesMetric, d.Kind)
+		}
+		return nil
+	}
+	return errors.Newf("runtime metric %s is not supported", schedLatenciesMetric)
+}
+
+// validateRuntimeMetrics checks, as the sampler is started, that the runtime
+// exports the scheduler latency histogram, degrading the sampler if it
+// doesn't.
+func (s *sampler) validateRuntimeMetrics(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := checkRuntimeMetrics(s.describe()); err != nil {
+		s.degradeLocked(ctx, err)
+	}
+}
+
+// degradeLocked marks the sampler as degraded, the runtime not exporting the
+// scheduler latency histogram (or no longer exporting it as one), for the
+// given reason. A degraded sampler stops sampling for good, having discarded
+// what it retained, instead of crashing the process over a change in the Go
+// runtime: listeners and callbacks are no longer invoked, Latest returns a
+// snapshot flagged as Degraded, and so does the degraded gauge. It's a no-op
+// if the sampler is already degraded.
+func (s *sampler) degradeLocked(ctx context.Context, err error) {
+	if s.mu.degraded {
+		return
+	}
+	log.Warningf(ctx, "%v; the scheduler latency sampler is degraded, and no longer samples", err)
+	s.mu.degraded = true
+	s.metrics.Degraded.Update(1)
+	s.resetWindowLocked()
+	s.mu.latestCumulative = nil
+	s.mu.heatmap.clear()
+	s.mu.trend.reset()
+	s.mu.ewma.reset()
+	if s.mu.overload.reset() && !s.standalone {
+		// There won't be a sampler to tell the callbacks otherwise.
+		s.invokeOverloadCallbacksLocked(ctx, false)
+	}
+	if !s.standalone {
+		latest.Store(&SampleSnapshot{Degraded: true})
+	}
+}
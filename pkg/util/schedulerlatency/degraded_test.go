@@ -0,0 +1,114 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRuntimeMetrics(t *testing.T) {
+	require.NoError(t, checkRuntimeMetrics(metrics.All()))
+	require.ErrorContains(t, checkRuntimeMetrics(nil), "not supported")
+	require.ErrorContains(t, checkRuntimeMetrics([]metrics.Description{
+		{Name: mutexWaitMetric, Kind: metrics.KindFloat64},
+	}), "not supported")
+	require.ErrorContains(t, checkRuntimeMetrics([]metrics.Description{
+		{Name: schedLatenciesMetric, Kind: metrics.KindFloat64},
+	}), "unexpected kind")
+}
+
+// TestSamplerDegraded verifies that a sampler finding the scheduler latency
+// histogram missing, as it's started or on any tick, stops sampling instead of
+// panicking, and says so.
+func TestSamplerDegraded(t *testing.T) {
+	ctx := context.Background()
+	defer latest.Store(nil)
+
+	setup := func(t *testing.T) (*testSampler, *sampleListener) {
+		s := newTestSampler(t, time.Second, 2*time.Second, nil)
+		listener := &sampleListener{}
+		s.addListener(listener)
+		return s, listener
+	}
+	tick := func(s *testSampler, n int) {
+		for i := 0; i < n; i++ {
+			s.tick(ctx)
+		}
+	}
+	requireDegraded := func(t *testing.T, s *testSampler) {
+		t.Helper()
+		require.True(t, s.mu.degraded)
+		require.Equal(t, int64(1), s.metrics.Degraded.Value())
+		require.Zero(t, s.mu.ringBuffer.Len())
+		require.Nil(t, s.mu.lastInterval)
+		snap, ok := Latest()
+		require.False(t, ok)
+		require.Equal(t, SampleSnapshot{Degraded: true}, snap)
+	}
+
+	t.Run("at start", func(t *testing.T) {
+		s, listener := setup(t)
+		var sampled int
+		s.sample = func() runtimeSample {
+			sampled++
+			return runtimeSample{}
+		}
+		s.describe = func() []metrics.Description {
+			return []metrics.Description{{Name: schedLatenciesMetric, Kind: metrics.KindFloat64}}
+		}
+		s.validateRuntimeMetrics(ctx)
+		requireDegraded(t, s)
+		tick(s, 5)
+		require.Zero(t, sampled)
+		require.Empty(t, listener.samples)
+		require.Zero(t, s.metrics.Ticks.Count())
+		requireDegraded(t, s)
+		// There's nothing to flush either.
+		s.flushFinal(ctx)
+		require.Zero(t, sampled)
+	})
+
+	t.Run("on tick", func(t *testing.T) {
+		s, listener := setup(t)
+		s.validateRuntimeMetrics(ctx)
+		require.False(t, s.mu.degraded)
+		tick(s, 3)
+		require.Len(t, listener.samples, 1)
+		_, ok := Latest()
+		require.True(t, ok)
+
+		// Have the runtime report the metric as unsupported, as it would if it
+		// changed kind, through the runtime sampler.
+		read := s.mu.runtime.read
+		var reads int
+		s.mu.runtime.read = func(ms []metrics.Sample) {
+			reads++
+			read(ms)
+			for i := range ms {
+				if ms[i].Name == schedLatenciesMetric {
+					ms[i].Value = metrics.Value{}
+				}
+			}
+		}
+		tick(s, 1)
+		requireDegraded(t, s)
+		require.Equal(t, 1, reads)
+		tick(s, 5)
+		require.Equal(t, 1, reads)
+		require.Len(t, listener.samples, 1)
+		requireDegraded(t, s)
+	})
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+var metaSuppressedDeliveries = metric.Metadata{
+	Name:        "go.scheduler_latency.sampler.suppressed_deliveries",
+	Help:        "Number of scheduler latency deliveries suppressed for listeners wrapped with delta suppression, the p99 not having changed by more than their epsilon",
+	Measurement: "Deliveries",
+	Unit:        metric.Unit_COUNT,
+}
+
+// DeltaEpsilon is how much the P99 has to change by, since the last value
+// delivered, for a listener wrapped using WithDeltaSuppression to be delivered
+// another sample. At least one of the fields must be set; if both are, a change
+// exceeding either is delivered.
+type DeltaEpsilon struct {
+	// Absolute is the change, as a duration.
+	Absolute time.Duration
+	// Relative is the change, as a fraction of the last P99 delivered. Any
+	// change from a P99 of zero exceeds it.
+	Relative float64
+}
+
+// WithDeltaSuppression wraps the given listener for deliveries to be suppressed
+// unless the P99 changed by more than the given epsilon since the last sample
+// delivered to it, for consumers that persist or forward every delivery
+// (loggers, exporters) and would otherwise churn while the latency is flat. A
+// delivery is forced once maxSuppression has elapsed since the last one, to
+// bound staleness; if zero, deliveries are suppressed for as long as the P99
+// remains within the epsilon. Samples that are idle or provisional when the
+// last one delivered wasn't (or vice versa) are always delivered. The
+// deliveries suppressed are counted, see SuppressedDeliveries. An epsilon with
+// neither field set returns the listener as is. It can be combined with
+// WithMinDeliveryInterval, in which case it only considers the deliveries the
+// interval lets through, WithPriority, and WithWindowDuration.
+func WithDeltaSuppression(
+	listener LatencyObserver, epsilon DeltaEpsilon, maxSuppression time.Duration,
+) LatencyObserver {
+	if listener == nil || (epsilon.Absolute <= 0 && epsilon.Relative <= 0) {
+		return listener
+	}
+	return &deltaListener{LatencyObserver: listener, epsilon: epsilon, maxSuppression: maxSuppression}
+}
+
+// SuppressedDeliveries returns the number of deliveries suppressed for the
+// given listener, as wrapped using WithDeltaSuppression (possibly along with
+// the other wrappers). It's zero for listeners that weren't.
+func SuppressedDeliveries(listener LatencyObserver) int64 {
+	for listener != nil {
+		switch l := listener.(type) {
+		case *deltaListener:
+			return l.suppressed.Load()
+		case *intervalListener:
+			listener = l.LatencyObserver
+		case *priorityListener:
+			listener = l.LatencyObserver
+		case *windowListener:
+			listener = l.LatencyObserver
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// deltaListener is a listener with delta suppression; see
+// WithDeltaSuppression.
+type deltaListener struct {
+	LatencyObserver
+	epsilon        DeltaEpsilon
+	maxSuppression time.Duration
+	// suppressed counts the deliveries suppressed; it's read through
+	// SuppressedDeliveries without holding the sampler's lock.
+	suppressed atomic.Int64
+}
+
+// deltaSuppressor tracks the last sample delivered to a listener wrapped using
+// WithDeltaSuppression, to decide whether to suppress the next one.
+type deltaSuppressor struct {
+	listener  *deltaListener // nil if deliveries aren't suppressed
+	last      Sample
+	delivered bool // whether last is set
+}
+
+// suppress returns true, counting it, if delivering the given sample is to be
+// suppressed. Otherwise, it records its delivery.
+func (d *deltaSuppressor) suppress(s Sample) bool {
+	if d.listener == nil {
+		return false
+	}
+	if d.delivered && !d.significant(s) &&
+		(d.listener.maxSuppression == 0 || s.At.Sub(d.last.At) < d.listener.maxSuppression) {
+		d.listener.suppressed.Add(1)
+		return true
+	}
+	d.last, d.delivered = s, true
+	return false
+}
+
+// significant returns true if the given sample differs from the last one
+// delivered by more than the epsilon.
+func (d *deltaSuppressor) significant(s Sample) bool {
+	if s.Idle != d.last.Idle || s.Provisional != d.last.Provisional {
+		return true
+	}
+	delta := s.P99 - d.last.P99
+	if delta < 0 {
+		delta = -delta
+	}
+	e := d.listener.epsilon
+	if e.Absolute > 0 && delta > e.Absolute {
+		return true
+	}
+	return e.Relative > 0 && float64(delta) > e.Relative*float64(d.last.P99)
+}
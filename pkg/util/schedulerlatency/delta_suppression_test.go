@@ -0,0 +1,175 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeltaSuppression verifies which of a sequence of samples, taken a second
+// apart, are delivered to a listener wrapped using WithDeltaSuppression.
+func TestDeltaSuppression(t *testing.T) {
+	const idle = -1 // marks idle samples in p99s
+	for _, tc := range []struct {
+		name           string
+		epsilon        DeltaEpsilon
+		maxSuppression time.Duration
+		p99s           []time.Duration // in µs
+		expDelivered   []int
+	}{
+		{
+			// A flat p99 is only delivered once every maxSuppression.
+			name:           "flat",
+			epsilon:        DeltaEpsilon{Absolute: 100 * time.Microsecond},
+			maxSuppression: 4 * time.Second,
+			p99s:           []time.Duration{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000},
+			expDelivered:   []int{0, 4, 8},
+		},
+		{
+			// As is one jittering within the epsilon of the last one delivered,
+			// even as it drifts.
+			name:           "noisy within epsilon",
+			epsilon:        DeltaEpsilon{Absolute: 100 * time.Microsecond},
+			maxSuppression: 4 * time.Second,
+			p99s:           []time.Duration{1000, 1050, 960, 1100, 1030, 1180, 1120, 1090},
+			expDelivered:   []int{0, 4, 5},
+		},
+		{
+			// Changes exceeding it are delivered as they happen.
+			name:           "noisy beyond epsilon",
+			epsilon:        DeltaEpsilon{Absolute: 100 * time.Microsecond},
+			maxSuppression: 4 * time.Second,
+			p99s:           []time.Duration{1000, 2000, 1950, 900, 1000, 3000},
+			expDelivered:   []int{0, 1, 3, 5},
+		},
+		{
+			// Relative epsilons apply to the last p99 delivered.
+			name:           "relative",
+			epsilon:        DeltaEpsilon{Relative: 0.1},
+			maxSuppression: time.Minute,
+			p99s:           []time.Duration{1000, 1090, 1110, 1200, 1300, 1250, 0, 0, 10},
+			expDelivered:   []int{0, 2, 4, 6, 8},
+		},
+		{
+			// With both, changes exceeding either are delivered.
+			name:           "absolute or relative",
+			epsilon:        DeltaEpsilon{Absolute: 500 * time.Microsecond, Relative: 0.5},
+			maxSuppression: time.Minute,
+			p99s:           []time.Duration{100, 140, 160, 1000, 1400, 1600},
+			expDelivered:   []int{0, 2, 3, 5},
+		},
+		{
+			// Samples turning idle, or no longer idle, are always delivered.
+			name:           "idle",
+			epsilon:        DeltaEpsilon{Absolute: time.Second},
+			maxSuppression: time.Minute,
+			p99s:           []time.Duration{1000, idle, idle, 1000, 1000},
+			expDelivered:   []int{0, 1, 3},
+		},
+		{
+			// Without a maximum suppression, a flat p99 is delivered once.
+			name:         "unbounded",
+			epsilon:      DeltaEpsilon{Absolute: 100 * time.Microsecond},
+			p99s:         []time.Duration{1000, 1000, 1000, 1000, 1000, 1000},
+			expDelivered: []int{0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := WithDeltaSuppression(&sampleListener{}, tc.epsilon, tc.maxSuppression)
+			d := deltaSuppressor{listener: l.(*deltaListener)}
+			var delivered []int
+			for i, p99 := range tc.p99s {
+				s := Sample{P99: p99 * time.Microsecond, At: timeutil.Unix(int64(i), 0)}
+				if p99 == idle {
+					s.P99, s.Idle = 0, true
+				}
+				if !d.suppress(s) {
+					delivered = append(delivered, i)
+				}
+			}
+			require.Equal(t, tc.expDelivered, delivered)
+			require.Equal(t, int64(len(tc.p99s)-len(delivered)), SuppressedDeliveries(l))
+		})
+	}
+
+	// Epsilons with neither field set don't wrap the listener.
+	l := &sampleListener{}
+	require.Equal(t, LatencyObserver(l), WithDeltaSuppression(l, DeltaEpsilon{}, time.Second))
+	require.Zero(t, SuppressedDeliveries(l))
+}
+
+// TestDeltaSuppressionDelivered verifies that the sampler suppresses deliveries
+// to listeners wrapped using WithDeltaSuppression, alongside the other
+// wrappers, and counts them.
+func TestDeltaSuppressionDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, 3ms). Every tick observes 100 events
+	// in the next bucket of the current sequence, which the window, spanning a
+	// single tick, has the p99 fall in.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, 0.003},
+	}
+	var buckets []int
+	s.sample = func() runtimeSample {
+		cumulative.Counts[buckets[0]] += 100
+		buckets = buckets[1:]
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var suppressed, unsuppressed sampleListener
+	wrapped := WithPriority(WithDeltaSuppression(&suppressed,
+		DeltaEpsilon{Absolute: 500 * time.Microsecond}, 3*time.Second), HighestPriority)
+	s.addListener(wrapped)
+	s.addListener(&unsuppressed)
+	tick := func(bucket int) {
+		buckets = append(buckets, bucket)
+		s.tick(ctx)
+	}
+
+	// The listeners are delivered the same samples, but for what they were
+	// previously delivered.
+	withoutPrevious := func(s Sample) Sample {
+		s.Previous = nil
+		return s
+	}
+
+	tick(0) // nothing to compare against yet
+	// A flat sequence is suppressed, but for the forced refresh every 3s.
+	for i := 0; i < 6; i++ {
+		tick(1)
+	}
+	require.Len(t, unsuppressed.samples, 6)
+	require.Len(t, suppressed.samples, 2)
+	require.Equal(t, suppressed.samples[0], unsuppressed.samples[0])
+	require.Equal(t, withoutPrevious(suppressed.samples[1]), withoutPrevious(unsuppressed.samples[3]))
+	require.Equal(t, suppressed.samples[0].At, suppressed.samples[1].Previous.At)
+	require.Equal(t, int64(4), SuppressedDeliveries(wrapped))
+	require.Equal(t, int64(4), s.metrics.SuppressedDeliveries.Count())
+
+	// A noisy one is delivered as it changes.
+	for _, bucket := range []int{2, 2, 0, 1, 1} {
+		tick(bucket)
+	}
+	require.Len(t, unsuppressed.samples, 11)
+	require.Len(t, suppressed.samples, 5)
+	for i, j := range []int{6, 8, 9} {
+		require.Equal(t, withoutPrevious(unsuppressed.samples[j]), withoutPrevious(suppressed.samples[2+i]))
+	}
+	require.Equal(t, int64(6), SuppressedDeliveries(wrapped))
+	require.Zero(t, SuppressedDeliveries(&unsuppressed))
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// distributionExportEnabled controls the export of the distribution of
+// scheduler latencies as a cumulative histogram, for scrapers to compute
+// quantiles (and heatmaps) of their own instead of making do with the windowed
+// ones. The go.scheduler_latency histogram only reflects the most recent
+// window, so it can't be used for the purpose.
+var distributionExportEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.distribution_export.enabled",
+	"when set, the distribution of scheduler latencies is exported as a cumulative histogram, "+
+		"go.scheduler_latency.distribution",
+	false,
+)
+
+var metaDistribution = metric.Metadata{
+	Name:        "go.scheduler_latency.distribution",
+	Help:        "Cumulative distribution of Go scheduling latency (if scheduler_latency.distribution_export.enabled is set)",
+	Measurement: "Nanoseconds",
+	Unit:        metric.Unit_NANOSECONDS,
+}
+
+var distributionLayoutOnce struct {
+	sync.Once
+	buckets []float64
+	values  []int64
+}
+
+// distributionLayout returns the layout of the exported distribution, that of
+// the go.scheduler_latency histogram, and how the sampler's histograms, in the
+// coarse layout, map onto it; see makeDistributionLayout. Both layouts are
+// fixed, so it's computed once. The returned slices must not be mutated.
+func distributionLayout() (buckets []float64, values []int64) {
+	distributionLayoutOnce.Do(func() {
+		distributionLayoutOnce.buckets, distributionLayoutOnce.values = makeDistributionLayout(
+			coarseBuckets(), cpuSchedulerLatencyBuckets(),
+		)
+	})
+	return distributionLayoutOnce.buckets, distributionLayoutOnce.values
+}
+
+// makeDistributionLayout maps histograms with the given bucket boundaries onto
+// a prometheus histogram with the given export ones, a subset of them, both
+// following runtime/metrics conventions. It returns the upper bounds of the
+// prometheus buckets, in nanoseconds (excluding the +Inf one prometheus adds),
+// and the value, also in nanoseconds, to record for each event in each of the
+// given buckets. Prometheus buckets are inclusive of their upper bounds, unlike
+// runtime/metrics ones, so events are recorded just past the lower bound of
+// their bucket (an underestimate, like the sums of go.scheduler_latency), or
+// at the upper bound if it's unbounded below.
+func makeDistributionLayout(buckets, export []float64) (upperBounds []float64, values []int64) {
+	upperBounds = make([]float64, 0, len(export))
+	for _, b := range export {
+		if math.IsInf(b, 0) {
+			continue
+		}
+		upperBounds = append(upperBounds, float64(SecondsToDuration(b).Nanoseconds()))
+	}
+	values = make([]int64, len(buckets)-1)
+	for i := range values {
+		if lower := buckets[i]; !math.IsInf(lower, -1) {
+			values[i] = SecondsToDuration(lower).Nanoseconds() + 1
+			continue
+		}
+		values[i] = SecondsToDuration(buckets[i+1]).Nanoseconds()
+	}
+	return upperBounds, values
+}
+
+func newDistributionHistogram() metric.IHistogram {
+	buckets, _ := distributionLayout()
+	return metric.NewHistogram(metric.HistogramOptions{
+		Metadata: metaDistribution,
+		Duration: base.DefaultHistogramWindowInterval(),
+		Buckets:  buckets,
+		Mode:     metric.HistogramModePrometheus,
+	})
+}
+
+// recordDistributionLocked adds the given interval histogram, in the coarse
+// layout, to the exported distribution, if
+// scheduler_latency.distribution_export.enabled is set. Prometheus histograms
+// are recorded into one event at a time, so this is linear in the number of
+// events, which is why it's disabled by default.
+func (s *sampler) recordDistributionLocked(interval *metrics.Float64Histogram) {
+	if !distributionExportEnabled.Get(&s.mu.st.SV) {
+		return
+	}
+	_, values := distributionLayout()
+	if len(interval.Counts) != len(values) {
+		return // not in the coarse layout, as injected by some tests
+	}
+	for i, count := range interval.Counts {
+		for ; count > 0; count-- {
+			s.metrics.Distribution.RecordValue(values[i])
+		}
+	}
+}
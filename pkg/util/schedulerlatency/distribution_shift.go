@@ -0,0 +1,76 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"math"
+	"math/bits"
+	"runtime/metrics"
+)
+
+// numShiftBins is the number of bins the histograms are re-binned into to
+// compute the distribution shift: one per power of two nanoseconds (the
+// octave of a latency being the bit length of its nanoseconds), zero
+// included.
+const numShiftBins = 65
+
+// distributionShift returns the total variation distance between the
+// distributions of the given interval histograms, that of the previous window
+// and the current one: half the sum, over the bins, of the absolute difference
+// between the fractions of either's events in the bin. It's zero for
+// identical distributions and 1 for disjoint ones, regardless of the number of
+// events either observed. The histograms are re-binned by octave first, their
+// buckets being too fine for a slight shift (moving events into adjacent
+// buckets) not to register as a large one; this also lets histograms of
+// different layouts be compared. It returns false if either histogram is nil
+// or observed no events.
+func distributionShift(previous, current *metrics.Float64Histogram) (float64, bool) {
+	if previous == nil || current == nil {
+		return 0, false
+	}
+	p, pTotal := shiftBins(previous)
+	q, qTotal := shiftBins(current)
+	if pTotal == 0 || qTotal == 0 {
+		return 0, false
+	}
+	var distance float64
+	for i := range p {
+		distance += math.Abs(p[i]/pTotal - q[i]/qTotal)
+	}
+	return distance / 2, true
+}
+
+// shiftBins re-bins the given histogram by octave, returning the counts of the
+// bins and their total. Events are binned by the lower bound of their bucket.
+func shiftBins(h *metrics.Float64Histogram) (bins [numShiftBins]float64, total float64) {
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		bins[shiftBin(h.Buckets[i])] += float64(c)
+		total += float64(c)
+	}
+	return bins, total
+}
+
+// shiftBin returns the bin of the given (second-denominated) latency: the bit
+// length of its nanoseconds, zero for non-positive ones (the lower bound of the
+// first bucket being -Inf or zero).
+func shiftBin(seconds float64) int {
+	if !(seconds > 0) { // NaNs too
+		return 0
+	}
+	ns := seconds * 1e9
+	if ns >= math.MaxInt64 {
+		return numShiftBins - 1
+	}
+	return bits.Len64(uint64(ns))
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDistributionShift verifies that the distribution shift between synthetic
+// distributions orders them by how much they differ, and that it's absent for
+// empty ones.
+func TestDistributionShift(t *testing.T) {
+	// Buckets: [-Inf, 1µs), [1µs, 2µs), [2µs, 4µs), [4µs, 8µs), [8µs, +Inf),
+	// of lower bounds in different octaves.
+	buckets := []float64{math.Inf(-1), 1e-6, 2e-6, 4e-6, 8e-6, math.Inf(+1)}
+	h := func(counts ...uint64) *metrics.Float64Histogram {
+		return &metrics.Float64Histogram{Counts: counts, Buckets: buckets}
+	}
+	previous := h(0, 10, 80, 10, 0)
+
+	shift := func(previous, current *metrics.Float64Histogram) float64 {
+		t.Helper()
+		d, ok := distributionShift(previous, current)
+		require.True(t, ok)
+		return d
+	}
+	// The same distribution, observed over twice as many events.
+	identical := shift(previous, h(0, 20, 160, 20, 0))
+	// A twentieth of the events moved up an octave.
+	slightlyShifted := shift(previous, h(0, 5, 80, 15, 0))
+	// Every event moved to a different octave.
+	completelyDifferent := shift(previous, h(100, 0, 0, 0, 100))
+	require.Zero(t, identical)
+	require.InDelta(t, 0.05, slightlyShifted, 1e-9)
+	require.Equal(t, 1.0, completelyDifferent)
+	require.Less(t, identical, slightlyShifted)
+	require.Less(t, slightlyShifted, completelyDifferent)
+	// It's symmetric.
+	require.InDelta(t, slightlyShifted, shift(h(0, 5, 80, 15, 0), previous), 1e-9)
+
+	// Events moving between buckets within an octave (here, [1024ns, 2048ns))
+	// don't register.
+	fine := []float64{1.1e-6, 1.2e-6, 1.3e-6}
+	require.Zero(t, shift(
+		&metrics.Float64Histogram{Counts: []uint64{100, 0}, Buckets: fine},
+		&metrics.Float64Histogram{Counts: []uint64{0, 100}, Buckets: fine},
+	))
+
+	// It's absent if either window observed no events.
+	for _, tc := range []struct {
+		name              string
+		previous, current *metrics.Float64Histogram
+	}{
+		{name: "no previous window", current: previous},
+		{name: "empty previous window", previous: h(0, 0, 0, 0, 0), current: previous},
+		{name: "empty window", previous: previous, current: h(0, 0, 0, 0, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ok := distributionShift(tc.previous, tc.current)
+			require.False(t, ok)
+		})
+	}
+}
+
+// TestDistributionShiftDelivered verifies that the distribution shift between
+// consecutive full windows is delivered to listeners, and absent for the first
+// one and for idle ones.
+func TestDistributionShiftDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func(fast, slow uint64) Sample {
+		t.Helper()
+		cumulative.Counts[0] += fast
+		cumulative.Counts[1] += slow
+		n := len(listener.samples)
+		s.tick(ctx)
+		require.Len(t, listener.samples, n+1)
+		return listener.samples[n]
+	}
+
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second)
+	// There's no previous window to compare the first against.
+	require.False(t, tick(90, 10).HasDistributionShift)
+	sample := tick(90, 10)
+	require.True(t, sample.HasDistributionShift)
+	require.Zero(t, sample.DistributionShift)
+	// A fifth of the events moved from the fast bucket to the slow one.
+	sample = tick(70, 30)
+	require.True(t, sample.HasDistributionShift)
+	require.InDelta(t, 0.2, sample.DistributionShift, 1e-9)
+
+	// Idle windows don't have one.
+	sample = tick(1, 0)
+	require.True(t, sample.Idle)
+	require.False(t, sample.HasDistributionShift)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDistributionLayout verifies that events in each of the coarse buckets are
+// recorded into the exported bucket containing it.
+func TestDistributionLayout(t *testing.T) {
+	coarse, export := coarseBuckets(), cpuSchedulerLatencyBuckets()
+	upperBounds, values := distributionLayout()
+	require.Len(t, values, len(coarse)-1)
+	require.Len(t, upperBounds, len(export)-2) // excluding both unbounded ends
+	require.True(t, sort.Float64sAreSorted(upperBounds))
+
+	for i, v := range values {
+		// Prometheus records the value into the first bucket whose (inclusive)
+		// upper bound is at least as large; the +Inf bucket if none is.
+		k := sort.SearchFloat64s(upperBounds, float64(v))
+		lower, upper := math.Inf(-1), math.Inf(+1)
+		if k > 0 {
+			lower = upperBounds[k-1]
+		}
+		if k < len(upperBounds) {
+			upper = upperBounds[k]
+		}
+		require.LessOrEqual(t, lower, float64(SecondsToDuration(coarse[i]).Nanoseconds()),
+			"bucket %d: [%f, %f)", i, coarse[i], coarse[i+1])
+		require.GreaterOrEqual(t, upper, float64(SecondsToDuration(coarse[i+1]).Nanoseconds()),
+			"bucket %d: [%f, %f)", i, coarse[i], coarse[i+1])
+	}
+}
+
+// TestDistributionExport verifies that the exported distribution accumulates
+// the events observed every tick, only if enabled, by scraping it as
+// prometheus would.
+func TestDistributionExport(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	st := s.st
+	registry := metric.NewRegistry()
+	s.registerMetrics(&attachment{}, registry)
+
+	buckets := coarseBuckets()
+	latencies := &metrics.Float64Histogram{
+		Counts:  make([]uint64, len(buckets)-1),
+		Buckets: buckets,
+	}
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(latencies)} }
+	tick := func() {
+		// Record 5 events at 10µs, 3 at 1ms, and 2 at 2s, beyond the highest
+		// exported bucket.
+		for d, n := range map[time.Duration]uint64{
+			10 * time.Microsecond: 5,
+			time.Millisecond:      3,
+			2 * time.Second:       2,
+		} {
+			i := sort.Search(len(buckets), func(i int) bool { return buckets[i] > d.Seconds() })
+			latencies.Counts[i-1] += n
+		}
+		s.tick(ctx)
+	}
+
+	// scrape returns the total count, and the cumulative counts of the buckets
+	// containing 10µs and 1ms.
+	exporter := metric.MakePrometheusExporter()
+	scrape := func() (total, at10us, at1ms uint64) {
+		var buf bytes.Buffer
+		require.NoError(t, exporter.ScrapeAndPrintAsText(&buf, expfmt.FmtText, func(pe *metric.PrometheusExporter) {
+			pe.ScrapeRegistry(registry, true /* includeChildMetrics */)
+		}))
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(&buf)
+		require.NoError(t, err)
+		family, ok := families["go_scheduler_latency_distribution"]
+		require.True(t, ok)
+		h := family.Metric[0].Histogram
+		at := func(d time.Duration) uint64 {
+			for _, b := range h.Bucket {
+				if b.GetUpperBound() >= float64(d.Nanoseconds()) {
+					return b.GetCumulativeCount()
+				}
+			}
+			t.Fatalf("no bucket for %s", d)
+			return 0
+		}
+		return h.GetSampleCount(), at(10 * time.Microsecond), at(time.Millisecond)
+	}
+	requireScraped := func(total, at10us, at1ms uint64) {
+		t.Helper()
+		gotTotal, got10us, got1ms := scrape()
+		require.Equal(t, []uint64{total, at10us, at1ms}, []uint64{gotTotal, got10us, got1ms})
+	}
+
+	// Nothing is recorded unless enabled.
+	tick()
+	tick()
+	requireScraped(0, 0, 0)
+
+	// Every tick's events are recorded exactly once, though windows overlap.
+	distributionExportEnabled.Override(ctx, &st.SV, true)
+	tick()
+	requireScraped(10, 5, 8)
+	tick()
+	requireScraped(20, 10, 16)
+	tick()
+	requireScraped(30, 15, 24)
+
+	// Once disabled, the distribution stops accumulating.
+	distributionExportEnabled.Override(ctx, &st.SV, false)
+	tick()
+	requireScraped(30, 15, 24)
+}
@@ -0,0 +1,28 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import "time"
+
+// eventsPerSecond returns the window's Sample.EventsPerSecond. Unlike the
+// percentiles, it's computed over idle windows too.
+func (w window) eventsPerSecond() float64 {
+	return eventsPerSecond(w.events, w.elapsed)
+}
+
+// eventsPerSecond returns the rate of the given number of scheduling events
+// over the given elapsed time, or zero if no time elapsed.
+func eventsPerSecond(events uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(events) / elapsed.Seconds()
+}
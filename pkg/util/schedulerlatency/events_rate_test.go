@@ -0,0 +1,122 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEventsPerSecond verifies the scheduling event rate computed over windows
+// of synthetic histograms.
+func TestEventsPerSecond(t *testing.T) {
+	start := timeutil.Unix(0, 0)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	sample := func(fast, slow uint64, at time.Duration) runtimeSample {
+		return runtimeSample{
+			latencies: &metrics.Float64Histogram{
+				Counts:  []uint64{fast, slow},
+				Buckets: []float64{0, 0.001, 0.002},
+			},
+			at: start.Add(at),
+		}
+	}
+	for _, tc := range []struct {
+		name       string
+		fast, slow uint64 // events observed over the window, in either bucket
+		elapsed    time.Duration
+		minEvents  uint64
+		expRate    float64
+	}{
+		{name: "one second", fast: 90, slow: 10, elapsed: time.Second, expRate: 100},
+		// It's computed over the time actually elapsed.
+		{name: "longer", fast: 900, slow: 100, elapsed: 4 * time.Second, expRate: 250},
+		{name: "shorter", fast: 9, slow: 1, elapsed: 100 * time.Millisecond, expRate: 100},
+		// Slow events count just the same.
+		{name: "slow", slow: 50, elapsed: time.Second, expRate: 50},
+		// It's computed over idle windows too.
+		{name: "idle", fast: 9, slow: 1, elapsed: time.Second, minEvents: 100, expRate: 10},
+		// It's zero if there's nothing to compute it over.
+		{name: "empty", elapsed: time.Second},
+		{name: "no time elapsed", fast: 90, slow: 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldest := sample(0, 0, 0)
+			latest := sample(tc.fast, tc.slow, tc.elapsed)
+			w, _, _ := computeWindow(latest, oldest, 1 /* samples */, time.Second, tc.minEvents)
+			require.InDelta(t, tc.expRate, w.eventsPerSecond(), 1e-9)
+		})
+	}
+}
+
+// TestEventsPerSecondDelivered verifies that the scheduling event rate is
+// delivered to listeners, published in the snapshot, and exported, over
+// gapped windows too.
+func TestEventsPerSecondDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	// Buckets: [0, 1ms), [1ms, 2ms). Every tick observes 100 events.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func(delay time.Duration) Sample {
+		t.Helper()
+		s.advance(ctx, delay)
+		require.NotEmpty(t, listener.samples)
+		sample := listener.samples[len(listener.samples)-1]
+		snap := latest.Load()
+		require.Equal(t, sample.At, snap.At)
+		require.Equal(t, sample.EventsPerSecond, snap.EventsPerSecond)
+		require.Equal(t, sample.Gapped, snap.Gapped)
+		require.Equal(t, sample.EventsPerSecond, s.metrics.EventsPerSecond.Value())
+		return sample
+	}
+
+	s.tick(ctx) // nothing to compare against yet
+	s.tick(ctx) // provisional
+	require.Len(t, listener.provisional, 1)
+	require.Equal(t, float64(100), listener.provisional[0].EventsPerSecond)
+
+	sample := tick(time.Second)
+	require.Equal(t, uint64(200), sample.Events)
+	require.Equal(t, float64(100), sample.EventsPerSecond)
+	require.False(t, sample.Gapped)
+
+	// A delayed tick spreads the same events over more time elapsed, and the
+	// window is flagged as gapped.
+	sample = tick(3 * time.Second)
+	require.Equal(t, uint64(200), sample.Events)
+	require.Equal(t, 4*time.Second, sample.Elapsed)
+	require.Equal(t, float64(50), sample.EventsPerSecond)
+	require.True(t, sample.Gapped)
+
+	// Empty windows report zero; they're idle, there being fewer than
+	// scheduler_latency.idle_window.min_events.
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+	tick(time.Second)
+	sample = tick(time.Second)
+	require.True(t, sample.Idle)
+	require.Zero(t, sample.Events)
+	require.Zero(t, sample.EventsPerSecond)
+}
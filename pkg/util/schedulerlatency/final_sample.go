@@ -0,0 +1,67 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// flushFinal takes one final sample as the sampler stops, and delivers the
+// partial window since the previous tick to the listeners, flagged as final,
+// recording it in the recent results. The latencies observed last, say while
+// a node drains, are otherwise discarded, and they're often the most telling.
+// It's a no-op if there's no previous sample to compare against, if no time
+// elapsed since, or if the sampler is closed or degraded.
+func (s *sampler) flushFinal(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.closed || s.mu.degraded || s.mu.ringBuffer.Len() == 0 {
+		return
+	}
+	start := timeutil.Now()
+	latestCumulative := s.sample()
+	latestCumulative.at = s.mu.timeSource.Now()
+	s.metrics.SampleNanos.Inc(timeutil.Since(start).Nanoseconds())
+	if latestCumulative.latencies == nil {
+		s.degradeLocked(ctx, errors.Newf("runtime metric %s is no longer a histogram", schedLatenciesMetric))
+		return
+	}
+	if err := checkMonotonic(s.mu.latestCumulative, latestCumulative.latencies); err != nil {
+		// There's no re-baselining this late; the window spanning the decrease
+		// would be garbage.
+		log.Warningf(ctx, "%v, discarding the final scheduler latency sample", err)
+		return
+	}
+	prev := s.mu.ringBuffer.GetFirst()
+	if !latestCumulative.at.After(prev.at) {
+		return // we've just ticked, there's nothing left to deliver
+	}
+
+	minEvents := uint64(idleWindowMinEvents.Get(&s.mu.st.SV))
+	w, interval, ok := computeWindow(latestCumulative, prev, 1 /* samples */, s.mu.period, minEvents)
+	if !ok {
+		return // there's nothing to deliver
+	}
+	w.final = true
+	s.recordDebugResultLocked(w, interval.Histogram())
+	computed := timeutil.Now()
+	s.invokeListenersLocked(ctx, Sample{
+		P99: w.p99, StdDev: w.stddev, Events: w.events, Period: s.mu.period, At: w.at,
+		Elapsed: w.elapsed, Idle: w.idle, Final: true, GOMAXPROCS: w.gomaxprocs,
+		LatencyRatio: w.latencyRatio(), TailRatio: w.tailRatio(), EventsPerSecond: w.eventsPerSecond(),
+		CPUUtilization: w.cpuUtilization, Window: w.config(s.mu.period),
+	})
+	s.metrics.CallbackNanos.Inc(timeutil.Since(computed).Nanoseconds())
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFinalSampleOnQuiesce verifies that quiescing the stopper of the last
+// caller attached to the shared sampler has it deliver a final sample, over the
+// time since the previous tick, before tearing down.
+func TestFinalSampleOnQuiesce(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// Use a clock that's only advanced within a period, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	var listener lockedSampleListener
+	require.NoError(t, StartSampler(ctx, st, stopper, metric.NewRegistry(), time.Hour, &listener, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	s.mu.Lock()
+	s.sample = busySample()
+	s.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	}
+	require.Len(t, listener.get(), 1)
+
+	clock.Advance(30 * time.Minute)
+	stopper.Stop(ctx)
+	samples := listener.get()
+	require.Len(t, samples, 2)
+	require.False(t, samples[0].Final)
+	final := samples[1]
+	require.True(t, final.Final)
+	require.False(t, final.Provisional)
+	require.Equal(t, 30*time.Minute, final.Elapsed)
+	require.Equal(t, clock.Now(), final.At)
+	require.Equal(t, uint64(100), final.Events)
+	require.Equal(t, 990*time.Millisecond, final.P99)
+	_, ok := Latest()
+	require.False(t, ok)
+}
+
+// TestFinalSample verifies that the final sample is delivered to every
+// SampleObserver, over the sampler's own window, but not to legacy listeners,
+// and is recorded in the recent results.
+func TestFinalSample(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	clock := s.clock
+	// Observe 200 events a second, however often it's sampled.
+	latencies := &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}
+	last := clock.Now()
+	s.sample = func() runtimeSample {
+		latencies.Counts[0] += uint64(200 * clock.Since(last).Seconds())
+		last = clock.Now()
+		return runtimeSample{latencies: clone(latencies)}
+	}
+	var listener, throttled, windowed sampleListener
+	var legacy countingListener
+	s.addListener(&listener)
+	s.addListener(WithMinDeliveryInterval(&throttled, time.Hour))
+	s.addListener(WithWindowDuration(&windowed, 4*time.Second))
+	s.addListener(&legacy)
+
+	// There's nothing to compare against, absent a previous sample.
+	s.flushFinal(ctx)
+	require.Empty(t, listener.samples)
+	for i := 0; i < 6; i++ {
+		s.tick(ctx)
+	}
+	require.Len(t, listener.samples, 4)
+	// The throttled listener was only delivered the first, provisional, one.
+	require.Len(t, throttled.provisional, 1)
+	require.Empty(t, throttled.samples)
+	require.Len(t, windowed.samples, 2)
+	require.Equal(t, 4, legacy.get())
+	// Nor, having just ticked, is there anything left to deliver.
+	s.flushFinal(ctx)
+	require.Len(t, listener.samples, 4)
+
+	clock.Advance(500 * time.Millisecond)
+	s.flushFinal(ctx)
+	for _, l := range []*sampleListener{&listener, &throttled, &windowed} {
+		final := l.samples[len(l.samples)-1]
+		require.True(t, final.Final)
+		require.Equal(t, 500*time.Millisecond, final.Elapsed)
+		require.Equal(t, uint64(100), final.Events)
+		require.Equal(t, 200.0, final.EventsPerSecond)
+	}
+	require.Equal(t, 4, legacy.get())
+	results := s.dump().Results
+	require.True(t, results[len(results)-1].Final)
+	require.False(t, results[len(results)-2].Final)
+	require.Equal(t, 500*time.Millisecond, results[len(results)-1].Elapsed)
+
+	// It isn't delivered once the sampler is closed.
+	s.close()
+	clock.Advance(500 * time.Millisecond)
+	s.flushFinal(ctx)
+	require.Len(t, listener.samples, 5)
+}
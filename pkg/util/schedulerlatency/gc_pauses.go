@@ -0,0 +1,62 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// gcPausesEnabled controls the GC pause sampler. It piggybacks on the scheduler
+// latency sampler: the histogram is read alongside the scheduler latencies, in
+// the same metrics.Read, and windowed over the same
+// scheduler_latency.sample_{period,duration}.
+var gcPausesEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.gc_pauses.enabled",
+	"when set, the p99 of GC stop-the-world pauses is computed over every "+
+		"scheduler_latency.sample_duration, and exported",
+	false,
+)
+
+var metaGCPauseP99 = metric.Metadata{
+	Name:        "go.gc_pauses.p99",
+	Help:        "p99 of GC stop-the-world pauses over the last scheduler_latency.sample_duration (if scheduler_latency.gc_pauses.enabled is set)",
+	Measurement: "Nanoseconds",
+	Unit:        metric.Unit_NANOSECONDS,
+}
+
+// gcPausesRuntimeMetric describes the GC pause histogram to the runtime
+// sampler. It's windowed independently of the scheduler latencies (it's only
+// read if enabled), and the p99 over every full window is exported and
+// delivered to the GC pause callbacks.
+func (s *sampler) gcPausesRuntimeMetric() *runtimeMetric {
+	return &runtimeMetric{
+		name:        gcPausesMetric,
+		kind:        histogramMetric,
+		enabled:     gcPausesEnabled,
+		independent: true,
+		summarize:   windowP99,
+		gauge:       s.metrics.GCPauseP99,
+		deliver:     s.invokeGCPauseCallbacksLocked,
+	}
+}
+
+// invokeGCPauseCallbacksLocked invokes the GC pause callbacks that are due a
+// delivery.
+func (s *sampler) invokeGCPauseCallbacksLocked(
+	ctx context.Context, p99, elapsed time.Duration, at time.Time,
+) {
+	gcPauseCallbacks.deliverLocked(ctx, s, at, func(f GCPauseCallback) { f(p99, elapsed) })
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGCPauses drives the sampler with injected GC pause histograms, verifying
+// the values delivered to callbacks and exported, and that disabling the GC
+// pause sampler re-baselines it.
+func TestGCPauses(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+
+	// Buckets: [0, 1ms), [1ms, +Inf).
+	latencies := &metrics.Float64Histogram{Counts: []uint64{0, 0}, Buckets: []float64{0, 0.001, math.Inf(+1)}}
+	gcPauses := &metrics.Float64Histogram{Counts: []uint64{0, 0}, Buckets: []float64{0, 0.001, math.Inf(+1)}}
+	enabled := true
+	s.sample = func() runtimeSample {
+		res := runtimeSample{latencies: clone(latencies)}
+		if enabled {
+			res.windowed = []runtimeValue{{histogram: clone(gcPauses), ok: true}}
+		}
+		return res
+	}
+
+	var delivered []time.Duration
+	id := RegisterGCPauseCallback(func(p99 time.Duration, elapsed time.Duration) {
+		require.Equal(t, time.Second, elapsed)
+		delivered = append(delivered, p99)
+	}, 0 /* minInterval */)
+	defer UnregisterGCPauseCallback(id)
+
+	tick := func(pauses uint64) {
+		gcPauses.Counts[0] += pauses
+		s.tick(ctx)
+	}
+	tick(100) // nothing to compare against yet
+	require.Empty(t, delivered)
+	tick(100)
+	tick(200)
+	require.Equal(t, []time.Duration{990 * time.Microsecond, 990 * time.Microsecond}, delivered)
+	require.Equal(t, (990 * time.Microsecond).Nanoseconds(), s.metrics.GCPauseP99.Value())
+
+	// Disabling the GC pause sampler stops deliveries and clears the gauge.
+	enabled = false
+	tick(100)
+	tick(100)
+	require.Len(t, delivered, 2)
+	require.Zero(t, s.metrics.GCPauseP99.Value())
+
+	// Re-enabling it re-baselines.
+	enabled = true
+	tick(100)
+	require.Len(t, delivered, 2)
+	tick(100)
+	require.Len(t, delivered, 3)
+}
+
+// TestSampleGCPauses verifies that the GC pause histogram is read from the
+// runtime alongside the scheduler latencies, only if enabled.
+func TestSampleGCPauses(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	s := newSampler(st, time.Second, time.Second)
+	s.mu.Lock()
+	sample := s.sample()
+	require.NotNil(t, sample.latencies)
+	// Neither the GC pauses nor the cgroup throttling are read.
+	require.Equal(t, []runtimeValue{{}, {}}, sample.windowed)
+	s.mu.Unlock()
+	gcPausesEnabled.Override(ctx, &st.SV, true)
+	s.mu.Lock()
+	sample = s.sample()
+	require.NotNil(t, sample.latencies)
+	require.Len(t, sample.windowed, 2)
+	require.True(t, sample.windowed[0].ok)
+	require.Equal(t, coarseBuckets(), sample.windowed[0].histogram.Buckets)
+	require.False(t, sample.windowed[1].ok)
+	s.mu.Unlock()
+}
@@ -0,0 +1,232 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/ring"
+)
+
+// heatmapEnabled controls the retention of the scheduler latency heatmap,
+// which the DB console renders with time on one axis and latency buckets on the
+// other. Percentiles don't suffice for the purpose, hence the bucket counts.
+var heatmapEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.heatmap.enabled",
+	"when set, the scheduler latency bucket counts are retained every 10s over the last hour, "+
+		"for the DB console to render as a heatmap",
+	true,
+)
+
+const (
+	// heatmapInterval is the minimum time between consecutive heatmap columns.
+	heatmapInterval = 10 * time.Second
+	// heatmapRetention is how long heatmap columns are retained for.
+	heatmapRetention = time.Hour
+	// maxHeatmapColumns bounds the number of heatmap columns retained. Columns
+	// are at least heatmapInterval apart, so it's only reached when they're
+	// recorded on time. With the coarse layout's 83 buckets, the worst case is
+	// 360 columns of 83 counts, about 230KiB, plus the copy of the cumulative
+	// histogram as of the latest column.
+	maxHeatmapColumns = int(heatmapRetention / heatmapInterval)
+)
+
+// Heatmap is the JSON representation of the scheduler latency bucket counts
+// retained over the last hour, served by HandleHeatmap. Each column holds the
+// counts observed since the previous one, for every bucket.
+type Heatmap struct {
+	// Interval is the nominal time between consecutive columns.
+	Interval time.Duration `json:"interval_nanos"`
+	// Retention is how far back the columns go.
+	Retention time.Duration `json:"retention_nanos"`
+	// Buckets are the latency buckets, in increasing order, that the counts of
+	// every column correspond to.
+	Buckets []HeatmapBucket `json:"buckets"`
+	// Columns are the columns retained, oldest first.
+	Columns []HeatmapColumn `json:"columns"`
+}
+
+// HeatmapBucket is a latency bucket of Heatmap, spanning [Lower, Upper).
+// Unbounded boundaries are omitted.
+type HeatmapBucket struct {
+	Lower *time.Duration `json:"lower_nanos,omitempty"`
+	Upper *time.Duration `json:"upper_nanos,omitempty"`
+}
+
+// HeatmapColumn is a column of Heatmap.
+type HeatmapColumn struct {
+	// At is when the latest sample in the column was taken.
+	At time.Time `json:"at"`
+	// Elapsed is the time elapsed since the previous column; it's
+	// Heatmap.Interval, or more if ticks were delayed or skipped.
+	Elapsed time.Duration `json:"elapsed_nanos"`
+	// Counts are the scheduling events observed over the column, for every
+	// bucket of Heatmap.Buckets.
+	Counts []uint64 `json:"counts"`
+}
+
+// RecentHeatmap returns the scheduler latency heatmap retained by the running
+// sampler. It returns false if there's no sampler running. The heatmap has no
+// columns if it's disabled through scheduler_latency.heatmap.enabled.
+func RecentHeatmap() (Heatmap, bool) {
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return Heatmap{}, false
+	}
+	return s.heatmap(), true
+}
+
+// HandleHeatmap serves the scheduler latency heatmap as JSON. It's sourced from
+// the running sampler, and responds with http.StatusServiceUnavailable if
+// there isn't one.
+func HandleHeatmap(w http.ResponseWriter, r *http.Request) {
+	h, ok := RecentHeatmap()
+	if !ok {
+		http.Error(w, "scheduler latency sampler is not running", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(h); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// heatmap returns the sampler's heatmap in its JSON representation.
+func (s *sampler) heatmap() Heatmap {
+	s.mu.Lock()
+	buckets := s.mu.heatmap.buckets
+	n := s.mu.heatmap.columns.Len()
+	columns := make([]heatmapColumn, n)
+	for i := 0; i < n; i++ {
+		columns[n-1-i] = s.mu.heatmap.columns.Get(i)
+	}
+	s.mu.Unlock()
+
+	// The columns are never mutated once recorded, so it's safe to read them
+	// without holding the lock; they're copied all the same, for the caller
+	// to own.
+	res := Heatmap{
+		Interval:  heatmapInterval,
+		Retention: heatmapRetention,
+		Buckets:   []HeatmapBucket{},
+		Columns:   make([]HeatmapColumn, n),
+	}
+	boundary := func(b float64) *time.Duration {
+		if math.IsInf(b, 0) {
+			return nil
+		}
+		d := SecondsToDuration(b)
+		return &d
+	}
+	for i := 0; i+1 < len(buckets); i++ {
+		res.Buckets = append(res.Buckets, HeatmapBucket{
+			Lower: boundary(buckets[i]),
+			Upper: boundary(buckets[i+1]),
+		})
+	}
+	for i, c := range columns {
+		res.Columns[i] = HeatmapColumn{
+			At:      c.at,
+			Elapsed: c.elapsed,
+			Counts:  append([]uint64(nil), c.counts...),
+		}
+	}
+	return res
+}
+
+// recordHeatmapLocked records a heatmap column from the latest cumulative
+// sample, if heatmapInterval has elapsed since the last one. The heatmap is
+// cleared once disabled. Standalone samplers don't retain one, there being no
+// way to serve it.
+func (s *sampler) recordHeatmapLocked(latestCumulative runtimeSample) {
+	if s.standalone {
+		return
+	}
+	if !heatmapEnabled.Get(&s.mu.st.SV) {
+		s.mu.heatmap.clear()
+		return
+	}
+	s.mu.heatmap.record(latestCumulative.latencies, latestCumulative.at)
+}
+
+// latencyHeatmap retains the scheduler latency bucket counts observed every
+// heatmapInterval, over the last heatmapRetention, in bounded memory (see
+// maxHeatmapColumns).
+type latencyHeatmap struct {
+	// columns are the columns retained, most recent first.
+	columns ring.Buffer[heatmapColumn]
+	// buckets are the bucket boundaries of the columns' counts.
+	buckets []float64
+	// last is a copy of the cumulative histogram as of the latest column, or
+	// the first one recorded since the heatmap was cleared, and lastAt is when
+	// it was sampled. It's nil if no histogram has been recorded.
+	last   *metrics.Float64Histogram
+	lastAt time.Time
+}
+
+// heatmapColumn is a column of the heatmap; see HeatmapColumn.
+type heatmapColumn struct {
+	at      time.Time
+	elapsed time.Duration
+	counts  []uint64
+}
+
+// record records a heatmap column from the given cumulative histogram, sampled
+// at the given time, if heatmapInterval has elapsed since the last one. The
+// columns older than heatmapRetention are rolled off. A histogram with a
+// different layout than the previous one restarts the heatmap.
+func (h *latencyHeatmap) record(cumulative *metrics.Float64Histogram, at time.Time) {
+	if h.last == nil || len(h.last.Counts) != len(cumulative.Counts) {
+		h.clear()
+		h.columns.Reserve(maxHeatmapColumns)
+		h.last, h.lastAt = clone(cumulative), at
+		h.buckets = h.last.Buckets
+		return
+	}
+	elapsed := at.Sub(h.lastAt)
+	if elapsed < heatmapInterval {
+		return
+	}
+	if h.columns.Len() == maxHeatmapColumns {
+		h.columns.RemoveLast()
+	}
+	h.columns.AddFirst(heatmapColumn{
+		at:      at,
+		elapsed: elapsed,
+		counts:  sub(cumulative, h.last).Counts,
+	})
+	h.last, h.lastAt = clone(cumulative), at
+	h.rollOff(at)
+}
+
+// rollOff removes the columns that are older than heatmapRetention as of the
+// given time.
+func (h *latencyHeatmap) rollOff(now time.Time) {
+	cutoff := now.Add(-heatmapRetention)
+	for h.columns.Len() > 0 && !h.columns.GetLast().at.After(cutoff) {
+		h.columns.RemoveLast()
+	}
+}
+
+// clear discards the heatmap, releasing its memory.
+func (h *latencyHeatmap) clear() {
+	*h = latencyHeatmap{}
+}
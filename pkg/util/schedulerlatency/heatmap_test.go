@@ -0,0 +1,143 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHeatmapRollOff verifies that heatmap columns are recorded every
+// heatmapInterval, and rolled off once older than heatmapRetention, without
+// the memory retained growing beyond maxHeatmapColumns.
+func TestHeatmapRollOff(t *testing.T) {
+	// Buckets: [0, 1ms), [1ms, 2ms). Every second observes one fast event, and
+	// every other second a slow one.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	now := timeutil.Unix(0, 0)
+	var h latencyHeatmap
+	record := func() {
+		h.record(clone(cumulative), now)
+	}
+	record() // the baseline, yielding no column
+	require.Zero(t, h.columns.Len())
+	for i := 1; i <= 2*3600; i++ {
+		now = now.Add(time.Second)
+		cumulative.Counts[0]++
+		if i%2 == 0 {
+			cumulative.Counts[1]++
+		}
+		record()
+		require.Equal(t, min(i/10, maxHeatmapColumns), h.columns.Len(), "after %ds", i)
+	}
+	require.Equal(t, maxHeatmapColumns, h.columns.Cap())
+	for i := 0; i < h.columns.Len(); i++ {
+		c := h.columns.Get(i)
+		require.Equal(t, now.Add(-time.Duration(i)*heatmapInterval), c.at)
+		require.Equal(t, heatmapInterval, c.elapsed)
+		require.Equal(t, []uint64{10, 5}, c.counts)
+	}
+
+	// After a gap, the columns older than the retention as of the next one are
+	// rolled off.
+	now = now.Add(30 * time.Minute)
+	cumulative.Counts[1] += 7
+	record()
+	require.Equal(t, maxHeatmapColumns/2+1, h.columns.Len())
+	require.Equal(t, heatmapColumn{at: now, elapsed: 30 * time.Minute, counts: []uint64{0, 7}},
+		h.columns.GetFirst())
+	require.Equal(t, now.Add(-heatmapRetention).Add(heatmapInterval), h.columns.GetLast().at)
+	now = now.Add(heatmapRetention)
+	record()
+	require.Equal(t, 1, h.columns.Len())
+
+	// A histogram with a different layout restarts the heatmap.
+	now = now.Add(heatmapInterval)
+	h.record(&metrics.Float64Histogram{Counts: []uint64{1}, Buckets: []float64{0, 1}}, now)
+	require.Zero(t, h.columns.Len())
+	require.Equal(t, []float64{0, 1}, h.buckets)
+}
+
+// TestHeatmapSampler verifies that the sampler retains the heatmap, serving it
+// oldest column first, and clears it once disabled or closed.
+func TestHeatmapSampler(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	st := s.st
+	// Buckets: [-Inf, 1ms), [1ms, +Inf). Every tick observes its number of
+	// events in the first bucket.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{math.Inf(-1), 0.001, math.Inf(+1)},
+	}
+	ticks := 0
+	s.sample = func() runtimeSample {
+		ticks++
+		cumulative.Counts[0] += uint64(ticks)
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	tick := func(n int) {
+		for i := 0; i < n; i++ {
+			s.tick(ctx)
+		}
+	}
+
+	tick(25) // the baseline, and two columns
+	h := s.heatmap()
+	require.Equal(t, heatmapInterval, h.Interval)
+	require.Equal(t, heatmapRetention, h.Retention)
+	ms := time.Millisecond
+	require.Equal(t, []HeatmapBucket{{Upper: &ms}, {Lower: &ms}}, h.Buckets)
+	require.Len(t, h.Columns, 2)
+	// The first column spans ticks 2 through 11, and the second 12 through 21.
+	require.Equal(t, HeatmapColumn{
+		At: timeutil.Unix(11, 0), Elapsed: heatmapInterval, Counts: []uint64{65, 0},
+	}, h.Columns[0])
+	require.Equal(t, HeatmapColumn{
+		At: timeutil.Unix(21, 0), Elapsed: heatmapInterval, Counts: []uint64{165, 0},
+	}, h.Columns[1])
+	// The heatmap served is the caller's to own.
+	h.Columns[0].Counts[0] = 0
+	require.Equal(t, uint64(65), s.heatmap().Columns[0].Counts[0])
+
+	// Once disabled, it's cleared, and no longer recorded.
+	heatmapEnabled.Override(ctx, &st.SV, false)
+	tick(20)
+	require.Empty(t, s.heatmap().Columns)
+	require.Nil(t, s.mu.heatmap.last)
+
+	// Once re-enabled, it's recorded afresh.
+	heatmapEnabled.Override(ctx, &st.SV, true)
+	tick(11)
+	require.Len(t, s.heatmap().Columns, 1)
+	s.close()
+	require.Empty(t, s.heatmap().Columns)
+	require.Empty(t, s.heatmap().Buckets)
+}
+
+// TestHandleHeatmap verifies that HandleHeatmap is unavailable absent a
+// running sampler.
+func TestHandleHeatmap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	HandleHeatmap(rec, httptest.NewRequest(http.MethodGet, "/debug/scheduler_latency/heatmap", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -13,6 +13,7 @@ package schedulerlatency
 import (
 	"math"
 	"runtime/metrics"
+	"sync"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
@@ -160,6 +161,99 @@ func (h *runtimeHistogram) GetMetadata() metric.Metadata {
 // Inspect is part of the Iterable interface.
 func (h *runtimeHistogram) Inspect(f func(interface{})) { f(h) }
 
+// The sampler re-bins the runtime's scheduler latency histogram into a coarser,
+// fixed layout immediately after sampling: the ring buffer retains, and
+// percentiles are computed from, the coarse histograms. The layout is derived
+// from the runtime's using reBucketExpAndTrim; adjacent boundaries within
+// [coarseBucketMin, coarseBucketMax] are at most 1.25x apart (see
+// TestCoarseBuckets), which roughly halves the number of buckets. Since
+// percentiles computed from either layout lie within the same coarse bucket,
+// for values in that range the coarse p99 is within 25% of the one computed
+// at full resolution. Values outside the range are clamped to it. The layout
+// is also a superset of the one used for the exported histogram metric.
+const (
+	coarseBucketBase = 1.1
+	coarseBucketMin  = time.Microsecond
+	coarseBucketMax  = time.Second
+)
+
+var coarseBucketsOnce struct {
+	sync.Once
+	buckets []float64
+}
+
+// coarseBuckets returns the bucket boundaries of the coarse layout, following
+// runtime/metrics conventions. The returned slice must not be mutated.
+func coarseBuckets() []float64 {
+	coarseBucketsOnce.Do(func() {
+		coarseBucketsOnce.buckets = reBucketExpAndTrim(
+			sample().Buckets, coarseBucketBase, coarseBucketMin.Seconds(), coarseBucketMax.Seconds(),
+		)
+	})
+	return coarseBucketsOnce.buckets
+}
+
+var cpuSchedulerLatencyBucketsOnce struct {
+	sync.Once
+	buckets []float64
+}
+
+// cpuSchedulerLatencyBuckets returns the bucket boundaries of the exported
+// histogram metrics, following runtime/metrics conventions. They're suitable
+// for a histogram that records a (second-denominated) quantity where
+// measurements correspond to delays in scheduling goroutines onto processors,
+// i.e. are in the {micro,milli}-second range during normal operation. See
+// TestHistogramBuckets for more details. The returned slice must not be
+// mutated.
+func cpuSchedulerLatencyBuckets() []float64 {
+	cpuSchedulerLatencyBucketsOnce.Do(func() {
+		cpuSchedulerLatencyBucketsOnce.buckets = reBucketExpAndTrim(
+			coarseBuckets(),                    // original buckets
+			1.1,                                // base
+			(50 * time.Microsecond).Seconds(),  // min
+			(100 * time.Millisecond).Seconds(), // max
+		)
+	})
+	return cpuSchedulerLatencyBucketsOnce.buckets
+}
+
+// rebin folds the given histogram into one with the given bucket boundaries,
+// which must be a subset of the histogram's (including both ends), as produced
+// by reBucketExpAndTrim. The returned histogram references the given buckets.
+func rebin(h *metrics.Float64Histogram, buckets []float64) *metrics.Float64Histogram {
+	res := &metrics.Float64Histogram{
+		Counts:  make([]uint64, len(buckets)-1),
+		Buckets: buckets,
+	}
+	var j int
+	for i, count := range h.Counts {
+		res.Counts[j] += count
+		if h.Buckets[i+1] == buckets[j+1] {
+			j++
+		}
+	}
+	return res
+}
+
+// SecondsToDuration converts a (second-denominated) float64, like the bucket
+// boundaries and percentiles computed from runtime/metrics histograms, to a
+// time.Duration. Unlike a plain conversion, it rounds to the nearest
+// nanosecond instead of truncating, and saturates at the bounds of
+// time.Duration instead of overflowing. NaNs are converted to zero.
+func SecondsToDuration(seconds float64) time.Duration {
+	nanos := math.Round(seconds * float64(time.Second.Nanoseconds()))
+	switch {
+	case math.IsNaN(nanos):
+		return 0
+	case nanos >= math.MaxInt64: // float64(math.MaxInt64) rounds up to 2^63
+		return time.Duration(math.MaxInt64)
+	case nanos <= math.MinInt64:
+		return time.Duration(math.MinInt64)
+	default:
+		return time.Duration(int64(nanos))
+	}
+}
+
 // reBucketExpAndTrim takes a list of bucket boundaries (lower bound inclusive)
 // and down samples the buckets to those a multiple of base apart. The end
 // result is a roughly exponential (in many cases, perfectly exponential)
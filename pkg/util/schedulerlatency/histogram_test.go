@@ -21,6 +21,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/testutils/datapathutils"
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/datadriven"
 	"github.com/stretchr/testify/require"
 )
@@ -117,6 +118,113 @@ func TestRuntimeHistogram(t *testing.T) {
 	)
 }
 
+func TestRebin(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3, 4, 5, 6, 7},
+		Buckets: []float64{math.Inf(-1), 0, 1, 2, 3, 4, 5, math.Inf(+1)},
+	}
+	res := rebin(h, []float64{math.Inf(-1), 1, 4, math.Inf(+1)})
+	require.Equal(t, []float64{math.Inf(-1), 1, 4, math.Inf(+1)}, res.Buckets)
+	require.Equal(t, []uint64{1 + 2, 3 + 4 + 5, 6 + 7}, res.Counts)
+
+	// Re-binning into the same layout is a no-op.
+	require.Equal(t, h, rebin(h, h.Buckets))
+}
+
+// TestCoarseBuckets verifies the documented properties of the coarse layout
+// the sampler re-bins runtime histograms into.
+func TestCoarseBuckets(t *testing.T) {
+	buckets := sample().Buckets
+	coarse := coarseBuckets()
+	require.Subset(t, buckets, coarse)
+	require.Less(t, len(coarse), len(buckets))
+	require.True(t, math.IsInf(coarse[0], -1))
+	require.True(t, math.IsInf(coarse[len(coarse)-1], +1))
+	require.LessOrEqual(t, coarse[1], coarseBucketMin.Seconds())
+	require.GreaterOrEqual(t, coarse[len(coarse)-2], coarseBucketMax.Seconds())
+	for i := 1; i < len(coarse)-2; i++ {
+		require.LessOrEqualf(t, coarse[i+1]/coarse[i], 1.25, "bucket[%d]=[%f, %f)", i, coarse[i], coarse[i+1])
+	}
+
+	// The layout used for the exported histogram metric is a subset of the
+	// coarse layout, and is the same whether derived from it or from the
+	// runtime's.
+	export := reBucketExpAndTrim(coarse, 1.1, (50 * time.Microsecond).Seconds(), (100 * time.Millisecond).Seconds())
+	require.Equal(t, reBucketExpAndTrim(buckets, 1.1, (50*time.Microsecond).Seconds(), (100*time.Millisecond).Seconds()), export)
+	require.Subset(t, coarse, export)
+}
+
+// TestCoarsePercentiles compares percentiles computed from re-binned
+// histograms against those computed at full resolution, which should be within
+// the documented bound.
+func TestCoarsePercentiles(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	buckets := sample().Buckets
+	for iter := 0; iter < 100; iter++ {
+		// Populate a random contiguous range of buckets within the coarse
+		// layout's range.
+		h := &metrics.Float64Histogram{Counts: make([]uint64, len(buckets)-1), Buckets: buckets}
+		var lo, hi int
+		for i := range h.Counts {
+			if buckets[i] < coarseBucketMin.Seconds() {
+				lo = i + 1
+			}
+			if buckets[i+1] <= coarseBucketMax.Seconds() {
+				hi = i
+			}
+		}
+		start := lo + rng.Intn(hi-lo)
+		end := start + rng.Intn(hi-start+1)
+		for i := start; i <= end; i++ {
+			h.Counts[i] = uint64(rng.Intn(1000))
+		}
+		h.Counts[end]++ // ensure there's at least one non-empty bucket
+
+		coarse := rebin(h, coarseBuckets())
+		for _, p := range []float64{0.5, 0.9, 0.99, 0.999} {
+			full, approx := mustPercentile(t, h, p), mustPercentile(t, coarse, p)
+			require.InDeltaf(t, full, approx, 0.25*full, "p=%f", p)
+		}
+	}
+}
+
+func TestSecondsToDuration(t *testing.T) {
+	for _, tc := range []struct {
+		seconds  float64
+		expected time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{-1, -time.Second},
+
+		// Nanosecond edges; values are rounded rather than truncated.
+		{1e-9, time.Nanosecond},
+		{0.4e-9, 0},
+		{0.5e-9, time.Nanosecond},
+		{2.9999999e-9, 3 * time.Nanosecond},
+		{-2.9999999e-9, -3 * time.Nanosecond},
+
+		// Microsecond edges, not all of which are exactly representable.
+		{0.000001, time.Microsecond},
+		{0.0000015, 1500 * time.Nanosecond},
+		{0.000753664, 753664 * time.Nanosecond},
+		{0.0009999999999, time.Millisecond},
+
+		// Values that would overflow int64 nanoseconds saturate.
+		{1e10, time.Duration(math.MaxInt64)},
+		{-1e10, time.Duration(math.MinInt64)},
+		{math.MaxInt64 / 1e9, time.Duration(math.MaxInt64)},
+		{math.Inf(+1), time.Duration(math.MaxInt64)},
+		{math.Inf(-1), time.Duration(math.MinInt64)},
+		{math.NaN(), 0},
+	} {
+		require.Equalf(t, tc.expected, SecondsToDuration(tc.seconds), "seconds=%v", tc.seconds)
+	}
+
+	// The largest value that doesn't saturate.
+	require.Equal(t, 9*time.Duration(1e18), SecondsToDuration(9e9))
+}
+
 // parseBuckets parses out the list of bucket boundaries when the given input is
 // of the form:
 //
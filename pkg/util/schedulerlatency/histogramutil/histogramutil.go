@@ -0,0 +1,396 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package histogramutil provides arithmetic over the histograms exported by
+// runtime/metrics (scheduler latencies, GC pauses, mutex waits), which are
+// cumulative: subtracting two samples yields the histogram over the interval
+// between them, from which percentiles can be computed.
+package histogramutil
+
+import (
+	"math"
+	"runtime/metrics"
+	"sort"
+)
+
+// Clone returns a copy of the given histogram.
+func Clone(h *metrics.Float64Histogram) *metrics.Float64Histogram {
+	res := &metrics.Float64Histogram{
+		Counts:  make([]uint64, len(h.Counts)),
+		Buckets: make([]float64, len(h.Buckets)),
+	}
+	copy(res.Counts, h.Counts)
+	copy(res.Buckets, h.Buckets)
+	return res
+}
+
+// Total returns the total count across all buckets of the given histogram,
+// including the unbounded ones.
+func Total(h *metrics.Float64Histogram) uint64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	return total
+}
+
+// Sub subtracts the counts of one histogram from another, assuming the bucket
+// boundaries are the same. For cumulative histograms, this can be used to
+// compute an interval histogram.
+func Sub(a, b *metrics.Float64Histogram) *metrics.Float64Histogram {
+	res := Clone(a)
+	for i := 0; i < len(res.Counts); i++ {
+		res.Counts[i] -= b.Counts[i]
+	}
+	return res
+}
+
+// Add adds the counts of one histogram to another, assuming the bucket
+// boundaries are the same. It can be used to combine interval histograms.
+func Add(a, b *metrics.Float64Histogram) *metrics.Float64Histogram {
+	res := Clone(a)
+	for i := 0; i < len(res.Counts); i++ {
+		res.Counts[i] += b.Counts[i]
+	}
+	return res
+}
+
+// Percentile computes a specific percentile value, p in [0, 1], of the given
+// histogram, linearly interpolating within the bucket containing it. Buckets
+// bounded by -Inf or +Inf aren't interpolated within: their finite bound is
+// used instead. The extremes are the bounds of the outermost non-empty buckets:
+// p=0 is the lower bound of the lowest one, and p=1 the upper bound of the
+// highest one (the finite bound instead, for unbounded buckets). It returns
+// false if the histogram is empty, there being no percentiles to speak of.
+//
+// TODO(irfansharif): Deduplicate this with the quantile computation in
+// util/metrics? Here we're using the raw histogram at the highest resolution
+// and with zero translation between types; there we rebucket the histogram and
+// translate to another type.
+func Percentile(h *metrics.Float64Histogram, p float64) (float64, bool) {
+	// Counts contains the number of occurrences for each histogram bucket.
+	// Given N buckets, Count[n] is the number of occurrences in the range
+	// [bucket[n], bucket[n+1]), for 0 <= n < N.
+	//
+	// TODO(irfansharif): Consider adjusting the default bucket count in the
+	// runtime to make this cheaper and with a more appropriate amount of
+	// resolution. The defaults can be seen through
+	// schedulerlatency.TestHistogramBuckets:
+	//
+	//   bucket[  0] width=0s boundary=[-Inf, 0s)
+	//   bucket[  1] width=1ns boundary=[0s, 1ns)
+	//   bucket[  2] width=1ns boundary=[1ns, 2ns)
+	//   bucket[  3] width=1ns boundary=[2ns, 3ns)
+	//   bucket[  4] width=1ns boundary=[3ns, 4ns)
+	//   ...
+	//   bucket[270] width=16.384µs boundary=[737.28µs, 753.664µs)
+	//   bucket[271] width=16.384µs boundary=[753.664µs, 770.048µs)
+	//   bucket[272] width=278.528µs boundary=[770.048µs, 1.048576ms)
+	//   bucket[273] width=32.768µs boundary=[1.048576ms, 1.081344ms)
+	//   bucket[274] width=32.768µs boundary=[1.081344ms, 1.114112ms)
+	//   ...
+	//   bucket[717] width=1h13m18.046511104s boundary=[53h45m14.046488576s, 54h58m32.09299968s)
+	//   bucket[718] width=1h13m18.046511104s boundary=[54h58m32.09299968s, 56h11m50.139510784s)
+	//   bucket[719] width=1h13m18.046511104s boundary=[56h11m50.139510784s, 57h25m8.186021888s)
+	//   bucket[720] width=57h25m8.186021888s boundary=[57h25m8.186021888s, +Inf)
+	//
+	var total uint64 // total count across all buckets
+	for i := range h.Counts {
+		total += h.Counts[i]
+	}
+	if total == 0 {
+		return 0, false
+	}
+	if p == 0 {
+		return minimum(h), true
+	}
+
+	// Linear approximation of the target value corresponding to percentile, p:
+	//
+	// Goal: We want to linear approximate the "p * total"-th value from the histogram.
+	//      - p * total is the ordinal rank (or simply, rank) of the target value
+	//        within the histogram bounds.
+	//
+	// Step 1: Find the bucket[i] which contains the target value.
+	//      - This will be the largest bucket[i] where the left-bound cumulative
+	//        count of bucket[i] <= p*total.
+	//
+	// Step 2: Determine the rank within the bucket (subset of histogram).
+	//      - Since the bucket is a subset of the original data set, we can simply
+	//        subtract left-bound cumulative to get the "subset_rank".
+	//
+	// Step 3: Use the "subset_rank" to interpolate the target value.
+	//      - So we want to interpolate the "subset_rank"-th value of the subset_count
+	//        that lies between [bucket_start, bucket_end). This is:
+	//        bucket_start + (bucket_start - bucket_end) * linear_interpolator
+	//        Where linear_interpolator = subset_rank / subset_count
+
+	// (Step 1) Iterate backwards (we're optimizing for higher percentiles) until
+	// we find the first bucket for which total-cumulative <= rank, which will be
+	// by design the largest bucket that meets that condition.
+	var cumulative uint64  // cumulative count of all buckets we've iterated through
+	var start, end float64 // start and end of current bucket
+	var i int              // index of current bucket
+	for i = len(h.Counts) - 1; i >= 0; i-- {
+		start, end = h.Buckets[i], h.Buckets[i+1]
+		if i == 0 && math.IsInf(h.Buckets[0], -1) { // -Inf
+			// Buckets[0] is permitted to have -Inf; avoid interpolating with
+			// infinity if our percentile value lies in this bucket.
+			start = end
+		}
+		if i == len(h.Counts)-1 && math.IsInf(h.Buckets[len(h.Buckets)-1], 1) { // +Inf
+			// Buckets[len(Buckets)-1] is permitted to have +Inf; avoid
+			// interpolating with infinity if our percentile value lies in this
+			// bucket.
+			end = start
+		}
+
+		if start == end && math.IsInf(start, 0) {
+			// Our (single) bucket boundary is [-Inf, +Inf), there's no
+			// information.
+			return 0.0, true
+		}
+
+		cumulative += h.Counts[i]
+		if p == 1.0 {
+			if cumulative > 0 {
+				break // we've found the highest bucket with a non-zero count (i.e. pmax)
+			}
+		} else if float64(total-cumulative) <= float64(total)*p {
+			break // we've found the bucket where the cumulative count until that point is p% of the total
+		}
+	}
+
+	// (Step 2) Find the target rank within bucket boundaries.
+	subsetRank := float64(total)*p - float64(total-cumulative)
+
+	// (Step 3) Using rank and the percentile to calculate the approximated value.
+	subsetPercentile := subsetRank / float64(h.Counts[i])
+	return start + (end-start)*subsetPercentile, true
+}
+
+// minimum returns the lower bound of the lowest non-empty bucket of the given
+// non-empty histogram, or its upper bound if it's unbounded below.
+func minimum(h *metrics.Float64Histogram) float64 {
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		if !math.IsInf(h.Buckets[i], -1) {
+			return h.Buckets[i]
+		}
+		if math.IsInf(h.Buckets[i+1], +1) {
+			return 0 // the bucket is [-Inf, +Inf), there's no information
+		}
+		return h.Buckets[i+1]
+	}
+	return 0
+}
+
+// Percentiles is like Percentile, but computes several percentiles of the
+// given histogram at once, returning them in the order requested. It computes
+// the total count and walks the buckets only once, instead of once per
+// percentile, producing results identical to repeated calls to Percentile. It
+// returns false, and zeroes, if the histogram is empty.
+func Percentiles(h *metrics.Float64Histogram, ps []float64) ([]float64, bool) {
+	res := make([]float64, len(ps))
+	var total uint64 // total count across all buckets
+	for i := range h.Counts {
+		total += h.Counts[i]
+	}
+	if total == 0 {
+		return res, false
+	}
+	if len(ps) == 0 {
+		return res, true
+	}
+
+	// Walking backwards, we're going to find the buckets containing higher
+	// percentiles first, so visit the requested percentiles in decreasing order.
+	// The slice is tiny; insertion sort it to avoid allocating.
+	order := make([]int, len(ps))
+	for i := range order {
+		order[i] = i
+		for j := i; j > 0 && ps[order[j]] > ps[order[j-1]]; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+
+	// See Percentile for an explanation of the approximation; we're doing the
+	// same thing, but for each percentile as we encounter its bucket.
+	var cumulative uint64  // cumulative count of all buckets we've iterated through
+	var start, end float64 // start and end of current bucket
+	next := 0              // index into order of the next percentile to find
+	for i := len(h.Counts) - 1; i >= 0 && next < len(order); i-- {
+		start, end = h.Buckets[i], h.Buckets[i+1]
+		if i == 0 && math.IsInf(h.Buckets[0], -1) { // -Inf
+			start = end
+		}
+		if i == len(h.Counts)-1 && math.IsInf(h.Buckets[len(h.Buckets)-1], 1) { // +Inf
+			end = start
+		}
+
+		if start == end && math.IsInf(start, 0) {
+			// Our (single) bucket boundary is [-Inf, +Inf), there's no
+			// information.
+			return res, true
+		}
+
+		cumulative += h.Counts[i]
+		for ; next < len(order); next++ {
+			p := ps[order[next]]
+			if p == 1.0 {
+				if cumulative == 0 {
+					break // yet to find the highest bucket with a non-zero count
+				}
+			} else if float64(total-cumulative) > float64(total)*p {
+				break // yet to find the bucket containing p% of the total
+			}
+			subsetRank := float64(total)*p - float64(total-cumulative)
+			subsetPercentile := subsetRank / float64(h.Counts[i])
+			res[order[next]] = start + (end-start)*subsetPercentile
+		}
+	}
+	return res, true
+}
+
+// StdDev approximates the standard deviation of the given histogram, taking
+// every count to lie at the midpoint of its bucket. Buckets bounded by -Inf or
+// +Inf have no midpoint, and their counts are excluded. It returns false if
+// there are no counts in the bounded buckets.
+//
+// Bucketing shifts every value by at most half the width of its bucket, and
+// the standard deviation changes by no more than the (root mean square) shift,
+// so the result is off by at most half the width of the widest non-empty
+// bucket. For values spread evenly within their buckets, the midpoints
+// underestimate the variance by about width²/12 (Sheppard's correction), which
+// isn't applied. The runtime's buckets are exponentially sized, so the error is
+// proportional to the latencies observed, not their spread: a tightly
+// clustered distribution, within a single wide bucket, has a standard
+// deviation of zero.
+func StdDev(h *metrics.Float64Histogram) (float64, bool) {
+	midpoint := func(i int) (float64, bool) {
+		start, end := h.Buckets[i], h.Buckets[i+1]
+		if math.IsInf(start, 0) || math.IsInf(end, 0) {
+			return 0, false
+		}
+		return start + (end-start)/2, true
+	}
+	// Compute the mean first, and the variance about it in a second pass,
+	// which unlike the sum of squares doesn't suffer from cancellation.
+	var n, sum float64
+	for i, c := range h.Counts {
+		if m, ok := midpoint(i); ok && c > 0 {
+			n += float64(c)
+			sum += float64(c) * m
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	mean := sum / n
+	var squares float64
+	for i, c := range h.Counts {
+		if m, ok := midpoint(i); ok && c > 0 {
+			squares += float64(c) * (m - mean) * (m - mean)
+		}
+	}
+	return math.Sqrt(squares / n), true
+}
+
+// PrefixSums is a histogram along with the cumulative counts of its buckets,
+// computed once so that any number of percentiles can then be queried by binary
+// search over them, in time logarithmic rather than linear in the number of
+// buckets. The histogram must not be modified once they're computed.
+type PrefixSums struct {
+	h *metrics.Float64Histogram
+	// sums[i] is the total count of the buckets below bucket i, i.e. the
+	// left-bound cumulative count of Percentile, so that sums[len(h.Counts)]
+	// is the total count.
+	sums []uint64
+}
+
+// NewPrefixSums computes the prefix sums of the given histogram.
+func NewPrefixSums(h *metrics.Float64Histogram) *PrefixSums {
+	sums := make([]uint64, len(h.Counts)+1)
+	for i, c := range h.Counts {
+		sums[i+1] = sums[i] + c
+	}
+	return &PrefixSums{h: h, sums: sums}
+}
+
+// Histogram returns the histogram the prefix sums were computed over.
+func (s *PrefixSums) Histogram() *metrics.Float64Histogram {
+	return s.h
+}
+
+// Total returns the total count across all buckets of the histogram.
+func (s *PrefixSums) Total() uint64 {
+	return s.sums[len(s.sums)-1]
+}
+
+// Percentile is like the package-level Percentile, producing identical
+// results, but finds the bucket containing the percentile by binary search.
+func (s *PrefixSums) Percentile(p float64) (float64, bool) {
+	total := s.Total()
+	if total == 0 {
+		return 0, false
+	}
+	return s.percentile(total, p), true
+}
+
+// Percentiles is like the package-level Percentiles, producing identical
+// results, but finds the bucket containing each percentile by binary search.
+func (s *PrefixSums) Percentiles(ps []float64) ([]float64, bool) {
+	res := make([]float64, len(ps))
+	total := s.Total()
+	if total == 0 {
+		return res, false
+	}
+	for i, p := range ps {
+		res[i] = s.percentile(total, p)
+	}
+	return res, true
+}
+
+// percentile computes the given percentile of the non-empty histogram with the
+// given total count. See Percentile for an explanation of the approximation.
+func (s *PrefixSums) percentile(total uint64, p float64) float64 {
+	h, n := s.h, len(s.h.Counts)
+	if n == 1 && math.IsInf(h.Buckets[0], -1) && math.IsInf(h.Buckets[1], +1) {
+		// Our (single) bucket boundary is [-Inf, +Inf), there's no
+		// information.
+		return 0.0
+	}
+
+	// (Step 1) Find the largest bucket whose left-bound cumulative count is <=
+	// rank, or for p=1, the highest bucket with a non-zero count. Both are
+	// found as the bucket preceding the first one that doesn't qualify; the
+	// lowest one always does, its left-bound cumulative count being zero.
+	rank := float64(total) * p
+	var i int
+	if p == 1.0 {
+		i = sort.Search(n, func(i int) bool { return s.sums[i] >= total }) - 1
+	} else {
+		i = sort.Search(n, func(i int) bool { return float64(s.sums[i]) > rank }) - 1
+	}
+	start, end := h.Buckets[i], h.Buckets[i+1]
+	if i == 0 && math.IsInf(h.Buckets[0], -1) { // -Inf
+		start = end
+	}
+	if i == n-1 && math.IsInf(h.Buckets[n], 1) { // +Inf
+		end = start
+	}
+
+	// (Steps 2 and 3) Interpolate the rank within the bucket.
+	subsetRank := rank - float64(s.sums[i])
+	subsetPercentile := subsetRank / float64(h.Counts[i])
+	return start + (end-start)*subsetPercentile
+}
@@ -0,0 +1,408 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package histogramutil
+
+import (
+	"math"
+	"math/rand"
+	"runtime/metrics"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClone(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3},
+		Buckets: []float64{math.Inf(-1), 0, 1, math.Inf(+1)},
+	}
+	cloned := Clone(h)
+	require.Equal(t, h, cloned)
+	h.Counts[0], h.Buckets[1] = 10, 0.5
+	require.Equal(t, []uint64{1, 2, 3}, cloned.Counts)
+	require.Equal(t, []float64{math.Inf(-1), 0, 1, math.Inf(+1)}, cloned.Buckets)
+}
+
+func TestTotal(t *testing.T) {
+	require.Zero(t, Total(&metrics.Float64Histogram{}))
+	require.Zero(t, Total(&metrics.Float64Histogram{Counts: []uint64{0, 0}, Buckets: []float64{0, 1, 2}}))
+	// The unbounded buckets are counted too.
+	require.Equal(t, uint64(6), Total(&metrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3},
+		Buckets: []float64{math.Inf(-1), 0, 1, math.Inf(+1)},
+	}))
+}
+
+// TestSubAdd verifies, over random histograms, that Sub undoes Add, that totals
+// add up, and that neither mutates its arguments.
+func TestSubAdd(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	for i := 0; i < 1000; i++ {
+		a := randHistogram(rng)
+		b := randCounts(rng, a)
+		origA, origB := Clone(a), Clone(b)
+
+		sum := Add(a, b)
+		require.Equal(t, Total(a)+Total(b), Total(sum))
+		require.Equal(t, a.Buckets, sum.Buckets)
+		require.Equal(t, a, Sub(sum, b))
+		require.Equal(t, b, Sub(sum, a))
+		require.Zero(t, Total(Sub(a, a)))
+		require.Equal(t, origA, a)
+		require.Equal(t, origB, b)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	inf := math.Inf(+1)
+	ps := []float64{0, 0.5, 0.99, 1}
+	for _, tc := range []struct {
+		name    string
+		counts  []uint64
+		buckets []float64
+		exp     []float64 // at ps; nil if the histogram is empty
+	}{
+		{name: "no-buckets"},
+		{
+			name:    "empty",
+			counts:  []uint64{0, 0, 0},
+			buckets: []float64{0, 10, 20, 30},
+		},
+		{
+			name:    "single",
+			counts:  []uint64{100},
+			buckets: []float64{10, 20},
+			exp:     []float64{10, 15, 19.9, 20},
+		},
+		{
+			name:    "single-non-empty",
+			counts:  []uint64{0, 100, 0},
+			buckets: []float64{0, 10, 20, 30},
+			exp:     []float64{10, 15, 19.9, 20},
+		},
+		{
+			// Unbounded buckets aren't interpolated within.
+			name:    "single-unbounded-below",
+			counts:  []uint64{100},
+			buckets: []float64{-inf, 10},
+			exp:     []float64{10, 10, 10, 10},
+		},
+		{
+			name:    "single-unbounded-above",
+			counts:  []uint64{100},
+			buckets: []float64{10, inf},
+			exp:     []float64{10, 10, 10, 10},
+		},
+		{
+			// A single unbounded bucket has no information.
+			name:    "single-unbounded",
+			counts:  []uint64{100},
+			buckets: []float64{-inf, inf},
+			exp:     []float64{0, 0, 0, 0},
+		},
+		{
+			name:    "full",
+			counts:  []uint64{10, 20, 30, 40},
+			buckets: []float64{0, 10, 20, 30, 40},
+			exp:     []float64{0, 20 + 10*20.0/30, 30 + 10*39.0/40, 40},
+		},
+		{
+			name:    "full-unbounded",
+			counts:  []uint64{10, 20, 30, 40},
+			buckets: []float64{-inf, 10, 20, 30, inf},
+			exp:     []float64{10, 20 + 10*20.0/30, 30, 30},
+		},
+		{
+			// Empty buckets at either end don't count towards the extremes.
+			name:    "sparse",
+			counts:  []uint64{0, 0, 50, 0, 50, 0},
+			buckets: []float64{0, 10, 20, 30, 40, 50, 60},
+			exp:     []float64{20, 40, 49.8, 50},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &metrics.Float64Histogram{Counts: tc.counts, Buckets: tc.buckets}
+			batch, ok := Percentiles(h, ps)
+			require.Equal(t, tc.exp != nil, ok)
+			sums := NewPrefixSums(h)
+			searched, ok := sums.Percentiles(ps)
+			require.Equal(t, tc.exp != nil, ok)
+			require.Equal(t, batch, searched)
+			for i, p := range ps {
+				v, ok := Percentile(h, p)
+				require.Equal(t, tc.exp != nil, ok, "p=%f", p)
+				require.Equal(t, v, batch[i], "p=%f", p)
+				sv, ok := sums.Percentile(p)
+				require.Equal(t, tc.exp != nil, ok, "p=%f", p)
+				require.Equal(t, v, sv, "p=%f", p)
+				if tc.exp == nil {
+					require.Zero(t, v, "p=%f", p)
+					continue
+				}
+				require.InDelta(t, tc.exp[i], v, 1e-9, "p=%f", p)
+			}
+		})
+	}
+}
+
+// TestPercentileProperties verifies, over random histograms, that percentiles
+// are non-decreasing in p, lie within the histogram's finite bounds, and that
+// Percentiles agrees with Percentile.
+func TestPercentileProperties(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	ps := make([]float64, 0, 102)
+	for i := 0; i <= 100; i++ {
+		ps = append(ps, float64(i)/100)
+	}
+	ps = append(ps, 0.999)
+
+	for i := 0; i < 1000; i++ {
+		h := randHistogram(rng)
+		if Total(h) == 0 {
+			h.Counts[rng.Intn(len(h.Counts))]++ // percentiles need a non-empty histogram
+		}
+		lo, hi := finiteBounds(h)
+
+		batch, ok := Percentiles(h, ps)
+		require.True(t, ok)
+		prev := math.Inf(-1)
+		for j, p := range ps[:101] {
+			v, ok := Percentile(h, p)
+			require.True(t, ok)
+			require.Equal(t, v, batch[j], "p=%f h=%v", p, h)
+			require.GreaterOrEqual(t, v, lo, "p=%f h=%v", p, h)
+			require.LessOrEqual(t, v, hi, "p=%f h=%v", p, h)
+			// Allow for floating point error where one bucket ends and the next
+			// one starts.
+			require.GreaterOrEqual(t, v, prev-1e-9, "p=%f h=%v", p, h)
+			prev = v
+		}
+		v, _ := Percentile(h, 0.999)
+		require.Equal(t, v, batch[len(batch)-1])
+	}
+}
+
+// TestPrefixSumsProperties verifies, over random histograms (empty ones
+// included), that percentiles queried from their prefix sums are identical to
+// those computed by walking the buckets.
+func TestPrefixSumsProperties(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	ps := make([]float64, 0, 104)
+	for i := 0; i <= 100; i++ {
+		ps = append(ps, float64(i)/100)
+	}
+	ps = append(ps, 0.999, 0.9999, rng.Float64())
+
+	for i := 0; i < 1000; i++ {
+		h := randHistogram(rng)
+		sums := NewPrefixSums(h)
+		require.Equal(t, Total(h), sums.Total())
+		require.Same(t, h, sums.Histogram())
+
+		batch, batchOK := Percentiles(h, ps)
+		searched, ok := sums.Percentiles(ps)
+		require.Equal(t, batchOK, ok)
+		require.Equal(t, batch, searched, "h=%v", h)
+		for _, p := range ps {
+			exp, expOK := Percentile(h, p)
+			v, ok := sums.Percentile(p)
+			require.Equal(t, expOK, ok, "p=%f h=%v", p, h)
+			require.Equal(t, exp, v, "p=%f h=%v", p, h)
+		}
+	}
+}
+
+// TestStdDev verifies the standard deviation approximated over synthetic
+// distributions whose true standard deviation is known.
+func TestStdDev(t *testing.T) {
+	inf := math.Inf(+1)
+	for _, tc := range []struct {
+		name    string
+		counts  []uint64
+		buckets []float64
+		exp     float64
+		expOK   bool
+	}{
+		{name: "no-buckets"},
+		{name: "empty", counts: []uint64{0, 0}, buckets: []float64{0, 1, 2}},
+		{
+			// A single bucket has no spread to speak of.
+			name:    "single",
+			counts:  []uint64{0, 100, 0},
+			buckets: []float64{0, 1, 2, 3},
+			exp:     0,
+			expOK:   true,
+		},
+		{
+			// Two equally likely values, at the bucket midpoints 1 and 3.
+			name:    "two-points",
+			counts:  []uint64{50, 50},
+			buckets: []float64{0, 2, 4},
+			exp:     1,
+			expOK:   true,
+		},
+		{
+			// Mostly calm, with a tenth spiking: 1 and 10, with probabilities
+			// 0.9 and 0.1, have a standard deviation of 9*sqrt(0.9*0.1).
+			name:    "calm-and-spike",
+			counts:  []uint64{90, 0, 10},
+			buckets: []float64{0.5, 1.5, 9.5, 10.5},
+			exp:     9 * math.Sqrt(0.9*0.1),
+			expOK:   true,
+		},
+		{
+			// The unbounded buckets are excluded, as if they were empty.
+			name:    "unbounded",
+			counts:  []uint64{1000, 50, 50, 1000},
+			buckets: []float64{-inf, 0, 2, 4, inf},
+			exp:     1,
+			expOK:   true,
+		},
+		{
+			name:    "only-unbounded",
+			counts:  []uint64{10, 0, 10},
+			buckets: []float64{-inf, 0, 1, inf},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &metrics.Float64Histogram{Counts: tc.counts, Buckets: tc.buckets}
+			v, ok := StdDev(h)
+			require.Equal(t, tc.expOK, ok)
+			require.InDelta(t, tc.exp, v, 1e-9)
+		})
+	}
+
+	// A uniform distribution over [0, 1) has a standard deviation of
+	// 1/sqrt(12); the midpoints of n equal buckets underestimate its variance
+	// by 1/(12n²).
+	const n = 1000
+	h := &metrics.Float64Histogram{Counts: make([]uint64, n), Buckets: make([]float64, n+1)}
+	for i := range h.Counts {
+		h.Counts[i] = 7
+		h.Buckets[i+1] = float64(i+1) / n
+	}
+	v, ok := StdDev(h)
+	require.True(t, ok)
+	require.InDelta(t, math.Sqrt(1.0/12-1.0/(12*n*n)), v, 1e-9)
+	require.InDelta(t, 1/math.Sqrt(12), v, 0.5/n)
+
+	// Over normally distributed values, it's off from their true standard
+	// deviation by at most half the width of a bucket.
+	rng, _ := randutil.NewTestRand()
+	const width = 0.25
+	h = &metrics.Float64Histogram{Counts: make([]uint64, 82), Buckets: make([]float64, 83)}
+	for i := range h.Buckets {
+		h.Buckets[i] = float64(i-1) * width
+	}
+	h.Buckets[0], h.Buckets[len(h.Buckets)-1] = math.Inf(-1), math.Inf(+1)
+	var values []float64
+	for i := 0; i < 100000; i++ {
+		x := 10 + 2*rng.NormFloat64()
+		j := sort.SearchFloat64s(h.Buckets, x)
+		if x != h.Buckets[j] {
+			j-- // x is within [h.Buckets[j], h.Buckets[j+1])
+		}
+		h.Counts[j]++
+		if j > 0 && j < len(h.Counts)-1 {
+			values = append(values, x) // not in an unbounded bucket
+		}
+	}
+	var mean, variance float64
+	for _, x := range values {
+		mean += x / float64(len(values))
+	}
+	for _, x := range values {
+		variance += (x - mean) * (x - mean) / float64(len(values))
+	}
+	v, ok = StdDev(h)
+	require.True(t, ok)
+	require.InDelta(t, math.Sqrt(variance), v, width/2)
+	require.InDelta(t, 2, v, 0.1)
+}
+
+// BenchmarkPercentiles compares computing four percentiles by walking the
+// buckets of a histogram with as many as the runtime's scheduler latency
+// histogram, against querying them from its prefix sums, with and without
+// computing the prefix sums first.
+func BenchmarkPercentiles(b *testing.B) {
+	rng, _ := randutil.NewTestRand()
+	h := &metrics.Float64Histogram{Counts: make([]uint64, 720), Buckets: make([]float64, 721)}
+	for i := range h.Buckets {
+		h.Buckets[i] = float64(i)
+	}
+	h.Buckets[0], h.Buckets[720] = math.Inf(-1), math.Inf(+1)
+	h = randCounts(rng, h)
+	ps := []float64{0.50, 0.90, 0.99, 0.999}
+
+	b.Run("walk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Percentiles(h, ps)
+		}
+	})
+	b.Run("prefix-sums", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewPrefixSums(h).Percentiles(ps)
+		}
+	})
+	b.Run("prefix-sums-cached", func(b *testing.B) {
+		sums := NewPrefixSums(h)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			sums.Percentiles(ps)
+		}
+	})
+}
+
+// randHistogram returns a histogram with random counts, some of them zero, and
+// random increasing bucket boundaries, possibly unbounded at either end.
+func randHistogram(rng *rand.Rand) *metrics.Float64Histogram {
+	n := 1 + rng.Intn(20)
+	h := &metrics.Float64Histogram{Counts: make([]uint64, n), Buckets: make([]float64, n+1)}
+	b := rng.Float64() * 100
+	for i := range h.Buckets {
+		h.Buckets[i] = b
+		b += 0.1 + rng.Float64()*10
+	}
+	if n > 1 && rng.Intn(2) == 0 {
+		h.Buckets[0] = math.Inf(-1)
+	}
+	if n > 1 && rng.Intn(2) == 0 {
+		h.Buckets[n] = math.Inf(+1)
+	}
+	return randCounts(rng, h)
+}
+
+// randCounts returns a histogram with the same layout as the given one, and
+// random counts.
+func randCounts(rng *rand.Rand, layout *metrics.Float64Histogram) *metrics.Float64Histogram {
+	h := Clone(layout)
+	for i := range h.Counts {
+		if rng.Intn(3) != 0 { // leave some buckets empty
+			h.Counts[i] = uint64(rng.Intn(1000))
+		}
+	}
+	return h
+}
+
+// finiteBounds returns the lowest and highest finite bucket boundaries of the
+// given histogram.
+func finiteBounds(h *metrics.Float64Histogram) (lo, hi float64) {
+	lo, hi = h.Buckets[0], h.Buckets[len(h.Buckets)-1]
+	if math.IsInf(lo, -1) {
+		lo = h.Buckets[1]
+	}
+	if math.IsInf(hi, +1) {
+		hi = h.Buckets[len(h.Buckets)-2]
+	}
+	return lo, hi
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+)
+
+// SamplerState is the state of a sampler's ring buffer, as returned by
+// InspectState for tests.
+type SamplerState struct {
+	// Retained is the number of cumulative samples retained.
+	Retained int
+	// Timestamps are those of the samples retained, oldest first.
+	Timestamps []time.Time
+	// Capacity is the number of samples the ring buffer is sized to retain:
+	// the number of sample periods spanned by the longest window computed (the
+	// sampler's, or a listener's own). It's resized on the tick following a
+	// change in the period, the duration, or the listeners.
+	Capacity int
+	// WindowSamples is the number of sample periods spanned by the sampler's
+	// window once full.
+	WindowSamples int
+	// WindowStart and WindowEnd are the bounds of the sampler's latest full
+	// window, the timestamps of its oldest and latest samples (the former
+	// having been evicted since, if the ring buffer is full). They're zero if
+	// there's none over the samples retained, the ring buffer being yet to
+	// fill up since the sampler started or last re-baselined.
+	WindowStart, WindowEnd time.Time
+}
+
+// InspectState returns the state of the running sampler's ring buffer. It's
+// meant for tests of consumers, to observe the windowing (when the window fills
+// up, what it spans, that it's re-baselined) without reaching into the
+// sampler. It returns false if there's no sampler running.
+//
+// It's only available in test builds (those using the crdb_test build tag,
+// which Bazel test targets do); otherwise, it returns the zero value, and
+// false. Tests relying on it should skip otherwise:
+//
+//	if !buildutil.CrdbTestBuild {
+//		skip.IgnoreLint(t, "requires a crdb_test build")
+//	}
+func InspectState() (SamplerState, bool) {
+	if !buildutil.CrdbTestBuild {
+		return SamplerState{}, false
+	}
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return SamplerState{}, false
+	}
+	return s.inspectState(), true
+}
+
+// InspectState returns the state of the sampler's ring buffer; see the
+// package-level InspectState. It returns false outside of test builds.
+func (s *Sampler) InspectState() (SamplerState, bool) {
+	if !buildutil.CrdbTestBuild {
+		return SamplerState{}, false
+	}
+	return s.s.inspectState(), true
+}
+
+// inspectState returns the state of the sampler's ring buffer, regardless of
+// the build.
+func (s *sampler) inspectState() SamplerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	retained := s.mu.ringBuffer.Len()
+	state := SamplerState{
+		Retained:      retained,
+		Capacity:      s.mu.ringBuffer.Cap(),
+		WindowSamples: s.mu.windowSamples,
+	}
+	if retained == 0 {
+		return state
+	}
+	state.Timestamps = make([]time.Time, retained)
+	for i := 0; i < retained; i++ {
+		state.Timestamps[retained-1-i] = s.mu.ringBuffer.Get(i).at
+	}
+	// The latest full window is retained across re-baselining; it's only over
+	// the samples retained if it ends at or after the oldest.
+	if w := s.mu.lastWindow; !w.at.IsZero() && !w.at.Before(state.Timestamps[0]) {
+		state.WindowStart, state.WindowEnd = w.at.Add(-w.elapsed), w.at
+	}
+	return state
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestInspectState verifies that the state of the ring buffer is exposed as
+// the window fills up, is full, and is re-baselined, and that it's only
+// exposed in test builds.
+func TestInspectState(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 3*time.Hour)
+
+	_, ok := InspectState()
+	require.False(t, ok) // there's no sampler running
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	s.sample = busySample()
+	var listener sampleListener
+	s.addListener(&listener)
+
+	state, ok := InspectState()
+	require.Equal(t, buildutil.CrdbTestBuild, ok)
+	if !buildutil.CrdbTestBuild {
+		// Outside of test builds, the state isn't exposed.
+		require.Zero(t, state)
+		return
+	}
+	require.Equal(t, SamplerState{Capacity: 3, WindowSamples: 3}, state)
+
+	var timestamps []time.Time
+	tick := func() SamplerState {
+		t.Helper()
+		clock.Advance(time.Hour)
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+		timestamps = append(timestamps, clock.Now())
+		state, ok := InspectState()
+		require.True(t, ok)
+		return state
+	}
+	// While the window fills up, there's no full window.
+	for i := 1; i <= 3; i++ {
+		state := tick()
+		require.Equal(t, SamplerState{
+			Retained:      i,
+			Timestamps:    timestamps,
+			Capacity:      3,
+			WindowSamples: 3,
+		}, state)
+	}
+	require.Empty(t, listener.samples)
+
+	// Once full, the oldest sample is evicted as the latest is recorded, and
+	// the window spans both.
+	for i := 0; i < 2; i++ {
+		state := tick()
+		require.Equal(t, timestamps[len(timestamps)-3:], state.Timestamps)
+		require.Equal(t, timestamps[len(timestamps)-4], state.WindowStart)
+		require.Equal(t, clock.Now(), state.WindowEnd)
+		sample := listener.samples[len(listener.samples)-1]
+		require.Equal(t, sample.Elapsed, state.WindowEnd.Sub(state.WindowStart))
+	}
+
+	// Re-baselining discards the samples, and the window.
+	ResetWindow()
+	state = tick()
+	require.Equal(t, []time.Time{clock.Now()}, state.Timestamps)
+	require.Zero(t, state.WindowStart)
+	require.Zero(t, state.WindowEnd)
+
+	// As does lengthening the duration, which resizes the ring buffer.
+	s.setPeriodAndDuration(time.Hour, 4*time.Hour)
+	state = tick()
+	require.Equal(t, 1, state.Retained)
+	require.Equal(t, 4, state.Capacity)
+	require.Equal(t, 4, state.WindowSamples)
+
+	// Standalone samplers expose theirs too.
+	standalone, err := NewSampler(SamplerOptions{Period: time.Second, Duration: 2 * time.Second})
+	require.NoError(t, err)
+	state, ok = standalone.InspectState()
+	require.True(t, ok)
+	require.Equal(t, SamplerState{Capacity: 2, WindowSamples: 2}, state)
+}
@@ -0,0 +1,33 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import "time"
+
+// latencyRatio returns the window's Sample.LatencyRatio.
+func (w window) latencyRatio() float64 {
+	if w.idle {
+		return 0
+	}
+	return latencyRatio(w.p99, w.events, w.elapsed, w.gomaxprocs)
+}
+
+// latencyRatio normalizes the given p99 scheduler latency, observed over the
+// given number of scheduling events and elapsed time with the given
+// GOMAXPROCS, as p99 * (events / elapsed) / gomaxprocs: the tail of the run
+// queue length per P, as per Little's law (see Sample.LatencyRatio). It's zero
+// if it can't be computed.
+func latencyRatio(p99 time.Duration, events uint64, elapsed time.Duration, gomaxprocs int) float64 {
+	if elapsed <= 0 || gomaxprocs <= 0 {
+		return 0
+	}
+	return p99.Seconds() * eventsPerSecond(events, elapsed) / float64(gomaxprocs)
+}
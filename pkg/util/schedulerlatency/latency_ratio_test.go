@@ -0,0 +1,106 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLatencyRatio verifies the latency ratio computed over windows of
+// synthetic histograms.
+func TestLatencyRatio(t *testing.T) {
+	start := timeutil.Unix(0, 0)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	sample := func(fast, slow uint64, at time.Duration, gomaxprocs int) runtimeSample {
+		return runtimeSample{
+			latencies: &metrics.Float64Histogram{
+				Counts:  []uint64{fast, slow},
+				Buckets: []float64{0, 0.001, 0.002},
+			},
+			at:         start.Add(at),
+			gomaxprocs: gomaxprocs,
+		}
+	}
+	for _, tc := range []struct {
+		name       string
+		fast, slow uint64 // events observed over the window, in either bucket
+		elapsed    time.Duration
+		gomaxprocs int
+		minEvents  uint64
+		expP99     time.Duration
+		expRatio   float64
+	}{
+		// 10% of the events are slow, for a p99 of 1.9ms; 100 events/s.
+		{name: "one P", fast: 90, slow: 10, elapsed: time.Second, gomaxprocs: 1,
+			expP99: 1900 * time.Microsecond, expRatio: 0.19},
+		// The ratio is shared among the Ps.
+		{name: "two Ps", fast: 90, slow: 10, elapsed: time.Second, gomaxprocs: 2,
+			expP99: 1900 * time.Microsecond, expRatio: 0.095},
+		// It scales with the scheduling rate...
+		{name: "busier", fast: 9000, slow: 1000, elapsed: time.Second, gomaxprocs: 2,
+			expP99: 1900 * time.Microsecond, expRatio: 9.5},
+		// ...which is computed over the time actually elapsed.
+		{name: "gapped", fast: 9000, slow: 1000, elapsed: 2 * time.Second, gomaxprocs: 2,
+			expP99: 1900 * time.Microsecond, expRatio: 4.75},
+		// And with the p99: here, every event is fast, for a p99 of 0.99ms.
+		{name: "fast", fast: 100, elapsed: time.Second, gomaxprocs: 1,
+			expP99: 990 * time.Microsecond, expRatio: 0.099},
+		// It's zero if GOMAXPROCS is unknown, or if the window is idle.
+		{name: "unknown GOMAXPROCS", fast: 90, slow: 10, elapsed: time.Second,
+			expP99: 1900 * time.Microsecond},
+		{name: "idle", fast: 90, slow: 10, elapsed: time.Second, gomaxprocs: 1, minEvents: 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldest := sample(0, 0, 0, tc.gomaxprocs)
+			latest := sample(tc.fast, tc.slow, tc.elapsed, tc.gomaxprocs)
+			w, _, ok := computeWindow(latest, oldest, 1 /* samples */, time.Second, tc.minEvents)
+			require.True(t, ok)
+			require.Equal(t, tc.expP99, w.p99)
+			require.InDelta(t, tc.expRatio, w.latencyRatio(), 1e-9)
+		})
+	}
+}
+
+// TestLatencyRatioDelivered verifies that the latency ratio, and the values
+// it's computed from, are delivered to listeners.
+func TestLatencyRatioDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		return runtimeSample{latencies: clone(cumulative), gomaxprocs: 4}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+
+	for i := 0; i < 2; i++ {
+		s.tick(ctx)
+	}
+	require.Len(t, listener.samples, 1)
+	sample := listener.samples[0]
+	require.Equal(t, 1900*time.Microsecond, sample.P99)
+	require.Equal(t, uint64(100), sample.Events)
+	require.Equal(t, time.Second, sample.Elapsed)
+	require.Equal(t, 4, sample.GOMAXPROCS)
+	require.InDelta(t, 0.0475, sample.LatencyRatio, 1e-9)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SampleSnapshot is a set of scheduler latency percentiles computed over a
+// window.
+type SampleSnapshot struct {
+	P50, P90, P99, P999 time.Duration
+	// Max is the upper bound of the highest non-empty bucket of the window's
+	// histogram, like Quantiles.Max. Like the percentiles, it's zero if the
+	// window is idle.
+	Max time.Duration
+	// P99RollingMax is the maximum P99 over the trailing horizon; see
+	// Sample.P99RollingMax.
+	P99RollingMax time.Duration
+	// StdDev is the approximate standard deviation of the scheduler latency
+	// over the window; see Sample.StdDev.
+	StdDev time.Duration
+	// At is when the latest sample in the window was taken. Readers can use it
+	// to detect stale snapshots, such as when the sampler is starved.
+	At time.Time
+	// Elapsed is the time elapsed over the window; see Sample.Elapsed.
+	Elapsed time.Duration
+	// Idle is set if the window observed too few scheduling events for the
+	// percentiles to be computed, in which case they're zero; see Sample.Idle.
+	Idle bool
+	// Gapped is set if the window's ticks were delayed far beyond the sample
+	// period; see Sample.Gapped.
+	Gapped bool
+	// EventsPerSecond is the rate of scheduling events over the window; see
+	// Sample.EventsPerSecond.
+	EventsPerSecond float64
+	// GOMAXPROCS is the value of GOMAXPROCS when the latest sample in the window
+	// was taken, or zero if unknown. The sampler re-baselines when it changes,
+	// so the window never spans a change.
+	GOMAXPROCS int
+	// Period is the sample period in effect (see Sample.Period), derived from
+	// GOMAXPROCS if scheduler_latency.sample_period is zero.
+	Period time.Duration
+	// Window is the configuration of the window; see Sample.Window.
+	Window WindowConfig
+	// Reconfigured is set if the sample period or duration in effect changed
+	// since the window, the sampler being yet to compute a full one of the new
+	// configuration (see Sample.Reconfigured): the snapshot is of the previous
+	// configuration, as per Window, until it has.
+	Reconfigured bool
+	// Overloaded is the state of the overload signal as of the window; see
+	// RegisterOverloadCallback. It's false if the signal is disabled.
+	Overloaded bool
+	// Anomalies counts the sampler's anomalies (re-baselines, clamped settings,
+	// skipped ticks and empty windows) since it started, as of the window.
+	Anomalies SamplerAnomalies
+	// Degraded is set if the runtime doesn't export the scheduler latency
+	// histogram, in which case nothing is sampled, and the snapshot is
+	// otherwise empty.
+	Degraded bool
+}
+
+// latest is the most recently computed snapshot, or nil if the sampler hasn't
+// observed a full window yet.
+var latest atomic.Pointer[SampleSnapshot]
+
+// Latest returns the most recently computed scheduler latency snapshot, for
+// consumers that want to read the current value when they happen to run
+// instead of registering a callback. It returns false if the sampler isn't
+// running or hasn't observed a full window yet, including while it's paused,
+// having no consumers (readers of Latest don't count), and if it's degraded,
+// in which case the snapshot returned has Degraded set.
+func Latest() (SampleSnapshot, bool) {
+	snap := latest.Load()
+	if snap == nil {
+		return SampleSnapshot{}, false
+	}
+	return *snap, !snap.Degraded
+}
+
+// PercentileNow computes the given percentile, p in (0, 1], of the scheduler
+// latency over the most recent full window, for diagnostics that want one the
+// sampler doesn't otherwise compute (say, the p99.99 when investigating extreme
+// tails). It's computed on demand from the window's interval histogram, without
+// affecting what callbacks are delivered, and even over idle windows. It
+// returns false if p is out of range, if the sampler isn't running or hasn't
+// observed a full window yet, or if the window observed no events at all.
+func PercentileNow(p float64) (time.Duration, bool) {
+	if !(p > 0 && p <= 1) { // rejects NaNs too
+		return 0, false
+	}
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return 0, false
+	}
+	return s.percentileNow(p)
+}
+
+func (s *sampler) percentileNow(p float64) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.lastInterval == nil {
+		return 0, false
+	}
+	v, ok := s.mu.lastInterval.Percentile(p)
+	if !ok {
+		return 0, false
+	}
+	return SecondsToDuration(v), true
+}
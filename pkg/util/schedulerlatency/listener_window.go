@@ -0,0 +1,122 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+)
+
+// listenerWindowMaxDuration caps the window durations requested by listeners
+// through WithWindowDuration. The sampler retains samples for the longest
+// window requested, so this bounds its memory use.
+var listenerWindowMaxDuration = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.listener_window.max_duration",
+	"the maximum duration of the windows scheduler latency listeners can request, "+
+		"instead of scheduler_latency.sample_duration",
+	time.Minute,
+	settings.PositiveDuration,
+)
+
+// listenerWindow is the window of a listener that requested its own; see
+// WithWindowDuration.
+type listenerWindow struct {
+	// duration is the duration requested; it's zero if the listener is
+	// delivered the sampler's own window.
+	duration time.Duration
+	// latest is the window computed on the most recent tick, if ok.
+	latest window
+	ok     bool
+}
+
+// listenerSamplesLocked returns the number of sample periods spanned by a
+// listener's window of the given requested duration: the duration rounded to
+// the nearest multiple of the period, capped by
+// scheduler_latency.listener_window.max_duration and maxWindowSamples, and
+// spanning at least minSamplesPerWindow periods.
+func (s *sampler) listenerSamplesLocked(duration time.Duration) int {
+	period := s.mu.period
+	samples := int((duration + period/2) / period)
+	if max := int(listenerWindowMaxDuration.Get(&s.mu.st.SV) / period); samples > max {
+		samples = max
+	}
+	if samples > maxWindowSamples {
+		samples = maxWindowSamples
+	}
+	if samples < minSamplesPerWindow {
+		samples = minSamplesPerWindow
+	}
+	return samples
+}
+
+// sizeRingLocked sizes the ring buffer for the longest window computed: the
+// sampler's own, or that of a listener that requested a longer one. Shrinking
+// it discards the oldest samples, beyond the longest window.
+func (s *sampler) sizeRingLocked() {
+	samples := s.mu.windowSamples
+	for _, l := range s.mu.listeners {
+		if l.window.duration == 0 {
+			continue
+		}
+		if n := s.listenerSamplesLocked(l.window.duration); n > samples {
+			samples = n
+		}
+	}
+	if samples == s.mu.ringBuffer.Cap() {
+		return
+	}
+	for s.mu.ringBuffer.Len() > samples {
+		// The most recent sample is always retained, so the histograms
+		// discarded aren't referenced as s.mu.latestCumulative.
+		s.mu.histograms.release(s.mu.ringBuffer.GetLast().latencies)
+		s.mu.ringBuffer.RemoveLast()
+	}
+	s.mu.ringBuffer.Resize(samples)
+}
+
+// computeListenerWindowsLocked computes the windows of the listeners that
+// requested their own, ending at the latest cumulative sample. It must be
+// called before the sample is recorded.
+func (s *sampler) computeListenerWindowsLocked(
+	latestCumulative runtimeSample, period time.Duration, minEvents uint64, gapFactor float64,
+) {
+	for i := range s.mu.listeners {
+		l := &s.mu.listeners[i]
+		if l.window.duration == 0 {
+			continue
+		}
+		l.window.latest, l.window.ok = window{}, false
+		if s.mu.ringBuffer.Len() == 0 {
+			continue
+		}
+		// Listener windows are rounded to a multiple of the period, which
+		// they span exactly.
+		samples := s.listenerSamplesLocked(l.window.duration)
+		l.window.latest, _, l.window.ok = s.windowLocked(latestCumulative,
+			samples, time.Duration(samples)*period, period, minEvents, gapFactor)
+	}
+}
+
+// withWindow returns the sample with the values computed over the given
+// window, for delivery to a listener that requested its own. The smoothed
+// values are retained: they're derived from the sampler's own windows.
+func (s Sample) withWindow(w window) Sample {
+	s.P99, s.StdDev, s.Events, s.At, s.Elapsed = w.p99, w.stddev, w.events, w.at, w.elapsed
+	s.Idle, s.Gapped, s.Provisional = w.idle, w.gapped, w.provisional
+	s.GOMAXPROCS, s.LatencyRatio, s.TailRatio = w.gomaxprocs, w.latencyRatio(), w.tailRatio()
+	s.EventsPerSecond, s.CPUUtilization = w.eventsPerSecond(), w.cpuUtilization
+	s.Window.Duration, s.Window.Samples = w.duration, w.samples
+	s.Reconfigured = w.reconfigured
+	s.Percentiles = nil // computed over the sampler's own window
+	return s
+}
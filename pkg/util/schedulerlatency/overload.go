@@ -0,0 +1,145 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base/serverident"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/eventpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+var overloadThreshold = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.overload.threshold",
+	"p99 scheduler latency above which the server is considered overloaded, if sustained for "+
+		"scheduler_latency.overload.min_duration (0 disables overload events)",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var overloadMinDuration = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.overload.min_duration",
+	"duration for which the p99 scheduler latency needs to be above "+
+		"scheduler_latency.overload.threshold for the server to be considered overloaded",
+	time.Minute,
+	settings.NonNegativeDuration,
+)
+
+// overloadTransition is a transition of the overloadDetector.
+type overloadTransition int
+
+const (
+	overloadUnchanged overloadTransition = iota
+	overloadStarted
+	overloadCleared
+)
+
+// overloadDetector detects sustained scheduler overload: the p99 being above a
+// threshold for at least a minimum duration.
+type overloadDetector struct {
+	since      time.Time // when the p99 exceeded the threshold; zero if it isn't above it
+	overloaded bool      // whether we've reported the ongoing overload
+}
+
+// observe is provided the p99 of every window and the time at which it was
+// computed. It returns the resulting transition, if any, and how long the p99
+// has been above the threshold.
+func (d *overloadDetector) observe(
+	p99 time.Duration, at time.Time, threshold, minDuration time.Duration,
+) (overloadTransition, time.Duration) {
+	if threshold == 0 || p99 <= threshold {
+		since, overloaded := d.since, d.overloaded
+		d.since, d.overloaded = time.Time{}, false
+		if !overloaded {
+			return overloadUnchanged, 0
+		}
+		return overloadCleared, at.Sub(since)
+	}
+	if d.since.IsZero() {
+		d.since = at
+	}
+	if d.overloaded || at.Sub(d.since) < minDuration {
+		return overloadUnchanged, 0
+	}
+	d.overloaded = true
+	return overloadStarted, at.Sub(d.since)
+}
+
+// overloadMonitor is a listener emitting structured events to the HEALTH
+// channel when the server's scheduler is persistently overloaded, and when the
+// condition clears. One is attached to every sampler, so it works even with no
+// other listeners; it uses the context and settings of the StartSampler caller
+// that created the sampler.
+type overloadMonitor struct {
+	ctx      context.Context
+	st       *cluster.Settings
+	emit     func(context.Context, logpb.Severity, logpb.EventPayload)
+	detector overloadDetector
+}
+
+var _ SampleObserver = &overloadMonitor{}
+
+func newOverloadMonitor(ctx context.Context, st *cluster.Settings) *overloadMonitor {
+	return &overloadMonitor{ctx: ctx, st: st, emit: log.StructuredEvent}
+}
+
+// SchedulerLatency implements the LatencyObserver interface.
+func (m *overloadMonitor) SchedulerLatency(p99 time.Duration, period time.Duration) {
+	m.SchedulerLatencySample(Sample{P99: p99, Period: period, At: timeutil.Now()})
+}
+
+// SchedulerLatencySample implements the SampleObserver interface.
+func (m *overloadMonitor) SchedulerLatencySample(s Sample) {
+	if s.Idle {
+		return // there's no p99 to go by
+	}
+	if s.Provisional || s.Final {
+		// The p99 is computed over a partial window, which could start or
+		// clear an overload spuriously.
+		return
+	}
+	transition, duration := m.detector.observe(s.P99, s.At,
+		overloadThreshold.Get(&m.st.SV), overloadMinDuration.Get(&m.st.SV))
+	switch transition {
+	case overloadStarted:
+		m.emit(m.ctx, severity.WARNING, &eventpb.GoSchedulerOverload{
+			NodeID:        nodeIDFromContext(m.ctx),
+			LatencyNanos:  s.P99.Nanoseconds(),
+			DurationNanos: duration.Nanoseconds(),
+		})
+	case overloadCleared:
+		m.emit(m.ctx, severity.INFO, &eventpb.GoSchedulerOverloadCleared{
+			NodeID:        nodeIDFromContext(m.ctx),
+			LatencyNanos:  s.P99.Nanoseconds(),
+			DurationNanos: duration.Nanoseconds(),
+		})
+	}
+}
+
+// nodeIDFromContext returns the node ID of the server identified in the given
+// context, or zero if there isn't one.
+func nodeIDFromContext(ctx context.Context) int32 {
+	nodeID, err := strconv.ParseInt(serverident.GetIdentificationPayload(ctx).NodeID, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(nodeID)
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+)
+
+var overloadSignalOnThreshold = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.overload_signal.on_threshold",
+	"p99 scheduler latency above which the overload signal turns on, if sustained for "+
+		"scheduler_latency.overload_signal.min_on_duration (0 disables the signal)",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var overloadSignalOffThreshold = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.overload_signal.off_threshold",
+	"p99 scheduler latency at or below which the overload signal turns off, if sustained for "+
+		"scheduler_latency.overload_signal.min_off_duration (0, or a value above "+
+		"scheduler_latency.overload_signal.on_threshold, uses the on threshold)",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var overloadSignalMinOnDuration = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.overload_signal.min_on_duration",
+	"duration for which the p99 scheduler latency needs to be above "+
+		"scheduler_latency.overload_signal.on_threshold for the overload signal to turn on",
+	10*time.Second,
+	settings.NonNegativeDuration,
+)
+
+var overloadSignalMinOffDuration = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.overload_signal.min_off_duration",
+	"duration for which the p99 scheduler latency needs to be at or below "+
+		"scheduler_latency.overload_signal.off_threshold for the overload signal to turn off",
+	30*time.Second,
+	settings.NonNegativeDuration,
+)
+
+// OverloadCallback is provided the state of the overload signal whenever it
+// transitions: true when the scheduler becomes overloaded, false when it no
+// longer is.
+type OverloadCallback func(overloaded bool)
+
+// RegisterOverloadCallback registers a callback to be run whenever the overload
+// signal transitions. The signal is computed every tick from the p99 over the
+// most recent window, with hysteresis: it turns on once the p99 is above
+// scheduler_latency.overload_signal.on_threshold for
+// scheduler_latency.overload_signal.min_on_duration, and back off once it's at
+// or below scheduler_latency.overload_signal.off_threshold for
+// scheduler_latency.overload_signal.min_off_duration. The callback isn't told
+// the state as of registering it; that's available through Latest.
+func RegisterOverloadCallback(cb OverloadCallback) (id int64) {
+	return overloadCallbacks.register(cb, 0 /* minInterval */)
+}
+
+// UnregisterOverloadCallback unregisters a callback registered through
+// RegisterOverloadCallback. Like UnregisterMutexWaitCallback, it mustn't be
+// called from within the callback itself.
+func UnregisterOverloadCallback(id int64) {
+	overloadCallbacks.unregister(id)
+}
+
+var overloadCallbacks = callbackRegistry[OverloadCallback]{kind: "overload"}
+
+// overloadSignalConfig is the configuration of the overloadSignal, derived from
+// the cluster settings.
+type overloadSignalConfig struct {
+	onThreshold, offThreshold     time.Duration
+	minOnDuration, minOffDuration time.Duration
+}
+
+// makeOverloadSignalConfig reads the overload signal's configuration off the
+// given settings. The off threshold is capped at the on one: a higher one
+// would have the signal cycle on every window.
+func makeOverloadSignalConfig(sv *settings.Values) overloadSignalConfig {
+	c := overloadSignalConfig{
+		onThreshold:    overloadSignalOnThreshold.Get(sv),
+		offThreshold:   overloadSignalOffThreshold.Get(sv),
+		minOnDuration:  overloadSignalMinOnDuration.Get(sv),
+		minOffDuration: overloadSignalMinOffDuration.Get(sv),
+	}
+	if c.offThreshold == 0 || c.offThreshold > c.onThreshold {
+		c.offThreshold = c.onThreshold
+	}
+	return c
+}
+
+// overloadSignal is a binary overload signal with hysteresis: unlike the
+// overloadDetector, which clears as soon as the p99 drops below its threshold,
+// it turns off only once the p99 has been at or below a (lower) off threshold
+// for a minimum duration, so that a p99 hovering around a single threshold
+// doesn't have it flap.
+type overloadSignal struct {
+	overloaded bool
+	// since is when the p99 first crossed the threshold that'd transition the
+	// signal, in the ongoing streak of windows crossing it; zero if the latest
+	// window didn't.
+	since time.Time
+}
+
+// observe is provided the p99 of every (non-idle) window and the time at which
+// it was computed. It returns true if the signal transitioned.
+func (o *overloadSignal) observe(p99 time.Duration, at time.Time, c overloadSignalConfig) bool {
+	if c.onThreshold == 0 {
+		// Disabled; turn off right away, if on.
+		o.since = time.Time{}
+		return o.set(false)
+	}
+	crossing, minDuration := p99 > c.onThreshold, c.minOnDuration
+	if o.overloaded {
+		crossing, minDuration = p99 <= c.offThreshold, c.minOffDuration
+	}
+	if !crossing {
+		o.since = time.Time{}
+		return false
+	}
+	if o.since.IsZero() {
+		o.since = at
+	}
+	if at.Sub(o.since) < minDuration {
+		return false
+	}
+	o.since = time.Time{}
+	return o.set(!o.overloaded)
+}
+
+// set sets the signal, returning true if it changed.
+func (o *overloadSignal) set(overloaded bool) bool {
+	changed := o.overloaded != overloaded
+	o.overloaded = overloaded
+	return changed
+}
+
+// reset turns the signal off, returning true if it was on.
+func (o *overloadSignal) reset() bool {
+	o.since = time.Time{}
+	return o.set(false)
+}
+
+// invokeOverloadCallbacksLocked invokes the overload callbacks with the given
+// state of the overload signal, having just transitioned to it.
+func (s *sampler) invokeOverloadCallbacksLocked(ctx context.Context, overloaded bool) {
+	// The overload callbacks are registered without a minimum interval, so
+	// they're delivered every transition regardless of the time given.
+	overloadCallbacks.deliverLocked(ctx, s, time.Time{}, func(f OverloadCallback) { f(overloaded) })
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOverloadSignal verifies the overload signal's hysteresis over scripted
+// sequences of p99s, one a second, that would have a naive threshold (the on
+// threshold, without dwell times) flap.
+func TestOverloadSignal(t *testing.T) {
+	config := overloadSignalConfig{
+		onThreshold:    10 * time.Millisecond,
+		offThreshold:   5 * time.Millisecond,
+		minOnDuration:  2 * time.Second,
+		minOffDuration: 3 * time.Second,
+	}
+	for _, tc := range []struct {
+		name   string
+		p99s   []time.Duration // in milliseconds
+		config overloadSignalConfig
+		// exp is the signal after every p99.
+		exp string
+		// naiveFlaps is the number of transitions of the naive threshold.
+		naiveFlaps int
+	}{
+		{
+			name:       "sustained",
+			p99s:       []time.Duration{1, 11, 12, 13, 12, 4, 3, 2, 1},
+			exp:        "___~~~~~_",
+			naiveFlaps: 2,
+		},
+		{
+			// Spikes above the on threshold shorter than the minimum on
+			// duration are ignored.
+			name:       "spikes",
+			p99s:       []time.Duration{11, 1, 11, 11, 1, 11, 1, 11, 11, 1},
+			exp:        "__________",
+			naiveFlaps: 8,
+		},
+		{
+			// Once on, hovering around the on threshold doesn't turn it off;
+			// neither does dipping below the off threshold briefly, nor being
+			// between both thresholds for long.
+			name:       "hovering",
+			p99s:       []time.Duration{11, 11, 11, 9, 11, 9, 11, 9, 4, 4, 9, 4, 4, 6, 6, 6, 6, 6, 6},
+			exp:        "__~~~~~~~~~~~~~~~~~",
+			naiveFlaps: 6,
+		},
+		{
+			// Turning off takes the minimum off duration of p99s at or below
+			// the off threshold, and turning back on the minimum on duration.
+			name:       "cycling",
+			p99s:       []time.Duration{11, 11, 11, 5, 5, 5, 5, 11, 11, 11, 11},
+			exp:        "__~~~~___~~",
+			naiveFlaps: 3,
+		},
+		{
+			// With no minimum durations, and no off threshold, it's the naive
+			// threshold.
+			name:       "naive",
+			p99s:       []time.Duration{11, 1, 11, 10, 11},
+			config:     overloadSignalConfig{onThreshold: 10 * time.Millisecond},
+			exp:        "~_~_~",
+			naiveFlaps: 5,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := config
+			if tc.config != (overloadSignalConfig{}) {
+				c = tc.config
+				c.offThreshold = c.onThreshold
+			}
+			var o overloadSignal
+			at := timeutil.Unix(0, 0)
+			var res []byte
+			var transitions, naiveFlaps int
+			var naive bool
+			for _, p99 := range tc.p99s {
+				p99 *= time.Millisecond
+				if o.observe(p99, at, c) {
+					transitions++
+				}
+				if p99 > c.onThreshold != naive {
+					naive = !naive
+					naiveFlaps++
+				}
+				if o.overloaded {
+					res = append(res, '~')
+				} else {
+					res = append(res, '_')
+				}
+				at = at.Add(time.Second)
+			}
+			require.Equal(t, tc.exp, string(res))
+			require.Equal(t, tc.naiveFlaps, naiveFlaps)
+			expTransitions := 0
+			for i := range res {
+				if (i == 0 && res[i] == '~') || (i > 0 && res[i] != res[i-1]) {
+					expTransitions++
+				}
+			}
+			require.Equal(t, expTransitions, transitions)
+		})
+	}
+
+	// Disabling it turns it off right away.
+	var o overloadSignal
+	at := timeutil.Unix(0, 0)
+	require.False(t, o.observe(11*time.Millisecond, at, config))
+	require.True(t, o.observe(11*time.Millisecond, at.Add(2*time.Second), config))
+	require.True(t, o.observe(11*time.Millisecond, at.Add(3*time.Second), overloadSignalConfig{}))
+	require.False(t, o.overloaded)
+}
+
+// TestOverloadSignalConfig verifies that the off threshold defaults to, and is
+// capped at, the on threshold.
+func TestOverloadSignalConfig(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	require.Equal(t, overloadSignalConfig{
+		minOnDuration: 10 * time.Second, minOffDuration: 30 * time.Second,
+	}, makeOverloadSignalConfig(&st.SV))
+	overloadSignalOnThreshold.Override(ctx, &st.SV, 10*time.Millisecond)
+	require.Equal(t, 10*time.Millisecond, makeOverloadSignalConfig(&st.SV).offThreshold)
+	overloadSignalOffThreshold.Override(ctx, &st.SV, 5*time.Millisecond)
+	require.Equal(t, 5*time.Millisecond, makeOverloadSignalConfig(&st.SV).offThreshold)
+	overloadSignalOffThreshold.Override(ctx, &st.SV, 20*time.Millisecond)
+	require.Equal(t, 10*time.Millisecond, makeOverloadSignalConfig(&st.SV).offThreshold)
+}
+
+// TestOverloadSignalDelivered verifies that the overload signal is computed by
+// the sampler every tick, delivered to the overload callbacks on transitions
+// only, and published in the snapshot.
+func TestOverloadSignalDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	st := s.st
+	overloadSignalOnThreshold.Override(ctx, &st.SV, 2*time.Millisecond)
+	overloadSignalOffThreshold.Override(ctx, &st.SV, time.Millisecond)
+	overloadSignalMinOnDuration.Override(ctx, &st.SV, time.Second)
+	overloadSignalMinOffDuration.Override(ctx, &st.SV, 2*time.Second)
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, 3ms). Every tick observes 100
+	// events in the given bucket, for the p99 to be in it.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, 0.003},
+	}
+	bucket := 0
+	s.sample = func() runtimeSample {
+		cumulative.Counts[bucket] += 100
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var transitions []bool
+	id := RegisterOverloadCallback(func(overloaded bool) {
+		transitions = append(transitions, overloaded)
+	})
+	defer UnregisterOverloadCallback(id)
+	tick := func(b int) bool {
+		t.Helper()
+		bucket = b
+		s.tick(ctx)
+		snap := latest.Load()
+		require.NotNil(t, snap)
+		return snap.Overloaded
+	}
+
+	s.tick(ctx) // the baseline
+	require.False(t, tick(0))
+	require.False(t, tick(2))
+	require.True(t, tick(2))
+	require.Equal(t, []bool{true}, transitions)
+	// Hovering between the thresholds, or dipping below the off one briefly,
+	// doesn't deliver anything.
+	for _, b := range []int{1, 2, 1, 0, 1, 0, 2, 1} {
+		require.True(t, tick(b))
+	}
+	// Idle windows hold the signal.
+	overloadSignalMinOffDuration.Override(ctx, &st.SV, time.Second)
+	require.True(t, tick(0))
+	idleWindowMinEvents.Override(ctx, &st.SV, 1000)
+	require.True(t, tick(0))
+	require.True(t, tick(0))
+	idleWindowMinEvents.Override(ctx, &st.SV, 0)
+	require.True(t, tick(2))
+	require.Equal(t, []bool{true}, transitions)
+	require.True(t, tick(0))
+	require.False(t, tick(0))
+	require.Equal(t, []bool{true, false}, transitions)
+
+	// Closing the sampler while overloaded turns it off.
+	require.False(t, tick(2))
+	require.True(t, tick(2))
+	s.close()
+	require.Equal(t, []bool{true, false, true, false}, transitions)
+	require.Nil(t, latest.Load())
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/log/eventpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOverloadEvents drives the sampler with injected histograms, scripting a
+// sustained overload and its recovery, and verifies the events emitted.
+func TestOverloadEvents(t *testing.T) {
+	ctx := context.Background()
+
+	// Buckets: [0, 500µs), [500µs, 2ms), [2ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.0005, 0.002, math.Inf(+1)},
+	}
+	s := newTestSampler(t, time.Second, time.Second, func() runtimeSample {
+		return runtimeSample{latencies: clone(cumulative)}
+	})
+	st := s.st
+	overloadThreshold.Override(ctx, &st.SV, time.Millisecond)
+	overloadMinDuration.Override(ctx, &st.SV, 3*time.Second)
+
+	type event struct {
+		sev     logpb.Severity
+		payload logpb.EventPayload
+	}
+	var events []event
+	m := newOverloadMonitor(ctx, st)
+	m.emit = func(_ context.Context, sev logpb.Severity, payload logpb.EventPayload) {
+		events = append(events, event{sev: sev, payload: payload})
+	}
+	s.addListener(m)
+
+	const slowP99, fastP99 = 1985 * time.Microsecond, 495 * time.Microsecond
+	tick := func(slow bool) {
+		if slow {
+			cumulative.Counts[1] += 100
+		} else {
+			cumulative.Counts[0] += 100
+		}
+		s.tick(ctx)
+	}
+
+	tick(false) // nothing to compare against yet
+	for i := 0; i < 3; i++ {
+		tick(true)
+		require.Empty(t, events) // not sustained for long enough
+	}
+	tick(true)
+	require.Equal(t, []event{{
+		sev: logpb.Severity_WARNING,
+		payload: &eventpb.GoSchedulerOverload{
+			LatencyNanos:  slowP99.Nanoseconds(),
+			DurationNanos: (3 * time.Second).Nanoseconds(),
+		},
+	}}, events)
+
+	// No further events while the overload is ongoing.
+	tick(true)
+	require.Len(t, events, 1)
+
+	tick(false)
+	require.Len(t, events, 2)
+	require.Equal(t, event{
+		sev: logpb.Severity_INFO,
+		payload: &eventpb.GoSchedulerOverloadCleared{
+			LatencyNanos:  fastP99.Nanoseconds(),
+			DurationNanos: (5 * time.Second).Nanoseconds(),
+		},
+	}, events[1])
+
+	// A brief breach goes unreported.
+	tick(true)
+	tick(false)
+	require.Len(t, events, 2)
+
+	// As do overloads when disabled.
+	overloadThreshold.Override(ctx, &st.SV, 0)
+	for i := 0; i < 10; i++ {
+		tick(true)
+	}
+	require.Len(t, events, 2)
+}
+
+// TestOverloadEventsIgnorePartialWindows verifies that provisional and final
+// samples, computed over partial windows, neither start nor clear an overload.
+func TestOverloadEventsIgnorePartialWindows(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	overloadThreshold.Override(ctx, &st.SV, time.Millisecond)
+	overloadMinDuration.Override(ctx, &st.SV, 3*time.Second)
+
+	var events []logpb.Severity
+	m := newOverloadMonitor(ctx, st)
+	m.emit = func(_ context.Context, sev logpb.Severity, _ logpb.EventPayload) {
+		events = append(events, sev)
+	}
+
+	const slowP99, fastP99 = 2 * time.Millisecond, 500 * time.Microsecond
+	now := timeutil.Unix(0, 0)
+	observe := func(p99 time.Duration, provisional, final bool) {
+		now = now.Add(time.Second)
+		m.SchedulerLatencySample(Sample{P99: p99, At: now, Provisional: provisional, Final: final})
+	}
+
+	// Partial windows don't start an overload, however long they breach the
+	// threshold.
+	for i := 0; i < 10; i++ {
+		observe(slowP99, true /* provisional */, false /* final */)
+	}
+	observe(slowP99, false /* provisional */, true /* final */)
+	require.Empty(t, events)
+
+	// Nor do they clear one.
+	for i := 0; i < 5; i++ {
+		observe(slowP99, false /* provisional */, false /* final */)
+	}
+	require.Equal(t, []logpb.Severity{logpb.Severity_WARNING}, events)
+	observe(fastP99, true /* provisional */, false /* final */)
+	observe(fastP99, false /* provisional */, true /* final */)
+	require.Len(t, events, 1)
+	observe(fastP99, false /* provisional */, false /* final */)
+	require.Equal(t, []logpb.Severity{logpb.Severity_WARNING, logpb.Severity_INFO}, events)
+}
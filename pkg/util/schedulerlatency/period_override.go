@@ -0,0 +1,82 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// periodOverride is a temporary override of the configured sample period. It's
+// the zero value if none is in effect.
+type periodOverride struct {
+	period time.Duration
+	until  time.Time
+}
+
+// SetTemporaryPeriod has the running sampler, if any, sample every period
+// instead of every scheduler_latency.sample_period, until expiry elapses;
+// scheduler_latency.sample_duration is retained, though it's extended to
+// span at least two periods. It's meant for callers wanting a burst of
+// finer-grained samples, such as admission control when it detects elastic
+// work is starved, without changing the cluster setting.
+//
+// If an override is already in effect, the finer of the two periods applies,
+// until the later of the two expiries: overlapping calls can only tighten the
+// period and extend the override. The configured period resumes on the first
+// tick after the override expires. Like any change to the period, applying or
+// expiring an override re-baselines the sampler; callbacks are told the period
+// in effect.
+func SetTemporaryPeriod(period time.Duration, expiry time.Duration) error {
+	if period < time.Millisecond {
+		return errors.Newf("minimum sample period is %s, got %s", time.Millisecond, period)
+	}
+	if expiry <= 0 {
+		return errors.Newf("expected a positive expiry, got %s", expiry)
+	}
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.mu.timeSource.Now()
+	until := now.Add(expiry)
+	if o := s.mu.override; o.period != 0 && now.Before(o.until) {
+		if o.period < period {
+			period = o.period
+		}
+		if o.until.After(until) {
+			until = o.until
+		}
+	}
+	s.mu.override = periodOverride{period: period, until: until}
+	if s.applyPeriodLocked() {
+		s.signalResetTicks()
+	}
+	return nil
+}
+
+// maybeExpireOverride clears the temporary period override if it expired,
+// returning true if the period in effect changed as a result.
+func (s *sampler) maybeExpireOverride() (changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.override.period == 0 || s.mu.timeSource.Now().Before(s.mu.override.until) {
+		return false
+	}
+	s.mu.override = periodOverride{}
+	return s.applyPeriodLocked()
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSetTemporaryPeriod applies a temporary period override to a running
+// sampler, verifying that it ticks (and informs callbacks) at the overridden
+// period until the override expires, after which the configured period
+// resumes. Ticks are aligned to wall-clock boundaries, which lets us
+// synchronize with the tick loop through the timer it arms whenever the
+// period changes.
+func TestSetTemporaryPeriod(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	clock := timeutil.NewManualTime(timeutil.Unix(100, 500*time.Millisecond.Nanoseconds()))
+	samplePeriod.Override(ctx, &st.SV, time.Second)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Second)
+	sampleAlignment.Override(ctx, &st.SV, true)
+
+	require.NoError(t, SetTemporaryPeriod(time.Millisecond, time.Second)) // no-op without a running sampler
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	s.sample = func() runtimeSample {
+		return runtimeSample{latencies: &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}}
+	}
+	var listener lockedSampleListener
+	s.addListener(&listener)
+
+	waitForAligner := func(at time.Time) {
+		t.Helper()
+		testutils.SucceedsSoon(t, func() error {
+			if timers := clock.Timers(); len(timers) != 1 || !timers[0].Equal(at) {
+				return errors.Newf("expected a timer at %s, found %v", at, timers)
+			}
+			return nil
+		})
+	}
+	waitForTicks := func(ticks int64) {
+		t.Helper()
+		testutils.SucceedsSoon(t, func() error {
+			if overhead, _ := TestingSamplerOverhead(); overhead.Ticks != ticks {
+				return errors.Newf("expected %d ticks, found %d", ticks, overhead.Ticks)
+			}
+			return nil
+		})
+	}
+	window := func() (period time.Duration, samples int) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.period, s.mu.ringBuffer.Cap()
+	}
+
+	waitForAligner(timeutil.Unix(101, 0))
+	clock.Advance(500 * time.Millisecond)
+	waitForTicks(1)
+
+	// Override the period for 3s; the window still spans 2s.
+	clock.Advance(50 * time.Millisecond)
+	require.NoError(t, SetTemporaryPeriod(250*time.Millisecond, 3*time.Second))
+	period, samples := window()
+	require.Equal(t, 250*time.Millisecond, period)
+	require.Equal(t, 8, samples)
+	waitForAligner(timeutil.Unix(101, 250*time.Millisecond.Nanoseconds()))
+	clock.Advance(200 * time.Millisecond)
+	waitForTicks(2)
+	for i := int64(2); i <= 12; i++ {
+		clock.Advance(250 * time.Millisecond)
+		waitForTicks(1 + i)
+	}
+	// The first full window, spanning 8 ticks at the overridden period, was
+	// observed on the 9th tick.
+	deliveries := listener.get()
+	require.Len(t, deliveries, 4)
+	for _, d := range deliveries {
+		require.Equal(t, 250*time.Millisecond, d.Period)
+		require.Equal(t, 2*time.Second, d.Elapsed)
+	}
+
+	// The next tick finds the override expired, and re-aligns to the
+	// configured period.
+	clock.Advance(250 * time.Millisecond)
+	waitForAligner(timeutil.Unix(105, 0))
+	period, samples = window()
+	require.Equal(t, time.Second, period)
+	require.Equal(t, 2, samples)
+	clock.Advance(750 * time.Millisecond)
+	waitForTicks(14)
+	clock.Advance(time.Second)
+	waitForTicks(15)
+	clock.Advance(time.Second)
+	waitForTicks(16)
+	deliveries = listener.get()
+	require.Len(t, deliveries, 5)
+	require.Equal(t, time.Second, deliveries[4].Period)
+}
+
+// TestSetTemporaryPeriodOverlapping verifies how overlapping overrides combine,
+// and that invalid ones are rejected.
+func TestSetTemporaryPeriodOverlapping(t *testing.T) {
+	s := newTestSampler(t, time.Hour, 2*time.Hour, nil)
+	clock := s.clock
+	// Install the sampler without running its tick loop, so overrides are only
+	// expired explicitly below.
+	shared.Lock()
+	shared.s = s.sampler
+	shared.Unlock()
+	defer func() {
+		shared.Lock()
+		defer shared.Unlock()
+		shared.s = nil
+	}()
+	override := func() periodOverride {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.override
+	}
+
+	require.EqualError(t, SetTemporaryPeriod(time.Microsecond, time.Minute),
+		"minimum sample period is 1ms, got 1µs")
+	require.EqualError(t, SetTemporaryPeriod(time.Second, 0), "expected a positive expiry, got 0s")
+	require.Equal(t, periodOverride{}, override())
+
+	require.NoError(t, SetTemporaryPeriod(time.Second, time.Minute))
+	require.Equal(t, periodOverride{period: time.Second, until: timeutil.Unix(60, 0)}, override())
+	// A coarser period with a later expiry only extends the override.
+	require.NoError(t, SetTemporaryPeriod(10*time.Second, 2*time.Minute))
+	require.Equal(t, periodOverride{period: time.Second, until: timeutil.Unix(120, 0)}, override())
+	// A finer period with an earlier expiry only tightens it.
+	require.NoError(t, SetTemporaryPeriod(100*time.Millisecond, time.Minute))
+	require.Equal(t, periodOverride{period: 100 * time.Millisecond, until: timeutil.Unix(120, 0)}, override())
+	require.Equal(t, 100*time.Millisecond, s.periodInEffect())
+
+	// The override isn't expired before it's due.
+	clock.Advance(119 * time.Second)
+	require.False(t, s.maybeExpireOverride())
+	clock.Advance(time.Second)
+	require.True(t, s.maybeExpireOverride())
+	require.Equal(t, periodOverride{}, override())
+	require.Equal(t, time.Hour, s.periodInEffect())
+
+	// Overrides that expired aren't combined with new ones.
+	require.NoError(t, SetTemporaryPeriod(time.Second, time.Minute))
+	clock.Advance(2 * time.Minute)
+	require.NoError(t, SetTemporaryPeriod(10*time.Second, time.Minute))
+	require.Equal(t, periodOverride{period: 10 * time.Second, until: timeutil.Unix(300, 0)}, override())
+}
+
+// lockedSampleListener is a SampleObserver that's safe to read from while the
+// sampler is running. It ignores provisional samples.
+type lockedSampleListener struct {
+	mu      syncutil.Mutex
+	samples []Sample
+}
+
+var _ SampleObserver = &lockedSampleListener{}
+
+func (l *lockedSampleListener) SchedulerLatency(time.Duration, time.Duration) {
+	panic("unexpected call to legacy interface")
+}
+
+func (l *lockedSampleListener) SchedulerLatencySample(s Sample) {
+	if s.Provisional {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples = append(l.samples, s)
+}
+
+func (l *lockedSampleListener) get() []Sample {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Sample(nil), l.samples...)
+}
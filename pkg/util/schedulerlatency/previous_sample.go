@@ -0,0 +1,43 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import "time"
+
+// PreviousSample is the percentile set of a sample previously delivered to a
+// listener; see Sample.Previous.
+type PreviousSample struct {
+	// P99 is the p99 scheduler latency over the previous sample's window.
+	P99 time.Duration
+	// Percentiles are the previous sample's Sample.Percentiles, if any.
+	Percentiles []time.Duration
+	// At is when the latest sample in the previous sample's window was taken.
+	At time.Time
+}
+
+// recordDelivered records the given sample, just delivered to the listener, as
+// the previous one for the next delivery, unless its percentiles aren't
+// comparable with those of full windows: if it's provisional, idle or final.
+func (l *listenerState) recordDelivered(sample Sample) {
+	if sample.Provisional || sample.Idle || sample.Final {
+		return
+	}
+	l.previous = &PreviousSample{P99: sample.P99, Percentiles: sample.Percentiles, At: sample.At}
+}
+
+// forgetPreviousLocked discards the previous sample recorded for every
+// listener, as the sampler re-baselines: the next full window delivered isn't
+// comparable with the ones before.
+func (s *sampler) forgetPreviousLocked() {
+	for i := range s.mu.listeners {
+		s.mu.listeners[i].previous = nil
+	}
+}
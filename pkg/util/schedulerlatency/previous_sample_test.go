@@ -0,0 +1,93 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSamplePrevious verifies that every sample delivered carries the most
+// recent full window previously delivered, across warm-up, idle windows and
+// re-baselines, over a scripted sequence.
+func TestSamplePrevious(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	s.percentiles = []float64{0.5}
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, 3ms). Every tick observes 100 events
+	// in the given bucket, if any; the window spans two ticks.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, 0.003},
+	}
+	var bucket int
+	s.sample = func() runtimeSample {
+		if bucket >= 0 {
+			cumulative.Counts[bucket] += 100
+		}
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func(b int) {
+		bucket = b
+		s.tick(ctx)
+	}
+	// requirePrevious requires the i-th non-provisional sample to have been
+	// delivered the j-th as the previous one, or none if j is negative.
+	requirePrevious := func(i, j int) {
+		t.Helper()
+		require.Len(t, listener.samples, i+1)
+		cur := listener.samples[i]
+		if j < 0 {
+			require.Nil(t, cur.Previous)
+			return
+		}
+		prev := listener.samples[j]
+		require.NotEmpty(t, prev.Percentiles)
+		require.Equal(t, &PreviousSample{P99: prev.P99, Percentiles: prev.Percentiles, At: prev.At}, cur.Previous)
+		require.NotEqual(t, cur.At, cur.Previous.At)
+	}
+
+	tick(0) // the baseline
+	tick(0)
+	require.Len(t, listener.provisional, 1)
+	require.Nil(t, listener.provisional[0].Previous) // nothing was delivered yet
+	tick(1)
+	requirePrevious(0, -1) // provisional samples aren't recorded
+	tick(2)
+	requirePrevious(1, 0)
+	require.NotEqual(t, listener.samples[0].P99, listener.samples[1].P99)
+	tick(-1)
+	requirePrevious(2, 1)
+	tick(-1)
+	require.True(t, listener.samples[3].Idle)
+	requirePrevious(3, 2)
+	tick(1)
+	requirePrevious(4, 2) // idle samples aren't recorded either
+
+	// Re-baselining forgets what was delivered before.
+	s.mu.Lock()
+	s.resetWindowLocked()
+	s.mu.Unlock()
+	tick(1) // the baseline
+	tick(1)
+	require.Len(t, listener.provisional, 2)
+	require.Nil(t, listener.provisional[1].Previous)
+	tick(1)
+	requirePrevious(5, -1)
+	tick(2)
+	requirePrevious(6, 5)
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// quantilesExportEnabled controls the export of the windowed scheduler latency
+// quantiles, for the internal time series database to record them and the DB
+// console to chart them historically. The go.scheduler_latency histogram is
+// cumulative since the process started, so it can't be used for the purpose.
+var quantilesExportEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.quantiles_export.enabled",
+	"when set, the p50, p90, p99 and max scheduler latency over the most recent "+
+		"scheduler_latency.sample_duration, and the ratio of the p99 to the p50, are exported as time series",
+	false,
+)
+
+var quantilesExportInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.quantiles_export.interval",
+	"the minimum duration between consecutive exports of the scheduler latency quantiles, "+
+		"if scheduler_latency.quantiles_export.enabled is set",
+	10*time.Second,
+	settings.NonNegativeDuration,
+)
+
+var (
+	metaWindowedP50 = metric.Metadata{
+		Name:        "go.scheduler_latency.windowed-p50",
+		Help:        "p50 Go scheduling latency over the last scheduler_latency.sample_duration (if scheduler_latency.quantiles_export.enabled is set)",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaWindowedP90 = metric.Metadata{
+		Name:        "go.scheduler_latency.windowed-p90",
+		Help:        "p90 Go scheduling latency over the last scheduler_latency.sample_duration (if scheduler_latency.quantiles_export.enabled is set)",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaWindowedP99 = metric.Metadata{
+		Name:        "go.scheduler_latency.windowed-p99",
+		Help:        "p99 Go scheduling latency over the last scheduler_latency.sample_duration (if scheduler_latency.quantiles_export.enabled is set)",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaWindowedMax = metric.Metadata{
+		Name:        "go.scheduler_latency.windowed-max",
+		Help:        "Maximum Go scheduling latency over the last scheduler_latency.sample_duration (if scheduler_latency.quantiles_export.enabled is set)",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+)
+
+// Quantiles summarizes a histogram of scheduling latencies, accumulated over
+// some elapsed time, with a small fixed set of quantiles, suitable for
+// recording as time series.
+type Quantiles struct {
+	P50, P90, P99 time.Duration
+	// Max is the upper bound of the highest non-empty bucket, or its lower
+	// bound if unbounded.
+	Max time.Duration
+	// EventsPerSecond is the rate of scheduling events over the elapsed time.
+	EventsPerSecond float64
+}
+
+// RebucketToQuantiles summarizes the given histogram, accumulated over the
+// given elapsed time, into Quantiles. It returns the zero value for an empty
+// (or nil) histogram.
+func RebucketToQuantiles(h *metrics.Float64Histogram, elapsed time.Duration) Quantiles {
+	if h == nil {
+		return Quantiles{}
+	}
+	ps, ok := percentiles(h, []float64{0.50, 0.90, 0.99})
+	if !ok {
+		return Quantiles{}
+	}
+	var q Quantiles
+	q.P50 = SecondsToDuration(ps[0])
+	q.P90 = SecondsToDuration(ps[1])
+	q.P99 = SecondsToDuration(ps[2])
+	q.Max = histogramMax(h)
+	q.EventsPerSecond = eventsPerSecond(count(h), elapsed)
+	return q
+}
+
+// histogramMax returns the upper bound of the highest non-empty bucket of the
+// given histogram, or its lower bound if unbounded, or zero if it's empty.
+func histogramMax(h *metrics.Float64Histogram) time.Duration {
+	for i := len(h.Counts) - 1; i >= 0; i-- {
+		if h.Counts[i] == 0 {
+			continue
+		}
+		upper := h.Buckets[i+1]
+		if math.IsInf(upper, +1) {
+			upper = h.Buckets[i]
+		}
+		return SecondsToDuration(upper)
+	}
+	return 0
+}
+
+// exportQuantilesLocked updates the windowed quantile gauges from the interval
+// histogram of the most recent full window, and the tail ratio gauge from the
+// window, at most once every scheduler_latency.quantiles_export.interval, if
+// enabled. The gauges are cleared once disabled.
+func (s *sampler) exportQuantilesLocked(w window) {
+	if !quantilesExportEnabled.Get(&s.mu.st.SV) {
+		if s.mu.quantilesExport.last.IsZero() {
+			return
+		}
+		s.mu.quantilesExport = deliveryThrottle{}
+		s.metrics.updateQuantiles(Quantiles{})
+		s.metrics.WindowedTailRatio.Update(0)
+		return
+	}
+	s.mu.quantilesExport.interval = quantilesExportInterval.Get(&s.mu.st.SV)
+	if !s.mu.quantilesExport.ready(w.at) {
+		return
+	}
+	var q Quantiles // idle windows report zero, like their percentiles
+	if !w.idle {
+		q = RebucketToQuantiles(s.mu.lastIntervalHistogram, w.elapsed)
+	}
+	s.metrics.updateQuantiles(q)
+	s.metrics.WindowedTailRatio.Update(w.tailRatio())
+}
+
+func (m samplerMetrics) updateQuantiles(q Quantiles) {
+	m.WindowedP50.Update(q.P50.Nanoseconds())
+	m.WindowedP90.Update(q.P90.Nanoseconds())
+	m.WindowedP99.Update(q.P99.Nanoseconds())
+	m.WindowedMax.Update(q.Max.Nanoseconds())
+}
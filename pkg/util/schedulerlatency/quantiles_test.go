@@ -0,0 +1,97 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebucketToQuantiles(t *testing.T) {
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, +Inf).
+	buckets := []float64{0, 0.001, 0.002, math.Inf(+1)}
+
+	require.Equal(t, Quantiles{}, RebucketToQuantiles(nil, time.Second))
+	require.Equal(t, Quantiles{}, RebucketToQuantiles(
+		&metrics.Float64Histogram{Counts: []uint64{0, 0, 0}, Buckets: buckets}, time.Second))
+
+	q := RebucketToQuantiles(
+		&metrics.Float64Histogram{Counts: []uint64{100, 100, 0}, Buckets: buckets}, 2*time.Second)
+	require.Equal(t, Quantiles{
+		P50:             time.Millisecond,
+		P90:             1800 * time.Microsecond,
+		P99:             1980 * time.Microsecond,
+		Max:             2 * time.Millisecond, // the upper bound of the highest non-empty bucket
+		EventsPerSecond: 100,
+	}, q)
+
+	// The highest bucket is unbounded; its lower bound is the max.
+	q = RebucketToQuantiles(
+		&metrics.Float64Histogram{Counts: []uint64{10, 0, 1}, Buckets: buckets}, 0)
+	require.Equal(t, 2*time.Millisecond, q.Max)
+	require.Zero(t, q.EventsPerSecond) // no time elapsed
+}
+
+// TestQuantilesExport verifies that the windowed quantiles are exported at most
+// once every scheduler_latency.quantiles_export.interval, only if enabled.
+func TestQuantilesExport(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	st := s.st
+
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, +Inf).
+	latencies := &metrics.Float64Histogram{Counts: []uint64{0, 0, 0}, Buckets: []float64{0, 0.001, 0.002, math.Inf(+1)}}
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(latencies)} }
+	tick := func(counts ...uint64) {
+		for i := range counts {
+			latencies.Counts[i] += counts[i]
+		}
+		s.tick(ctx)
+	}
+	exported := func() []time.Duration {
+		return []time.Duration{
+			time.Duration(s.metrics.WindowedP50.Value()),
+			time.Duration(s.metrics.WindowedP90.Value()),
+			time.Duration(s.metrics.WindowedP99.Value()),
+			time.Duration(s.metrics.WindowedMax.Value()),
+		}
+	}
+	zero := []time.Duration{0, 0, 0, 0}
+
+	// Nothing is exported unless enabled.
+	tick()
+	tick(100, 100, 0)
+	require.Equal(t, zero, exported())
+
+	quantilesExportEnabled.Override(ctx, &st.SV, true)
+	quantilesExportInterval.Override(ctx, &st.SV, 2*time.Second)
+	tick(100, 100, 0)
+	expected := []time.Duration{
+		time.Millisecond, 1800 * time.Microsecond, 1980 * time.Microsecond, 2 * time.Millisecond,
+	}
+	require.Equal(t, expected, exported())
+	tick(100, 0, 0) // throttled
+	require.Equal(t, expected, exported())
+	tick(100, 0, 0)
+	require.Equal(t, []time.Duration{
+		500 * time.Microsecond, 900 * time.Microsecond, 990 * time.Microsecond, time.Millisecond,
+	}, exported())
+
+	// Disabling the export clears the exported quantiles.
+	quantilesExportEnabled.Override(ctx, &st.SV, false)
+	tick(100, 0, 0)
+	require.Equal(t, zero, exported())
+}
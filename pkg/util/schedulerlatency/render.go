@@ -0,0 +1,146 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRenderWidth is the width of the longest bar rendered by RenderText,
+// if none is specified.
+const defaultRenderWidth = 50
+
+// maxRenderWidth bounds the width accepted by HandleDebugText.
+const maxRenderWidth = 1000
+
+// RenderText renders the histogram as a bar chart, one row per (non-empty)
+// bucket in increasing order, for quick inspection in a terminal. The bucket
+// ranges are right-aligned, the longest bar is width characters wide, and the
+// buckets housing the p50 and p99 are marked, for example:
+//
+//	     [7.168µs, 81.92µs) |##############################| 800 <- p50
+//	   [81.92µs, 917.504µs) |#########                     | 240
+//	[917.504µs, 10.48576ms) |##                            |  30 <- p99
+//
+// If width isn't positive, defaultRenderWidth is used.
+func (h DebugHistogram) RenderText(w io.Writer, width int) error {
+	if width <= 0 {
+		width = defaultRenderWidth
+	}
+	bw := bufio.NewWriter(w)
+	var events, maxCount uint64
+	for _, b := range h.Buckets {
+		events += b.Count
+		if b.Count > maxCount {
+			maxCount = b.Count
+		}
+	}
+	fmt.Fprintf(bw, "scheduler latency: %d events over %s (window %s), as of %s\n",
+		events, h.Elapsed, h.Window, h.At.UTC().Format(time.RFC3339Nano))
+	if h.Idle {
+		fmt.Fprintf(bw, "idle: fewer events than scheduler_latency.idle_window.min_events, no percentiles\n")
+	} else {
+		p := h.Percentiles
+		fmt.Fprintf(bw, "p50=%s p90=%s p99=%s p99.9=%s\n", p.P50, p.P90, p.P99, p.P999)
+	}
+	if len(h.Buckets) == 0 {
+		fmt.Fprintf(bw, "no scheduling events in the window\n")
+		return bw.Flush()
+	}
+
+	ranges := make([]string, len(h.Buckets))
+	var rangeWidth int
+	for i, b := range h.Buckets {
+		ranges[i] = fmt.Sprintf("[%s, %s)", renderDebugBoundary(b.Lower, "-Inf"), renderDebugBoundary(b.Upper, "+Inf"))
+		rangeWidth = max(rangeWidth, len([]rune(ranges[i])))
+	}
+	countWidth := len(strconv.FormatUint(maxCount, 10))
+	p50, p99 := -1, -1
+	if !h.Idle {
+		p50, p99 = h.bucketOf(h.Percentiles.P50), h.bucketOf(h.Percentiles.P99)
+	}
+	for i, b := range h.Buckets {
+		// Round up, so as to not hide sparsely populated buckets.
+		bar := int((b.Count*uint64(width) + maxCount - 1) / maxCount)
+		pad := rangeWidth - len([]rune(ranges[i]))
+		fmt.Fprintf(bw, "%s%s |%s%s| %*d",
+			strings.Repeat(" ", pad), ranges[i],
+			strings.Repeat("#", bar), strings.Repeat(" ", width-bar),
+			countWidth, b.Count)
+		switch {
+		case i == p50 && i == p99:
+			fmt.Fprintf(bw, " <- p50, p99")
+		case i == p50:
+			fmt.Fprintf(bw, " <- p50")
+		case i == p99:
+			fmt.Fprintf(bw, " <- p99")
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// bucketOf returns the index of the bucket housing the given latency, or -1
+// if there's none.
+func (h DebugHistogram) bucketOf(d time.Duration) int {
+	for i, b := range h.Buckets {
+		if (b.Lower == nil || *b.Lower <= d) && (b.Upper == nil || d < *b.Upper) {
+			return i
+		}
+	}
+	// Percentiles are interpolated within buckets, and could land on the
+	// (exclusive) upper boundary of the last one.
+	for i := len(h.Buckets) - 1; i >= 0; i-- {
+		if b := h.Buckets[i]; b.Upper != nil && *b.Upper == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// renderDebugBoundary renders a bucket boundary of DebugHistogram, using
+// unbounded for those that aren't.
+func renderDebugBoundary(b *time.Duration, unbounded string) string {
+	if b == nil {
+		return unbounded
+	}
+	return b.String()
+}
+
+// HandleDebugText serves the most recent interval histogram as rendered by
+// RenderText, for consumption in a terminal. The width of the bars can be
+// specified using the width query parameter. Like HandleDebug, it responds with
+// http.StatusServiceUnavailable if there's no histogram to render.
+func HandleDebugText(w http.ResponseWriter, r *http.Request) {
+	width := defaultRenderWidth
+	if v := r.URL.Query().Get("width"); v != "" {
+		var err error
+		if width, err = strconv.Atoi(v); err != nil || width <= 0 || width > maxRenderWidth {
+			http.Error(w, fmt.Sprintf("invalid width %q: must be in [1, %d]", v, maxRenderWidth),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	h, ok := debugHistogramOrError(w)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := h.RenderText(w, width); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
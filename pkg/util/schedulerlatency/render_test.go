@@ -0,0 +1,126 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils/datapathutils"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/datadriven"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRenderText is a datadriven test for DebugHistogram.RenderText. It comes
+// with the following command.
+//
+//   - "render" [width=<int>] [min-events=<int>]
+//     Render the interval histogram given as input, one bucket per line in
+//     increasing order as "<lower> <upper> <count>", with boundaries rendered
+//     as durations or -Inf/+Inf. The buckets must be contiguous. The window is
+//     computed over 10s, treating it as idle if it has fewer than min-events.
+func TestRenderText(t *testing.T) {
+	datadriven.RunTest(t, datapathutils.TestDataPath(t, "render_text"),
+		func(t *testing.T, d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "render":
+				var width int
+				if d.HasArg("width") {
+					d.ScanArgs(t, "width", &width)
+				}
+				var minEvents uint64
+				if d.HasArg("min-events") {
+					d.ScanArgs(t, "min-events", &minEvents)
+				}
+
+				latest := runtimeSample{
+					latencies: parseRenderHistogram(t, d.Input),
+					at:        timeutil.Unix(0, 0).Add(10 * time.Second),
+				}
+				oldest := runtimeSample{
+					latencies: clone(latest.latencies),
+					at:        timeutil.Unix(0, 0),
+				}
+				for i := range oldest.latencies.Counts {
+					oldest.latencies.Counts[i] = 0
+				}
+				w, interval, ok := computeWindow(latest, oldest, 10 /* samples */, time.Second, minEvents)
+				require.True(t, ok, "empty windows must be idle")
+
+				var buf strings.Builder
+				require.NoError(t, makeDebugHistogram(interval.Histogram(), w).RenderText(&buf, width))
+				return buf.String()
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		},
+	)
+}
+
+// parseRenderHistogram parses the input of the "render" command of
+// TestRenderText.
+func parseRenderHistogram(t *testing.T, input string) *metrics.Float64Histogram {
+	parseBoundary := func(s string) float64 {
+		switch s {
+		case "-Inf":
+			return math.Inf(-1)
+		case "+Inf":
+			return math.Inf(+1)
+		}
+		d, err := time.ParseDuration(s)
+		require.NoError(t, err)
+		return d.Seconds()
+	}
+	h := &metrics.Float64Histogram{}
+	for _, line := range strings.Split(input, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		require.Len(t, fields, 3, "expected <lower> <upper> <count>: %s", line)
+		lower, upper := parseBoundary(fields[0]), parseBoundary(fields[1])
+		if len(h.Buckets) == 0 {
+			h.Buckets = append(h.Buckets, lower)
+		}
+		require.Equal(t, h.Buckets[len(h.Buckets)-1], lower, "buckets must be contiguous: %s", line)
+		h.Buckets = append(h.Buckets, upper)
+		count, err := strconv.ParseUint(fields[2], 10, 64)
+		require.NoError(t, err)
+		h.Counts = append(h.Counts, count)
+	}
+	return h
+}
+
+// TestHandleDebugText verifies that HandleDebugText validates the width it's
+// given, and is unavailable absent a running sampler.
+func TestHandleDebugText(t *testing.T) {
+	serve := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		HandleDebugText(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+	for _, width := range []string{"0", "-1", "1001", "wide"} {
+		rec := serve("/debug/scheduler_latency/text?width=" + width)
+		require.Equal(t, http.StatusBadRequest, rec.Code, width)
+		require.Contains(t, rec.Body.String(), "invalid width")
+	}
+	rec := serve("/debug/scheduler_latency/text?width=20")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
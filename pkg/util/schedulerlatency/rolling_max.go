@@ -0,0 +1,71 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/ring"
+)
+
+var rollingMaxHorizon = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.rolling_max.horizon",
+	"horizon over which the maximum of the delivered p99 scheduler latencies is tracked; "+
+		"zero disables it",
+	5*time.Minute,
+	settings.NonNegativeDuration,
+)
+
+var metaP99RollingMax = metric.Metadata{
+	Name:        "go.scheduler_latency.p99_rolling_max",
+	Help:        "Maximum p99 Go scheduling latency delivered over the last scheduler_latency.rolling_max.horizon",
+	Measurement: "Nanoseconds",
+	Unit:        metric.Unit_NANOSECONDS,
+}
+
+// p99RollingMax tracks the maximum p99 delivered over a trailing horizon, in
+// amortized O(1) time per delivery. It retains a monotonic deque of deliveries
+// with strictly decreasing p99s, newest first: a delivery is dropped as soon as
+// a later one is at least as large, since it can no longer be the maximum
+// before aging out. The oldest retained delivery is the maximum.
+type p99RollingMax struct {
+	deque ring.Buffer[trendPoint]
+}
+
+// observe records the p99 delivered at the given time and returns the maximum
+// over the deliveries within the given horizon of it (including this one).
+func (m *p99RollingMax) observe(at time.Time, p99 time.Duration, horizon time.Duration) time.Duration {
+	for m.deque.Len() > 0 && m.deque.GetFirst().p99 <= p99 {
+		m.deque.RemoveFirst()
+	}
+	m.deque.AddFirst(trendPoint{at: at, p99: p99})
+	return m.current(at, horizon) // never removes the delivery just added
+}
+
+// current returns the maximum over the deliveries within the given horizon of
+// the given time, without recording one; it's zero if there are none.
+func (m *p99RollingMax) current(at time.Time, horizon time.Duration) time.Duration {
+	for m.deque.Len() > 0 && at.Sub(m.deque.GetLast().at) > horizon {
+		m.deque.RemoveLast()
+	}
+	if m.deque.Len() == 0 {
+		return 0
+	}
+	return m.deque.GetLast().p99
+}
+
+// reset discards all recorded deliveries.
+func (m *p99RollingMax) reset() {
+	m.deque.Reset()
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestP99RollingMax(t *testing.T) {
+	const horizon = 3 * time.Second
+	at := func(i int) time.Time { return timeutil.Unix(int64(i), 0) }
+	ms := func(ms ...int) []time.Duration {
+		res := make([]time.Duration, len(ms))
+		for i := range ms {
+			res[i] = time.Duration(ms[i]) * time.Millisecond
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name string
+		p99s []time.Duration // delivered once a second
+		maxs []time.Duration
+	}{
+		{
+			name: "rising",
+			p99s: ms(1, 2, 3, 4),
+			maxs: ms(1, 2, 3, 4),
+		},
+		{
+			// Each spike is retained for the horizon, then decays to the largest
+			// p99 delivered since.
+			name: "decaying",
+			p99s: ms(5, 3, 4, 1, 1, 1, 1),
+			maxs: ms(5, 5, 5, 5, 4, 4, 1),
+		},
+		{
+			// A repeated maximum is retained as of its latest delivery.
+			name: "repeated",
+			p99s: ms(2, 1, 2, 1, 1, 1, 1),
+			maxs: ms(2, 2, 2, 2, 2, 2, 1),
+		},
+		{
+			name: "flat",
+			p99s: ms(1, 1, 1, 1, 1),
+			maxs: ms(1, 1, 1, 1, 1),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var m p99RollingMax
+			for i, p99 := range tc.p99s {
+				require.Equal(t, tc.maxs[i], m.observe(at(i), p99, horizon), "delivery %d", i)
+			}
+			// The deque never retains more than the deliveries within the horizon.
+			require.LessOrEqual(t, m.deque.Len(), 4)
+		})
+	}
+
+	t.Run("gap", func(t *testing.T) {
+		// Deliveries older than the horizon age out, however many there are.
+		var m p99RollingMax
+		m.observe(at(0), 9*time.Millisecond, horizon)
+		m.observe(at(1), 8*time.Millisecond, horizon)
+		m.observe(at(2), 7*time.Millisecond, horizon)
+		require.Equal(t, 3, m.deque.Len())
+		require.Equal(t, time.Millisecond, m.observe(at(60), time.Millisecond, horizon))
+		require.Equal(t, 1, m.deque.Len())
+	})
+
+	t.Run("horizon", func(t *testing.T) {
+		// Shrinking the horizon takes effect on the next delivery; a zero horizon
+		// only retains the latest.
+		var m p99RollingMax
+		m.observe(at(0), 9*time.Millisecond, horizon)
+		m.observe(at(1), time.Millisecond, horizon)
+		require.Equal(t, 9*time.Millisecond, m.observe(at(2), time.Millisecond, horizon))
+		require.Equal(t, time.Millisecond, m.observe(at(3), time.Millisecond, time.Second))
+		require.Equal(t, 2*time.Millisecond, m.observe(at(4), 2*time.Millisecond, 0))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		var m p99RollingMax
+		m.observe(at(0), 9*time.Millisecond, horizon)
+		m.reset()
+		require.Equal(t, time.Millisecond, m.observe(at(1), time.Millisecond, horizon))
+	})
+}
+
+// TestSampleP99RollingMax verifies that the rolling maximum is delivered to
+// listeners, published in the snapshot and the gauge, that it decays once a
+// spike is older than the horizon, and that a zero horizon disables it.
+func TestSampleP99RollingMax(t *testing.T) {
+	ctx := context.Background()
+
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, 3ms), [3ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, 0.003, math.Inf(+1)},
+	}
+	s := newTestSampler(t, time.Second, time.Second, func() runtimeSample {
+		return runtimeSample{latencies: clone(cumulative)}
+	})
+	st := s.st
+	rollingMaxHorizon.Override(ctx, &st.SV, 5*time.Second)
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func(bucket int) Sample {
+		cumulative.Counts[bucket] += 100
+		s.tick(ctx)
+		sample := listener.samples[len(listener.samples)-1]
+		require.Equal(t, sample.P99RollingMax, latest.Load().P99RollingMax)
+		require.Equal(t, sample.P99RollingMax.Nanoseconds(), s.metrics.P99RollingMax.Value())
+		return sample
+	}
+
+	const low, high = 990 * time.Microsecond, 2990 * time.Microsecond
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second) // nothing to compare against yet
+	require.Equal(t, low, tick(0).P99RollingMax)
+	spike := tick(2)
+	require.Equal(t, high, spike.P99)
+	require.Equal(t, high, spike.P99RollingMax)
+	// The spike is retained for the horizon, then decays.
+	for i := 0; i < 5; i++ {
+		sample := tick(0)
+		require.Equal(t, low, sample.P99)
+		require.Equal(t, high, sample.P99RollingMax)
+	}
+	require.Equal(t, low, tick(0).P99RollingMax)
+
+	// A zero horizon disables it.
+	rollingMaxHorizon.Override(ctx, &st.SV, 0)
+	require.Zero(t, tick(2).P99RollingMax)
+	rollingMaxHorizon.Override(ctx, &st.SV, 5*time.Second)
+	require.Equal(t, low, tick(0).P99RollingMax)
+}
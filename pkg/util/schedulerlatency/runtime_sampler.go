@@ -0,0 +1,325 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// runtimeMetricKind is the kind of a cumulative runtime/metrics metric.
+type runtimeMetricKind int
+
+const (
+	// histogramMetric is a metrics.KindFloat64Histogram, in the layout the
+	// runtime uses for time histograms; it's re-binned into the coarse layout
+	// when read.
+	histogramMetric runtimeMetricKind = iota
+	// counterMetric is a metrics.KindFloat64 counter.
+	counterMetric
+	// gaugeMetric is a metrics.KindUint64 gauge, read as is.
+	gaugeMetric
+)
+
+// runtimeMetric describes a cumulative runtime/metrics metric tracked by the
+// sampler. All the metrics exported by the runtime are read in a single
+// metrics.Read every tick. The scheduler latency histogram and the mutex wait
+// counter are windowed by the sampler itself, retained in its ring buffer;
+// other metrics are windowed independently, over windows sized like it, and
+// export and deliver a summary of every full window.
+type runtimeMetric struct {
+	name string // the runtime/metrics name
+	kind runtimeMetricKind
+	// enabled, if set, controls whether the metric is read at all. The window
+	// over an independently windowed metric that isn't is re-baselined.
+	enabled *settings.BoolSetting
+	// independent is set if the metric is windowed independently, in which
+	// case summarize is required.
+	independent bool
+	// summarize reduces a full window to the value exported and delivered: a
+	// percentile of a histogram, say, or the increase of a counter.
+	summarize func(w *runtimeMetricWindow) time.Duration
+	// gauge, if set, exports the summary of the most recent full window, in
+	// nanoseconds. It's cleared when the metric is disabled.
+	gauge *metric.Gauge
+	// deliver, if set, delivers the summary of a full window, and the time
+	// elapsed over it, alongside the sampler's deliveries to listeners.
+	deliver func(ctx context.Context, summary, elapsed time.Duration, at time.Time)
+	// read, if set, reads the metric instead of metrics.Read, for metrics the
+	// runtime doesn't export (cgroup CPU throttling). It returns the zero value
+	// if the metric can't be read.
+	read func() runtimeValue
+}
+
+// isEnabled returns whether the metric is to be read.
+func (m *runtimeMetric) isEnabled(sv *settings.Values) bool {
+	return m.enabled == nil || m.enabled.Get(sv)
+}
+
+// value converts the given value, as read, into a runtimeValue, or the zero
+// value if it's not of the expected kind (as is the case for metrics the
+// runtime doesn't support).
+func (k runtimeMetricKind) value(v *metrics.Value) runtimeValue {
+	switch {
+	case k == histogramMetric && v.Kind() == metrics.KindFloat64Histogram:
+		return runtimeValue{histogram: rebin(v.Float64Histogram(), coarseBuckets()), ok: true}
+	case k == counterMetric && v.Kind() == metrics.KindFloat64:
+		return runtimeValue{counter: v.Float64(), ok: true}
+	case k == gaugeMetric && v.Kind() == metrics.KindUint64:
+		return runtimeValue{gauge: v.Uint64(), ok: true}
+	default:
+		return runtimeValue{}
+	}
+}
+
+// runtimeValue is a sample of a runtime metric: a cumulative histogram or
+// counter, or a gauge, as per the metric's kind. It's the zero value if the
+// metric wasn't read.
+type runtimeValue struct {
+	histogram *metrics.Float64Histogram
+	counter   float64
+	gauge     uint64
+	ok        bool
+}
+
+// runtimeMetricWindow is the window over an independently windowed metric.
+type runtimeMetricWindow struct {
+	kind       runtimeMetricKind
+	histograms histogramWindow // the window over a histogramMetric
+	counters   counterWindow   // the window over a counterMetric
+	// full is set if the most recent sample recorded completed a full window,
+	// whose summary is summary.
+	full    bool
+	summary time.Duration
+}
+
+// makeRuntimeMetricWindow returns a window over the given number of samples of
+// the given metric.
+func makeRuntimeMetricWindow(
+	m *runtimeMetric, pool *histogramPool, samples int,
+) runtimeMetricWindow {
+	w := runtimeMetricWindow{kind: m.kind}
+	switch m.kind {
+	case histogramMetric:
+		w.histograms = makeHistogramWindow(m.name, samples)
+		w.histograms.pool = pool
+	case counterMetric:
+		w.counters = makeCounterWindow(samples)
+	}
+	return w
+}
+
+// len returns the number of samples retained.
+func (w *runtimeMetricWindow) len() int {
+	if w.kind == counterMetric {
+		return w.counters.ring.Len()
+	}
+	return w.histograms.ring.Len()
+}
+
+// elapsed returns the time elapsed over the most recent full window.
+func (w *runtimeMetricWindow) elapsed() time.Duration {
+	if w.kind == counterMetric {
+		return w.counters.elapsed
+	}
+	return w.histograms.elapsed
+}
+
+// record the given cumulative sample, taken at the given time, returning true
+// if a full window has been observed.
+func (w *runtimeMetricWindow) record(v runtimeValue, at time.Time) bool {
+	if w.kind == counterMetric {
+		return w.counters.record(v.counter, at)
+	}
+	return w.histograms.record(v.histogram, at)
+}
+
+// resize the window to span the given number of samples, re-baselining it.
+func (w *runtimeMetricWindow) resize(samples int) {
+	if w.kind == counterMetric {
+		w.counters.resize(samples)
+	} else {
+		w.histograms.resize(samples)
+	}
+	w.full, w.summary = false, 0
+}
+
+// reset discards the samples retained, re-baselining the window.
+func (w *runtimeMetricWindow) reset() {
+	if w.kind == counterMetric {
+		w.resize(w.counters.ring.Cap())
+	} else {
+		w.resize(w.histograms.ring.Cap())
+	}
+}
+
+// windowP99 summarizes a window over a histogram as its p99.
+func windowP99(w *runtimeMetricWindow) time.Duration {
+	ps, _ := w.histograms.percentiles([]float64{0.99})
+	return ps[0]
+}
+
+// windowIncrease summarizes a window over a counter of seconds as its
+// increase.
+func windowIncrease(w *runtimeMetricWindow) time.Duration {
+	return SecondsToDuration(w.counters.increase)
+}
+
+// runtimeSampler reads the runtime metrics described to it, in a single
+// metrics.Read per tick, and maintains the windows over the independently
+// windowed ones. It's not safe for concurrent use; the sampler's lock guards
+// it.
+type runtimeSampler struct {
+	metrics []*runtimeMetric
+	// windowed are the independently windowed metrics, and windows the
+	// windows over them, in the same order.
+	windowed []*runtimeMetric
+	windows  []runtimeMetricWindow
+	// read reads the given samples; it's metrics.Read unless overridden in
+	// tests.
+	read func([]metrics.Sample)
+	// samples and reading are reused across reads: the samples of the metrics
+	// being read, and the indexes of their descriptors.
+	samples []metrics.Sample
+	reading []int
+}
+
+// makeRuntimeSampler returns a runtime sampler for the given metrics,
+// windowing the independently windowed ones over the given number of samples.
+// The histograms retained are drawn from, and returned to, the given pool.
+func makeRuntimeSampler(
+	pool *histogramPool, samples int, ms ...*runtimeMetric,
+) runtimeSampler {
+	r := runtimeSampler{metrics: ms, read: metrics.Read}
+	for _, m := range ms {
+		if m.independent {
+			r.windowed = append(r.windowed, m)
+			r.windows = append(r.windows, makeRuntimeMetricWindow(m, pool, samples))
+		}
+	}
+	return r
+}
+
+// readValues reads the enabled metrics, returning their values in the order
+// of the descriptors; metrics that aren't enabled have the zero value.
+func (r *runtimeSampler) readValues(sv *settings.Values) []runtimeValue {
+	r.samples, r.reading = r.samples[:0], r.reading[:0]
+	values := make([]runtimeValue, len(r.metrics))
+	for i, m := range r.metrics {
+		if !m.isEnabled(sv) {
+			continue
+		}
+		if m.read != nil {
+			values[i] = m.read()
+			continue
+		}
+		r.samples = append(r.samples, metrics.Sample{Name: m.name})
+		r.reading = append(r.reading, i)
+	}
+	r.read(r.samples)
+	for j, i := range r.reading {
+		values[i] = r.metrics[i].kind.value(&r.samples[j].Value)
+	}
+	return values
+}
+
+// sample reads the runtime metrics into a runtimeSample: the scheduler
+// latencies, mutex wait and CPU classes, windowed by the sampler, GOMAXPROCS,
+// and the values of the independently windowed metrics. The latencies are nil
+// if the runtime doesn't export them as a histogram, which degrades the
+// sampler.
+func (r *runtimeSampler) sample(sv *settings.Values) runtimeSample {
+	values := r.readValues(sv)
+	var res runtimeSample
+	var cpuClassesRead int
+	for i, m := range r.metrics {
+		switch {
+		case m.independent:
+			res.windowed = append(res.windowed, values[i])
+		case m.name == schedLatenciesMetric:
+			res.latencies = values[i].histogram
+		case m.name == mutexWaitMetric:
+			// This is supported as of go1.20; we treat it as never increasing
+			// otherwise.
+			res.mutexWait = values[i].counter
+		case m.name == gomaxprocsMetric:
+			res.gomaxprocs = int(values[i].gauge)
+		default:
+			// These are supported as of go1.20.
+			if c, ok := cpuClassOf(m.name); ok && values[i].ok {
+				res.cpu.seconds[c] = values[i].counter
+				cpuClassesRead++
+			}
+		}
+	}
+	res.cpu.ok = cpuClassesRead == int(numCPUClasses)
+	return res
+}
+
+// resize the windows over the independently windowed metrics, re-baselining
+// them.
+func (r *runtimeSampler) resize(samples int) {
+	for i := range r.windows {
+		r.windows[i].resize(samples)
+	}
+}
+
+// reset re-baselines the windows over the independently windowed metrics.
+func (r *runtimeSampler) reset() {
+	for i := range r.windows {
+		r.windows[i].reset()
+	}
+}
+
+// record the given values of the independently windowed metrics, in the order
+// they were described, taken at the given time, exporting the summaries of the
+// full windows observed. Metrics lacking a value, having been disabled,
+// re-baseline their window and clear their gauge.
+func (r *runtimeSampler) record(values []runtimeValue, at time.Time) {
+	for i, m := range r.windowed {
+		w := &r.windows[i]
+		w.full = false
+		var v runtimeValue
+		if i < len(values) {
+			v = values[i]
+		}
+		if !v.ok {
+			if w.len() > 0 {
+				w.reset()
+				if m.gauge != nil {
+					m.gauge.Update(0)
+				}
+			}
+			continue
+		}
+		if !w.record(v, at) {
+			continue
+		}
+		w.full, w.summary = true, m.summarize(w)
+		if m.gauge != nil {
+			m.gauge.Update(w.summary.Nanoseconds())
+		}
+	}
+}
+
+// deliver the summaries of the full windows observed by the most recent
+// record.
+func (r *runtimeSampler) deliver(ctx context.Context, at time.Time) {
+	for i, m := range r.windowed {
+		if w := &r.windows[i]; w.full && m.deliver != nil {
+			m.deliver(ctx, w.summary, w.elapsed(), at)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRuntimeSamplerRead verifies that the runtime sampler reads all the
+// enabled metrics in a single metrics.Read, returning their values in the
+// order they were described.
+func TestRuntimeSamplerRead(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	r := makeRuntimeSampler(nil /* pool */, 1,
+		&runtimeMetric{name: schedLatenciesMetric, kind: histogramMetric},
+		&runtimeMetric{name: gcPausesMetric, kind: histogramMetric, enabled: gcPausesEnabled},
+		&runtimeMetric{name: mutexWaitMetric, kind: counterMetric},
+	)
+	var reads [][]string
+	r.read = func(m []metrics.Sample) {
+		var names []string
+		for _, s := range m {
+			names = append(names, s.Name)
+		}
+		reads = append(reads, names)
+		metrics.Read(m)
+	}
+
+	values := r.readValues(&st.SV)
+	require.Equal(t, [][]string{{schedLatenciesMetric, mutexWaitMetric}}, reads)
+	require.True(t, values[0].ok)
+	require.Equal(t, coarseBuckets(), values[0].histogram.Buckets)
+	require.Equal(t, runtimeValue{}, values[1]) // not enabled
+	require.True(t, values[2].ok)
+
+	gcPausesEnabled.Override(ctx, &st.SV, true)
+	values = r.readValues(&st.SV)
+	require.Len(t, reads, 2)
+	require.Equal(t, []string{schedLatenciesMetric, gcPausesMetric, mutexWaitMetric}, reads[1])
+	require.True(t, values[1].ok)
+	require.Equal(t, coarseBuckets(), values[1].histogram.Buckets)
+}
+
+// TestRuntimeSamplerWindows registers two independently windowed metrics, a
+// histogram and a counter, verifying that both are windowed, exported and
+// delivered from the same ticks, alongside the scheduler latencies.
+func TestRuntimeSamplerWindows(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+
+	type delivery struct {
+		name             string
+		summary, elapsed time.Duration
+	}
+	var delivered []delivery
+	deliverTo := func(name string) func(context.Context, time.Duration, time.Duration, time.Time) {
+		return func(_ context.Context, summary, elapsed time.Duration, _ time.Time) {
+			delivered = append(delivered, delivery{name, summary, elapsed})
+		}
+	}
+	histogramGauge := metric.NewGauge(metric.Metadata{Name: "test.histogram"})
+	counterGauge := metric.NewGauge(metric.Metadata{Name: "test.counter"})
+	s.mu.runtime = makeRuntimeSampler(&s.mu.histograms, 2,
+		&runtimeMetric{name: schedLatenciesMetric, kind: histogramMetric},
+		&runtimeMetric{
+			name: "/test/histogram:seconds", kind: histogramMetric, independent: true,
+			summarize: windowP99, gauge: histogramGauge, deliver: deliverTo("histogram"),
+		},
+		&runtimeMetric{
+			name: "/test/counter:seconds", kind: counterMetric, independent: true,
+			summarize: windowIncrease, gauge: counterGauge, deliver: deliverTo("counter"),
+		},
+	)
+
+	// Buckets: [0, 1ms), [1ms, +Inf).
+	latencies := &metrics.Float64Histogram{Counts: []uint64{0, 0}, Buckets: []float64{0, 0.001, math.Inf(+1)}}
+	histogram := &metrics.Float64Histogram{Counts: []uint64{0, 0}, Buckets: []float64{0, 0.001, math.Inf(+1)}}
+	var counter float64
+	s.sample = func() runtimeSample {
+		return runtimeSample{
+			latencies: clone(latencies),
+			windowed: []runtimeValue{
+				{histogram: clone(histogram), ok: true},
+				{counter: counter, ok: true},
+			},
+		}
+	}
+	tick := func(events uint64, seconds float64) {
+		latencies.Counts[0] += 100
+		histogram.Counts[1] += events
+		counter += seconds
+		s.tick(ctx)
+	}
+
+	// Neither is delivered until a full window is observed.
+	tick(100, 0.5)
+	tick(100, 0.5)
+	require.Empty(t, delivered)
+	require.Zero(t, histogramGauge.Value())
+	require.Zero(t, counterGauge.Value())
+
+	// Both windows fill up on the same tick, and slide alike. The histogram's
+	// events are all at or above 1ms, and its p99 is interpolated into the
+	// unbounded bucket as its lower bound.
+	tick(100, 0.5)
+	require.Equal(t, []delivery{
+		{"histogram", time.Millisecond, 2 * time.Second},
+		{"counter", time.Second, 2 * time.Second},
+	}, delivered)
+	require.Equal(t, time.Millisecond.Nanoseconds(), histogramGauge.Value())
+	require.Equal(t, time.Second.Nanoseconds(), counterGauge.Value())
+
+	tick(0, 2)
+	require.Len(t, delivered, 4)
+	require.Equal(t, delivery{"counter", 2500 * time.Millisecond, 2 * time.Second}, delivered[3])
+	require.Equal(t, (2500 * time.Millisecond).Nanoseconds(), counterGauge.Value())
+	for _, w := range s.mu.runtime.windows {
+		require.True(t, w.full)
+	}
+	require.Equal(t, []uint64{0, 100}, s.mu.runtime.windows[0].histograms.interval.Counts)
+}
@@ -13,16 +13,22 @@ package schedulerlatency
 import (
 	"context"
 	"fmt"
-	"math"
+	"runtime"
 	"runtime/metrics"
+	"sort"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/ring"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency/histogramutil"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
 )
 
 // samplePeriod controls the duration between consecutive scheduler latency
@@ -36,20 +42,62 @@ import (
 var samplePeriod = settings.RegisterDurationSetting(
 	settings.ApplicationLevel, // used in virtual clusters
 	"scheduler_latency.sample_period",
-	"controls the duration between consecutive scheduler latency samples",
+	"controls the duration between consecutive scheduler latency samples; "+
+		"scheduler_latency.sample_duration must be at least twice as long; "+
+		"0 derives it from GOMAXPROCS, as 800ms/GOMAXPROCS clamped to [1ms, 250ms]",
 	100*time.Millisecond,
 	settings.WithValidateDuration(func(period time.Duration) error {
-		if period < time.Millisecond {
+		if period != 0 && period < time.Millisecond {
 			return fmt.Errorf("minimum sample period is %s, got %s", time.Millisecond, period)
 		}
 		return nil
 	}),
 )
 
+const (
+	// autoSamplePeriodBudget is divided by GOMAXPROCS to derive the sample
+	// period when scheduler_latency.sample_period is 0: larger machines, whose
+	// elastic CPU granter hands out more CPU per tick, are sampled more often.
+	// It's the default period (100ms) on an 8-vCPU machine.
+	autoSamplePeriodBudget = 800 * time.Millisecond
+	// minAutoSamplePeriod and maxAutoSamplePeriod clamp the derived period.
+	minAutoSamplePeriod = time.Millisecond
+	maxAutoSamplePeriod = 250 * time.Millisecond
+)
+
+// autoSamplePeriod derives the sample period from the given GOMAXPROCS, as
+// autoSamplePeriodBudget/GOMAXPROCS, clamped to [minAutoSamplePeriod,
+// maxAutoSamplePeriod]. A GOMAXPROCS of zero, if unknown, is treated as one.
+func autoSamplePeriod(gomaxprocs int) time.Duration {
+	if gomaxprocs < 1 {
+		gomaxprocs = 1
+	}
+	period := autoSamplePeriodBudget / time.Duration(gomaxprocs)
+	if period < minAutoSamplePeriod {
+		return minAutoSamplePeriod
+	}
+	if period > maxAutoSamplePeriod {
+		return maxAutoSamplePeriod
+	}
+	return period
+}
+
+// sampleAlignment aligns every node's sample ticks to the same wall-clock
+// instants, so that windows cover the same intervals across nodes and can be
+// compared.
+var sampleAlignment = settings.RegisterBoolSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.sample_alignment.enabled",
+	"when set, scheduler latency samples are taken at multiples of "+
+		"scheduler_latency.sample_period since the Unix epoch, instead of at an arbitrary phase",
+	false,
+)
+
 var sampleDuration = settings.RegisterDurationSetting(
 	settings.ApplicationLevel, // used in virtual clusters
 	"scheduler_latency.sample_duration",
-	"controls the duration over which each scheduler latency sample is a measurement over",
+	"controls the duration over which each scheduler latency sample is a measurement over; "+
+		"must be at least twice scheduler_latency.sample_period, and is clamped to that otherwise",
 	2500*time.Millisecond,
 	settings.WithValidateDuration(func(duration time.Duration) error {
 		if duration < 100*time.Millisecond {
@@ -59,6 +107,38 @@ var sampleDuration = settings.RegisterDurationSetting(
 	}),
 )
 
+// idleWindowMinEvents is the number of goroutine scheduling events below which
+// a window is considered idle. Percentiles computed over so few events are
+// noise (a single slow event dominates the p99), so they aren't computed at
+// all; see Sample.Idle. If zero, windows are never idle, but those without any
+// events, having no percentiles, aren't delivered.
+var idleWindowMinEvents = settings.RegisterIntSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.idle_window.min_events",
+	"minimum number of goroutine scheduling events observed over a window for scheduler latency "+
+		"percentiles to be computed over it; windows with fewer are considered idle "+
+		"(0 disables idle detection, skipping windows with no events instead)",
+	10,
+	settings.NonNegativeInt,
+)
+
+// gappedWindowFactor is the multiple of the nominal window duration which, if
+// exceeded by the time elapsed over a window, has it flagged as gapped; see
+// Sample.Gapped.
+var gappedWindowFactor = settings.RegisterFloatSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.gapped_window.factor",
+	"multiple of scheduler_latency.sample_duration which, if exceeded by the time elapsed over a "+
+		"window (ticks having been delayed), has the window flagged as gapped",
+	1.5,
+	settings.WithValidateFloat(func(v float64) error {
+		if v < 1 {
+			return errors.Errorf("expected value of at least 1, got: %f", v)
+		}
+		return nil
+	}),
+)
+
 var schedulerLatency = metric.Metadata{
 	Name:        "go.scheduler_latency",
 	Help:        "Go scheduling latency",
@@ -66,8 +146,190 @@ var schedulerLatency = metric.Metadata{
 	Unit:        metric.Unit_NANOSECONDS,
 }
 
+var (
+	metaSamplerTicks = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.ticks",
+		Help:        "Number of ticks processed by the scheduler latency sampler",
+		Measurement: "Ticks",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSamplerSkippedTicks = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.skipped_ticks",
+		Help:        "Number of ticks skipped by the scheduler latency sampler, having fallen behind by more than a sample period",
+		Measurement: "Ticks",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSamplerRebaselines = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.rebaselines",
+		Help:        "Number of times the scheduler latency sampler discarded its window after observing a gap between ticks far exceeding the sample period, or a change in GOMAXPROCS",
+		Measurement: "Rebaselines",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSamplerSampleNanos = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.sample_nanos",
+		Help:        "Time spent by the scheduler latency sampler reading runtime metrics",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaSamplerComputeNanos = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.compute_nanos",
+		Help:        "Time spent by the scheduler latency sampler computing windowed statistics",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaSamplerCallbackNanos = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.callback_nanos",
+		Help:        "Time spent by the scheduler latency sampler invoking callbacks",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaSamplerPeriod = metric.Metadata{
+		Name:        "go.scheduler_latency.sampler.period",
+		Help:        "Sample period in effect for the scheduler latency sampler, derived from GOMAXPROCS if scheduler_latency.sample_period is 0",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaP99EWMA = metric.Metadata{
+		Name:        "go.scheduler_latency.p99_ewma",
+		Help:        "Exponentially weighted moving average of the p99 Go scheduling latency (see scheduler_latency.ewma.alpha)",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaEventsPerSecond = metric.Metadata{
+		Name:        "go.scheduler_latency.events_per_second",
+		Help:        "Rate of goroutine scheduling events over the last scheduler_latency.sample_duration, from which scheduling latency percentiles are computed",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaMutexWait = metric.Metadata{
+		Name:        "go.mutex_wait",
+		Help:        "Time goroutines spent blocked on a sync.Mutex or sync.RWMutex over the last scheduler_latency.sample_duration",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+)
+
+// samplerMetrics capture the overhead of the sampler itself, and the windowed
+// values it computes that aren't exported otherwise. They're process-wide,
+// like the sampler, and registered with every caller's registry.
+type samplerMetrics struct {
+	Ticks                *metric.Counter
+	SkippedTicks         *metric.Counter
+	Rebaselines          *metric.Counter
+	ClampedSettings      *metric.Counter
+	EmptyWindows         *metric.Counter
+	CallbackPanics       *metric.Counter
+	SuppressedDeliveries *metric.Counter
+	DroppedDeliveries    *metric.Counter
+	SampleNanos          *metric.Counter
+	ComputeNanos         *metric.Counter
+	CallbackNanos        *metric.Counter
+	Period               *metric.Gauge
+	Degraded             *metric.Gauge
+	P99EWMA              *metric.Gauge
+	P99RollingMax        *metric.Gauge
+	EventsPerSecond      *metric.GaugeFloat64
+	CPUUtilization       *metric.GaugeFloat64
+	MutexWait            *metric.Gauge
+	GCPauseP99           *metric.Gauge
+	CgroupThrottled      *metric.Gauge
+	WindowedP50          *metric.Gauge
+	WindowedP90          *metric.Gauge
+	WindowedP99          *metric.Gauge
+	WindowedMax          *metric.Gauge
+	WindowedTailRatio    *metric.GaugeFloat64
+	Distribution         metric.IHistogram
+}
+
+var _ metric.Struct = samplerMetrics{}
+
+// MetricStruct implements the metric.Struct interface.
+func (samplerMetrics) MetricStruct() {}
+
+// iterables returns the individual metrics, for registering them and removing
+// them from registries once the sampler is torn down.
+func (m samplerMetrics) iterables() []metric.Iterable {
+	return []metric.Iterable{
+		m.Ticks, m.SkippedTicks, m.Rebaselines, m.ClampedSettings, m.EmptyWindows,
+		m.CallbackPanics, m.SuppressedDeliveries, m.DroppedDeliveries,
+		m.SampleNanos, m.ComputeNanos, m.CallbackNanos, m.Period, m.Degraded,
+		m.P99EWMA, m.P99RollingMax, m.EventsPerSecond, m.CPUUtilization, m.MutexWait, m.GCPauseP99,
+		m.CgroupThrottled,
+		m.WindowedP50, m.WindowedP90, m.WindowedP99, m.WindowedMax, m.WindowedTailRatio,
+		m.Distribution,
+	}
+}
+
+func makeSamplerMetrics() samplerMetrics {
+	return samplerMetrics{
+		Ticks:                metric.NewCounter(metaSamplerTicks),
+		SkippedTicks:         metric.NewCounter(metaSamplerSkippedTicks),
+		Rebaselines:          metric.NewCounter(metaSamplerRebaselines),
+		ClampedSettings:      metric.NewCounter(metaSamplerClampedSettings),
+		EmptyWindows:         metric.NewCounter(metaSamplerEmptyWindows),
+		CallbackPanics:       metric.NewCounter(metaCallbackPanics),
+		SuppressedDeliveries: metric.NewCounter(metaSuppressedDeliveries),
+		DroppedDeliveries:    metric.NewCounter(metaDroppedDeliveries),
+		SampleNanos:          metric.NewCounter(metaSamplerSampleNanos),
+		ComputeNanos:         metric.NewCounter(metaSamplerComputeNanos),
+		CallbackNanos:        metric.NewCounter(metaSamplerCallbackNanos),
+		Period:               metric.NewGauge(metaSamplerPeriod),
+		Degraded:             metric.NewGauge(metaSamplerDegraded),
+		P99EWMA:              metric.NewGauge(metaP99EWMA),
+		P99RollingMax:        metric.NewGauge(metaP99RollingMax),
+		EventsPerSecond:      metric.NewGaugeFloat64(metaEventsPerSecond),
+		CPUUtilization:       metric.NewGaugeFloat64(metaCPUUtilization),
+		MutexWait:            metric.NewGauge(metaMutexWait),
+		GCPauseP99:           metric.NewGauge(metaGCPauseP99),
+		CgroupThrottled:      metric.NewGauge(metaCgroupThrottled),
+		WindowedP50:          metric.NewGauge(metaWindowedP50),
+		WindowedP90:          metric.NewGauge(metaWindowedP90),
+		WindowedP99:          metric.NewGauge(metaWindowedP99),
+		WindowedMax:          metric.NewGauge(metaWindowedMax),
+		WindowedTailRatio:    metric.NewGaugeFloat64(metaWindowedTailRatio),
+		Distribution:         newDistributionHistogram(),
+	}
+}
+
+// SamplerOverhead is the cumulative time spent by the sampler, broken down by
+// what it was spent on.
+type SamplerOverhead struct {
+	Ticks, SkippedTicks        int64
+	Sample, Compute, Callbacks time.Duration
+}
+
+// TestingSamplerOverhead returns the cumulative overhead of the running
+// sampler, or false if it isn't running.
+func TestingSamplerOverhead() (SamplerOverhead, bool) {
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return SamplerOverhead{}, false
+	}
+	return s.overhead(), true
+}
+
 // StartSampler spawn a goroutine to periodically sample the scheduler latencies
 // and invoke all registered callbacks.
+//
+// The Go scheduler is process-wide, so there's a single sampler per process.
+// Repeated calls (from multiple servers in a test cluster, or from
+// shared-process tenants) attach the given listener and metric registry to the
+// already running sampler instead of starting another one; each listener is
+// invoked once per tick. The sampler is driven by the settings and stopper of
+// the caller that started it; if that stopper quiesces before the others', the
+// sampler is handed off to one of the remaining callers. Once every caller's
+// stopper has quiesced, the sampler is torn down and a subsequent call starts
+// a fresh one.
+//
+// The given registry may be nil, for the metrics not to be exported. With
+// nothing to consume its results (no listeners, callbacks, metrics or
+// exporters), the sampler pauses, and resumes once there's something.
+//
+// The given time source drives both the sampler's ticks and those used to
+// export stats, and timestamps samples; tests can use a timeutil.ManualTime to
+// tick deterministically. If nil, the real clock is used.
 func StartSampler(
 	ctx context.Context,
 	st *cluster.Settings,
@@ -75,147 +337,1278 @@ func StartSampler(
 	registry *metric.Registry,
 	statsInterval time.Duration,
 	listener LatencyObserver,
+	timeSource timeutil.TimeSource,
 ) error {
-	return stopper.RunAsyncTask(ctx, "scheduler-latency-sampler", func(ctx context.Context) {
-		settingsValuesMu := struct {
-			syncutil.Mutex
-			period, duration time.Duration
-		}{}
-
-		settingsValuesMu.period = samplePeriod.Get(&st.SV)
-		settingsValuesMu.duration = sampleDuration.Get(&st.SV)
-
-		s := newSampler(settingsValuesMu.period, settingsValuesMu.duration, listener)
-		_ = stopper.RunAsyncTask(ctx, "export-scheduler-stats", func(ctx context.Context) {
-			// cpuSchedulerLatencyBuckets are prometheus histogram buckets
-			// suitable for a histogram that records a (second-denominated)
-			// quantity where measurements correspond to delays in scheduling
-			// goroutines onto processors, i.e. are in the {micro,milli}-second
-			// range during normal operation. See TestHistogramBuckets for more
-			// details.
-			cpuSchedulerLatencyBuckets := reBucketExpAndTrim(
-				sample().Buckets,                   // original buckets
-				1.1,                                // base
-				(50 * time.Microsecond).Seconds(),  // min
-				(100 * time.Millisecond).Seconds(), // max
-			)
-
-			schedulerLatencyHistogram := newRuntimeHistogram(schedulerLatency, cpuSchedulerLatencyBuckets)
-			registry.AddMetric(schedulerLatencyHistogram)
-
-			ticker := time.NewTicker(statsInterval) // compute periodic stats
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-stopper.ShouldQuiesce():
-					return
-				case <-ticker.C:
-					lastIntervalHistogram := s.lastIntervalHistogram()
-					if lastIntervalHistogram == nil {
-						continue
-					}
-
-					schedulerLatencyHistogram.update(lastIntervalHistogram)
-				}
-			}
-		})
-
-		ticker := time.NewTicker(settingsValuesMu.period)
+	if timeSource == nil {
+		timeSource = timeutil.DefaultTimeSource{}
+	}
+	s, a, err := attach(ctx, st, stopper, listener, timeSource)
+	if err != nil {
+		return err
+	}
+	// The metrics are registered before returning, for them to be exported
+	// (and unregistered) along with the caller's.
+	schedulerLatencyHistogram := newRuntimeHistogram(schedulerLatency, cpuSchedulerLatencyBuckets())
+	s.registerMetrics(a, registry, schedulerLatencyHistogram)
+	ticker := timeSource.NewTicker(statsInterval) // compute periodic stats
+	if err := stopper.RunAsyncTask(ctx, "export-scheduler-stats", func(ctx context.Context) {
+		defer detach(s, a)
 		defer ticker.Stop()
-		samplePeriod.SetOnChange(&st.SV, func(ctx context.Context) {
-			period := samplePeriod.Get(&st.SV)
-			settingsValuesMu.Lock()
-			defer settingsValuesMu.Unlock()
-			settingsValuesMu.period = period
-			ticker.Reset(period)
-			s.setPeriodAndDuration(settingsValuesMu.period, settingsValuesMu.duration)
-		})
-		sampleDuration.SetOnChange(&st.SV, func(ctx context.Context) {
-			duration := sampleDuration.Get(&st.SV)
-			settingsValuesMu.Lock()
-			defer settingsValuesMu.Unlock()
-			settingsValuesMu.duration = duration
-			s.setPeriodAndDuration(settingsValuesMu.period, settingsValuesMu.duration)
-		})
-
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-stopper.ShouldQuiesce():
 				return
-			case <-ticker.C:
-				period := func() time.Duration {
-					settingsValuesMu.Lock()
-					defer settingsValuesMu.Unlock()
-					return settingsValuesMu.period
-				}()
-				s.sampleOnTickAndInvokeCallbacks(period)
+			case <-ticker.Ch():
+				lastIntervalHistogram := s.lastIntervalHistogram()
+				if lastIntervalHistogram == nil {
+					continue
+				}
+
+				schedulerLatencyHistogram.update(lastIntervalHistogram)
+			}
+		}
+	}); err != nil {
+		ticker.Stop()
+		detach(s, a)
+		return err
+	}
+	return nil
+}
+
+// shared is the process-wide sampler, shared across all StartSampler callers.
+var shared struct {
+	syncutil.Mutex
+	s        *sampler      // nil if there are no attached callers
+	attached []*attachment // callers attached to s
+}
+
+// attachment is a StartSampler caller attached to the shared sampler.
+type attachment struct {
+	// ctx is used to start the sampler's tick loop, if it's handed off to
+	// this caller.
+	ctx        context.Context
+	st         *cluster.Settings
+	stopper    *stop.Stopper
+	listener   LatencyObserver
+	timeSource timeutil.TimeSource
+}
+
+// attach the given caller to the shared sampler, creating and starting it if
+// needed.
+func attach(
+	ctx context.Context,
+	st *cluster.Settings,
+	stopper *stop.Stopper,
+	listener LatencyObserver,
+	timeSource timeutil.TimeSource,
+) (*sampler, *attachment, error) {
+	shared.Lock()
+	defer shared.Unlock()
+
+	s := shared.s
+	if s == nil {
+		// The options are derived from the settings; they're kept up to date
+		// as the settings change once the tick loop starts.
+		s = newSamplerWithOptions(st, SamplerOptions{
+			Period:    samplePeriod.Get(&st.SV),
+			Duration:  sampleDuration.Get(&st.SV),
+			Listeners: []LatencyObserver{newOverloadMonitor(ctx, st)},
+		})
+		latest.Store(nil) // we're yet to observe a full window
+	}
+	a := &attachment{
+		ctx: ctx, st: st, stopper: stopper, listener: listener, timeSource: timeSource,
+	}
+	if !s.running {
+		if err := s.startLocked(a); err != nil {
+			return nil, nil, err
+		}
+	}
+	shared.s = s
+	shared.attached = append(shared.attached, a)
+	s.addListener(listener)
+	return s, a, nil
+}
+
+// detach the given caller from the shared sampler, tearing it down if it was
+// the last one.
+func detach(s *sampler, a *attachment) {
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.s != s {
+		return // already torn down
+	}
+	for i := range shared.attached {
+		if shared.attached[i] == a {
+			shared.attached = append(shared.attached[:i], shared.attached[i+1:]...)
+			break
+		}
+	}
+	if len(shared.attached) == 0 {
+		// Flush the final sample before the caller's listener is removed, for
+		// it to be delivered to.
+		s.flushFinal(a.ctx)
+	}
+	s.removeListener(a.listener)
+	s.unregisterMetrics(a)
+	if len(shared.attached) == 0 {
+		shared.s, shared.attached = nil, nil
+		s.close()
+	}
+}
+
+// close tears down the sampler once every caller has detached, on both the
+// context cancellation and stopper quiescence paths. It releases the samples
+// and histograms retained, clears the snapshot served by Latest, and removes
+// the metrics registered by any remaining caller. A closed sampler ignores
+// ticks that race with it; subsequent StartSampler calls create a new one.
+func (s *sampler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.closed = true
+	s.mu.listeners = nil
+	s.mu.ringBuffer.Discard()
+	s.mu.lastIntervalHistogram, s.mu.lastInterval = nil, nil
+	s.mu.lastWindow = window{}
+	s.mu.debugResults.Discard()
+	s.mu.heatmap.clear()
+	s.mu.latestCumulative = nil
+	s.mu.aggregateIntervalHistogram = nil
+	s.mu.trend.reset()
+	s.mu.ewma.reset()
+	s.mu.rollingMax.reset()
+	s.mu.runtime.reset()
+	if s.mu.overload.reset() {
+		// Don't leave the callbacks believing the server overloaded, there
+		// being no sampler to tell them otherwise.
+		s.invokeOverloadCallbacksLocked(context.Background(), false)
+	}
+	for a, r := range s.mu.registrations {
+		for _, m := range r.metrics {
+			r.registry.RemoveMetric(m)
+		}
+		delete(s.mu.registrations, a)
+	}
+	latest.Store(nil)
+}
+
+// registration is the set of metrics registered with a caller's registry.
+type registration struct {
+	registry *metric.Registry
+	metrics  []metric.Iterable
+}
+
+// registerMetrics adds the sampler's metrics, and the given caller-specific
+// ones, to the given caller's registry, if any.
+func (s *sampler) registerMetrics(
+	a *attachment, registry *metric.Registry, metrics ...metric.Iterable,
+) {
+	if registry == nil {
+		return
+	}
+	metrics = append(metrics, s.metrics.iterables()...)
+	for _, m := range metrics {
+		registry.AddMetric(m)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.registrations[a] = registration{registry: registry, metrics: metrics}
+}
+
+// unregisterMetrics removes the metrics added through registerMetrics for the
+// given caller, if any.
+func (s *sampler) unregisterMetrics(a *attachment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.mu.registrations[a]
+	if !ok {
+		return
+	}
+	for _, m := range r.metrics {
+		r.registry.RemoveMetric(m)
+	}
+	delete(s.mu.registrations, a)
+}
+
+// startLocked starts the sampler's tick loop, and the snapshot logger's
+// goroutine, using the given caller's settings and stopper. shared must be
+// locked.
+func (s *sampler) startLocked(a *attachment) error {
+	// The caller's settings and time source are in effect as soon as it's
+	// started, not once the tick loop gets around to running.
+	s.setSettings(a.st, a.timeSource)
+	s.validateRuntimeMetrics(a.ctx)
+	// The snapshot logger's goroutine is started first, so that it's running
+	// by the time the tick loop hands it snapshots. It stops along with the
+	// tick loop, and is restarted along with it on handoff.
+	if err := a.stopper.RunAsyncTask(a.ctx, "scheduler-latency-snapshot-logger", func(ctx context.Context) {
+		s.snapshots.run(ctx, a.stopper)
+	}); err != nil {
+		return err
+	}
+	// The ticker is created before returning, for the sampler to tick a period
+	// after it's started rather than after its goroutine gets around to it.
+	ticker := a.timeSource.NewTicker(s.periodInEffect())
+	_, watched := s.watched[a.st]
+	if err := a.stopper.RunAsyncTask(a.ctx, "scheduler-latency-sampler", func(ctx context.Context) {
+		defer ticker.Stop()
+		if !s.standalone {
+			// The settings are applied now and whenever they change, having the
+			// tick loop reset its ticker; standalone samplers are configured
+			// once and for all, through their options. The callbacks only take
+			// effect while a caller with these settings drives the tick loop,
+			// so the settings of the callers it was handed off from are
+			// ignored.
+			st := a.st
+			if watched {
+				s.applySettings(ctx, samplePeriod.Get(&st.SV), sampleDuration.Get(&st.SV))
+				s.signalResetTicks()
+			} else {
+				driving := func() bool { return s.drivenBy(st) }
+				s.watchSettings(ctx, st, func(time.Duration) { s.signalResetTicks() }, driving)
+				sampleAlignment.SetOnChange(&st.SV, func(context.Context) {
+					if driving() {
+						s.signalResetTicks()
+					}
+				})
 			}
 		}
+		s.run(ctx, a.st, a.stopper, a.timeSource, ticker)
+		s.handoff(a)
+	}); err != nil {
+		ticker.Stop()
+		return err
+	}
+	if !s.standalone && !watched {
+		if s.watched == nil {
+			s.watched = make(map[*cluster.Settings]struct{})
+		}
+		s.watched[a.st] = struct{}{}
+	}
+	s.running, s.driver = true, a
+	return nil
+}
+
+// drivenBy returns whether the sampler is the shared one, with its tick loop
+// driven by a caller with the given settings.
+func (s *sampler) drivenBy(st *cluster.Settings) bool {
+	shared.Lock()
+	defer shared.Unlock()
+	return shared.s == s && s.driver != nil && s.driver.st == st
+}
+
+// handoff is invoked when the sampler's tick loop, started using the given
+// caller's stopper, exits. It restarts the loop using some other attached
+// caller, if any.
+func (s *sampler) handoff(from *attachment) {
+	shared.Lock()
+	defer shared.Unlock()
+
+	s.running, s.driver = false, nil
+	if shared.s != s {
+		return // already torn down
+	}
+	for _, a := range shared.attached {
+		if a == from {
+			continue
+		}
+		if err := s.startLocked(a); err == nil {
+			return
+		}
+		// The caller's stopper is quiescing, try the next one.
+	}
+}
+
+// run is the sampler's tick loop, driven by the given ticker; it returns when
+// the given stopper quiesces.
+func (s *sampler) run(
+	ctx context.Context,
+	st *cluster.Settings,
+	stopper *stop.Stopper,
+	timeSource timeutil.TimeSource,
+	ticker timeutil.TickerI,
+) {
+	// When aligning ticks to wall-clock boundaries, aligner fires at the next
+	// boundary, at which point the ticker is reset to tick every period from
+	// there on. Ticks received while waiting on it have an arbitrary phase, and
+	// are ignored.
+	aligner := timeSource.NewTimer()
+	defer aligner.Stop()
+	var aligning bool
+	// The ticker is reset (or re-aligned) by this goroutine whenever the period
+	// in effect or the alignment setting changes, to not race with it.
+	resetTicker := func() {
+		period := s.periodInEffect()
+		aligning = sampleAlignment.Get(&st.SV)
+		if aligning {
+			aligner.Reset(untilAligned(timeSource.Now(), period))
+		} else {
+			aligner.Stop()
+			ticker.Reset(period)
+		}
+	}
+	if sampleAlignment.Get(&st.SV) {
+		s.signalResetTicks()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if s.standalone {
+				s.flushFinal(ctx)
+			}
+			return
+		case <-stopper.ShouldQuiesce():
+			// The shared sampler is flushed once every caller has detached
+			// instead (see detach), not when handed off.
+			if s.standalone {
+				s.flushFinal(ctx)
+			}
+			return
+		case <-s.resetTicks:
+			resetTicker()
+		case <-aligner.Ch():
+			aligner.MarkRead()
+			aligning = false
+			period := s.periodInEffect()
+			ticker.Reset(period)
+			drain(ticker.Ch())
+			s.sampleOnTickAndInvokeCallbacks(ctx, period)
+		case scheduled := <-ticker.Ch():
+			if aligning {
+				continue
+			}
+			if s.maybeExpireOverride() {
+				// The temporary period override expired; the configured
+				// period resumes.
+				resetTicker()
+				if aligning {
+					continue
+				}
+			}
+			period := s.periodInEffect()
+			if s.maybeSkipTick(ctx, scheduled, timeSource.Now(), period) {
+				continue
+			}
+			s.sampleOnTickAndInvokeCallbacks(ctx, period)
+		}
+	}
+}
+
+// maybeSkipTick returns true, counting the tick as skipped, if the tick
+// scheduled at the given time is processed more than a sample period late:
+// processing earlier ticks took longer than the sample period and this one was
+// queued up behind them. It's skipped instead of processing ticks
+// back-to-back, so we catch up.
+func (s *sampler) maybeSkipTick(
+	ctx context.Context, scheduled, now time.Time, period time.Duration,
+) bool {
+	if now.Sub(scheduled) <= period {
+		return false
+	}
+	s.recordAnomaly(ctx, anomalySkippedTick, now)
+	return true
+}
+
+// untilAligned returns the duration from now until the next multiple of the
+// given period since the Unix epoch; it's zero if now is one.
+func untilAligned(now time.Time, period time.Duration) time.Duration {
+	if rem := time.Duration(now.UnixNano()) % period; rem != 0 {
+		return period - rem
+	}
+	return 0
+}
+
+// drain discards the ticks queued up in the given channel.
+func drain(ch <-chan time.Time) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
+
+// watchSettings applies the sample period and duration settings to the
+// sampler, now and whenever they change, resetting the ticker through the
+// given function when the period changes. If active is non-nil, the changes
+// are only applied while it returns true. It returns a function to retrieve
+// the current period.
+func (s *sampler) watchSettings(
+	ctx context.Context,
+	st *cluster.Settings,
+	resetTicker func(time.Duration),
+	active func() bool,
+) (getPeriod func() time.Duration) {
+	settingsValuesMu := struct {
+		syncutil.Mutex
+		period, duration time.Duration
+	}{}
+	// apply must be called with settingsValuesMu held.
+	apply := func(ctx context.Context) {
+		s.applySettings(ctx, settingsValuesMu.period, settingsValuesMu.duration)
+	}
+
+	settingsValuesMu.period = samplePeriod.Get(&st.SV)
+	settingsValuesMu.duration = sampleDuration.Get(&st.SV)
+	apply(ctx)
+
+	samplePeriod.SetOnChange(&st.SV, func(ctx context.Context) {
+		period := samplePeriod.Get(&st.SV)
+		settingsValuesMu.Lock()
+		defer settingsValuesMu.Unlock()
+		settingsValuesMu.period = period
+		if active == nil || active() {
+			apply(ctx)
+			resetTicker(period)
+		}
+	})
+	sampleDuration.SetOnChange(&st.SV, func(ctx context.Context) {
+		duration := sampleDuration.Get(&st.SV)
+		settingsValuesMu.Lock()
+		defer settingsValuesMu.Unlock()
+		settingsValuesMu.duration = duration
+		if active == nil || active() {
+			apply(ctx)
+		}
 	})
+	return func() time.Duration {
+		settingsValuesMu.Lock()
+		defer settingsValuesMu.Unlock()
+		return settingsValuesMu.period
+	}
+}
+
+// applySettings sets the given sample period and duration settings, clamping
+// the duration if it's too short for the period.
+func (s *sampler) applySettings(ctx context.Context, period, duration time.Duration) {
+	if err := validatePeriodAndDuration(period, duration); err != nil {
+		// The settings are validated individually, so we can't reject the
+		// update; clamp the duration instead.
+		duration = minSamplesPerWindow * period
+		log.Warningf(ctx, "%v; using a sample duration of %s instead", err, duration)
+		s.recordAnomaly(ctx, anomalyClampedSetting, timeutil.Now())
+	}
+	s.setPeriodAndDuration(period, duration)
+}
+
+// maxWindowSamples is the maximum number of sample periods spanned by a window,
+// the sampler's own or a listener's, bounding the samples retained by the ring
+// buffer (each with a copy of the latency histogram). It's 10s worth at the
+// minimum scheduler_latency.sample_period of 1ms; windows are capped to it at
+// shorter periods.
+const maxWindowSamples = 10000
+
+// minSamplesPerWindow is the minimum number of sample periods spanned by the
+// sample duration; with fewer, p99s are computed over a single period and are
+// unduly jittery.
+const minSamplesPerWindow = 2
+
+// validatePeriodAndDuration checks that the sample duration spans at least
+// minSamplesPerWindow sample periods.
+func validatePeriodAndDuration(period, duration time.Duration) error {
+	if duration < minSamplesPerWindow*period {
+		return errors.Newf("%s (%s) must be at least %d times %s (%s)",
+			sampleDuration.Name(), duration, minSamplesPerWindow, samplePeriod.Name(), period)
+	}
+	return nil
 }
 
 // sampler contains the local state maintained across scheduler latency samples.
 type sampler struct {
-	listener LatencyObserver
-	mu       struct {
+	// sample is used to sample the cumulative runtime metrics we track; it's
+	// overridden in tests to inject values.
+	sample func() runtimeSample
+	// describe lists the metrics supported by the runtime; it's metrics.All
+	// unless overridden in tests.
+	describe func() []metrics.Description
+	running  bool // whether the tick loop is running; guarded by shared
+	// driver is the caller whose settings and stopper drive the tick loop,
+	// while it's running, and watched are the settings the sampler has
+	// registered callbacks with, once per settings as they can't be
+	// unregistered; both are guarded by shared.
+	driver  *attachment
+	watched map[*cluster.Settings]struct{}
+	// standalone is set for samplers constructed using NewSampler. They aren't
+	// driven by the cluster settings, and are private to their embedder: they
+	// don't publish to Latest, nor do they run the callbacks registered with
+	// this package.
+	standalone bool
+	// percentiles are computed over every full window, and delivered as
+	// Sample.Percentiles; see SamplerOptions.Percentiles.
+	percentiles []float64
+	metrics     samplerMetrics
+	mu          struct {
 		syncutil.Mutex
-		ringBuffer            ring.Buffer[*metrics.Float64Histogram]
+		st *cluster.Settings
+		// timeSource is used to timestamp samples.
+		timeSource            timeutil.TimeSource
+		listeners             []listenerState
+		ringBuffer            ring.Buffer[runtimeSample]
 		lastIntervalHistogram *metrics.Float64Histogram
+		// lastInterval holds the prefix sums of lastIntervalHistogram, computed
+		// once per tick for the percentiles queried over it until the next one.
+		lastInterval *histogramutil.PrefixSums
+		// lastWindow contains the values computed alongside
+		// lastIntervalHistogram.
+		lastWindow window
+		// debugResults are the results computed over the most recent full
+		// windows, most recent first, up to maxDebugResults (see Dump).
+		debugResults ring.Buffer[DebugResult]
+		// heatmap retains the bucket counts served by HandleHeatmap.
+		heatmap latencyHeatmap
+		// histograms recycles the copies of the cumulative histograms retained
+		// by ringBuffer and the runtime sampler's windows.
+		histograms histogramPool
+		// latestCumulative is the most recent cumulative sample, retained
+		// independently of the ring buffer (which is discarded when resized).
+		latestCumulative *metrics.Float64Histogram
+		// aggregateIntervalHistogram is the sum of all interval histograms
+		// observed since the sampler started.
+		aggregateIntervalHistogram *metrics.Float64Histogram
+		breachLogger               breachLogger
+		trend                      p99Trend
+		ewma                       p99EWMA
+		rollingMax                 p99RollingMax
+		// overload is the overload signal delivered to the overload callbacks
+		// and published by Latest; it's only computed by the shared sampler.
+		overload overloadSignal
+		// runtime reads the runtime metrics sampled every tick, and windows the
+		// ones windowed independently of ringBuffer, over windows sized like it
+		// (GC pauses, if scheduler_latency.gc_pauses.enabled is set).
+		runtime runtimeSampler
+		// cgroupThrottling reads the cgroup CPU throttling counters for the
+		// runtime sampler, if scheduler_latency.cgroup_throttling.enabled is
+		// set.
+		cgroupThrottling cgroupThrottlingSampler
+		// quantilesExport throttles the export of the windowed quantiles, if
+		// scheduler_latency.quantiles_export.enabled is set.
+		quantilesExport deliveryThrottle
+		// registrations are the metrics registered by each attached caller.
+		registrations map[*attachment]registration
+		// closed is set once the sampler is torn down.
+		closed bool
+		// paused is set while the sampler has no consumers; see pauseLocked.
+		paused bool
+		// degraded is set once the runtime is found not to export the
+		// scheduler latency histogram; see degradeLocked.
+		degraded bool
+		// period and duration are the ones in effect, which the ring buffer
+		// was last sized for. They're the configured ones, derived from the
+		// cluster settings, unless a temporary period override is in effect.
+		period, duration time.Duration
+		configured       struct{ period, duration time.Duration }
+		override         periodOverride
+		// windowSamples is the number of sample periods retained for the
+		// sampler's own window: the duration in effect divided by the period,
+		// rounded up, for windows to be able to span the duration even when
+		// it isn't a multiple of the period (see windowLocked). The ring
+		// buffer retains more samples if listeners requested longer windows
+		// (see WithWindowDuration).
+		windowSamples int
+		// reconfigured is set once the period or duration in effect changed,
+		// until the window is next discarded; provisional windows computed in
+		// the meantime are flagged as such. See markReconfiguredLocked.
+		reconfigured bool
+		// gomaxprocs is the most recently observed GOMAXPROCS, from which the
+		// period is derived if scheduler_latency.sample_period is zero.
+		gomaxprocs int
 	}
+	// resetTicks is used to have the tick loop reset its ticker when the
+	// period in effect changes.
+	resetTicks chan struct{}
+	// snapshots records snapshots of the windows computed by the tick loop,
+	// if scheduler_latency.snapshot_log.interval is set. Its goroutine runs
+	// alongside the tick loop.
+	snapshots snapshotLogger
+	// anomalyLogs rate-limits the summaries logged for every kind of anomaly;
+	// see recordAnomaly.
+	anomalyLogs [numAnomalyKinds]anomalyLog
 }
 
-func newSampler(period, duration time.Duration, listener LatencyObserver) *sampler {
-	s := &sampler{listener: listener}
-	s.mu.ringBuffer = ring.MakeBuffer(([]*metrics.Float64Histogram)(nil))
+func newSampler(st *cluster.Settings, period, duration time.Duration) *sampler {
+	s := &sampler{
+		metrics:    makeSamplerMetrics(),
+		resetTicks: make(chan struct{}, 1),
+		snapshots:  makeSnapshotLogger(),
+	}
+	// The sample function is invoked with s.mu held.
+	s.sample = func() runtimeSample { return s.mu.runtime.sample(&s.mu.st.SV) }
+	s.describe = metrics.All
+	s.mu.st = st
+	s.mu.timeSource = timeutil.DefaultTimeSource{}
+	s.mu.ringBuffer = ring.MakeBuffer(([]runtimeSample)(nil))
+	s.mu.breachLogger = makeBreachLogger()
+	s.mu.registrations = make(map[*attachment]registration)
+	s.mu.gomaxprocs = runtime.GOMAXPROCS(0)
+	ms := []*runtimeMetric{
+		{name: schedLatenciesMetric, kind: histogramMetric},
+		{name: mutexWaitMetric, kind: counterMetric},
+		{name: gomaxprocsMetric, kind: gaugeMetric},
+	}
+	ms = append(ms, cpuClassesRuntimeMetrics()...)
+	ms = append(ms, s.gcPausesRuntimeMetric(), s.cgroupThrottlingRuntimeMetric())
+	s.mu.cgroupThrottling = makeCgroupThrottlingSampler()
+	s.mu.runtime = makeRuntimeSampler(&s.mu.histograms, 1, ms...)
 	s.setPeriodAndDuration(period, duration)
 	return s
 }
 
+func (s *sampler) setSettings(st *cluster.Settings, timeSource timeutil.TimeSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.st = st
+	s.mu.timeSource = timeSource
+}
+
+// listenerState is a listener added to the sampler, and its deliveries.
+type listenerState struct {
+	listener LatencyObserver // as added, and removed
+	target   LatencyObserver // the listener to invoke, possibly unwrapped
+	priority int
+	window   listenerWindow
+	throttle deliveryThrottle
+	deltas   deltaSuppressor
+	panics   panicTracker
+	// previous is the most recent full window delivered to the listener since
+	// the sampler last re-baselined, if any; see Sample.Previous.
+	previous *PreviousSample
+}
+
+// addListener adds a listener invoked on every tick, or less often if it was
+// wrapped using WithMinDeliveryInterval or WithDeltaSuppression; nil listeners
+// are ignored. Listeners are kept in the order they're invoked in: by priority
+// (see WithPriority), then in the order they were added. Listeners wrapped
+// using WithWindowDuration are delivered their own windows; the ring buffer is
+// resized for them on the next tick.
+func (s *sampler) addListener(listener LatencyObserver) {
+	if listener == nil {
+		return
+	}
+	state := listenerState{listener: listener, target: listener, priority: DefaultPriority}
+	for unwrapped := false; !unwrapped; {
+		switch l := state.target.(type) {
+		case *intervalListener:
+			state.target, state.throttle.interval = l.LatencyObserver, l.interval
+		case *priorityListener:
+			state.target, state.priority = l.LatencyObserver, l.priority
+		case *windowListener:
+			state.target, state.window.duration = l.LatencyObserver, l.duration
+		case *deltaListener:
+			state.target, state.deltas.listener = l.LatencyObserver, l
+		default:
+			unwrapped = true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.mu.listeners), func(i int) bool {
+		return s.mu.listeners[i].priority > state.priority
+	})
+	s.mu.listeners = append(s.mu.listeners, listenerState{})
+	copy(s.mu.listeners[i+1:], s.mu.listeners[i:])
+	s.mu.listeners[i] = state
+}
+
+// removeListener removes a listener previously added through addListener.
+func (s *sampler) removeListener(listener LatencyObserver) {
+	if listener == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.mu.listeners {
+		if s.mu.listeners[i].listener == listener {
+			s.mu.listeners = append(s.mu.listeners[:i], s.mu.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// setPeriodAndDuration sets the configured sample period and duration.
 func (s *sampler) setPeriodAndDuration(period, duration time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.mu.ringBuffer.Discard()
-	numSamples := int(duration / period)
+	s.mu.configured.period, s.mu.configured.duration = period, duration
+	s.applyPeriodLocked()
+}
+
+// applyPeriodLocked applies the configured sample period and duration, or the
+// temporary period override if one is in effect, re-baselining and resizing
+// the ring buffer if they changed. A configured period of zero is derived from
+// GOMAXPROCS (see autoSamplePeriod). It returns true if the period in effect changed.
+func (s *sampler) applyPeriodLocked() (changed bool) {
+	period, duration := s.mu.configured.period, s.mu.configured.duration
+	if period == 0 {
+		period = autoSamplePeriod(s.mu.gomaxprocs)
+	}
+	if s.mu.override.period != 0 {
+		period = s.mu.override.period
+	}
+	var clamped bool
+	if period != s.mu.configured.period && duration < minSamplesPerWindow*period {
+		// The duration was validated against the configured period, not the
+		// one derived from GOMAXPROCS or overridden.
+		duration, clamped = minSamplesPerWindow*period, true
+	}
+	if duration > maxWindowSamples*period {
+		// Bound the samples retained, shortening the window at (sub-millisecond)
+		// periods that would otherwise have it retain far too many.
+		duration, clamped = maxWindowSamples*period, true
+	}
+	if s.mu.period == period && s.mu.duration == duration {
+		return false // nothing to do, retain the samples we have
+	}
+	if clamped {
+		s.recordAnomaly(context.Background(), anomalyClampedSetting, s.mu.timeSource.Now())
+	}
+	changed = s.mu.period != period
+	previousPeriod := s.mu.period
+	s.mu.period, s.mu.duration = period, duration
+	s.metrics.Period.Update(period.Nanoseconds())
+	numSamples := int((duration + period - 1) / period)
 	if numSamples < 1 {
 		numSamples = 1 // we need at least one sample to compare (also safeguards against integer division)
 	}
-	s.mu.ringBuffer.Resize(numSamples)
-	s.mu.lastIntervalHistogram = nil
+	s.mu.windowSamples = numSamples
+	s.resetWindowLocked()
+	s.markReconfiguredLocked(previousPeriod)
+	s.sizeRingLocked()
+	s.mu.runtime.resize(numSamples)
+	return changed
+}
+
+// periodInEffect returns the sample period in effect.
+func (s *sampler) periodInEffect() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.period
+}
+
+// signalResetTicks has the tick loop reset its ticker to the period in effect.
+func (s *sampler) signalResetTicks() {
+	select {
+	case s.resetTicks <- struct{}{}:
+	default:
+	}
+}
+
+// rebaselineGapMultiple is the multiple of the sample period that, if elapsed
+// between consecutive ticks, has the sampler re-baseline.
+const rebaselineGapMultiple = 10
+
+// ResetWindow has the running sampler, if any, discard the samples it retains
+// and re-baseline: callbacks aren't invoked until a full fresh window is
+// observed. It's meant for when the process is known to have been suspended
+// (VM suspension, live migration), where the first window spanning the gap
+// would be meaningless. The sampler also does so automatically when the time
+// elapsed between ticks exceeds rebaselineGapMultiple sample periods, and when
+// GOMAXPROCS changes (as it does when the process's CPU limit is resized).
+func ResetWindow() {
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetWindowLocked()
+}
+
+// resetWindowLocked discards the samples retained, re-baselining the sampler.
+// The ring buffer retains its capacity, which discarding it wouldn't. The
+// histograms retained aren't recycled: the latest one is still referenced, as
+// s.mu.latestCumulative.
+func (s *sampler) resetWindowLocked() {
+	for s.mu.ringBuffer.Len() > 0 {
+		s.mu.ringBuffer.RemoveLast()
+	}
+	s.mu.runtime.reset()
+	s.mu.lastIntervalHistogram, s.mu.lastInterval = nil, nil
+	s.mu.reconfigured = false
+	s.forgetPreviousLocked()
 }
 
 // sampleOnTickAndInvokeCallbacks samples scheduler latency stats as the ticker
 // has ticked. It invokes all callbacks registered with this package.
-func (s *sampler) sampleOnTickAndInvokeCallbacks(period time.Duration) {
+func (s *sampler) sampleOnTickAndInvokeCallbacks(ctx context.Context, period time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.mu.closed || s.mu.degraded {
+		return // raced with teardown, or there's nothing to sample
+	}
+	if !s.hasConsumersLocked() {
+		s.pauseLocked(ctx)
+		return
+	}
+	if s.mu.paused {
+		// This sample is the new baseline, the ring having been drained.
+		log.Infof(ctx, "resuming the scheduler latency sampler")
+		s.mu.paused = false
+	}
 
-	latestCumulative := sample()
-	oldestCumulative, ok := s.recordLocked(latestCumulative)
-	if !ok {
+	// Measure our own overhead, reading the clock once at the boundary between
+	// each section.
+	start := timeutil.Now()
+	s.metrics.Ticks.Inc(1)
+	latestCumulative := s.sample()
+	latestCumulative.at = s.mu.timeSource.Now()
+	sampled := timeutil.Now()
+	s.metrics.SampleNanos.Inc(sampled.Sub(start).Nanoseconds())
+	if latestCumulative.latencies == nil {
+		s.degradeLocked(ctx, errors.Newf("runtime metric %s is no longer a histogram", schedLatenciesMetric))
 		return
 	}
-	s.mu.lastIntervalHistogram = sub(latestCumulative, oldestCumulative)
-	p99 := time.Duration(int64(percentile(s.mu.lastIntervalHistogram, 0.99) * float64(time.Second.Nanoseconds())))
 
-	// Perform the callback if there's a listener.
-	if s.listener != nil {
-		s.listener.SchedulerLatency(p99, period)
+	if err := checkMonotonic(s.mu.latestCumulative, latestCumulative.latencies); err != nil {
+		if buildutil.CrdbTestBuild {
+			// Catch it loudly in tests: it's either a runtime bug, or one of
+			// ours, reusing the memory of a histogram still retained.
+			panic(err)
+		}
+		// The intervals spanning the decrease would be garbage; start afresh
+		// from this sample, as if it were the first.
+		log.Warningf(ctx, "%v, re-baselining scheduler latency samples", err)
+		s.resetWindowLocked()
+		s.mu.latestCumulative = nil
+		s.mu.heatmap.clear()
+		s.recordAnomaly(ctx, anomalyRebaseline, latestCumulative.at)
 	}
+	if s.mu.ringBuffer.Len() > 0 {
+		prev := s.mu.ringBuffer.GetFirst()
+		gap := latestCumulative.at.Sub(prev.at)
+		if gap > rebaselineGapMultiple*period {
+			// The process was likely suspended; the window spanning the gap
+			// would be meaningless, so start afresh from this sample.
+			log.Infof(ctx, "%s elapsed since the last scheduler latency sample (period %s), re-baselining",
+				gap, period)
+			s.resetWindowLocked()
+			s.recordAnomaly(ctx, anomalyRebaseline, latestCumulative.at)
+		} else if cur := latestCumulative.gomaxprocs; prev.gomaxprocs != 0 && cur != 0 && prev.gomaxprocs != cur {
+			// The latencies observed with a different number of Ps don't
+			// compare; don't blend them into the windows to come.
+			log.Infof(ctx, "GOMAXPROCS changed from %d to %d, re-baselining scheduler latency samples",
+				prev.gomaxprocs, cur)
+			s.resetWindowLocked()
+			s.recordAnomaly(ctx, anomalyRebaseline, latestCumulative.at)
+		}
+	}
+
+	if g := latestCumulative.gomaxprocs; g != 0 && g != s.mu.gomaxprocs {
+		s.mu.gomaxprocs = g
+		if s.mu.configured.period == 0 && s.applyPeriodLocked() {
+			// The period derived from GOMAXPROCS changed; this sample is the
+			// new baseline, and the ticker is reset to the new period.
+			s.signalResetTicks()
+		}
+	}
+
+	w, ok := s.computeLocked(ctx, latestCumulative, period)
+	s.mu.runtime.record(latestCumulative.windowed, latestCumulative.at)
+	computed := timeutil.Now()
+	s.metrics.ComputeNanos.Inc(computed.Sub(sampled).Nanoseconds())
+	if !ok || w.provisional {
+		// We're yet to observe a full window, having just started or
+		// re-baselined; don't compute the trend across the gap in deliveries.
+		s.mu.trend.reset()
+		s.mu.ewma.reset()
+		if ok {
+			// Deliver the provisional window to listeners that can tell it
+			// apart.
+			s.invokeListenersLocked(ctx, Sample{
+				P99: w.p99, StdDev: w.stddev, Events: w.events, Period: period, At: w.at, Elapsed: w.elapsed,
+				Idle: w.idle, Gapped: w.gapped, Provisional: true,
+				GOMAXPROCS: w.gomaxprocs, LatencyRatio: w.latencyRatio(), TailRatio: w.tailRatio(),
+				EventsPerSecond: w.eventsPerSecond(), CPUUtilization: w.cpuUtilization,
+				Window: w.config(period), Reconfigured: w.reconfigured,
+			})
+			s.metrics.CallbackNanos.Inc(timeutil.Since(computed).Nanoseconds())
+		}
+		return
+	}
+	// Unlike the trend, the rolling maximum isn't reset when re-baselining:
+	// it's tracked over wall time, and deliveries age out regardless.
+	horizon := rollingMaxHorizon.Get(&s.mu.st.SV)
+	if horizon == 0 {
+		s.mu.rollingMax.reset()
+	}
+	var slope, ewma, rollingMax time.Duration
+	if w.idle {
+		// There's no p99 to speak of; rather than feeding zeroes into the
+		// smoothed values, hold them, letting the rolling maximum age out.
+		ewma = s.mu.ewma.current()
+		if horizon > 0 {
+			rollingMax = s.mu.rollingMax.current(w.at, horizon)
+		}
+	} else {
+		slope = s.mu.trend.observe(w.at, w.p99, int(trendDeliveries.Get(&s.mu.st.SV)))
+		ewma = s.mu.ewma.observe(w.p99, ewmaAlpha.Get(&s.mu.st.SV))
+		if horizon > 0 {
+			rollingMax = s.mu.rollingMax.observe(w.at, w.p99, horizon)
+		}
+	}
+	s.metrics.P99EWMA.Update(ewma.Nanoseconds())
+	s.metrics.P99RollingMax.Update(rollingMax.Nanoseconds())
+
+	var overloadTransitioned bool
+	if !s.standalone {
+		// Idle windows have no p99 to go by; the signal holds, unless it was
+		// just disabled.
+		if c := makeOverloadSignalConfig(&s.mu.st.SV); !w.idle || c.onThreshold == 0 {
+			overloadTransitioned = s.mu.overload.observe(w.p99, w.at, c)
+		}
+		var windowMax time.Duration
+		if !w.idle {
+			windowMax = histogramMax(s.mu.lastIntervalHistogram)
+		}
+		latest.Store(&SampleSnapshot{
+			P50: w.p50, P90: w.p90, P99: w.p99, P999: w.p999, Max: windowMax,
+			P99RollingMax: rollingMax,
+			StdDev:        w.stddev,
+			At:            w.at, Elapsed: w.elapsed, Idle: w.idle, Gapped: w.gapped,
+			EventsPerSecond: w.eventsPerSecond(),
+			GOMAXPROCS:      latestCumulative.gomaxprocs,
+			Period:          period,
+			Window:          w.config(period),
+			Overloaded:      s.mu.overload.overloaded,
+			Anomalies:       s.anomalies(),
+		})
+	}
+	s.exportQuantilesLocked(w)
+	s.maybeLogSnapshotLocked(w)
+
+	// Perform the callbacks for every listener.
+	sample := Sample{
+		P99: w.p99, P99Slope: slope, P99EWMA: ewma, P99RollingMax: rollingMax, StdDev: w.stddev,
+		Events: w.events, Period: period, At: w.at, Elapsed: w.elapsed, Idle: w.idle,
+		Gapped: w.gapped, GOMAXPROCS: w.gomaxprocs, LatencyRatio: w.latencyRatio(),
+		TailRatio: w.tailRatio(), EventsPerSecond: w.eventsPerSecond(), CPUUtilization: w.cpuUtilization,
+		DistributionShift: w.distributionShift, HasDistributionShift: w.hasDistributionShift,
+		Window: w.config(period),
+	}
+	if len(s.percentiles) > 0 && !w.idle {
+		if ps, ok := s.mu.lastInterval.Percentiles(s.percentiles); ok {
+			sample.Percentiles = make([]time.Duration, len(ps))
+			for i := range ps {
+				sample.Percentiles[i] = SecondsToDuration(ps[i])
+			}
+		}
+	}
+	s.invokeListenersLocked(ctx, sample)
+	if s.standalone {
+		s.metrics.CallbackNanos.Inc(timeutil.Since(computed).Nanoseconds())
+		return
+	}
+	mutexWaitCallbacks.deliverLocked(ctx, s, w.at, func(f MutexWaitCallback) {
+		f(w.mutexWait, w.elapsed)
+	})
+	if overloadTransitioned {
+		s.invokeOverloadCallbacksLocked(ctx, s.mu.overload.overloaded)
+	}
+	s.mu.runtime.deliver(ctx, w.at)
+	s.metrics.CallbackNanos.Inc(timeutil.Since(computed).Nanoseconds())
+}
+
+// invokeListenersLocked invokes the listeners that are due a delivery with the
+// given sample, or with their own window if they requested one. Provisional
+// and idle samples are only delivered to SampleObservers; the legacy interface
+// can't mark them as such. The sample is then sent to the subscriptions (see
+// Subscribe), unless the sampler is standalone.
+func (s *sampler) invokeListenersLocked(ctx context.Context, sample Sample) {
+	maxPanics := maxCallbackPanics.Get(&s.mu.st.SV)
+	listeners := s.mu.listeners[:0]
+	for _, l := range s.mu.listeners {
+		sample := sample
+		if sample.Final {
+			// The final sample is delivered to every SampleObserver, however
+			// recently it was last delivered to, and over the sampler's own
+			// window: listener windows would blend in stale samples.
+			if _, ok := l.target.(SampleObserver); ok {
+				sample.Previous = l.previous
+				name := fmt.Sprintf("listener %T", l.target)
+				s.invokeCallbackLocked(ctx, name, func() { observe(l.target, sample) })
+			}
+			listeners = append(listeners, l)
+			continue
+		}
+		if l.window.duration > 0 {
+			if !l.window.ok {
+				listeners = append(listeners, l) // nothing to deliver
+				continue
+			}
+			sample = sample.withWindow(l.window.latest)
+		}
+		_, ok := l.target.(SampleObserver)
+		if (ok || (!sample.Provisional && !sample.Idle)) && l.throttle.ready(sample.At) {
+			if l.deltas.suppress(sample) {
+				s.metrics.SuppressedDeliveries.Inc(1)
+				listeners = append(listeners, l)
+				continue
+			}
+			sample.Previous = l.previous
+			name := fmt.Sprintf("listener %T", l.target)
+			panicked := s.invokeCallbackLocked(ctx, name, func() { observe(l.target, sample) })
+			l.recordDelivered(sample)
+			if l.panics.record(panicked, maxPanics) {
+				logEviction(ctx, name, maxPanics)
+				continue
+			}
+		}
+		listeners = append(listeners, l)
+	}
+	s.mu.listeners = listeners
+	if !s.standalone {
+		s.sendToSubscriptionsLocked(sample)
+	}
+}
+
+// window contains the values computed over a full window of samples.
+type window struct {
+	p50, p90  time.Duration // p50 and p90 scheduler latency
+	p99, p999 time.Duration // p99 and p99.9 scheduler latency
+	stddev    time.Duration // approximate standard deviation of the scheduler latency
+	events    uint64        // number of goroutine scheduling events
+	mutexWait time.Duration // total time spent blocked on mutexes
+	duration  time.Duration // the nominal duration of the window
+	elapsed   time.Duration // the time elapsed between the oldest and latest samples
+	at        time.Time     // when the latest sample was taken
+	// provisional is set if the window is partial, spanning the samples
+	// retained while the ring buffer is yet to fill up.
+	provisional bool
+	// idle is set if fewer than scheduler_latency.idle_window.min_events were
+	// observed over the window, in which case the percentiles are zero.
+	idle bool
+	// gapped is set if elapsed exceeds the nominal span of the window by more
+	// than scheduler_latency.gapped_window.factor.
+	gapped bool
+	// final is set if the window is the partial one since the previous tick,
+	// computed as the sampler stops; see flushFinal.
+	final bool
+	// gomaxprocs is GOMAXPROCS when the latest sample was taken, or zero if
+	// unknown.
+	gomaxprocs int
+	// cpuUtilization is the fraction of the CPU time that was busy, or zero if
+	// it can't be computed; see cpuUtilization.
+	cpuUtilization float64
+	// samples is the number of sample periods spanned by the window once full.
+	samples int
+	// reconfigured is set if the window is provisional, the sampler having
+	// discarded its window as the period or duration in effect changed.
+	reconfigured bool
+	// distributionShift is the distance between the distributions of the
+	// window and the previous full one, if hasDistributionShift is set; see
+	// distributionShift.
+	distributionShift    float64
+	hasDistributionShift bool
+}
+
+// windowPercentiles are the percentiles computed over every window, in the
+// order of the fields of the window type.
+var windowPercentiles = []float64{0.50, 0.90, 0.99, 0.999}
+
+// computeLocked records the latest cumulative sample and computes the values
+// over the window, if a full window is available. If not, but at least two
+// samples are retained, a provisional window is computed over them instead.
+// The windows of the listeners that requested their own are computed alongside.
+func (s *sampler) computeLocked(
+	ctx context.Context, latestCumulative runtimeSample, period time.Duration,
+) (w window, ok bool) {
+	// Retain a copy of the latency histogram rather than the one sampled,
+	// whose memory isn't ours to keep: were it reused, every retained sample
+	// would alias it. The values of the independently windowed metrics are
+	// retained (and copied) by s.mu.runtime instead.
+	latestCumulative.latencies = s.mu.histograms.clone(latestCumulative.latencies)
+	latestCumulative.windowed = nil
+	s.aggregateLocked(latestCumulative.latencies)
+	s.recordHeatmapLocked(latestCumulative)
+	s.sizeRingLocked()
+	minEvents := uint64(idleWindowMinEvents.Get(&s.mu.st.SV))
+	gapFactor := gappedWindowFactor.Get(&s.mu.st.SV)
+	// The windows are computed from the samples retained before the latest one
+	// is recorded, which may evict the oldest.
+	s.computeListenerWindowsLocked(latestCumulative, period, minEvents, gapFactor)
+	retained := s.mu.ringBuffer.Len()
+	var interval *histogramutil.PrefixSums
+	if retained > 0 {
+		w, interval, ok = s.windowLocked(
+			latestCumulative, s.mu.windowSamples, s.mu.duration, period, minEvents, gapFactor)
+	}
+	if evicted, full := s.recordLocked(latestCumulative); full {
+		// The interval histograms are computed afresh, so the oldest sample,
+		// having been evicted, is no longer referenced.
+		s.mu.histograms.release(evicted.latencies)
+	}
+	if retained == 0 {
+		return window{}, false
+	}
+	if w.provisional {
+		// The provisional window is only delivered to listeners; it doesn't
+		// feed into the exported metrics or the breach logger.
+		return w, ok
+	}
+	if !w.idle {
+		// Compared against the previous full window before it's replaced.
+		w.distributionShift, w.hasDistributionShift = distributionShift(
+			s.mu.lastIntervalHistogram, interval.Histogram())
+	}
+	s.mu.lastIntervalHistogram, s.mu.lastInterval = interval.Histogram(), interval
+	s.metrics.EventsPerSecond.Update(w.eventsPerSecond())
+	s.metrics.MutexWait.Update(w.mutexWait.Nanoseconds())
+	s.metrics.CPUUtilization.Update(w.cpuUtilization)
+	s.mu.lastWindow = w
+	if w.events == 0 {
+		s.recordAnomaly(ctx, anomalyEmptyWindow, w.at)
+	}
+	if !ok {
+		return window{}, false // there's nothing to deliver
+	}
+	s.recordDebugResultLocked(w, interval.Histogram())
+	if !w.idle {
+		s.maybeLogBreachLocked(ctx, w.p50, w.p99, w.duration)
+	}
+	return w, true
+}
+
+// windowLocked computes the values over the window of the given target
+// duration, spanning up to the given number of sample periods (the duration
+// divided by the period, rounded up), ending at the latest cumulative sample,
+// from the samples retained before it's recorded; at least one must be. If
+// fewer than the given number are retained, a provisional window is computed
+// over all of them.
+//
+// If the duration isn't a multiple of the period, the window spans either the
+// given number of periods or one fewer, whichever's elapsed time (going by the
+// samples' timestamps, not their number) is nearest the duration. It's
+// nominally of the target duration regardless; the time it actually covered
+// is its elapsed time.
+func (s *sampler) windowLocked(
+	latestCumulative runtimeSample,
+	samples int,
+	duration time.Duration,
+	period time.Duration,
+	minEvents uint64,
+	gapFactor float64,
+) (w window, interval *histogramutil.PrefixSums, ok bool) {
+	if retained := s.mu.ringBuffer.Len(); retained < samples {
+		w, interval, ok = computeWindow(
+			latestCumulative, s.mu.ringBuffer.GetLast(), samples, period, minEvents)
+		w.duration = duration
+		w.provisional = true
+		w.reconfigured = s.mu.reconfigured
+		// The provisional window spans the samples retained so far, not the
+		// nominal duration.
+		w.gapped = isGapped(w.elapsed, time.Duration(retained)*period, gapFactor)
+		return w, interval, ok
+	}
+	oldest := s.mu.ringBuffer.Get(samples - 1)
+	if fewer := samples - 1; fewer > 0 && duration%period != 0 {
+		// The duration isn't a multiple of the period; see which of the two
+		// samples straddling it is nearest.
+		if candidate := s.mu.ringBuffer.Get(fewer - 1); absDuration(latestCumulative.at.Sub(candidate.at)-duration) <
+			absDuration(latestCumulative.at.Sub(oldest.at)-duration) {
+			oldest, samples = candidate, fewer
+		}
+	}
+	w, interval, ok = computeWindow(latestCumulative, oldest, samples, period, minEvents)
+	w.duration = duration
+	w.gapped = isGapped(w.elapsed, w.duration, gapFactor)
+	return w, interval, ok
+}
+
+// absDuration returns the absolute value of the given duration.
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// computeWindow computes the values over the window between the oldest and
+// latest cumulative samples, spanning the given number of sample periods,
+// returning them and the interval histogram, along with its prefix sums for the
+// percentiles to be queried over it. The window is idle if it observed
+// fewer than minEvents scheduling events, and its percentiles aren't computed.
+// It returns false if the window isn't idle but the percentiles can't be
+// computed either, having observed no events at all.
+func computeWindow(
+	latestCumulative, oldestCumulative runtimeSample,
+	samples int,
+	period time.Duration,
+	minEvents uint64,
+) (w window, interval *histogramutil.PrefixSums, ok bool) {
+	w.duration = time.Duration(samples) * period
+	w.samples = samples
+	w.elapsed = latestCumulative.at.Sub(oldestCumulative.at)
+	w.at = latestCumulative.at
+	w.gomaxprocs = latestCumulative.gomaxprocs
+	interval = histogramutil.NewPrefixSums(sub(latestCumulative.latencies, oldestCumulative.latencies))
+	w.events = interval.Total()
+	w.idle = w.events < minEvents
+	w.mutexWait = SecondsToDuration(subCounter(latestCumulative.mutexWait, oldestCumulative.mutexWait))
+	w.cpuUtilization = cpuUtilization(latestCumulative.cpu, oldestCumulative.cpu)
+	if w.idle {
+		return w, interval, true
+	}
+	ps, ok := interval.Percentiles(windowPercentiles)
+	if !ok {
+		return w, interval, false
+	}
+	w.p50 = SecondsToDuration(ps[0])
+	w.p90 = SecondsToDuration(ps[1])
+	w.p99 = SecondsToDuration(ps[2])
+	w.p999 = SecondsToDuration(ps[3])
+	if stddev, ok := histogramutil.StdDev(interval.Histogram()); ok {
+		w.stddev = SecondsToDuration(stddev)
+	}
+	return w, interval, true
 }
 
-func (s *sampler) recordLocked(
-	sample *metrics.Float64Histogram,
-) (oldest *metrics.Float64Histogram, ok bool) {
+// isGapped returns true if the time elapsed over a window exceeds its nominal
+// span by more than the given factor, ticks having been delayed (by GC pauses
+// or CPU starvation) well beyond the sample period.
+func isGapped(elapsed, nominal time.Duration, factor float64) bool {
+	return float64(elapsed) > factor*float64(nominal)
+}
+
+func (s *sampler) overhead() SamplerOverhead {
+	return SamplerOverhead{
+		Ticks:        s.metrics.Ticks.Count(),
+		SkippedTicks: s.metrics.SkippedTicks.Count(),
+		Sample:       time.Duration(s.metrics.SampleNanos.Count()),
+		Compute:      time.Duration(s.metrics.ComputeNanos.Count()),
+		Callbacks:    time.Duration(s.metrics.CallbackNanos.Count()),
+	}
+}
+
+func (s *sampler) recordLocked(sample runtimeSample) (oldest runtimeSample, ok bool) {
 	if s.mu.ringBuffer.Len() == s.mu.ringBuffer.Cap() { // no more room, clear out the oldest
-		oldest = s.mu.ringBuffer.GetLast()
+		oldest, ok = s.mu.ringBuffer.GetLast(), true
 		s.mu.ringBuffer.RemoveLast()
 	}
 	s.mu.ringBuffer.AddFirst(sample)
-	return oldest, oldest != nil
+	return oldest, ok
+}
+
+// aggregateLocked adds the interval since the previous cumulative sample to the
+// aggregate interval histogram.
+func (s *sampler) aggregateLocked(latestCumulative *metrics.Float64Histogram) {
+	previousCumulative := s.mu.latestCumulative
+	s.mu.latestCumulative = latestCumulative
+	if previousCumulative == nil {
+		return
+	}
+	interval := sub(latestCumulative, previousCumulative)
+	s.recordDistributionLocked(interval)
+	if s.mu.aggregateIntervalHistogram == nil {
+		s.mu.aggregateIntervalHistogram = interval
+		return
+	}
+	s.mu.aggregateIntervalHistogram = add(s.mu.aggregateIntervalHistogram, interval)
 }
 
 func (s *sampler) lastIntervalHistogram() *metrics.Float64Histogram {
@@ -224,12 +1617,68 @@ func (s *sampler) lastIntervalHistogram() *metrics.Float64Histogram {
 	return s.mu.lastIntervalHistogram
 }
 
+func (s *sampler) aggregateIntervalHistogram() *metrics.Float64Histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.aggregateIntervalHistogram == nil {
+		return nil
+	}
+	return clone(s.mu.aggregateIntervalHistogram)
+}
+
+// AggregateIntervalHistogram returns the distribution of scheduler latencies
+// observed since the sampler was started, i.e. the sum of all the interval
+// histograms it has seen. Unlike the cumulative histogram maintained by the Go
+// runtime, it excludes latencies from before the sampler started (such as
+// those during process startup). It's intended for use in debug endpoints, and
+// returns nil if the sampler isn't running or hasn't taken two samples yet.
+func AggregateIntervalHistogram() *metrics.Float64Histogram {
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	if s == nil {
+		return nil
+	}
+	return s.aggregateIntervalHistogram()
+}
+
+const (
+	schedLatenciesMetric = "/sched/latencies:seconds"
+	mutexWaitMetric      = "/sync/mutex/wait/total:seconds"
+	gcPausesMetric       = "/gc/pauses:seconds"
+	gomaxprocsMetric     = "/sched/gomaxprocs:threads"
+)
+
+// runtimeSample is a cumulative (since process start) sample of the runtime
+// metrics tracked by the sampler. Samples are retained in the sampler's ring
+// buffer; values over a window are computed as the difference between the
+// latest and oldest samples.
+type runtimeSample struct {
+	// latencies is the scheduler latency histogram, re-binned into the coarse
+	// layout.
+	latencies *metrics.Float64Histogram
+	// mutexWait is the total time (in seconds) goroutines spent blocked on a
+	// sync.Mutex or sync.RWMutex.
+	mutexWait float64
+	// cpu are the CPU class counters.
+	cpu cpuClasses
+	// gomaxprocs is the value of GOMAXPROCS, or zero if unknown.
+	gomaxprocs int
+	// windowed are the values of the metrics windowed independently of the
+	// ring buffer, in the order they were described to the runtime sampler;
+	// they're the zero value for metrics that weren't read (GC pauses, unless
+	// scheduler_latency.gc_pauses.enabled is set).
+	windowed []runtimeValue
+	// at is when the sample was taken.
+	at time.Time
+}
+
 // sample the cumulative (since process start) scheduler latency histogram from
 // the go runtime.
 func sample() *metrics.Float64Histogram {
 	m := []metrics.Sample{
 		{
-			Name: "/sched/latencies:seconds",
+			Name: schedLatenciesMetric,
 		},
 	}
 	metrics.Read(m)
@@ -241,125 +1690,64 @@ func sample() *metrics.Float64Histogram {
 	return h
 }
 
-// clone the given histogram.
+// clone the given histogram; see histogramutil.Clone.
 func clone(h *metrics.Float64Histogram) *metrics.Float64Histogram {
-	res := &metrics.Float64Histogram{
-		Counts:  make([]uint64, len(h.Counts)),
-		Buckets: make([]float64, len(h.Buckets)),
-	}
-	copy(res.Counts, h.Counts)
-	copy(res.Buckets, h.Buckets)
-	return res
+	return histogramutil.Clone(h)
+}
+
+// count returns the total count across all buckets of the given histogram; see
+// histogramutil.Total.
+func count(h *metrics.Float64Histogram) uint64 {
+	return histogramutil.Total(h)
 }
 
-// sub subtracts the counts of one histogram from another, assuming the bucket
-// boundaries are the same. For cumulative scheduler latency histograms, this
-// can be used to compute an interval histogram.
+// sub subtracts the counts of one histogram from another; see
+// histogramutil.Sub.
 func sub(a, b *metrics.Float64Histogram) *metrics.Float64Histogram {
-	res := clone(a)
-	for i := 0; i < len(res.Counts); i++ {
-		res.Counts[i] -= b.Counts[i]
-	}
-	return res
+	return histogramutil.Sub(a, b)
 }
 
-// percentile computes a specific percentile value of the given histogram.
-//
-// TODO(irfansharif): Deduplicate this with the quantile computation in
-// util/metrics? Here we're using the raw histogram at the highest resolution
-// and with zero translation between types; there we rebucket the histogram and
-// translate to another type.
-func percentile(h *metrics.Float64Histogram, p float64) float64 {
-	// Counts contains the number of occurrences for each histogram bucket.
-	// Given N buckets, Count[n] is the number of occurrences in the range
-	// [bucket[n], bucket[n+1]), for 0 <= n < N.
-	//
-	// TODO(irfansharif): Consider adjusting the default bucket count in the
-	// runtime to make this cheaper and with a more appropriate amount of
-	// resolution. The defaults can be seen through TestHistogramBuckets:
-	//
-	//   bucket[  0] width=0s boundary=[-Inf, 0s)
-	//   bucket[  1] width=1ns boundary=[0s, 1ns)
-	//   bucket[  2] width=1ns boundary=[1ns, 2ns)
-	//   bucket[  3] width=1ns boundary=[2ns, 3ns)
-	//   bucket[  4] width=1ns boundary=[3ns, 4ns)
-	//   ...
-	//   bucket[270] width=16.384µs boundary=[737.28µs, 753.664µs)
-	//   bucket[271] width=16.384µs boundary=[753.664µs, 770.048µs)
-	//   bucket[272] width=278.528µs boundary=[770.048µs, 1.048576ms)
-	//   bucket[273] width=32.768µs boundary=[1.048576ms, 1.081344ms)
-	//   bucket[274] width=32.768µs boundary=[1.081344ms, 1.114112ms)
-	//   ...
-	//   bucket[717] width=1h13m18.046511104s boundary=[53h45m14.046488576s, 54h58m32.09299968s)
-	//   bucket[718] width=1h13m18.046511104s boundary=[54h58m32.09299968s, 56h11m50.139510784s)
-	//   bucket[719] width=1h13m18.046511104s boundary=[56h11m50.139510784s, 57h25m8.186021888s)
-	//   bucket[720] width=57h25m8.186021888s boundary=[57h25m8.186021888s, +Inf)
-	//
-	var total uint64 // total count across all buckets
-	for i := range h.Counts {
-		total += h.Counts[i]
-	}
-
-	// Linear approximation of the target value corresponding to percentile, p:
-	//
-	// Goal: We want to linear approximate the "p * total"-th value from the histogram.
-	//      - p * total is the ordinal rank (or simply, rank) of the target value
-	//        within the histogram bounds.
-	//
-	// Step 1: Find the bucket[i] which contains the target value.
-	//      - This will be the largest bucket[i] where the left-bound cumulative
-	//        count of bucket[i] <= p*total.
-	//
-	// Step 2: Determine the rank within the bucket (subset of histogram).
-	//      - Since the bucket is a subset of the original data set, we can simply
-	//        subtract left-bound cumulative to get the "subset_rank".
-	//
-	// Step 3: Use the "subset_rank" to interpolate the target value.
-	//      - So we want to interpolate the "subset_rank"-th value of the subset_count
-	//        that lies between [bucket_start, bucket_end). This is:
-	//        bucket_start + (bucket_start - bucket_end) * linear_interpolator
-	//        Where linear_interpolator = subset_rank / subset_count
-
-	// (Step 1) Iterate backwards (we're optimizing for higher percentiles) until
-	// we find the first bucket for which total-cumulative <= rank, which will be
-	// by design the largest bucket that meets that condition.
-	var cumulative uint64  // cumulative count of all buckets we've iterated through
-	var start, end float64 // start and end of current bucket
-	var i int              // index of current bucket
-	for i = len(h.Counts) - 1; i >= 0; i-- {
-		start, end = h.Buckets[i], h.Buckets[i+1]
-		if i == 0 && math.IsInf(h.Buckets[0], -1) { // -Inf
-			// Buckets[0] is permitted to have -Inf; avoid interpolating with
-			// infinity if our percentile value lies in this bucket.
-			start = end
-		}
-		if i == len(h.Counts)-1 && math.IsInf(h.Buckets[len(h.Buckets)-1], 1) { // +Inf
-			// Buckets[len(Buckets)-1] is permitted to have +Inf; avoid
-			// interpolating with infinity if our percentile value lies in this
-			// bucket.
-			end = start
-		}
-
-		if start == end && math.IsInf(start, 0) {
-			// Our (single) bucket boundary is [-Inf, +Inf), there's no
-			// information.
-			return 0.0
-		}
-
-		cumulative += h.Counts[i]
-		if p == 1.0 {
-			if cumulative > 0 {
-				break // we've found the highest bucket with a non-zero count (i.e. pmax)
-			}
-		} else if float64(total-cumulative) <= float64(total)*p {
-			break // we've found the bucket where the cumulative count until that point is p% of the total
+// add adds the counts of one histogram to another; see histogramutil.Add.
+func add(a, b *metrics.Float64Histogram) *metrics.Float64Histogram {
+	return histogramutil.Add(a, b)
+}
+
+// checkMonotonic returns an assertion failure, identifying the offending
+// bucket, if any bucket of the latest cumulative histogram counts fewer events
+// than the previous one did. Cumulative histograms only ever grow; histograms
+// with different layouts aren't compared.
+func checkMonotonic(previous, latest *metrics.Float64Histogram) error {
+	if previous == nil || len(previous.Counts) != len(latest.Counts) {
+		return nil
+	}
+	for i := range latest.Counts {
+		if latest.Counts[i] < previous.Counts[i] {
+			return errors.AssertionFailedf(
+				"cumulative scheduler latency histogram decreased in bucket %d [%v, %v): from %d to %d",
+				i, previous.Buckets[i], previous.Buckets[i+1], previous.Counts[i], latest.Counts[i])
 		}
 	}
+	return nil
+}
 
-	// (Step 2) Find the target rank within bucket boundaries.
-	subsetRank := float64(total)*p - float64(total-cumulative)
+// subCounter subtracts one sample of a cumulative counter from another. The
+// counter is supposed to be monotonic, but we clamp the difference to zero to
+// not surface negative values if it isn't.
+func subCounter(a, b float64) float64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+// percentile computes a specific percentile value of the given histogram, or
+// returns false if it's empty; see histogramutil.Percentile.
+func percentile(h *metrics.Float64Histogram, p float64) (float64, bool) {
+	return histogramutil.Percentile(h, p)
+}
 
-	// (Step 3) Using rank and the percentile to calculate the approximated value.
-	subsetPercentile := subsetRank / float64(h.Counts[i])
-	return start + (end-start)*subsetPercentile
+// percentiles computes several percentiles of the given histogram at once, or
+// returns false if it's empty; see histogramutil.Percentiles.
+func percentiles(h *metrics.Float64Histogram, ps []float64) ([]float64, bool) {
+	return histogramutil.Percentiles(h, ps)
 }
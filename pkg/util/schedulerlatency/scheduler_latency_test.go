@@ -12,19 +12,27 @@ package schedulerlatency
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
+	"runtime"
 	"runtime/metrics"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/schedulerlatency/histogramutil"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
@@ -32,6 +40,7 @@ import (
 // TestSchedulerLatencySampler is an integration test for the scheduler latency
 // sampler -- it verifies that scheduling latencies are measured, registered
 // callbacks are invoked, and that the prometheus metrics emitted are non-empty.
+// Ticks are driven using a manual clock, instead of waiting for them.
 func TestSchedulerLatencySampler(t *testing.T) {
 	skip.UnderStress(t)
 	skip.UnderShort(t)
@@ -66,9 +75,30 @@ func TestSchedulerLatencySampler(t *testing.T) {
 
 	mu := testListener{}
 
+	const statsInterval = 10 * time.Second
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
 	reg := metric.NewRegistry()
-	require.NoError(t, StartSampler(ctx, st, stopper, reg, 10*time.Second, &mu))
+	require.NoError(t, StartSampler(ctx, st, stopper, reg, statsInterval, &mu, clock))
+	// tick advances the clock by a sample period, and waits for the sampler to
+	// process the tick. Advancing by more at once would have the sampler skip
+	// ticks, and re-baseline.
+	tick := func() {
+		prev, _ := TestingSamplerOverhead()
+		clock.Advance(samplePeriod.Get(&st.SV))
+		testutils.SucceedsSoon(t, func() error {
+			cur, _ := TestingSamplerOverhead()
+			if cur.Ticks+cur.SkippedTicks == prev.Ticks+prev.SkippedTicks {
+				return errors.New("tick yet to be processed")
+			}
+			return nil
+		})
+	}
 	testutils.SucceedsSoon(t, func() error {
+		// Tick the sampler over a few windows, and export the stats.
+		for i := time.Duration(0); i < statsInterval; i += samplePeriod.Get(&st.SV) {
+			tick()
+		}
+
 		mu.Lock()
 		defer mu.Unlock()
 		if mu.p99.Nanoseconds() == 0 {
@@ -77,6 +107,9 @@ func TestSchedulerLatencySampler(t *testing.T) {
 
 		var err error
 		reg.Each(func(name string, mtr interface{}) {
+			if name != schedulerLatency.Name {
+				return // the sampler's own metrics
+			}
 			wh := mtr.(metric.WindowedHistogram)
 			windowSnapshot := wh.WindowedSnapshot()
 			avg := windowSnapshot.Mean()
@@ -111,6 +144,1025 @@ func (l *testListener) SchedulerLatency(p99 time.Duration, period time.Duration)
 	l.p99 = p99
 }
 
+// TestStartSamplerShared is a regression test ensuring that multiple
+// StartSampler calls share a single sampler, with every listener invoked once
+// per tick, and that the sampler is torn down once all stoppers quiesce.
+func TestStartSamplerShared(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// Use a clock that's never advanced, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+
+	sharedSampler := func() (*sampler, int, bool) {
+		shared.Lock()
+		defer shared.Unlock()
+		if shared.s == nil {
+			return nil, 0, false
+		}
+		return shared.s, len(shared.attached), shared.s.running
+	}
+
+	stopperA, stopperB := stop.NewStopper(), stop.NewStopper()
+	defer stopperA.Stop(ctx)
+	defer stopperB.Stop(ctx)
+
+	var listenerA, listenerB countingListener
+	require.NoError(t, StartSampler(ctx, st, stopperA, metric.NewRegistry(), time.Hour, &listenerA, clock))
+	require.NoError(t, StartSampler(ctx, st, stopperB, metric.NewRegistry(), time.Hour, &listenerB, clock))
+
+	s, attached, running := sharedSampler()
+	require.NotNil(t, s)
+	require.Equal(t, 2, attached)
+	require.True(t, running)
+	s.sample = busySample()
+
+	const ticks = 5
+	for i := 0; i < ticks; i++ {
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	}
+	// The first two ticks fill up the window.
+	require.Equal(t, ticks-2, listenerA.get())
+	require.Equal(t, ticks-2, listenerB.get())
+
+	// Stopping the stopper used to start the sampler hands it off to the
+	// other caller, which continues to receive samples.
+	stopperA.Stop(ctx)
+	cur, attached, running := sharedSampler()
+	require.Same(t, s, cur)
+	require.Equal(t, 1, attached)
+	require.True(t, running)
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	require.Equal(t, ticks-2, listenerA.get())
+	require.Equal(t, ticks-1, listenerB.get())
+
+	// Once all stoppers have quiesced, the sampler is torn down and can be
+	// started afresh.
+	stopperB.Stop(ctx)
+	cur, _, _ = sharedSampler()
+	require.Nil(t, cur)
+
+	stopperC := stop.NewStopper()
+	defer stopperC.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, st, stopperC, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	cur, attached, running = sharedSampler()
+	require.NotNil(t, cur)
+	require.NotSame(t, s, cur)
+	require.Equal(t, 1, attached)
+	require.True(t, running)
+}
+
+// TestStartSamplerHandoffSettings verifies that the shared sampler is only
+// configured by the settings of the caller driving its tick loop, including
+// once it's handed off to another caller with different settings.
+func TestStartSamplerHandoffSettings(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	stA, stB := cluster.MakeTestingClusterSettings(), cluster.MakeTestingClusterSettings()
+	for _, st := range []*cluster.Settings{stA, stB} {
+		sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+		samplePeriod.Override(ctx, &st.SV, time.Hour)
+	}
+
+	stopperA, stopperB := stop.NewStopper(), stop.NewStopper()
+	defer stopperA.Stop(ctx)
+	defer stopperB.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, stA, stopperA, nil /* registry */, time.Hour, nil /* listener */, clock))
+	require.NoError(t, StartSampler(
+		ctx, stB, stopperB, nil /* registry */, time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	configuredPeriod := func() time.Duration {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.configured.period
+	}
+	// setPeriod overrides the period of the given settings until it takes
+	// effect, the callbacks being registered asynchronously by the tick loop:
+	// it's reverted on each failed attempt, for the next one to be a change.
+	setPeriod := func(st *cluster.Settings, period time.Duration) {
+		prev := samplePeriod.Get(&st.SV)
+		testutils.SucceedsSoon(t, func() error {
+			samplePeriod.Override(ctx, &st.SV, period)
+			if cur := configuredPeriod(); cur != period {
+				samplePeriod.Override(ctx, &st.SV, prev)
+				return errors.Newf("configured period %s, expected %s", cur, period)
+			}
+			return nil
+		})
+	}
+
+	// The sampler is driven by the first caller, so the settings of the second
+	// one are ignored.
+	setPeriod(stA, 30*time.Minute)
+	samplePeriod.Override(ctx, &stB.SV, 20*time.Minute)
+	require.Equal(t, 30*time.Minute, configuredPeriod())
+
+	// Once handed off, the settings of the second caller are applied, and
+	// those of the first one are ignored.
+	stopperA.Stop(ctx)
+	testutils.SucceedsSoon(t, func() error {
+		if cur := configuredPeriod(); cur != 20*time.Minute {
+			return errors.Newf("configured period %s, expected %s", cur, 20*time.Minute)
+		}
+		return nil
+	})
+	setPeriod(stB, 40*time.Minute)
+	samplePeriod.Override(ctx, &stA.SV, 10*time.Minute)
+	require.Equal(t, 40*time.Minute, configuredPeriod())
+}
+
+// TestStartSamplerVirtualCluster verifies that separate-process virtual cluster
+// servers, whose settings forbid access to SystemOnly ones, can start and tune
+// the sampler, and that their listeners receive samples.
+func TestStartSamplerVirtualCluster(t *testing.T) {
+	// None of the sampler's settings are SystemOnly.
+	var all, virtual []settings.InternalKey
+	for _, key := range settings.Keys(true /* forSystemTenant */) {
+		if strings.HasPrefix(string(key), "scheduler_latency.") {
+			all = append(all, key)
+		}
+	}
+	for _, key := range settings.Keys(false /* forSystemTenant */) {
+		if strings.HasPrefix(string(key), "scheduler_latency.") {
+			virtual = append(virtual, key)
+		}
+	}
+	require.NotEmpty(t, all)
+	require.Equal(t, all, virtual)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// Accessing SystemOnly settings panics in test builds, once specialized.
+	st.SV.SpecializeForVirtualCluster()
+	// Use a clock that's never advanced, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	var listener countingListener
+	require.NoError(t, StartSampler(ctx, st, stopper, metric.NewRegistry(), time.Hour, &listener, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	s.sample = busySample()
+
+	const ticks = 5
+	for i := 0; i < ticks; i++ {
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	}
+	// The first two ticks fill up the window.
+	require.Equal(t, ticks-2, listener.get())
+}
+
+// TestResetWindow verifies that resetting the window, either explicitly or
+// automatically after a gap between ticks, suppresses callbacks until a full
+// fresh window is observed.
+func TestResetWindow(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// The sampler's own ticker is never going to fire, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	ResetWindow() // no-op without a running sampler
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	s.sample = func() runtimeSample {
+		return runtimeSample{latencies: &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+
+	const period = time.Second
+	tick := func(elapsed time.Duration) (delivered bool) {
+		n := len(listener.samples)
+		clock.Advance(elapsed)
+		s.sampleOnTickAndInvokeCallbacks(ctx, period)
+		return len(listener.samples) > n
+	}
+	require.False(t, tick(period))
+	require.False(t, tick(period))
+	require.True(t, tick(period))
+	state := s.inspectState()
+	require.Equal(t, 2, state.Retained)
+	require.Equal(t, clock.Now().Add(-2*period), state.WindowStart)
+	require.Equal(t, clock.Now(), state.WindowEnd)
+
+	// Explicitly resetting the window.
+	ResetWindow()
+	require.Zero(t, s.inspectState().Retained)
+	require.False(t, tick(period))
+	// The window last computed isn't over the samples retained.
+	state = s.inspectState()
+	require.Equal(t, []time.Time{clock.Now()}, state.Timestamps)
+	require.Zero(t, state.WindowEnd)
+	require.False(t, tick(period))
+	require.True(t, tick(period))
+	require.Equal(t, 2*period, listener.samples[len(listener.samples)-1].Elapsed)
+	require.Zero(t, s.metrics.Rebaselines.Count())
+
+	// A gap between ticks exceeding rebaselineGapMultiple periods re-baselines
+	// automatically, with the sample following the gap as the new baseline.
+	require.False(t, tick(11*period))
+	require.Equal(t, int64(1), s.metrics.Rebaselines.Count())
+	require.False(t, tick(period))
+	require.True(t, tick(period))
+	require.Equal(t, 2*period, listener.samples[len(listener.samples)-1].Elapsed)
+
+	// Shorter gaps don't.
+	require.True(t, tick(rebaselineGapMultiple*period))
+	require.Equal(t, int64(1), s.metrics.Rebaselines.Count())
+}
+
+// TestGOMAXPROCSChange verifies that the sampler re-baselines when GOMAXPROCS
+// changes, the windows never spanning a change, and that the current value is
+// exposed in the snapshot.
+func TestGOMAXPROCSChange(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// The sampler's own ticker is never going to fire, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	gomaxprocs := 4
+	busy := busySample()
+	s.sample = func() runtimeSample {
+		sample := busy()
+		sample.gomaxprocs = gomaxprocs
+		return sample
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+
+	const period = time.Second
+	tick := func() (delivered bool) {
+		n := len(listener.samples)
+		clock.Advance(period)
+		s.sampleOnTickAndInvokeCallbacks(ctx, period)
+		return len(listener.samples) > n
+	}
+	requireSnapshot := func(gomaxprocs int) {
+		t.Helper()
+		snap, ok := Latest()
+		require.True(t, ok)
+		require.Equal(t, gomaxprocs, snap.GOMAXPROCS)
+	}
+	require.False(t, tick())
+	require.False(t, tick())
+	require.True(t, tick())
+	requireSnapshot(4)
+
+	// The sample following the change is the new baseline.
+	gomaxprocs = 8
+	provisional := len(listener.provisional)
+	require.False(t, tick())
+	require.Equal(t, int64(1), s.metrics.Rebaselines.Count())
+	require.Len(t, listener.provisional, provisional)
+	require.False(t, tick())
+	require.Len(t, listener.provisional, provisional+1)
+	require.True(t, tick())
+	require.Equal(t, 2*period, listener.samples[len(listener.samples)-1].Elapsed)
+	requireSnapshot(8)
+
+	// The value being unknown doesn't count as a change.
+	gomaxprocs = 0
+	require.True(t, tick())
+	gomaxprocs = 8
+	require.True(t, tick())
+	require.Equal(t, int64(1), s.metrics.Rebaselines.Count())
+	requireSnapshot(8)
+}
+
+// TestNonMonotonicCumulativeSamples verifies that a cumulative sample with
+// fewer events in any bucket than the previous one fails an assertion in test
+// builds, and re-baselines the sampler otherwise.
+func TestNonMonotonicCumulativeSamples(t *testing.T) {
+	require.NoError(t, checkMonotonic(nil, &metrics.Float64Histogram{Counts: []uint64{1}, Buckets: []float64{0, 1}}))
+	require.NoError(t, checkMonotonic(
+		&metrics.Float64Histogram{Counts: []uint64{1, 2}, Buckets: []float64{0, 1, 2}},
+		&metrics.Float64Histogram{Counts: []uint64{1, 3}, Buckets: []float64{0, 1, 2}},
+	))
+	// Histograms with different layouts aren't compared.
+	require.NoError(t, checkMonotonic(
+		&metrics.Float64Histogram{Counts: []uint64{1, 2}, Buckets: []float64{0, 1, 2}},
+		&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}},
+	))
+	err := checkMonotonic(
+		&metrics.Float64Histogram{Counts: []uint64{1, 5, 2}, Buckets: []float64{0, 1, 2, 3}},
+		&metrics.Float64Histogram{Counts: []uint64{2, 4, 2}, Buckets: []float64{0, 1, 2, 3}},
+	)
+	require.True(t, errors.IsAssertionFailure(err))
+	require.ErrorContains(t, err, "decreased in bucket 1 [1, 2): from 5 to 4")
+
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	// Buckets: [0, 1ms), [1ms, 2ms). Every tick observes an event in each,
+	// unless told to forget some.
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	var forget uint64
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0]++
+		cumulative.Counts[1] += 1 - forget
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func() {
+		s.tick(ctx)
+	}
+	for i := 0; i < 3; i++ {
+		tick()
+	}
+	require.Len(t, listener.samples, 1)
+
+	forget = 3 // the slow bucket decreases by 2
+	if buildutil.CrdbTestBuild {
+		require.PanicsWithError(t,
+			"cumulative scheduler latency histogram decreased in bucket 1 [0.001, 0.002): from 3 to 1",
+			tick)
+		return
+	}
+	tick()
+	require.Equal(t, int64(1), s.metrics.Rebaselines.Count())
+	require.Len(t, listener.samples, 1)
+	// The decreased sample is the new baseline; the windows following it are
+	// sane.
+	forget = 0
+	tick()
+	tick()
+	require.Len(t, listener.samples, 2)
+	require.Equal(t, uint64(4), listener.samples[1].Events)
+}
+
+// TestAutoSamplePeriod verifies the derivation of the sample period from
+// GOMAXPROCS when scheduler_latency.sample_period is zero, and that it's
+// re-derived when GOMAXPROCS changes.
+func TestAutoSamplePeriod(t *testing.T) {
+	for _, tc := range []struct {
+		gomaxprocs int
+		exp        time.Duration
+	}{
+		{gomaxprocs: 0, exp: 250 * time.Millisecond}, // unknown, treated as 1
+		{gomaxprocs: 1, exp: 250 * time.Millisecond},
+		{gomaxprocs: 2, exp: 250 * time.Millisecond}, // 400ms, clamped
+		{gomaxprocs: 4, exp: 200 * time.Millisecond},
+		{gomaxprocs: 8, exp: 100 * time.Millisecond},
+		{gomaxprocs: 16, exp: 50 * time.Millisecond},
+		{gomaxprocs: 96, exp: 8333333 * time.Nanosecond},
+		{gomaxprocs: 1024, exp: time.Millisecond}, // 781.25µs, clamped
+	} {
+		require.Equal(t, tc.exp, autoSamplePeriod(tc.gomaxprocs), "GOMAXPROCS=%d", tc.gomaxprocs)
+	}
+
+	ctx := context.Background()
+	s := newTestSampler(t, 0 /* period */, time.Second, nil)
+	clock := s.clock
+	requirePeriod := func(exp time.Duration) {
+		t.Helper()
+		require.Equal(t, exp, s.periodInEffect())
+		require.Equal(t, exp.Nanoseconds(), s.metrics.Period.Value())
+	}
+	requirePeriod(autoSamplePeriod(runtime.GOMAXPROCS(0)))
+
+	gomaxprocs := 16
+	busy := busySample()
+	s.sample = func() runtimeSample {
+		sample := busy()
+		sample.gomaxprocs = gomaxprocs
+		return sample
+	}
+	tick := func() {
+		clock.Advance(s.periodInEffect())
+		s.sampleOnTickAndInvokeCallbacks(ctx, s.periodInEffect())
+	}
+	tick()
+	requirePeriod(50 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		tick()
+	}
+	snap, ok := Latest()
+	require.True(t, ok)
+	require.Equal(t, 50*time.Millisecond, snap.Period)
+
+	// Re-derived when GOMAXPROCS changes, with the ticker reset to it.
+	drainResetTicks := func() bool {
+		select {
+		case <-s.resetTicks:
+			return true
+		default:
+			return false
+		}
+	}
+	drainResetTicks()
+	gomaxprocs = 4
+	tick()
+	requirePeriod(200 * time.Millisecond)
+	require.True(t, drainResetTicks())
+	for i := 0; i < 5; i++ {
+		tick()
+	}
+	snap, ok = Latest()
+	require.True(t, ok)
+	require.Equal(t, 200*time.Millisecond, snap.Period)
+	require.Equal(t, 4, snap.GOMAXPROCS)
+
+	// The configured duration spans at least two derived periods.
+	gomaxprocs = 1
+	s.setPeriodAndDuration(0, 100*time.Millisecond)
+	tick()
+	requirePeriod(250 * time.Millisecond)
+	s.mu.Lock()
+	require.Equal(t, 500*time.Millisecond, s.mu.duration)
+	s.mu.Unlock()
+
+	// A configured period isn't re-derived.
+	s.setPeriodAndDuration(time.Second, 2*time.Second)
+	gomaxprocs = 32
+	tick()
+	requirePeriod(time.Second)
+}
+
+// TestSamplerClose verifies that once the stopper quiesces, the sampler is torn
+// down, retaining no data and unregistering its metrics.
+func TestSamplerClose(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// Use a clock that's never advanced, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	reg := metric.NewRegistry()
+	require.NoError(t, StartSampler(ctx, st, stopper, reg, time.Hour, nil /* listener */, clock))
+
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	for i := 0; i < 3; i++ {
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	}
+	_, ok := Latest()
+	require.True(t, ok)
+	require.NotNil(t, s.lastIntervalHistogram())
+	testutils.SucceedsSoon(t, func() error {
+		if !reg.Contains(schedulerLatency.Name) {
+			return errors.New("metrics yet to be registered")
+		}
+		return nil
+	})
+	require.True(t, reg.Contains(metaSamplerTicks.Name))
+
+	stopper.Stop(ctx)
+	_, ok = Latest()
+	require.False(t, ok)
+	_, ok = TestingSamplerOverhead()
+	require.False(t, ok)
+	require.Nil(t, s.lastIntervalHistogram())
+	require.Nil(t, s.aggregateIntervalHistogram())
+	_, ok = s.debugHistogram()
+	require.False(t, ok)
+	require.Empty(t, s.dump().Results)
+	_, ok = Dump()
+	require.False(t, ok)
+	require.False(t, reg.Contains(schedulerLatency.Name))
+	for _, m := range s.metrics.iterables() {
+		require.False(t, reg.Contains(m.GetName()))
+	}
+
+	// Ticks racing with the teardown are ignored.
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	s.mu.Lock()
+	require.Zero(t, s.mu.ringBuffer.Len())
+	s.mu.Unlock()
+}
+
+// TestDump verifies that the sampler retains its recent results, up to
+// maxDebugResults, oldest first, alongside the interval histogram, and that
+// they're serialized as JSON.
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	clock := s.clock
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	tick := func() {
+		s.tick(ctx)
+	}
+
+	dump := s.dump()
+	require.Empty(t, dump.Results)
+	require.Nil(t, dump.Histogram)
+
+	tick() // nothing to compare against yet
+	tick()
+	tick()
+	dump = s.dump()
+	result := DebugResult{
+		At:      timeutil.Unix(2, 0),
+		Elapsed: time.Second,
+		P50:     555556 * time.Nanosecond,
+		P99:     1900 * time.Microsecond,
+		Max:     2 * time.Millisecond,
+		Events:  100,
+	}
+	require.Len(t, dump.Results, 2)
+	require.Equal(t, result, dump.Results[0])
+	result.At = timeutil.Unix(3, 0)
+	require.Equal(t, result, dump.Results[1])
+	require.NotNil(t, dump.Histogram)
+	require.Len(t, dump.Histogram.Buckets, 2)
+
+	// The dump round-trips through JSON.
+	b, err := json.Marshal(dump)
+	require.NoError(t, err)
+	var parsed DebugDump
+	require.NoError(t, json.Unmarshal(b, &parsed))
+	require.Equal(t, result.P99, parsed.Results[1].P99)
+	require.True(t, result.At.Equal(parsed.Results[1].At))
+	require.Equal(t, dump.Histogram.Buckets, parsed.Histogram.Buckets)
+
+	// The results retained are bounded, evicting the oldest ones.
+	for i := 0; i < maxDebugResults; i++ {
+		tick()
+	}
+	dump = s.dump()
+	require.Len(t, dump.Results, maxDebugResults)
+	require.Equal(t, timeutil.Unix(4, 0), dump.Results[0].At) // the first two were evicted
+	require.Equal(t, clock.Now(), dump.Results[maxDebugResults-1].At)
+}
+
+// TestSamplerOverhead verifies that the sampler measures its own overhead.
+func TestSamplerOverhead(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// Use a clock that's never advanced, we'll tick manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	_, ok := TestingSamplerOverhead()
+	require.False(t, ok)
+
+	var listener countingListener
+	reg := metric.NewRegistry()
+	require.NoError(t, StartSampler(ctx, st, stopper, reg, time.Hour, &listener, clock))
+	require.True(t, reg.Contains(metaSamplerSampleNanos.Name))
+
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	s.sample = busySample()
+
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	prev, ok := TestingSamplerOverhead()
+	require.True(t, ok)
+	require.Equal(t, int64(1), prev.Ticks)
+	require.Zero(t, prev.Callbacks) // nothing to compare against yet
+	for i := 0; i < 5; i++ {
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+		cur, ok := TestingSamplerOverhead()
+		require.True(t, ok)
+		require.Equal(t, prev.Ticks+1, cur.Ticks)
+		require.Greater(t, cur.Sample, prev.Sample)
+		require.Greater(t, cur.Compute, prev.Compute)
+		require.GreaterOrEqual(t, cur.Callbacks, prev.Callbacks)
+		prev = cur
+	}
+	require.Equal(t, 4, listener.get())
+}
+
+// TestSamplerSkipsStaleTicks verifies that when processing a tick takes longer
+// than the sample period, the ticks queued up in the meantime are skipped and
+// counted, instead of being processed back-to-back.
+func TestSamplerSkipsStaleTicks(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Second)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Second)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	// The listener is slow the first time it's invoked, taking 5 sample
+	// periods.
+	listener := slowListener{clock: clock, slowness: 5 * time.Second}
+	require.NoError(t, StartSampler(ctx, st, stopper, metric.NewRegistry(), time.Hour, &listener, clock))
+	shared.Lock()
+	shared.s.sample = busySample()
+	shared.Unlock()
+
+	waitFor := func(ticks, skippedTicks int64) {
+		t.Helper()
+		testutils.SucceedsSoon(t, func() error {
+			overhead, ok := TestingSamplerOverhead()
+			if !ok {
+				return errors.New("sampler is not running")
+			}
+			if overhead.Ticks != ticks || overhead.SkippedTicks != skippedTicks {
+				return errors.Newf("expected %d ticks and %d skipped ticks, found %d and %d",
+					ticks, skippedTicks, overhead.Ticks, overhead.SkippedTicks)
+			}
+			return nil
+		})
+	}
+	for i := int64(1); i <= 2; i++ {
+		clock.Advance(time.Second)
+		waitFor(i, 0)
+	}
+
+	// The third tick completes the window and invokes the slow listener.
+	// Meanwhile, another five ticks are queued up; the first three are more
+	// than a period stale by the time they're received.
+	clock.Advance(time.Second)
+	waitFor(5, 3)
+
+	// Ticks aren't skipped once we've caught up.
+	clock.Advance(time.Second)
+	waitFor(6, 3)
+}
+
+// TestSamplerAlignment verifies that with scheduler_latency.sample_alignment
+// enabled, ticks land on multiples of the sample period since the epoch, and
+// are re-aligned when the period changes.
+func TestSamplerAlignment(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	clock := timeutil.NewManualTime(timeutil.Unix(100, 300*time.Millisecond.Nanoseconds()))
+	samplePeriod.Override(ctx, &st.SV, time.Second)
+	sampleDuration.Override(ctx, &st.SV, 4*time.Second)
+	sampleAlignment.Override(ctx, &st.SV, true)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+	var mu struct {
+		syncutil.Mutex
+		sampledAt []time.Time
+	}
+	s.sample = func() runtimeSample {
+		mu.Lock()
+		defer mu.Unlock()
+		mu.sampledAt = append(mu.sampledAt, clock.Now())
+		return runtimeSample{latencies: &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}}
+	}
+
+	// waitForAligner waits for the sampler to arm its timer for the given
+	// aligned boundary.
+	waitForAligner := func(at time.Time) {
+		t.Helper()
+		testutils.SucceedsSoon(t, func() error {
+			if timers := clock.Timers(); len(timers) != 1 || !timers[0].Equal(at) {
+				return errors.Newf("expected a timer at %s, found %v", at, timers)
+			}
+			return nil
+		})
+	}
+	// waitForSamples waits for samples to have been taken at the given times.
+	waitForSamples := func(expected ...time.Time) {
+		t.Helper()
+		testutils.SucceedsSoon(t, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(mu.sampledAt) != len(expected) {
+				return errors.Newf("expected %d samples, found %d", len(expected), len(mu.sampledAt))
+			}
+			return nil
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range expected {
+			require.True(t, expected[i].Equal(mu.sampledAt[i]),
+				"expected sample %d at %s, found %s", i, expected[i], mu.sampledAt[i])
+		}
+	}
+
+	// The first tick is delayed until the next whole second, and the ticks
+	// that follow are a period apart from there.
+	waitForAligner(timeutil.Unix(101, 0))
+	clock.Advance(700 * time.Millisecond)
+	waitForSamples(timeutil.Unix(101, 0))
+	clock.Advance(time.Second)
+	waitForSamples(timeutil.Unix(101, 0), timeutil.Unix(102, 0))
+
+	// Changing the period re-aligns ticks to the new period; the ticks due in
+	// the meantime, at the old phase, are ignored.
+	clock.Advance(500 * time.Millisecond)
+	samplePeriod.Override(ctx, &st.SV, 2*time.Second)
+	waitForAligner(timeutil.Unix(104, 0))
+	clock.Advance(500 * time.Millisecond)
+	clock.Advance(time.Second)
+	waitForSamples(timeutil.Unix(101, 0), timeutil.Unix(102, 0), timeutil.Unix(104, 0))
+	clock.Advance(2 * time.Second)
+	waitForSamples(timeutil.Unix(101, 0), timeutil.Unix(102, 0), timeutil.Unix(104, 0), timeutil.Unix(106, 0))
+}
+
+func TestUntilAligned(t *testing.T) {
+	for _, tc := range []struct {
+		now      time.Time
+		period   time.Duration
+		expected time.Duration
+	}{
+		{now: timeutil.Unix(100, 0), period: time.Second, expected: 0},
+		{now: timeutil.Unix(100, 1), period: time.Second, expected: time.Second - 1},
+		{now: timeutil.Unix(100, 300*time.Millisecond.Nanoseconds()), period: time.Second, expected: 700 * time.Millisecond},
+		{now: timeutil.Unix(101, 0), period: 2 * time.Second, expected: time.Second},
+		{now: timeutil.Unix(0, 50*time.Millisecond.Nanoseconds()), period: 100 * time.Millisecond, expected: 50 * time.Millisecond},
+	} {
+		require.Equal(t, tc.expected, untilAligned(tc.now, tc.period), "now=%s period=%s", tc.now, tc.period)
+	}
+}
+
+// slowListener simulates a listener that's slow enough to delay the sampler's
+// processing of subsequent ticks, by advancing the clock the first time it's
+// invoked.
+type slowListener struct {
+	clock    *timeutil.ManualTime
+	slowness time.Duration
+	invoked  bool
+}
+
+func (l *slowListener) SchedulerLatency(time.Duration, time.Duration) {
+	if !l.invoked {
+		l.invoked = true
+		l.clock.Advance(l.slowness)
+	}
+}
+
+// TestSamplePeriodAndDurationValidation verifies that setting updates resulting
+// in a sample duration shorter than two sample periods are clamped, regardless
+// of the order in which they're applied.
+func TestSamplePeriodAndDurationValidation(t *testing.T) {
+	ctx := context.Background()
+
+	require.NoError(t, validatePeriodAndDuration(time.Second, 2*time.Second))
+	require.EqualError(t, validatePeriodAndDuration(10*time.Second, 5*time.Second),
+		"scheduler_latency.sample_duration (5s) must be at least 2 times scheduler_latency.sample_period (10s)")
+
+	window := func(s *sampler) (period, duration time.Duration, samples int) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.period, s.mu.duration, s.mu.ringBuffer.Cap()
+	}
+	for _, periodFirst := range []bool{true, false} {
+		t.Run(fmt.Sprintf("period-first=%t", periodFirst), func(t *testing.T) {
+			st := cluster.MakeTestingClusterSettings()
+			samplePeriod.Override(ctx, &st.SV, time.Second)
+			sampleDuration.Override(ctx, &st.SV, 10*time.Second)
+			s := newSampler(st, time.Second, 10*time.Second)
+			var resets []time.Duration
+			getPeriod := s.watchSettings(ctx, st, func(period time.Duration) {
+				resets = append(resets, period)
+			}, nil /* active */)
+
+			if periodFirst {
+				samplePeriod.Override(ctx, &st.SV, 10*time.Second)
+				period, duration, samples := window(s)
+				require.Equal(t, 10*time.Second, period)
+				require.Equal(t, 20*time.Second, duration) // clamped
+				require.Equal(t, 2, samples)
+				sampleDuration.Override(ctx, &st.SV, 5*time.Second)
+			} else {
+				sampleDuration.Override(ctx, &st.SV, 5*time.Second)
+				period, duration, samples := window(s)
+				require.Equal(t, time.Second, period)
+				require.Equal(t, 5*time.Second, duration)
+				require.Equal(t, 5, samples)
+				samplePeriod.Override(ctx, &st.SV, 10*time.Second)
+			}
+			period, duration, samples := window(s)
+			require.Equal(t, 10*time.Second, period)
+			require.Equal(t, 20*time.Second, duration) // clamped
+			require.Equal(t, 2, samples)
+			require.Equal(t, 10*time.Second, getPeriod())
+			require.Equal(t, []time.Duration{10 * time.Second}, resets)
+
+			// A valid duration is used as is.
+			sampleDuration.Override(ctx, &st.SV, time.Minute)
+			period, duration, samples = window(s)
+			require.Equal(t, 10*time.Second, period)
+			require.Equal(t, time.Minute, duration)
+			require.Equal(t, 6, samples)
+		})
+	}
+}
+
+// TestPercentileNow verifies that arbitrary percentiles of the most recent full
+// window can be computed on demand, including ones in the overflow bucket.
+func TestPercentileNow(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	_, ok := PercentileNow(0.5)
+	require.False(t, ok) // the sampler isn't running
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, math.Inf(+1)},
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 4950
+		cumulative.Counts[1] += 4950
+		cumulative.Counts[2] += 100
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	tick := func() {
+		clock.Advance(time.Minute)
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	}
+
+	tick()
+	tick()
+	_, ok = PercentileNow(0.5)
+	require.False(t, ok) // we're yet to observe a full window
+	tick()
+
+	// The window has 9900 events in each of the first two buckets, and 200 in
+	// the overflow bucket, which isn't interpolated within.
+	for _, tc := range []struct {
+		p   float64
+		exp time.Duration
+	}{
+		{p: 0.01, exp: 20202 * time.Nanosecond},
+		{p: 0.5, exp: 1010101 * time.Nanosecond},
+		{p: 0.98, exp: 1979798 * time.Nanosecond},
+		{p: 0.99, exp: 2 * time.Millisecond},
+		{p: 0.9999, exp: 2 * time.Millisecond},
+		{p: 1, exp: 2 * time.Millisecond},
+	} {
+		v, ok := PercentileNow(tc.p)
+		require.True(t, ok, "p=%f", tc.p)
+		requireDuration(t, tc.exp, v)
+	}
+	// Callbacks are none the wiser.
+	require.Len(t, listener.samples, 1)
+	require.Equal(t, 2*time.Millisecond, listener.samples[0].P99)
+
+	// Out of range percentiles are rejected.
+	for _, p := range []float64{0, -0.5, 1.01, math.NaN(), math.Inf(+1)} {
+		_, ok := PercentileNow(p)
+		require.False(t, ok, "p=%f", p)
+	}
+
+	stopper.Stop(ctx)
+	_, ok = PercentileNow(0.5)
+	require.False(t, ok)
+}
+
+// TestLatest verifies the snapshot returned by Latest before and after a full
+// window is observed, and when read concurrently with ticks.
+func TestLatest(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// Use a clock that's never advanced far enough to tick, we'll tick
+	// manually.
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	samplePeriod.Override(ctx, &st.SV, time.Hour)
+	sampleDuration.Override(ctx, &st.SV, 2*time.Hour)
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, StartSampler(
+		ctx, st, stopper, metric.NewRegistry(), time.Hour, nil /* listener */, clock))
+	shared.Lock()
+	s := shared.s
+	shared.Unlock()
+
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+
+	_, ok := Latest()
+	require.False(t, ok)
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Minute)
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+		_, ok = Latest()
+		require.False(t, ok) // we're yet to observe a full window
+	}
+	clock.Advance(time.Minute)
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+	snap, ok := Latest()
+	require.True(t, ok)
+	require.Equal(t, SampleSnapshot{
+		P50:           555556 * time.Nanosecond,
+		P90:           time.Millisecond,
+		P99:           1900 * time.Microsecond,
+		P999:          1990 * time.Microsecond,
+		Max:           2 * time.Millisecond,
+		P99RollingMax: 1900 * time.Microsecond,
+		StdDev:        300 * time.Microsecond, // 90% and 10% at the midpoints 0.5ms and 1.5ms
+		At:            clock.Now(),
+		Elapsed:       2 * time.Minute,
+		// 200 events over the two minutes elapsed.
+		EventsPerSecond: 200 / (2 * time.Minute).Seconds(),
+		Period:          time.Hour,
+		Window:          WindowConfig{Period: time.Hour, Duration: 2 * time.Hour, Samples: 2},
+	}, snap)
+
+	// Read snapshots concurrently with ticks.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			s.sampleOnTickAndInvokeCallbacks(ctx, time.Hour)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		snap, ok := Latest()
+		require.True(t, ok)
+		require.LessOrEqual(t, snap.P50, snap.P90)
+		require.LessOrEqual(t, snap.P90, snap.P99)
+		require.LessOrEqual(t, snap.P99, snap.P999)
+	}
+	wg.Wait()
+
+	// The snapshot is cleared once the sampler is torn down.
+	stopper.Stop(ctx)
+	_, ok = Latest()
+	require.False(t, ok)
+}
+
+type countingListener struct {
+	syncutil.Mutex
+	count int
+}
+
+func (l *countingListener) SchedulerLatency(p99 time.Duration, period time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.count++
+}
+
+func (l *countingListener) get() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.count
+}
+
 func TestComputeSchedulerPercentile(t *testing.T) {
 	{
 		//	  ▲
@@ -129,12 +1181,12 @@ func TestComputeSchedulerPercentile(t *testing.T) {
 			Buckets: []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120, 130},
 		}
 
-		require.InDelta(t, 100.0, percentile(&hist, 1.00), 0.001)  // pmax
-		require.InDelta(t, 0.0, percentile(&hist, 0.00), 0.001)    // pmin
-		require.InDelta(t, 49.375, percentile(&hist, 0.50), 0.001) // p50
-		require.InDelta(t, 70.625, percentile(&hist, 0.75), 0.001) // p75
-		require.InDelta(t, 90.600, percentile(&hist, 0.90), 0.001) // p90
-		require.InDelta(t, 99.060, percentile(&hist, 0.99), 0.001) // p99
+		require.InDelta(t, 100.0, mustPercentile(t, &hist, 1.00), 0.001)  // pmax
+		require.InDelta(t, 0.0, mustPercentile(t, &hist, 0.00), 0.001)    // pmin
+		require.InDelta(t, 49.375, mustPercentile(t, &hist, 0.50), 0.001) // p50
+		require.InDelta(t, 70.625, mustPercentile(t, &hist, 0.75), 0.001) // p75
+		require.InDelta(t, 90.600, mustPercentile(t, &hist, 0.90), 0.001) // p90
+		require.InDelta(t, 99.060, mustPercentile(t, &hist, 0.99), 0.001) // p99
 	}
 
 	{
@@ -142,12 +1194,12 @@ func TestComputeSchedulerPercentile(t *testing.T) {
 			Counts:  []uint64{100, 50},
 			Buckets: []float64{math.Inf(-1), 10, math.Inf(+1)},
 		}
-		require.Equal(t, 10.0, percentile(&hist, 1.00)) // pmax
-		require.Equal(t, 10.0, percentile(&hist, 0.00)) // pmin
-		require.Equal(t, 10.0, percentile(&hist, 0.50)) // p50
-		require.Equal(t, 10.0, percentile(&hist, 0.75)) // p75
-		require.Equal(t, 10.0, percentile(&hist, 0.90)) // p90
-		require.Equal(t, 10.0, percentile(&hist, 0.99)) // p99
+		require.Equal(t, 10.0, mustPercentile(t, &hist, 1.00)) // pmax
+		require.Equal(t, 10.0, mustPercentile(t, &hist, 0.00)) // pmin
+		require.Equal(t, 10.0, mustPercentile(t, &hist, 0.50)) // p50
+		require.Equal(t, 10.0, mustPercentile(t, &hist, 0.75)) // p75
+		require.Equal(t, 10.0, mustPercentile(t, &hist, 0.90)) // p90
+		require.Equal(t, 10.0, mustPercentile(t, &hist, 0.99)) // p99
 	}
 
 	{
@@ -155,9 +1207,9 @@ func TestComputeSchedulerPercentile(t *testing.T) {
 			Counts:  []uint64{100},
 			Buckets: []float64{math.Inf(-1), math.Inf(+1)},
 		}
-		require.Equal(t, 00.0, percentile(&hist, 1.00)) // pmax
-		require.Equal(t, 00.0, percentile(&hist, 0.00)) // pmin
-		require.Equal(t, 00.0, percentile(&hist, 0.50)) // p50
+		require.Equal(t, 00.0, mustPercentile(t, &hist, 1.00)) // pmax
+		require.Equal(t, 00.0, mustPercentile(t, &hist, 0.00)) // pmin
+		require.Equal(t, 00.0, mustPercentile(t, &hist, 0.50)) // p50
 	}
 }
 
@@ -196,15 +1248,72 @@ func TestComputeSchedulerPercentileAgainstPrometheus(t *testing.T) {
 		}
 
 		histWindow := promhist.WindowedSnapshot()
-		require.InDelta(t, histWindow.ValueAtQuantile(100), percentile(&hist, 1.00), 1) // pmax
-		require.InDelta(t, histWindow.ValueAtQuantile(0), percentile(&hist, 0.00), 1)   // pmin
-		require.InDelta(t, histWindow.ValueAtQuantile(50), percentile(&hist, 0.50), 1)  // p50
-		require.InDelta(t, histWindow.ValueAtQuantile(75), percentile(&hist, 0.75), 1)  // p75
-		require.InDelta(t, histWindow.ValueAtQuantile(90), percentile(&hist, 0.90), 1)  // p90
-		require.InDelta(t, histWindow.ValueAtQuantile(99), percentile(&hist, 0.99), 1)  // p99
+		require.InDelta(t, histWindow.ValueAtQuantile(100), mustPercentile(t, &hist, 1.00), 1) // pmax
+		require.InDelta(t, histWindow.ValueAtQuantile(0), mustPercentile(t, &hist, 0.00), 1)   // pmin
+		require.InDelta(t, histWindow.ValueAtQuantile(50), mustPercentile(t, &hist, 0.50), 1)  // p50
+		require.InDelta(t, histWindow.ValueAtQuantile(75), mustPercentile(t, &hist, 0.75), 1)  // p75
+		require.InDelta(t, histWindow.ValueAtQuantile(90), mustPercentile(t, &hist, 0.90), 1)  // p90
+		require.InDelta(t, histWindow.ValueAtQuantile(99), mustPercentile(t, &hist, 0.99), 1)  // p99
+	}
+}
+
+// TestComputeSchedulerPercentiles verifies that computing several percentiles
+// at once produces results identical to computing them one at a time, on
+// random histograms.
+func TestComputeSchedulerPercentiles(t *testing.T) {
+	rng, _ := randutil.NewTestRand()
+	for iter := 0; iter < 1000; iter++ {
+		n := 1 + rng.Intn(20)
+		h := &metrics.Float64Histogram{
+			Counts:  make([]uint64, n),
+			Buckets: make([]float64, n+1),
+		}
+		for i := range h.Buckets {
+			h.Buckets[i] = float64(i * 10)
+		}
+		if rng.Intn(2) == 0 {
+			h.Buckets[0] = math.Inf(-1)
+		}
+		if rng.Intn(2) == 0 {
+			h.Buckets[n] = math.Inf(+1)
+		}
+		for i := range h.Counts {
+			if rng.Intn(3) != 0 { // leave some buckets empty
+				h.Counts[i] = uint64(rng.Intn(100))
+			}
+		}
+		h.Counts[rng.Intn(n)]++ // percentile expects a non-empty histogram
+
+		ps := make([]float64, rng.Intn(6))
+		for i := range ps {
+			switch rng.Intn(4) {
+			case 0:
+				ps[i] = 0
+			case 1:
+				ps[i] = 1
+			default:
+				ps[i] = rng.Float64()
+			}
+		}
+
+		res, ok := percentiles(h, ps)
+		require.True(t, ok)
+		require.Len(t, res, len(ps))
+		for i, p := range ps {
+			require.Equalf(t, mustPercentile(t, h, p), res[i], "p=%f histogram=%v", p, h)
+		}
 	}
 }
 
+// mustPercentile computes the given percentile of the given non-empty
+// histogram.
+func mustPercentile(t *testing.T, h *metrics.Float64Histogram, p float64) float64 {
+	t.Helper()
+	v, ok := percentile(h, p)
+	require.True(t, ok)
+	return v
+}
+
 func TestSubtractHistograms(t *testing.T) {
 	//	  ▲
 	//	8 │               ┌───┐
@@ -247,6 +1356,788 @@ func TestSubtractHistograms(t *testing.T) {
 	}
 }
 
+func TestAddHistograms(t *testing.T) {
+	a := metrics.Float64Histogram{
+		Counts:  []uint64{1, 3, 5, 7, 8, 6, 5, 4, 3, 5},
+		Buckets: []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100},
+	}
+	b := metrics.Float64Histogram{
+		Counts:  []uint64{0, 2, 2, 5, 3, 4, 5, 3, 2, 3},
+		Buckets: []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100},
+	}
+
+	c := add(&a, &b)
+	require.Equal(t, []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, c.Buckets)
+	for i := range c.Counts {
+		require.Equal(t, a.Counts[i]+b.Counts[i], c.Counts[i])
+	}
+	require.Equal(t, a.Counts, sub(c, &b).Counts)
+}
+
+// TestAggregateIntervalHistogram verifies that the aggregate interval
+// histogram maintained by the sampler is the difference between the latest and
+// the first cumulative samples, including across window resizes.
+func TestAggregateIntervalHistogram(t *testing.T) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	rng, _ := randutil.NewTestRand()
+
+	cumulative := &metrics.Float64Histogram{
+		Counts:  make([]uint64, 10),
+		Buckets: []float64{math.Inf(-1), 0, 10, 20, 30, 40, 50, 60, 70, 80, math.Inf(+1)},
+	}
+	var first *metrics.Float64Histogram
+	s := newSampler(st, time.Second, 4*time.Second)
+	s.sample = func() runtimeSample {
+		for i := range cumulative.Counts {
+			cumulative.Counts[i] += uint64(rng.Intn(100))
+		}
+		h := clone(cumulative)
+		if first == nil {
+			first = h
+		}
+		return runtimeSample{latencies: h}
+	}
+
+	// Nothing to aggregate before the second sample.
+	require.Nil(t, s.aggregateIntervalHistogram())
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second)
+	require.Nil(t, s.aggregateIntervalHistogram())
+
+	for i := 0; i < 20; i++ {
+		if i == 10 {
+			s.setPeriodAndDuration(time.Second, 2*time.Second) // discards the ring buffer
+		}
+		s.sampleOnTickAndInvokeCallbacks(ctx, time.Second)
+		require.Equal(t, sub(cumulative, first), s.aggregateIntervalHistogram())
+	}
+}
+
+// TestMutexWait drives the sampler with injected mutex wait values, verifying
+// the per-window deltas delivered to registered callbacks and the gauge.
+func TestMutexWait(t *testing.T) {
+	ctx := context.Background()
+
+	var cumulative float64 // in seconds
+	s := newTestSampler(t, time.Second, 2*time.Second, func() runtimeSample {
+		return runtimeSample{
+			latencies: &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}},
+			mutexWait: cumulative,
+		}
+	})
+
+	var delivered []time.Duration
+	id := RegisterMutexWaitCallback(func(wait time.Duration, elapsed time.Duration) {
+		require.Equal(t, 2*time.Second, elapsed)
+		delivered = append(delivered, wait)
+	}, 0 /* minInterval */)
+	defer UnregisterMutexWaitCallback(id)
+
+	for _, tc := range []struct {
+		cumulative float64
+		exp        []time.Duration
+	}{
+		{cumulative: 1, exp: nil},
+		{cumulative: 1.5, exp: nil}, // we need a full window
+		{cumulative: 2, exp: []time.Duration{time.Second}},
+		{cumulative: 2.25, exp: []time.Duration{time.Second, 750 * time.Millisecond}},
+		// Non-monotonic values are clamped to zero.
+		{cumulative: 0.5, exp: []time.Duration{time.Second, 750 * time.Millisecond, 0}},
+	} {
+		cumulative = tc.cumulative
+		s.tick(ctx)
+		require.Equal(t, tc.exp, delivered)
+		if len(tc.exp) > 0 {
+			require.Equal(t, tc.exp[len(tc.exp)-1].Nanoseconds(), s.metrics.MutexWait.Value())
+		}
+	}
+
+	// Resizing the window re-baselines.
+	s.setPeriodAndDuration(time.Second, time.Second)
+	delivered = nil
+	cumulative = 1
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second)
+	require.Nil(t, delivered)
+}
+
+// TestProvisionalSamples walks through the warm-up sequence, verifying that
+// provisional samples are delivered over the partial window once two samples
+// are retained, until the ring buffer fills up, and that legacy listeners
+// aren't provided them.
+func TestProvisionalSamples(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 4*time.Second, nil)
+	// Every tick observes another 10 scheduling events.
+	latencies := &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}
+	s.sample = func() runtimeSample {
+		latencies.Counts[0] += 10
+		return runtimeSample{latencies: clone(latencies)}
+	}
+	var listener sampleListener
+	var legacy countingListener
+	s.addListener(&listener)
+	s.addListener(&legacy)
+	tick := func() {
+		s.tick(ctx)
+	}
+
+	warmUp := func() {
+		t.Helper()
+		listener.samples, listener.provisional = nil, nil
+		legacyDeliveries := legacy.get()
+		tick() // a single sample, nothing to compare against
+		require.Empty(t, listener.provisional)
+		for i := 1; i <= 3; i++ {
+			tick()
+			require.Len(t, listener.provisional, i)
+			sample := listener.provisional[i-1]
+			require.True(t, sample.Provisional)
+			require.Equal(t, time.Duration(i)*time.Second, sample.Elapsed)
+			require.Equal(t, uint64(10*i), sample.Events)
+			require.Equal(t, time.Second, sample.Period)
+			require.Zero(t, sample.P99Slope)
+			require.Zero(t, sample.P99EWMA)
+			require.Empty(t, listener.samples)
+			require.Equal(t, legacyDeliveries, legacy.get())
+		}
+		// The ring buffer fills up on the fifth sample, which is the first
+		// full window.
+		tick()
+		require.Len(t, listener.provisional, 3)
+		require.Len(t, listener.samples, 1)
+		require.False(t, listener.samples[0].Provisional)
+		require.Equal(t, 4*time.Second, listener.samples[0].Elapsed)
+		require.Equal(t, uint64(40), listener.samples[0].Events)
+	}
+	warmUp()
+	require.Equal(t, 1, legacy.get())
+	tick()
+	require.Len(t, listener.provisional, 3)
+	require.Len(t, listener.samples, 2)
+	require.Equal(t, 2, legacy.get())
+
+	// Re-baselining warms up again.
+	s.mu.Lock()
+	s.resetWindowLocked()
+	s.mu.Unlock()
+	warmUp()
+	require.Equal(t, 3, legacy.get())
+}
+
+// TestSampleElapsed verifies that listeners are provided the time elapsed over
+// the window, which reflects delayed ticks, and that legacy listeners continue
+// to be invoked.
+func TestSampleElapsed(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestSampler(t, time.Second, 2*time.Second, busySample())
+	clock := s.clock
+	var listener sampleListener
+	var legacy countingListener
+	s.addListener(&listener)
+	s.addListener(&legacy)
+
+	for _, delay := range []time.Duration{
+		time.Second,
+		time.Second,
+		time.Second,
+		3 * time.Second, // delayed tick
+		time.Second,
+		time.Second,
+	} {
+		s.advance(ctx, delay)
+	}
+
+	require.Len(t, listener.samples, 4)
+	for i, exp := range []time.Duration{
+		2 * time.Second,
+		4 * time.Second, // includes the delayed tick
+		4 * time.Second,
+		2 * time.Second,
+	} {
+		require.Equalf(t, exp, listener.samples[i].Elapsed, "sample %d", i)
+		require.Equal(t, time.Second, listener.samples[i].Period)
+	}
+	require.Equal(t, clock.Now(), listener.samples[3].At)
+	require.Equal(t, 4, legacy.get())
+}
+
+// TestFractionalWindows verifies that windows whose duration isn't a multiple
+// of the sample period span whichever number of periods, going by the samples'
+// timestamps, covers the time nearest the duration, rather than the truncated
+// number, and that they're delivered with the time they actually covered.
+func TestFractionalWindows(t *testing.T) {
+	ctx := context.Background()
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name             string
+		period, duration time.Duration
+		// delays are the delays between consecutive ticks; uniformly the
+		// period if nil.
+		delays []time.Duration
+		// samples is the number of samples retained for the window.
+		samples int
+		// elapsed is the time covered by the full windows.
+		elapsed time.Duration
+	}{
+		// Previously, the window was truncated to 2400ms; it still is, being
+		// nearer 2500ms than 2800ms.
+		{name: "2500ms/400ms", period: 400 * ms, duration: 2500 * ms, samples: 7, elapsed: 2400 * ms},
+		// Previously truncated to 2s, rather than the nearer 3s.
+		{name: "2900ms/1s", period: time.Second, duration: 2900 * ms, samples: 3, elapsed: 3 * time.Second},
+		{name: "1s/300ms", period: 300 * ms, duration: time.Second, samples: 4, elapsed: 900 * ms},
+		{name: "1s/600ms", period: 600 * ms, duration: time.Second, samples: 2, elapsed: 1200 * ms},
+		// Ties are broken in favor of the longer window.
+		{name: "2500ms/1s", period: time.Second, duration: 2500 * ms, samples: 3, elapsed: 3 * time.Second},
+		// Multiples of the period are spanned exactly, as before.
+		{name: "2s/500ms", period: 500 * ms, duration: 2 * time.Second, samples: 4, elapsed: 2 * time.Second},
+		{
+			// It goes by the timestamps: with ticks alternately early and
+			// late, the two periods ending with a late tick (2s) are nearer
+			// 2500ms than the three (3.3s), unlike with uniform ticks.
+			name: "jittered", period: time.Second, duration: 2500 * ms,
+			delays:  []time.Duration{700 * ms, 1300 * ms, 700 * ms, 1300 * ms, 700 * ms, 1300 * ms},
+			samples: 3, elapsed: 2 * time.Second,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSampler(t, tc.period, tc.duration, busySample())
+			clock := s.clock
+			var listener sampleListener
+			s.addListener(&listener)
+			require.Equal(t, tc.samples, s.mu.windowSamples)
+
+			delays := tc.delays
+			if delays == nil {
+				delays = make([]time.Duration, 3*tc.samples)
+				for i := range delays {
+					delays[i] = tc.period
+				}
+			}
+			s.sampleOnTickAndInvokeCallbacks(ctx, tc.period)
+			for _, delay := range delays {
+				s.advance(ctx, delay)
+			}
+			// The window is provisional until the samples retained span the
+			// number of periods the duration is rounded up to.
+			require.Len(t, listener.provisional, tc.samples-1)
+			require.Len(t, listener.samples, len(delays)-(tc.samples-1))
+			sample := listener.samples[len(listener.samples)-1]
+			require.Equal(t, tc.elapsed, sample.Elapsed)
+			require.False(t, sample.Gapped)
+			// The window's nominal duration is the one configured.
+			require.Equal(t, tc.duration, s.mu.lastWindow.duration)
+			// The ring buffer retains as many samples as the periods the
+			// duration is rounded up to, and the window ends at the latest.
+			state := s.inspectState()
+			require.Equal(t, tc.samples, state.Capacity)
+			require.Equal(t, tc.samples, state.Retained)
+			require.Equal(t, clock.Now(), state.WindowEnd)
+			require.Equal(t, tc.elapsed, state.WindowEnd.Sub(state.WindowStart))
+		})
+	}
+}
+
+// TestGappedWindows verifies that windows spanning delayed ticks, well beyond
+// the nominal window duration, are delivered but flagged as gapped.
+func TestGappedWindows(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestSampler(t, time.Second, 2*time.Second, busySample())
+	st := s.st
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func(delay time.Duration) Sample {
+		t.Helper()
+		n := len(listener.samples) + len(listener.provisional)
+		s.advance(ctx, delay)
+		require.Equal(t, n+1, len(listener.samples)+len(listener.provisional))
+		if len(listener.samples) == 0 {
+			return listener.provisional[len(listener.provisional)-1]
+		}
+		return listener.samples[len(listener.samples)-1]
+	}
+
+	s.tick(ctx) // nothing to compare against yet
+	// The provisional window spans a single period, but 3s elapsed.
+	require.True(t, tick(3*time.Second).Gapped)
+	// A full window is gapped while the delayed tick is in it: 4s elapsed over
+	// a 2s window, more than 1.5x.
+	require.True(t, tick(time.Second).Gapped)
+	require.False(t, tick(time.Second).Gapped)
+	// 3s elapsed, exactly 1.5x the window, isn't; 3.5s is.
+	require.False(t, tick(2*time.Second).Gapped)
+	require.True(t, tick(1500*time.Millisecond).Gapped)
+
+	// The factor is configurable.
+	gappedWindowFactor.Override(ctx, &st.SV, 2)
+	sample := tick(time.Second)
+	require.Equal(t, 2500*time.Millisecond, sample.Elapsed)
+	require.False(t, sample.Gapped)
+	sample = tick(4 * time.Second)
+	require.Equal(t, 5*time.Second, sample.Elapsed)
+	require.True(t, sample.Gapped)
+	require.Len(t, listener.provisional, 1)
+}
+
+// TestSampleEvents verifies that the number of scheduling events over each
+// window is delivered and exported, and that idle windows report zero
+// latencies; see TestIdleWindows.
+func TestSampleEvents(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+
+	// Buckets: [0, 1ms), [1ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, math.Inf(+1)},
+	}
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+	var listener sampleListener
+	s.addListener(&listener)
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second) // nothing to compare against yet
+
+	for _, tc := range []struct {
+		name   string
+		counts []uint64 // added over each of the two ticks spanning the window
+		p99    time.Duration
+	}{
+		{name: "busy", counts: []uint64{250000, 250000}, p99: 990 * time.Microsecond},
+		{name: "sparse", counts: []uint64{20, 30}, p99: 990 * time.Microsecond},
+		{name: "nearly-empty", counts: []uint64{2, 3}, p99: 0},
+		{name: "empty", counts: []uint64{0, 0}, p99: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, c := range tc.counts {
+				cumulative.Counts[0] += c
+				s.tick(ctx)
+			}
+			sample := listener.samples[len(listener.samples)-1]
+			events := tc.counts[0] + tc.counts[1]
+			require.Equal(t, events, sample.Events)
+			require.Equal(t, tc.p99, sample.P99)
+			require.Equal(t, events < 10, sample.Idle)
+			require.Equal(t, 2*time.Second, sample.Elapsed)
+			require.Equal(t, float64(events)/2, s.metrics.EventsPerSecond.Value())
+		})
+	}
+
+	// Events in the overflow bucket are counted too.
+	cumulative.Counts[1] += 10
+	s.tick(ctx)
+	sample := listener.samples[len(listener.samples)-1]
+	require.Equal(t, uint64(10), sample.Events)
+	require.Equal(t, time.Millisecond, sample.P99)
+}
+
+// TestSampleStdDev verifies that the approximate standard deviation of the
+// scheduler latency is delivered and published in the snapshot, telling steady
+// latency apart from alternating calm and spikes.
+func TestSampleStdDev(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+	st := s.st
+
+	// Buckets: [0, 1ms), [1ms, 2ms), ..., [9ms, 10ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  make([]uint64, 10),
+		Buckets: make([]float64, 11),
+	}
+	for i := range cumulative.Buckets {
+		cumulative.Buckets[i] = float64(i) / 1000
+	}
+	buckets := []int{4}
+	ticks := 0
+	s.sample = func() runtimeSample {
+		cumulative.Counts[buckets[ticks%len(buckets)]] += 100
+		ticks++
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func() Sample {
+		t.Helper()
+		s.tick(ctx)
+		sample := listener.samples[len(listener.samples)-1]
+		snap := latest.Load()
+		require.Equal(t, sample.At, snap.At)
+		require.Equal(t, sample.StdDev, snap.StdDev)
+		return sample
+	}
+	for i := 0; i < 3; i++ {
+		s.tick(ctx)
+	}
+
+	// Steady latency, all within [4ms, 5ms), has no spread.
+	require.Zero(t, tick().StdDev)
+	// Alternating calm, within [0, 1ms), and spikes, within [8ms, 9ms), does:
+	// every window has half its events at either midpoint.
+	buckets = []int{0, 8}
+	tick()
+	for i := 0; i < 3; i++ {
+		sample := tick()
+		require.Equal(t, 4*time.Millisecond, sample.StdDev)
+		require.Equal(t, 8980*time.Microsecond, sample.P99)
+	}
+	// It's zero over idle windows.
+	idleWindowMinEvents.Override(ctx, &st.SV, 1000)
+	sample := tick()
+	require.True(t, sample.Idle)
+	require.Zero(t, sample.StdDev)
+}
+
+// TestIdleWindows verifies that windows observing fewer than
+// scheduler_latency.idle_window.min_events are delivered as idle, with no
+// percentiles, to SampleObservers only; and that the smoothed values and their
+// gauges hold, while the windowed quantile gauges report zero.
+// Disabling idle detection, windows without any events aren't delivered.
+func TestIdleWindows(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	st := s.st
+	quantilesExportEnabled.Override(ctx, &st.SV, true)
+	quantilesExportInterval.Override(ctx, &st.SV, 0)
+
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, math.Inf(+1)},
+	}
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+	var listener sampleListener
+	var legacy countingListener
+	s.addListener(&listener)
+	s.addListener(&legacy)
+	// window adds the given number of events to the given bucket over a window
+	// (a single tick), returning the sample delivered over it.
+	window := func(bucket int, events uint64) Sample {
+		t.Helper()
+		cumulative.Counts[bucket] += events
+		s.tick(ctx)
+		return listener.samples[len(listener.samples)-1]
+	}
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second) // nothing to compare against yet
+
+	const busyP99 = 1990 * time.Microsecond
+	busy := window(1, 100)
+	require.False(t, busy.Idle)
+	require.Equal(t, busyP99, busy.P99)
+	require.Equal(t, 1, legacy.get())
+
+	for _, tc := range []struct {
+		name   string
+		bucket int
+		events uint64
+	}{
+		{name: "empty", bucket: 0, events: 0},
+		{name: "nearly-empty", bucket: 2, events: 8}, // would be a p99 of 2ms
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sample := window(tc.bucket, tc.events)
+			require.True(t, sample.Idle)
+			require.Equal(t, tc.events, sample.Events)
+			require.Zero(t, sample.P99)
+			require.Zero(t, sample.P99Slope)
+			require.Equal(t, busyP99, sample.P99EWMA)
+			require.Equal(t, busyP99, sample.P99RollingMax)
+			require.Equal(t, 1, legacy.get()) // legacy listeners are skipped
+
+			snap := latest.Load()
+			require.True(t, snap.Idle)
+			require.Zero(t, snap.P99)
+			require.Equal(t, busyP99.Nanoseconds(), s.metrics.P99EWMA.Value())
+			require.Equal(t, busyP99.Nanoseconds(), s.metrics.P99RollingMax.Value())
+			require.Zero(t, s.metrics.WindowedP99.Value())
+			require.Zero(t, s.metrics.WindowedMax.Value())
+		})
+	}
+
+	// With a lower threshold, the nearly-empty window isn't idle.
+	idleWindowMinEvents.Override(ctx, &st.SV, 1)
+	sample := window(2, 8)
+	require.False(t, sample.Idle)
+	require.Equal(t, 2*time.Millisecond, sample.P99)
+	require.Equal(t, 1995*time.Microsecond, sample.P99EWMA)
+	require.Equal(t, 2*time.Millisecond, sample.P99RollingMax)
+	require.Equal(t, 2, legacy.get())
+	require.False(t, latest.Load().Idle)
+
+	// With idle detection disabled, windows without any events have no
+	// percentiles, and aren't delivered at all.
+	idleWindowMinEvents.Override(ctx, &st.SV, 0)
+	n := len(listener.samples)
+	s.tick(ctx)
+	require.Len(t, listener.samples, n)
+	require.Equal(t, 2, legacy.get())
+	sample = window(2, 1)
+	require.Len(t, listener.samples, n+1)
+	require.False(t, sample.Idle)
+	require.Equal(t, 2*time.Millisecond, sample.P99)
+	require.Equal(t, 3, legacy.get())
+}
+
+// TestMinDeliveryInterval verifies that callbacks registered with a minimum
+// delivery interval are invoked at most once every interval, with the most
+// recent window, while the others are invoked every tick.
+func TestMinDeliveryInterval(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, busySample())
+
+	var everyTick countingListener
+	var everyTwo, everyThree sampleListener
+	s.addListener(WithMinDeliveryInterval(&everyTick, 0))
+	s.addListener(WithMinDeliveryInterval(&everyTwo, 2*time.Second))
+	throttled := WithMinDeliveryInterval(&everyThree, 3*time.Second)
+	s.addListener(throttled)
+	var mutexWaitDeliveries int
+	id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) {
+		mutexWaitDeliveries++
+	}, 2*time.Second)
+	defer UnregisterMutexWaitCallback(id)
+
+	at := func(l *sampleListener) (res []time.Time) {
+		for _, sample := range l.samples {
+			res = append(res, sample.At)
+		}
+		return res
+	}
+	// The first tick fills up the window; the rest are delivered at t=2s..9s.
+	for i := 0; i < 9; i++ {
+		s.tick(ctx)
+	}
+	require.Equal(t, 8, everyTick.get())
+	require.Equal(t, []time.Time{
+		timeutil.Unix(2, 0), timeutil.Unix(4, 0), timeutil.Unix(6, 0), timeutil.Unix(8, 0),
+	}, at(&everyTwo))
+	require.Equal(t, []time.Time{
+		timeutil.Unix(2, 0), timeutil.Unix(5, 0), timeutil.Unix(8, 0),
+	}, at(&everyThree))
+	require.Equal(t, 4, mutexWaitDeliveries)
+
+	// Throttled listeners can be removed like any other.
+	s.removeListener(throttled)
+	s.advance(ctx, 3*time.Second)
+	require.Equal(t, 9, everyTick.get())
+	require.Len(t, everyTwo.samples, 5)
+	require.Len(t, everyThree.samples, 3)
+}
+
+// busySample returns a function to inject as a sampler's sample, observing
+// enough scheduling events every tick for windows not to be idle.
+func busySample() func() runtimeSample {
+	latencies := &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}
+	return func() runtimeSample {
+		latencies.Counts[0] += 100
+		return runtimeSample{latencies: clone(latencies)}
+	}
+}
+
+// testSampler is a sampler timestamping its samples using a manual clock, for
+// tests to drive it deterministically.
+type testSampler struct {
+	*sampler
+	st     *cluster.Settings
+	clock  *timeutil.ManualTime
+	period time.Duration
+}
+
+// newTestSampler returns a sampler with the given period and duration, using
+// testing cluster settings and a manual clock starting at the Unix epoch, and
+// reading the runtime metrics using sampleFn, if non-nil (see busySample).
+func newTestSampler(
+	t testing.TB, period, duration time.Duration, sampleFn func() runtimeSample,
+) *testSampler {
+	t.Helper()
+	st := cluster.MakeTestingClusterSettings()
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	s := newSampler(st, period, duration)
+	s.setSettings(st, clock)
+	if sampleFn != nil {
+		s.sample = sampleFn
+	}
+	return &testSampler{sampler: s, st: st, clock: clock, period: period}
+}
+
+// tick advances the clock by the sampler's period and samples, like its tick
+// loop would.
+func (s *testSampler) tick(ctx context.Context) {
+	s.advance(ctx, s.period)
+}
+
+// advance advances the clock by the given duration and samples, like a tick
+// delayed (or early) by the difference to the sampler's period would.
+func (s *testSampler) advance(ctx context.Context, elapsed time.Duration) {
+	s.clock.Advance(elapsed)
+	s.sampleOnTickAndInvokeCallbacks(ctx, s.period)
+}
+
+type sampleListener struct {
+	samples     []Sample
+	provisional []Sample // the provisional samples, delivered while warming up
+}
+
+var _ SampleObserver = &sampleListener{}
+
+func (l *sampleListener) SchedulerLatency(time.Duration, time.Duration) {
+	panic("unexpected call to legacy interface")
+}
+
+func (l *sampleListener) SchedulerLatencySample(s Sample) {
+	if s.Provisional {
+		l.provisional = append(l.provisional, s)
+		return
+	}
+	l.samples = append(l.samples, s)
+}
+
+// TestListenerPriority verifies that listeners are invoked in order of
+// priority, then in the order they were added, including after some are
+// removed.
+func TestListenerPriority(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, busySample())
+
+	var invoked []string
+	listeners := make(map[string]LatencyObserver)
+	add := func(name string, wrap func(LatencyObserver) LatencyObserver) {
+		listeners[name] = wrap(&orderListener{name: name, invoked: &invoked})
+		s.addListener(listeners[name])
+	}
+	unwrapped := func(l LatencyObserver) LatencyObserver { return l }
+	withPriority := func(priority int) func(LatencyObserver) LatencyObserver {
+		return func(l LatencyObserver) LatencyObserver { return WithPriority(l, priority) }
+	}
+	tick := func() []string {
+		invoked = nil
+		s.tick(ctx)
+		return invoked
+	}
+
+	add("exporter", unwrapped)
+	add("low-1", withPriority(5))
+	add("granter", withPriority(HighestPriority))
+	add("low-2", withPriority(5))
+	// Priorities compose with minimum delivery intervals, in either order.
+	add("throttled", func(l LatencyObserver) LatencyObserver {
+		return WithPriority(WithMinDeliveryInterval(l, 2*time.Second), -1)
+	})
+	add("default", withPriority(DefaultPriority))
+	tick() // nothing to compare against yet
+	require.Equal(t, []string{"granter", "throttled", "exporter", "default", "low-1", "low-2"}, tick())
+	require.Equal(t, []string{"granter", "exporter", "default", "low-1", "low-2"}, tick())
+
+	// Removing listeners retains the order of the rest, and ties with
+	// listeners added subsequently are broken by the order they were added.
+	s.removeListener(listeners["exporter"])
+	s.removeListener(listeners["low-1"])
+	add("late", unwrapped)
+	add("late-granter", func(l LatencyObserver) LatencyObserver {
+		return WithMinDeliveryInterval(WithPriority(l, HighestPriority), time.Second)
+	})
+	require.Equal(t, []string{"granter", "late-granter", "throttled", "default", "late", "low-2"}, tick())
+}
+
+// orderListener records the order listeners are invoked in.
+type orderListener struct {
+	name    string
+	invoked *[]string
+}
+
+func (l *orderListener) SchedulerLatency(time.Duration, time.Duration) {
+	*l.invoked = append(*l.invoked, l.name)
+}
+
+// TestListenerWindows verifies that listeners that requested their own window
+// durations are delivered percentiles consistent with their own windows,
+// computed from a ring buffer sized for the longest one.
+func TestListenerWindows(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 3*time.Second, nil)
+	st, clock := s.st, s.clock
+
+	// Every tick observes 100 events in a bucket of its own, [i, i+1) ms on the
+	// i-th one, so the p99 over a window spanning n periods up to it is
+	// i+1-n/100 ms.
+	const buckets = 20
+	cumulative := &metrics.Float64Histogram{
+		Counts:  make([]uint64, buckets),
+		Buckets: make([]float64, buckets+1),
+	}
+	for i := range cumulative.Buckets {
+		cumulative.Buckets[i] = float64(i) / 1000
+	}
+	var ticks int
+	s.sample = func() runtimeSample {
+		cumulative.Counts[ticks] += 100
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	tick := func() {
+		s.tick(ctx)
+		ticks++
+	}
+	requireWindow := func(sample Sample, n int) {
+		t.Helper()
+		requireDuration(t, time.Duration(ticks)*time.Millisecond-time.Duration(n)*10*time.Microsecond, sample.P99)
+		require.Equal(t, uint64(100*n), sample.Events)
+		require.Equal(t, time.Duration(n)*time.Second, sample.Elapsed)
+		require.Equal(t, clock.Now(), sample.At)
+	}
+
+	var short, long, sampler sampleListener
+	s.addListener(WithWindowDuration(&short, 2*time.Second))
+	// Requested durations are rounded to the nearest multiple of the period.
+	longListener := WithWindowDuration(&long, 4600*time.Millisecond)
+	s.addListener(longListener)
+	s.addListener(&sampler)
+	require.Equal(t, 3, s.mu.ringBuffer.Cap()) // resized on the next tick
+
+	// Each listener is delivered provisional windows until its own fills up,
+	// and then the ones spanning its own duration, off the same ticks.
+	for ticks < 10 {
+		tick()
+		require.Equal(t, 5, s.mu.ringBuffer.Cap())
+		latest := ticks - 1
+		for _, tc := range []struct {
+			l *sampleListener
+			n int
+		}{{&short, 2}, {&sampler, 3}, {&long, 5}} {
+			if latest < tc.n {
+				require.Empty(t, tc.l.samples)
+				require.Len(t, tc.l.provisional, latest)
+				continue
+			}
+			require.Len(t, tc.l.samples, latest-tc.n+1)
+			requireWindow(tc.l.samples[len(tc.l.samples)-1], tc.n)
+		}
+	}
+
+	// Requested durations are capped, shrinking the ring buffer.
+	listenerWindowMaxDuration.Override(ctx, &st.SV, 3*time.Second)
+	tick()
+	require.Equal(t, 3, s.mu.ringBuffer.Cap())
+	requireWindow(long.samples[len(long.samples)-1], 3)
+
+	// Lifting the cap grows the ring buffer again, retaining the samples it
+	// has, over which the long window is provisional until it fills up.
+	listenerWindowMaxDuration.Override(ctx, &st.SV, time.Minute)
+	tick()
+	require.Equal(t, 5, s.mu.ringBuffer.Cap())
+	requireWindow(long.provisional[len(long.provisional)-1], 3)
+	requireWindow(short.samples[len(short.samples)-1], 2)
+	tick()
+	requireWindow(long.provisional[len(long.provisional)-1], 4)
+	tick()
+	requireWindow(long.samples[len(long.samples)-1], 5)
+
+	// Once the listener is removed, the ring buffer is sized for the sampler's
+	// own window again.
+	s.removeListener(longListener)
+	tick()
+	require.Equal(t, 3, s.mu.ringBuffer.Cap())
+	requireWindow(short.samples[len(short.samples)-1], 2)
+	requireWindow(sampler.samples[len(sampler.samples)-1], 3)
+}
+
 func TestCloneHistogram(t *testing.T) {
 	hist := metrics.Float64Histogram{
 		Counts:  []uint64{9, 7, 6, 5, 4, 2, 0, 1, 2, 5},
@@ -257,6 +2148,47 @@ func TestCloneHistogram(t *testing.T) {
 	require.Equal(t, cloned.Buckets, hist.Buckets)
 }
 
+// TestSamplerRetainsCopies verifies that the sampler retains copies of the
+// histograms sampled, such that scribbling over them after the fact (as reusing
+// their memory would) doesn't affect the windows computed, and that the copies
+// are recycled as they're evicted.
+func TestSamplerRetainsCopies(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, nil)
+
+	// The same histogram is returned for both latencies and GC pauses every
+	// tick, overwritten with the cumulative counts.
+	var cumulative uint64
+	scratch := &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}
+	s.sample = func() runtimeSample {
+		cumulative += 100
+		scratch.Counts[0] = cumulative
+		return runtimeSample{latencies: scratch, windowed: []runtimeValue{{histogram: scratch, ok: true}}}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+
+	retained := make(map[*metrics.Float64Histogram]struct{})
+	for i := 0; i < 20; i++ {
+		s.tick(ctx)
+		scratch.Counts[0] = 0
+
+		for j := 0; j < s.mu.ringBuffer.Len(); j++ {
+			sample := s.mu.ringBuffer.Get(j)
+			require.NotSame(t, scratch, sample.latencies)
+			require.Nil(t, sample.windowed) // retained by the GC pause window
+			retained[sample.latencies] = struct{}{}
+		}
+		if i >= 2 {
+			require.Equal(t, uint64(200), listener.samples[len(listener.samples)-1].Events)
+			require.Equal(t, []uint64{200}, s.mu.runtime.windows[0].histograms.interval.Counts)
+		}
+	}
+	// Copies are recycled: between the two windows, no more than a few are
+	// ever allocated.
+	require.LessOrEqual(t, len(retained), 2*(s.mu.ringBuffer.Cap()+1))
+}
+
 // BenchmarkSampleSchedulerLatencies measures the overhead of sampling scheduler
 // latencies.
 //
@@ -286,6 +2218,52 @@ func BenchmarkComputeSchedulerP99Latency(b *testing.B) {
 	}
 }
 
+// BenchmarkComputeSchedulerPercentiles compares computing the four percentiles
+// the sampler needs every tick one at a time, against computing them at once,
+// and against querying them from prefix sums computed for the purpose.
+func BenchmarkComputeSchedulerPercentiles(b *testing.B) {
+	s := rebin(sample(), coarseBuckets())
+	b.Run("individually", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, p := range windowPercentiles {
+				percentile(s, p)
+			}
+		}
+	})
+	b.Run("at-once", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			percentiles(s, windowPercentiles)
+		}
+	})
+	b.Run("prefix-sums", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			histogramutil.NewPrefixSums(s).Percentiles(windowPercentiles)
+		}
+	})
+}
+
+// BenchmarkComputeSchedulerP99LatencyCoarse is like
+// BenchmarkComputeSchedulerP99Latency, but computes the p99 from the coarse
+// layout the sampler re-bins into.
+func BenchmarkComputeSchedulerP99LatencyCoarse(b *testing.B) {
+	s := rebin(sample(), coarseBuckets())
+	for i := 0; i < b.N; i++ {
+		percentile(s, 0.99)
+	}
+}
+
+// BenchmarkRebinLatencyHistogram measures how long it takes to re-bin a
+// scheduling latency histogram obtained from the Go runtime into the coarse
+// layout.
+func BenchmarkRebinLatencyHistogram(b *testing.B) {
+	s, coarse := sample(), coarseBuckets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rebin(s, coarse)
+	}
+}
+
 // BenchmarkCloneLatencyHistogram measures how long it takes to clone scheduling
 // latency histogram obtained from the Go runtime.
 //
@@ -301,6 +2279,18 @@ func BenchmarkCloneLatencyHistogram(b *testing.B) {
 	}
 }
 
+// BenchmarkCloneLatencyHistogramCoarse is like BenchmarkCloneLatencyHistogram,
+// but for the coarse layout the sampler retains in its ring buffer; allocated
+// bytes per op reflect the memory retained per sample.
+func BenchmarkCloneLatencyHistogramCoarse(b *testing.B) {
+	s := rebin(sample(), coarseBuckets())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clone(s)
+	}
+}
+
 // BenchmarkSubtractLatencyHistograms measures how long it takes to subtract a
 // histogram from another.
 //
@@ -315,3 +2305,17 @@ func BenchmarkSubtractLatencyHistograms(b *testing.B) {
 		sub(a, z)
 	}
 }
+
+// BenchmarkSampleOnTick measures the per-tick overhead of the sampler,
+// including reading runtime metrics, computing windowed statistics using the
+// default settings, and invoking a single (no-op) callback.
+func BenchmarkSampleOnTick(b *testing.B) {
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	s := newSampler(st, samplePeriod.Default(), sampleDuration.Default())
+	s.addListener(&countingListener{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.sampleOnTickAndInvokeCallbacks(ctx, samplePeriod.Default())
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+)
+
+// Callback is any of the kinds of callbacks registered with this package.
+type Callback interface {
+	MutexWaitCallback | GCPauseCallback | OverloadCallback | CgroupThrottlingCallback
+}
+
+// RegisterCallbackWithStopper registers a callback, like the Register*Callback
+// function for its kind, for the lifetime of the given stopper: it's
+// unregistered automatically once the stopper begins quiescing, for callbacks
+// referencing the state of a subsystem tied to the stopper not to outlive it.
+// Stopping the stopper waits for an ongoing run of the callback, if any, to
+// return, and it isn't run again once the stopper has stopped. The callback is
+// named as given in logs. minInterval is as for RegisterMutexWaitCallback, and
+// is ignored for OverloadCallbacks, which are run on every transition.
+//
+// It returns an error, having not registered the callback, if the stopper is
+// already quiescing.
+func RegisterCallbackWithStopper[CB Callback](
+	stopper *stop.Stopper, name string, cb CB, minInterval time.Duration,
+) error {
+	r := registryOf[CB]()
+	if _, ok := any(cb).(OverloadCallback); ok {
+		minInterval = 0
+	}
+	id := r.registerNamed(cb, minInterval, name)
+	// The callback is unregistered by a task rather than using OnQuiesce, which
+	// runs under the stopper's lock: waiting there for an ongoing run would
+	// deadlock with callbacks starting tasks on the same stopper.
+	if err := stopper.RunAsyncTask(context.Background(), "scheduler-latency-callback-"+name,
+		func(ctx context.Context) {
+			<-stopper.ShouldQuiesce()
+			r.unregister(id)
+		}); err != nil {
+		r.unregister(id)
+		return err
+	}
+	return nil
+}
+
+// registryOf returns the registry of the given kind of callbacks.
+func registryOf[CB Callback]() *callbackRegistry[CB] {
+	var r any
+	var cb CB
+	switch any(cb).(type) {
+	case MutexWaitCallback:
+		r = &mutexWaitCallbacks
+	case GCPauseCallback:
+		r = &gcPauseCallbacks
+	case OverloadCallback:
+		r = &overloadCallbacks
+	case CgroupThrottlingCallback:
+		r = &cgroupThrottlingCallbacks
+	}
+	return r.(*callbackRegistry[CB])
+}
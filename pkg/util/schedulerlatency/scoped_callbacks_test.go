@@ -0,0 +1,101 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegisterCallbackWithStopper verifies that a callback registered for the
+// lifetime of a stopper is no longer run once the stopper stops mid-stream, and
+// that stopping it waits out an ongoing run.
+func TestRegisterCallbackWithStopper(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, 2*time.Second, busySample())
+
+	var invoked atomic.Int64
+	inside, release := make(chan struct{}), make(chan struct{})
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, RegisterCallbackWithStopper(stopper, "test", MutexWaitCallback(
+		func(time.Duration, time.Duration) {
+			if invoked.Add(1) == 5 {
+				// Block the fifth run, for the stopper to be stopped during it.
+				close(inside)
+				<-release
+			}
+		}), 0 /* minInterval */))
+	require.Len(t, mutexWaitCallbacks.snapshot(), 1)
+
+	ticking := make(chan struct{})
+	var ticks atomic.Int64
+	go func() {
+		defer close(ticking)
+		for ticks.Load() < 100 {
+			s.tick(ctx)
+			ticks.Add(1)
+		}
+	}()
+
+	<-inside
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		stopper.Stop(ctx)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopper stopped during a run of the callback")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	require.Empty(t, mutexWaitCallbacks.snapshot())
+	require.Equal(t, int64(5), invoked.Load())
+	<-ticking
+	require.Equal(t, int64(5), invoked.Load())
+}
+
+// TestRegisterCallbackWithStopperKinds verifies that callbacks of every kind
+// are registered with their own registry, and aren't registered if the stopper
+// is already quiescing.
+func TestRegisterCallbackWithStopperKinds(t *testing.T) {
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	require.NoError(t, RegisterCallbackWithStopper(stopper, "mutex",
+		MutexWaitCallback(func(time.Duration, time.Duration) {}), 0 /* minInterval */))
+	require.NoError(t, RegisterCallbackWithStopper(stopper, "gc",
+		GCPauseCallback(func(time.Duration, time.Duration) {}), time.Minute))
+	require.NoError(t, RegisterCallbackWithStopper(stopper, "admission",
+		OverloadCallback(func(bool) {}), time.Minute))
+	require.Len(t, mutexWaitCallbacks.snapshot(), 1)
+	require.Len(t, gcPauseCallbacks.snapshot(), 1)
+	require.Equal(t, time.Minute, gcPauseCallbacks.snapshot()[0].throttle.interval)
+	overload := overloadCallbacks.snapshot()
+	require.Len(t, overload, 1)
+	require.Zero(t, overload[0].throttle.interval)
+	require.Equal(t, fmt.Sprintf("overload callback %d (admission)", overload[0].id), overload[0].name)
+	stopper.Stop(ctx)
+	require.Empty(t, mutexWaitCallbacks.snapshot())
+	require.Empty(t, gcPauseCallbacks.snapshot())
+	require.Empty(t, overloadCallbacks.snapshot())
+
+	require.Error(t, RegisterCallbackWithStopper(stopper, "late",
+		MutexWaitCallback(func(time.Duration, time.Duration) {}), 0 /* minInterval */))
+	require.Empty(t, mutexWaitCallbacks.snapshot())
+}
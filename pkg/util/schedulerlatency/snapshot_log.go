@@ -0,0 +1,106 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/eventpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+)
+
+// snapshotLogInterval controls the low-frequency persistence of scheduler
+// latency snapshots to the HEALTH channel, for post-incident analysis once the
+// in-memory history and the metrics have expired.
+var snapshotLogInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.snapshot_log.interval",
+	"the interval at which the p50, p99 and max scheduler latency over the most recent "+
+		"scheduler_latency.sample_duration are recorded as a structured event (0 disables it)",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// snapshotLogger records scheduler latency snapshots as structured events, at
+// most once every scheduler_latency.snapshot_log.interval. The events are
+// emitted by a goroutine of its own, so the tick loop never blocks on logging;
+// snapshots handed to it while it's yet to emit the previous one are dropped.
+type snapshotLogger struct {
+	// throttle is guarded by the sampler's lock.
+	throttle deliveryThrottle
+	pending  chan *eventpb.GoSchedulerLatencySnapshot
+	emit     func(context.Context, logpb.Severity, logpb.EventPayload)
+}
+
+func makeSnapshotLogger() snapshotLogger {
+	return snapshotLogger{
+		pending: make(chan *eventpb.GoSchedulerLatencySnapshot, 1),
+		emit:    log.StructuredEvent,
+	}
+}
+
+// due returns true, recording a snapshot, if one is due at the given time.
+func (l *snapshotLogger) due(at time.Time, interval time.Duration) bool {
+	if interval == 0 {
+		l.throttle = deliveryThrottle{}
+		return false
+	}
+	l.throttle.interval = interval
+	return l.throttle.ready(at)
+}
+
+// hand the given snapshot to the logger's goroutine, unless it's yet to emit
+// the previous one.
+func (l *snapshotLogger) hand(ev *eventpb.GoSchedulerLatencySnapshot) {
+	select {
+	case l.pending <- ev:
+	default:
+	}
+}
+
+// run emits the snapshots handed to the logger until the stopper quiesces.
+func (l *snapshotLogger) run(ctx context.Context, stopper *stop.Stopper) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopper.ShouldQuiesce():
+			return
+		case ev := <-l.pending:
+			ev.NodeID = nodeIDFromContext(ctx)
+			l.emit(ctx, severity.INFO, ev)
+		}
+	}
+}
+
+// maybeLogSnapshotLocked hands a snapshot of the given window to the snapshot
+// logger, if scheduler_latency.snapshot_log.interval is set and one is due.
+// Like the windowed quantile gauges, idle windows report zero latencies.
+func (s *sampler) maybeLogSnapshotLocked(w window) {
+	if !s.snapshots.due(w.at, snapshotLogInterval.Get(&s.mu.st.SV)) {
+		return
+	}
+	ev := &eventpb.GoSchedulerLatencySnapshot{
+		P50Nanos:    w.p50.Nanoseconds(),
+		P99Nanos:    w.p99.Nanoseconds(),
+		Events:      w.events,
+		WindowNanos: w.elapsed.Nanoseconds(),
+	}
+	if !w.idle {
+		ev.MaxNanos = RebucketToQuantiles(s.mu.lastIntervalHistogram, w.elapsed).Max.Nanoseconds()
+	}
+	s.snapshots.hand(ev)
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/log/eventpb"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSnapshotLog verifies that scheduler latency snapshots are recorded as
+// structured events, off the tick loop, at most once every
+// scheduler_latency.snapshot_log.interval.
+func TestSnapshotLog(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	st := s.st
+
+	// Buckets: [0, 1ms), [1ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, math.Inf(+1)},
+	}
+	s.sample = func() runtimeSample { return runtimeSample{latencies: clone(cumulative)} }
+	// Every tick observes a different number of events, for the snapshots to
+	// identify the tick they were taken at.
+	tick := func(events uint64) {
+		cumulative.Counts[0] += events
+		s.tick(ctx)
+	}
+
+	type event struct {
+		sev     logpb.Severity
+		payload logpb.EventPayload
+	}
+	emitted := make(chan event, 100)
+	s.snapshots.emit = func(_ context.Context, sev logpb.Severity, payload logpb.EventPayload) {
+		emitted <- event{sev: sev, payload: payload}
+	}
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, stopper.RunAsyncTask(ctx, "snapshot-logger", func(ctx context.Context) {
+		s.snapshots.run(ctx, stopper)
+	}))
+	requireEmitted := func(events uint64) {
+		t.Helper()
+		select {
+		case ev := <-emitted:
+			require.Equal(t, event{
+				sev: logpb.Severity_INFO,
+				payload: &eventpb.GoSchedulerLatencySnapshot{
+					P50Nanos:    (500 * time.Microsecond).Nanoseconds(),
+					P99Nanos:    (990 * time.Microsecond).Nanoseconds(),
+					MaxNanos:    time.Millisecond.Nanoseconds(),
+					Events:      events,
+					WindowNanos: time.Second.Nanoseconds(),
+				},
+			}, ev)
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatalf("snapshot with %d events not emitted", events)
+		}
+	}
+
+	// Nothing is recorded unless enabled.
+	for i := uint64(0); i < 5; i++ {
+		tick(100 + i)
+	}
+
+	// Snapshots are recorded once every three ticks, starting with the first
+	// one after being enabled.
+	snapshotLogInterval.Override(ctx, &st.SV, 3*time.Second)
+	for i := uint64(0); i < 10; i++ {
+		tick(200 + i)
+		if i%3 == 0 {
+			requireEmitted(200 + i)
+		}
+	}
+
+	// Nor are they once disabled.
+	snapshotLogInterval.Override(ctx, &st.SV, 0)
+	for i := uint64(0); i < 5; i++ {
+		tick(300 + i)
+	}
+
+	// Having stopped the logger, there are no snapshots still to be emitted,
+	// nor any that were unexpectedly.
+	stopper.Stop(ctx)
+	require.Empty(t, s.snapshots.pending)
+	require.Empty(t, emitted)
+}
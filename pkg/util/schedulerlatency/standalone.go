@@ -0,0 +1,141 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// SamplerOptions configure a sampler constructed using NewSampler.
+type SamplerOptions struct {
+	// Period is the duration between consecutive samples. If zero, it's
+	// derived from GOMAXPROCS, like scheduler_latency.sample_period.
+	Period time.Duration
+	// Duration is the window over which latencies are computed; it must be at
+	// least twice the Period.
+	Duration time.Duration
+	// Percentiles, each in (0, 1], are computed over every full window and
+	// delivered as Sample.Percentiles, in the same order.
+	Percentiles []float64
+	// Listeners are invoked with every window, as they would be if passed to
+	// StartSampler; they can be wrapped using WithMinDeliveryInterval,
+	// WithPriority, and WithWindowDuration.
+	Listeners []LatencyObserver
+	// TimeSource drives the sampler's ticks, and timestamps samples. If nil,
+	// the real clock is used.
+	TimeSource timeutil.TimeSource
+	// TestingAllowSubMillisecondPeriod lowers the minimum Period from 1ms (as
+	// enforced for scheduler_latency.sample_period) to minTestingSamplePeriod,
+	// for stress tests exploring the sampler at extreme frequencies, and unit
+	// tests using real tickers. The ring buffer remains bounded by
+	// maxWindowSamples, which caps the window at such periods.
+	TestingAllowSubMillisecondPeriod bool
+}
+
+// minTestingSamplePeriod is the minimum period of samplers constructed with
+// SamplerOptions.TestingAllowSubMillisecondPeriod set.
+const minTestingSamplePeriod = 50 * time.Microsecond
+
+// Validate checks that the options are usable.
+func (o SamplerOptions) Validate() error {
+	if o.Period < 0 {
+		return errors.Newf("sample period must be non-negative, got %s", o.Period)
+	}
+	minPeriod := time.Millisecond
+	if o.TestingAllowSubMillisecondPeriod {
+		minPeriod = minTestingSamplePeriod
+	}
+	if o.Period > 0 && o.Period < minPeriod {
+		return errors.Newf("minimum sample period is %s, got %s", minPeriod, o.Period)
+	}
+	if o.Duration <= 0 {
+		return errors.Newf("sample duration must be positive, got %s", o.Duration)
+	}
+	if o.Duration < minSamplesPerWindow*o.Period {
+		return errors.Newf("sample duration (%s) must be at least %d times the sample period (%s)",
+			o.Duration, minSamplesPerWindow, o.Period)
+	}
+	for _, p := range o.Percentiles {
+		if !(p > 0 && p <= 1) { // rejects NaNs too
+			return errors.Newf("percentiles must be in (0, 1], got %v", p)
+		}
+	}
+	for i, l := range o.Listeners {
+		if l == nil {
+			return errors.Newf("listener %d is nil", i)
+		}
+	}
+	return nil
+}
+
+// Sampler is a scheduler latency sampler constructed using NewSampler, for use
+// outside of a server (by CLI tools, workload generators, and the like).
+type Sampler struct {
+	s          *sampler
+	timeSource timeutil.TimeSource
+}
+
+// NewSampler constructs a sampler configured using the given options rather
+// than cluster settings; the knobs the options don't cover take the defaults of
+// their settings. Unlike the sampler started by StartSampler, it's
+// private to its embedder: it doesn't publish to Latest (and the debug
+// endpoints), nor does it export metrics or run the callbacks registered with
+// this package. Any number of them can run alongside the shared one; use Run
+// to start it.
+func NewSampler(opts SamplerOptions) (*Sampler, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid scheduler latency sampler options")
+	}
+	if opts.TimeSource == nil {
+		opts.TimeSource = timeutil.DefaultTimeSource{}
+	}
+	s := newSamplerWithOptions(cluster.MakeClusterSettings(), opts)
+	s.standalone = true
+	return &Sampler{s: s, timeSource: opts.TimeSource}, nil
+}
+
+// newSamplerWithOptions constructs a sampler using the given options, and the
+// given settings for the knobs the options don't cover.
+func newSamplerWithOptions(st *cluster.Settings, opts SamplerOptions) *sampler {
+	s := newSampler(st, opts.Period, opts.Duration)
+	s.percentiles = append([]float64(nil), opts.Percentiles...)
+	if opts.TimeSource != nil {
+		s.mu.timeSource = opts.TimeSource
+	}
+	for _, l := range opts.Listeners {
+		s.addListener(l)
+	}
+	return s
+}
+
+// Run starts the sampler's tick loop as an async task of the given stopper,
+// returning once it's started. It runs until the context is canceled or the
+// stopper quiesces, after which it can be run again; it returns an error if it's
+// already running.
+func (s *Sampler) Run(ctx context.Context, stopper *stop.Stopper) error {
+	shared.Lock()
+	defer shared.Unlock()
+	if s.s.running {
+		return errors.AssertionFailedf("scheduler latency sampler is already running")
+	}
+	s.s.mu.Lock()
+	st := s.s.mu.st
+	s.s.mu.Unlock()
+	return s.s.startLocked(&attachment{
+		ctx: ctx, st: st, stopper: stopper, timeSource: s.timeSource,
+	})
+}
@@ -0,0 +1,298 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSamplerOptionsValidate verifies the validation of SamplerOptions.
+func TestSamplerOptionsValidate(t *testing.T) {
+	valid := SamplerOptions{Period: time.Second, Duration: 2 * time.Second}
+	for _, tc := range []struct {
+		name   string
+		modify func(*SamplerOptions)
+		expErr string
+	}{
+		{name: "valid", modify: func(*SamplerOptions) {}},
+		{name: "derived period", modify: func(o *SamplerOptions) { o.Period = 0 }},
+		{name: "percentiles", modify: func(o *SamplerOptions) { o.Percentiles = []float64{0.5, 1} }},
+		{name: "negative period", modify: func(o *SamplerOptions) { o.Period = -time.Second },
+			expErr: "sample period must be non-negative"},
+		{name: "short period", modify: func(o *SamplerOptions) { o.Period = time.Microsecond },
+			expErr: "minimum sample period is 1ms"},
+		{name: "sub-millisecond period", modify: func(o *SamplerOptions) {
+			o.Period, o.TestingAllowSubMillisecondPeriod = 100*time.Microsecond, true
+		}},
+		{name: "testing period", modify: func(o *SamplerOptions) {
+			o.Period, o.TestingAllowSubMillisecondPeriod = 10*time.Microsecond, true
+		}, expErr: "minimum sample period is 50µs"},
+		{name: "no duration", modify: func(o *SamplerOptions) { o.Duration = 0 },
+			expErr: "sample duration must be positive"},
+		{name: "short duration", modify: func(o *SamplerOptions) { o.Duration = time.Second },
+			expErr: "must be at least 2 times the sample period"},
+		{name: "zero percentile", modify: func(o *SamplerOptions) { o.Percentiles = []float64{0} },
+			expErr: "percentiles must be in (0, 1]"},
+		{name: "NaN percentile", modify: func(o *SamplerOptions) { o.Percentiles = []float64{math.NaN()} },
+			expErr: "percentiles must be in (0, 1]"},
+		{name: "nil listener", modify: func(o *SamplerOptions) { o.Listeners = []LatencyObserver{nil} },
+			expErr: "listener 0 is nil"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := valid
+			tc.modify(&opts)
+			err := opts.Validate()
+			_, newErr := NewSampler(opts)
+			if tc.expErr == "" {
+				require.NoError(t, err)
+				require.NoError(t, newErr)
+				return
+			}
+			require.ErrorContains(t, err, tc.expErr)
+			require.ErrorContains(t, newErr, tc.expErr)
+		})
+	}
+}
+
+// TestStandaloneSampler exercises a sampler constructed using NewSampler,
+// driven by a manual clock and injected histograms.
+func TestStandaloneSampler(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	var listener lockedSampleListener
+	s, err := NewSampler(SamplerOptions{
+		Period:      time.Second,
+		Duration:    2 * time.Second,
+		Percentiles: []float64{0.5, 0.99},
+		Listeners:   []LatencyObserver{&listener},
+		TimeSource:  clock,
+	})
+	require.NoError(t, err)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 90
+		cumulative.Counts[1] += 10
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	// The sampler is private: it doesn't run the callbacks registered with the
+	// package.
+	id := RegisterMutexWaitCallback(func(time.Duration, time.Duration) {
+		t.Error("unexpected mutex wait callback")
+	}, 0 /* minInterval */)
+	defer UnregisterMutexWaitCallback(id)
+
+	// Nor does it publish to the package-level accessors (which other tests'
+	// samplers may have published to).
+	published := latest.Load()
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, s.Run(ctx, stopper))
+	require.ErrorContains(t, s.Run(ctx, stopper), "already running")
+
+	// tick advances the clock by a sample period, and waits for the sampler to
+	// process the tick.
+	tick := func() {
+		prev := s.s.metrics.Ticks.Count() + s.s.metrics.SkippedTicks.Count()
+		clock.Advance(time.Second)
+		testutils.SucceedsSoon(t, func() error {
+			if s.s.metrics.Ticks.Count()+s.s.metrics.SkippedTicks.Count() == prev {
+				return errors.New("tick yet to be processed")
+			}
+			return nil
+		})
+		// The tick is counted as it starts; wait out the deliveries.
+		s.s.mu.Lock()
+		defer s.s.mu.Unlock()
+	}
+	// The first tick is the baseline, and the second yields a provisional
+	// window; the third yields a full one.
+	for i := 0; i < 3; i++ {
+		tick()
+	}
+	samples := listener.get()
+	require.Len(t, samples, 1)
+	sample := samples[0]
+	require.Equal(t, time.Second, sample.Period)
+	require.Equal(t, 2*time.Second, sample.Elapsed)
+	require.Equal(t, uint64(200), sample.Events)
+	require.Equal(t, 1900*time.Microsecond, sample.P99)
+	// Of the 180 fast events, the 100th is the p50.
+	require.Equal(t, []time.Duration{SecondsToDuration(0.001 * 100 / 180), sample.P99}, sample.Percentiles)
+	require.Equal(t, published, latest.Load())
+
+	// Stopping it delivers a final sample, over the time since the last tick.
+	clock.Advance(500 * time.Millisecond)
+	stopper.Stop(ctx)
+	samples = listener.get()
+	require.Len(t, samples, 2)
+	require.True(t, samples[1].Final)
+	require.Equal(t, 500*time.Millisecond, samples[1].Elapsed)
+
+	// It can be run again once stopped.
+	stopper = stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, s.Run(ctx, stopper))
+	tick()
+	require.Len(t, listener.get(), 3)
+}
+
+// TestStandaloneSamplerSubMillisecond stresses a sampler ticking every 100µs
+// using a real ticker, with injected histograms: it's most useful under the
+// race detector, verifying that the values delivered are sane while the
+// sampler's state is read concurrently.
+func TestStandaloneSamplerSubMillisecond(t *testing.T) {
+	ctx := context.Background()
+	var listener checkingListener
+	s, err := NewSampler(SamplerOptions{
+		Period:                           100 * time.Microsecond,
+		Duration:                         time.Millisecond,
+		Percentiles:                      []float64{0.5, 0.9, 0.99, 0.999},
+		Listeners:                        []LatencyObserver{&listener},
+		TestingAllowSubMillisecondPeriod: true,
+	})
+	require.NoError(t, err)
+	// The histograms are injected, with random counts (or none at all, every
+	// so often) in each bucket, including the unbounded ones.
+	rng, _ := randutil.NewTestRand()
+	buckets := []float64{math.Inf(-1), 0, 1e-5, 1e-4, 1e-3, 1e-2, math.Inf(+1)}
+	cumulative := &metrics.Float64Histogram{
+		Counts:  make([]uint64, len(buckets)-1),
+		Buckets: buckets,
+	}
+	s.s.sample = func() runtimeSample {
+		if rng.Intn(10) > 0 {
+			for i := range cumulative.Counts {
+				cumulative.Counts[i] += uint64(rng.Intn(100))
+			}
+		}
+		return runtimeSample{latencies: clone(cumulative), gomaxprocs: 2}
+	}
+
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	require.NoError(t, s.Run(ctx, stopper))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // read the sampler's state concurrently with its ticks
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, _ = s.s.percentileNow(0.99)
+			_, _ = s.s.debugHistogram()
+			_ = s.s.dump()
+		}
+	}()
+	// Timers this fine-grained are at the mercy of the environment: ticks may
+	// be skipped, or spaced out enough for the sampler to re-baseline, so only
+	// the ticks are waited for, not deliveries.
+	testutils.SucceedsSoon(t, func() error {
+		if n := s.s.metrics.Ticks.Count() + s.s.metrics.SkippedTicks.Count(); n < 200 {
+			return errors.Newf("%d ticks so far", n)
+		}
+		return nil
+	})
+	close(done)
+	wg.Wait()
+	stopper.Stop(ctx)
+	require.NoError(t, listener.error())
+}
+
+// TestMaxWindowSamples verifies that windows are capped to maxWindowSamples
+// sample periods.
+func TestMaxWindowSamples(t *testing.T) {
+	s, err := NewSampler(SamplerOptions{
+		Period:                           50 * time.Microsecond,
+		Duration:                         10 * time.Second,
+		Listeners:                        []LatencyObserver{WithWindowDuration(&sampleListener{}, time.Minute)},
+		TestingAllowSubMillisecondPeriod: true,
+	})
+	require.NoError(t, err)
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	s.s.sizeRingLocked()
+	require.Equal(t, maxWindowSamples, s.s.mu.windowSamples)
+	require.Equal(t, maxWindowSamples*50*time.Microsecond, s.s.mu.duration)
+	require.Equal(t, maxWindowSamples, s.s.mu.ringBuffer.Cap())
+}
+
+// checkingListener checks the sanity of the samples delivered to it, which can
+// be read concurrently with the sampler's tick loop.
+type checkingListener struct {
+	mu struct {
+		syncutil.Mutex
+		deliveries int
+		err        error
+	}
+}
+
+var _ SampleObserver = &checkingListener{}
+
+func (l *checkingListener) SchedulerLatency(time.Duration, time.Duration) {
+	panic("unexpected call to legacy interface")
+}
+
+func (l *checkingListener) SchedulerLatencySample(s Sample) {
+	var err error
+	switch {
+	case s.P99 < 0 || s.P99 > 10*time.Millisecond:
+		err = errors.Newf("p99 out of range: %s", s.P99)
+	case s.Elapsed <= 0:
+		err = errors.Newf("non-positive elapsed: %s", s.Elapsed)
+	case math.IsNaN(s.LatencyRatio) || math.IsInf(s.LatencyRatio, 0) || s.LatencyRatio < 0:
+		err = errors.Newf("invalid latency ratio: %f", s.LatencyRatio)
+	case s.Idle && s.P99 != 0:
+		err = errors.Newf("idle sample with p99 %s", s.P99)
+	}
+	for i := 1; i < len(s.Percentiles); i++ {
+		if s.Percentiles[i] < s.Percentiles[i-1] {
+			err = errors.Newf("unordered percentiles: %v", s.Percentiles)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mu.deliveries++
+	l.mu.err = errors.CombineErrors(l.mu.err, err)
+}
+
+func (l *checkingListener) deliveries() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mu.deliveries
+}
+
+func (l *checkingListener) error() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mu.err
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+var metaDroppedDeliveries = metric.Metadata{
+	Name:        "go.scheduler_latency.sampler.dropped_deliveries",
+	Help:        "Number of scheduler latency samples dropped instead of sent to subscriptions whose buffer was full",
+	Measurement: "Deliveries",
+	Unit:        metric.Unit_COUNT,
+}
+
+// Subscribe subscribes to the scheduler latency samples, for consumers that
+// would rather select on a channel in their own control loop than be invoked
+// on the sampler's goroutine. Every sample a SampleObserver attached through
+// StartSampler is delivered (including provisional, idle and final ones), is
+// sent on the returned channel, buffered as given (buffer must be
+// non-negative). Sends never block the sampler: a sample is dropped if the
+// buffer is full, and the samples dropped are counted (see
+// go.scheduler_latency.sampler.dropped_deliveries). Unlike those delivered to
+// listeners, the samples sent don't carry Sample.Previous; subscribers can keep
+// track of the previous one themselves.
+//
+// The subscription is process-wide, and outlives the sampler: if it's torn down
+// and started afresh, samples are sent again once it is. The returned function
+// cancels the subscription, closing the channel once an ongoing send, if any,
+// has returned; it's idempotent, and can be called from any goroutine,
+// including concurrently with (or from within) a delivery. The channel is
+// garbage collected once cancelled and no longer referenced.
+func Subscribe(buffer int) (<-chan Sample, func()) {
+	ch := make(chan Sample, buffer)
+	id := subscriptions.registerNamed(ch, 0 /* minInterval */, fmt.Sprintf("buffer %d", buffer))
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			// Unregistering waits out an ongoing send, after which there are
+			// none: the channel can be closed.
+			subscriptions.unregister(id)
+			close(ch)
+		})
+	}
+}
+
+var subscriptions = callbackRegistry[chan Sample]{kind: "subscription"}
+
+// sendToSubscriptionsLocked sends the given sample, delivered to listeners, to
+// every subscription with room in its buffer, dropping it for the others.
+func (s *sampler) sendToSubscriptionsLocked(sample Sample) {
+	sample.Previous = nil
+	for _, sub := range subscriptions.snapshot() {
+		sub.invoke(func(ch chan Sample) {
+			select {
+			case ch <- sample:
+			default:
+				s.metrics.DroppedDeliveries.Inc(1)
+			}
+		})
+	}
+}
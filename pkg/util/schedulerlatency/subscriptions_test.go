@@ -0,0 +1,150 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// funcListener is a SampleObserver invoking the given function.
+type funcListener func(Sample)
+
+var _ SampleObserver = funcListener(nil)
+
+func (f funcListener) SchedulerLatency(time.Duration, time.Duration) {}
+
+func (f funcListener) SchedulerLatencySample(s Sample) { f(s) }
+
+// TestSubscribe verifies that subscriptions are sent the samples delivered to
+// listeners, that samples are dropped (and counted) instead of blocking the
+// sampler when a subscription's buffer is full, and that cancelling a
+// subscription closes its channel, including during a delivery.
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T) *testSampler {
+		return newTestSampler(t, time.Second, 2*time.Second, busySample())
+	}
+	// drain returns the samples buffered in the given channel.
+	drain := func(ch <-chan Sample) []Sample {
+		var samples []Sample
+		for {
+			select {
+			case sample := <-ch:
+				samples = append(samples, sample)
+			default:
+				return samples
+			}
+		}
+	}
+
+	t.Run("consumption", func(t *testing.T) {
+		s := setup(t)
+		var listener sampleListener
+		s.addListener(&listener)
+		ch, cancel := Subscribe(10)
+		defer cancel()
+		for i := 0; i < 5; i++ {
+			s.tick(ctx)
+		}
+		// The subscription is sent every sample the listener is delivered,
+		// provisional ones included, without Previous.
+		expected := append([]Sample(nil), listener.provisional...)
+		for _, sample := range listener.samples {
+			sample.Previous = nil
+			expected = append(expected, sample)
+		}
+		require.Len(t, expected, 4)
+		require.NotNil(t, listener.samples[1].Previous)
+		require.Equal(t, expected, drain(ch))
+		require.Zero(t, s.metrics.DroppedDeliveries.Count())
+
+		// Standalone samplers don't send to subscriptions.
+		s.standalone = true
+		s.tick(ctx)
+		require.Len(t, listener.samples, 4)
+		require.Empty(t, drain(ch))
+	})
+
+	t.Run("slow consumer", func(t *testing.T) {
+		s := setup(t)
+		ch, cancel := Subscribe(2)
+		defer cancel()
+		for i := 0; i < 6; i++ {
+			s.tick(ctx) // doesn't block, despite nobody receiving
+		}
+		// The first two samples (of five) are buffered, the others dropped.
+		samples := drain(ch)
+		require.Len(t, samples, 2)
+		require.True(t, samples[0].Provisional)
+		require.Equal(t, int64(3), s.metrics.DroppedDeliveries.Count())
+		// Once there's room again, samples are sent again.
+		s.tick(ctx)
+		require.Len(t, drain(ch), 1)
+		require.Equal(t, int64(3), s.metrics.DroppedDeliveries.Count())
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		s := setup(t)
+		ch, cancel := Subscribe(10)
+		s.tick(ctx)
+		s.tick(ctx)
+		require.Len(t, subscriptions.snapshot(), 1)
+		cancel()
+		cancel() // it's idempotent
+		require.Empty(t, subscriptions.snapshot())
+		// The channel is closed, having first been sent the sample buffered.
+		sample, ok := <-ch
+		require.True(t, ok)
+		require.True(t, sample.Provisional)
+		_, ok = <-ch
+		require.False(t, ok)
+		s.tick(ctx) // nothing is sent
+		require.Zero(t, s.metrics.DroppedDeliveries.Count())
+	})
+
+	t.Run("cancellation during delivery", func(t *testing.T) {
+		s := setup(t)
+		// Cancelled by a listener, on the sampler's goroutine, during the
+		// delivery it would have been sent the sample of.
+		cancelled, cancelFromListener := Subscribe(10)
+		s.addListener(funcListener(func(Sample) { cancelFromListener() }))
+		s.tick(ctx)
+		s.tick(ctx)
+		_, ok := <-cancelled
+		require.False(t, ok)
+
+		// Cancelled by the consumer, concurrently with deliveries.
+		ch, cancel := Subscribe(1)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; len(subscriptions.snapshot()) > 0; i++ {
+				s.mu.Lock()
+				s.invokeListenersLocked(ctx, Sample{P99: time.Duration(i)})
+				s.mu.Unlock()
+			}
+		}()
+		var received int
+		for range ch {
+			if received++; received == 10 {
+				cancel()
+			}
+		}
+		wg.Wait()
+		require.Empty(t, subscriptions.snapshot())
+	})
+}
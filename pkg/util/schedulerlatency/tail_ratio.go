@@ -0,0 +1,41 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+var metaWindowedTailRatio = metric.Metadata{
+	Name:        "go.scheduler_latency.windowed-tail-ratio",
+	Help:        "Ratio of the p99 to the p50 Go scheduling latency over the last scheduler_latency.sample_duration, or zero if the p50 is (if scheduler_latency.quantiles_export.enabled is set)",
+	Measurement: "Ratio",
+	Unit:        metric.Unit_COUNT,
+}
+
+// tailRatio returns the window's Sample.TailRatio.
+func (w window) tailRatio() float64 {
+	if w.idle {
+		return 0
+	}
+	return tailRatio(w.p50, w.p99)
+}
+
+// tailRatio returns the ratio of the given p99 scheduler latency to the given
+// p50, or zero if the p50 is zero (or negative), the ratio being undefined.
+func tailRatio(p50, p99 time.Duration) float64 {
+	if p50 <= 0 {
+		return 0
+	}
+	return float64(p99) / float64(p50)
+}
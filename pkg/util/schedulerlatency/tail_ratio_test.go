@@ -0,0 +1,105 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTailRatio verifies the tail ratio computed over windows of synthetic
+// histograms, and that it's absent if the p50 is zero or the window is empty.
+func TestTailRatio(t *testing.T) {
+	start := timeutil.Unix(0, 0)
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	sample := func(fast, slow uint64, at time.Duration) runtimeSample {
+		return runtimeSample{
+			latencies: &metrics.Float64Histogram{
+				Counts:  []uint64{fast, slow},
+				Buckets: []float64{0, 0.001, 0.002},
+			},
+			at: start.Add(at),
+		}
+	}
+	for _, tc := range []struct {
+		name       string
+		fast, slow uint64 // events observed over the window, in either bucket
+		minEvents  uint64
+		expOK      bool
+		expRatio   float64
+	}{
+		// Half the events are slow: the p50 is 1ms and the p99 1.98ms, for
+		// general overload.
+		{name: "overload", fast: 100, slow: 100, expOK: true, expRatio: 1.98},
+		// A few are: the p50 is ~0.51ms and the p99 1.5ms, for sporadic stalls.
+		{name: "stalls", fast: 980, slow: 20, expOK: true, expRatio: 2.94},
+		// It's absent if the window is idle, or observed no events at all.
+		{name: "idle", fast: 100, slow: 100, minEvents: 1000, expOK: true},
+		{name: "empty", expOK: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldest := sample(0, 0, 0)
+			latest := sample(tc.fast, tc.slow, time.Second)
+			w, _, ok := computeWindow(latest, oldest, 1 /* samples */, time.Second, tc.minEvents)
+			require.Equal(t, tc.expOK, ok)
+			require.InDelta(t, tc.expRatio, w.tailRatio(), 1e-3)
+		})
+	}
+
+	// It's absent if the p50 is zero, rather than infinite.
+	require.Zero(t, tailRatio(0, time.Millisecond))
+	require.Zero(t, window{p99: time.Millisecond}.tailRatio())
+	require.Equal(t, 1.0, tailRatio(time.Millisecond, time.Millisecond))
+}
+
+// TestTailRatioDelivered verifies that the tail ratio is delivered to
+// listeners, and exported only if scheduler_latency.quantiles_export.enabled
+// is set.
+func TestTailRatioDelivered(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, time.Second, time.Second, nil)
+	st := s.st
+	// Buckets: [0, 1ms), [1ms, 2ms).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0},
+		Buckets: []float64{0, 0.001, 0.002},
+	}
+	s.sample = func() runtimeSample {
+		cumulative.Counts[0] += 100
+		cumulative.Counts[1] += 100
+		return runtimeSample{latencies: clone(cumulative)}
+	}
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func() {
+		s.tick(ctx)
+	}
+
+	tick()
+	tick()
+	require.Len(t, listener.samples, 1)
+	require.InDelta(t, 1.98, listener.samples[0].TailRatio, 1e-9)
+	require.Zero(t, s.metrics.WindowedTailRatio.Value()) // not exported
+
+	quantilesExportEnabled.Override(ctx, &st.SV, true)
+	tick()
+	require.InDelta(t, 1.98, s.metrics.WindowedTailRatio.Value(), 1e-9)
+
+	// Disabling the export clears it.
+	quantilesExportEnabled.Override(ctx, &st.SV, false)
+	tick()
+	require.Zero(t, s.metrics.WindowedTailRatio.Value())
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/errors"
+)
+
+var trendDeliveries = settings.RegisterIntSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.trend.deliveries",
+	"number of most recent samples over which the slope of the p99 scheduler latency is computed",
+	5,
+	settings.IntWithMinimum(2),
+)
+
+var ewmaAlpha = settings.RegisterFloatSetting(
+	settings.ApplicationLevel, // used in virtual clusters
+	"scheduler_latency.ewma.alpha",
+	"weight, in (0, 1], of the most recent sample in the exponentially weighted moving average "+
+		"of the p99 scheduler latency",
+	0.5,
+	settings.WithValidateFloat(func(v float64) error {
+		if v <= 0 || v > 1 {
+			return errors.Errorf("expected value in range (0, 1], got: %f", v)
+		}
+		return nil
+	}),
+)
+
+// trendPoint is a single p99 delivered to listeners.
+type trendPoint struct {
+	at  time.Time
+	p99 time.Duration
+}
+
+// p99Trend tracks the direction of the p99 scheduler latency, computing its
+// slope over the last few deliveries using a least-squares fit. It's reset
+// whenever the sampler re-baselines and skips deliveries, so the slope is never
+// computed across a gap.
+type p99Trend struct {
+	points []trendPoint // oldest first
+}
+
+// observe records the p99 delivered at the given time and returns the slope
+// over the last k deliveries (including this one), as the change in p99 per
+// second. It's zero with fewer than two deliveries.
+func (t *p99Trend) observe(at time.Time, p99 time.Duration, k int) time.Duration {
+	t.points = append(t.points, trendPoint{at: at, p99: p99})
+	if n := len(t.points); n > k {
+		copy(t.points, t.points[n-k:])
+		t.points = t.points[:k]
+	}
+	if len(t.points) < 2 {
+		return 0
+	}
+
+	// Fit p99 (in nanoseconds) against time (in seconds, relative to the oldest
+	// point to preserve precision).
+	var meanX, meanY float64
+	for _, p := range t.points {
+		meanX += p.at.Sub(t.points[0].at).Seconds()
+		meanY += float64(p.p99)
+	}
+	n := float64(len(t.points))
+	meanX, meanY = meanX/n, meanY/n
+	var cov, variance float64
+	for _, p := range t.points {
+		dx := p.at.Sub(t.points[0].at).Seconds() - meanX
+		cov += dx * (float64(p.p99) - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0 // all deliveries at the same instant; there's no slope
+	}
+	return time.Duration(cov / variance)
+}
+
+// reset discards all recorded deliveries.
+func (t *p99Trend) reset() {
+	t.points = t.points[:0]
+}
+
+// p99EWMA is an exponentially weighted moving average of the p99 scheduler
+// latency, smoothing it over deliveries. Like p99Trend, it's reset whenever the
+// sampler re-baselines.
+type p99EWMA struct {
+	value       float64 // in nanoseconds
+	initialized bool
+}
+
+// observe records the p99 delivered, weighing it by alpha, and returns the
+// resulting average. The first delivery after a reset initializes the average.
+func (e *p99EWMA) observe(p99 time.Duration, alpha float64) time.Duration {
+	if !e.initialized {
+		e.value, e.initialized = float64(p99), true
+	} else {
+		e.value = alpha*float64(p99) + (1-alpha)*e.value
+	}
+	return time.Duration(e.value)
+}
+
+// current returns the average, without recording a delivery; it's zero if
+// there's been none since the last reset.
+func (e *p99EWMA) current() time.Duration {
+	return time.Duration(e.value)
+}
+
+// reset discards the average.
+func (e *p99EWMA) reset() {
+	*e = p99EWMA{}
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestP99Trend(t *testing.T) {
+	const k = 5
+	at := func(i int) time.Time { return timeutil.Unix(int64(i), 0) }
+
+	for _, tc := range []struct {
+		name   string
+		p99s   []time.Duration // delivered once a second
+		slopes []time.Duration
+	}{
+		{
+			name:   "rising",
+			p99s:   []time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond},
+			slopes: []time.Duration{0, time.Millisecond, time.Millisecond},
+		},
+		{
+			name:   "falling",
+			p99s:   []time.Duration{9 * time.Millisecond, 7 * time.Millisecond, 5 * time.Millisecond},
+			slopes: []time.Duration{0, -2 * time.Millisecond, -2 * time.Millisecond},
+		},
+		{
+			name:   "flat",
+			p99s:   []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond},
+			slopes: []time.Duration{0, 0, 0},
+		},
+		{
+			// Only the last k deliveries count: the early spike ages out.
+			name: "aged-out",
+			p99s: []time.Duration{
+				100 * time.Millisecond,
+				time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond,
+			},
+			slopes: []time.Duration{
+				0,
+				-99 * time.Millisecond, // two points
+				-49500 * time.Microsecond,
+				-29700 * time.Microsecond,
+				-19800 * time.Microsecond,
+				0,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var trend p99Trend
+			for i, p99 := range tc.p99s {
+				requireDuration(t, tc.slopes[i], trend.observe(at(i), p99, k))
+			}
+		})
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		var trend p99Trend
+		trend.observe(at(0), time.Millisecond, k)
+		trend.observe(at(1), 2*time.Millisecond, k)
+		trend.reset()
+		require.Zero(t, trend.observe(at(5), 10*time.Millisecond, k))
+		requireDuration(t, -time.Millisecond, trend.observe(at(6), 9*time.Millisecond, k))
+	})
+
+	t.Run("irregular", func(t *testing.T) {
+		// Deliveries that aren't evenly spaced are fit against their timestamps.
+		var trend p99Trend
+		trend.observe(at(0), 0, k)
+		requireDuration(t, time.Millisecond, trend.observe(at(4), 4*time.Millisecond, k))
+	})
+}
+
+func TestP99EWMA(t *testing.T) {
+	var ewma p99EWMA
+	// The first delivery initializes the average.
+	require.Equal(t, 10*time.Millisecond, ewma.observe(10*time.Millisecond, 0.5))
+	require.Equal(t, 6*time.Millisecond, ewma.observe(2*time.Millisecond, 0.5))
+	require.Equal(t, 4*time.Millisecond, ewma.observe(2*time.Millisecond, 0.5))
+	// An alpha of 1 disables smoothing.
+	require.Equal(t, 8*time.Millisecond, ewma.observe(8*time.Millisecond, 1))
+
+	ewma.reset()
+	require.Equal(t, time.Millisecond, ewma.observe(time.Millisecond, 0.5))
+}
+
+// TestSampleP99EWMA verifies that the EWMA delivered to listeners converges to
+// the p99 after a step change in the latency distribution, at a rate determined
+// by alpha, and that it's reset when the sampler re-baselines.
+func TestSampleP99EWMA(t *testing.T) {
+	ctx := context.Background()
+
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, 3ms), [3ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, 0.003, math.Inf(+1)},
+	}
+	s := newTestSampler(t, time.Second, time.Second, func() runtimeSample {
+		return runtimeSample{latencies: clone(cumulative)}
+	})
+	st := s.st
+	var listener sampleListener
+	s.addListener(&listener)
+	tick := func(bucket int) Sample {
+		cumulative.Counts[bucket] += 100
+		s.tick(ctx)
+		return listener.samples[len(listener.samples)-1]
+	}
+
+	const low, high = 990 * time.Microsecond, 2990 * time.Microsecond
+	s.sampleOnTickAndInvokeCallbacks(ctx, time.Second) // nothing to compare against yet
+	for _, alpha := range []float64{0.5, 0.2} {
+		ewmaAlpha.Override(ctx, &st.SV, alpha)
+		// Settle at the low latency.
+		for i := 0; i < 100; i++ {
+			tick(0)
+		}
+		requireDuration(t, low, tick(0).P99EWMA)
+
+		// After a step change, the distance to the new p99 shrinks by a factor
+		// of (1-alpha) every window.
+		distance := float64(high - low)
+		for i := 0; i < 10; i++ {
+			sample := tick(2)
+			require.Equal(t, high, sample.P99)
+			distance *= 1 - alpha
+			require.InDelta(t, float64(high)-distance, float64(sample.P99EWMA), 1)
+			require.Less(t, sample.P99EWMA, high)
+		}
+		require.Equal(t, s.metrics.P99EWMA.Value(), listener.samples[len(listener.samples)-1].P99EWMA.Nanoseconds())
+
+		// Step back down.
+		for i := 0; i < 100; i++ {
+			tick(0)
+		}
+		requireDuration(t, low, tick(0).P99EWMA)
+	}
+
+	// Re-baselining the sampler resets the average, which starts afresh at the
+	// p99 of the first full window.
+	s.setPeriodAndDuration(time.Second, 2*time.Second)
+	s.tick(ctx)
+	tick(2)
+	require.Equal(t, high, tick(2).P99EWMA)
+}
+
+// TestSampleP99Slope verifies that the slope is delivered to listeners, and
+// that it's reset when the sampler re-baselines.
+func TestSampleP99Slope(t *testing.T) {
+	ctx := context.Background()
+
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, 3ms), [3ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, 0.003, math.Inf(+1)},
+	}
+	s := newTestSampler(t, time.Second, time.Second, func() runtimeSample {
+		return runtimeSample{latencies: clone(cumulative)}
+	})
+	var listener sampleListener
+	s.addListener(&listener)
+
+	tick := func(bucket int) {
+		cumulative.Counts[bucket] += 100
+		s.tick(ctx)
+	}
+	requireSlopes := func(expected ...time.Duration) {
+		t.Helper()
+		require.Len(t, listener.samples, len(expected))
+		for i, sample := range listener.samples {
+			requireDuration(t, expected[i], sample.P99Slope)
+		}
+		listener.samples = nil
+	}
+
+	tick(0) // nothing to compare against yet
+	for _, bucket := range []int{0, 1, 2} {
+		tick(bucket)
+	}
+	// The p99s are 990µs, 1.99ms and 2.99ms.
+	requireSlopes(0, time.Millisecond, time.Millisecond)
+
+	// Re-baseline the sampler; there are no deliveries until there's a full
+	// window again, and the slope starts afresh.
+	s.setPeriodAndDuration(time.Second, 2*time.Second)
+	tick(2)
+	tick(2)
+	requireSlopes()
+	tick(2)
+	tick(1)
+	// The p99s are 2.99ms and 2.98ms.
+	requireSlopes(0, -10*time.Microsecond)
+}
+
+// requireDuration asserts that the given durations are equal, give or take a
+// nanosecond of floating point error.
+func requireDuration(t *testing.T, expected, actual time.Duration) {
+	t.Helper()
+	require.InDelta(t, float64(expected), float64(actual), 1)
+}
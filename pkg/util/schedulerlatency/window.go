@@ -0,0 +1,207 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"runtime/metrics"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/ring"
+)
+
+// histogramWindow computes statistics over a sliding window of samples of a
+// cumulative runtime/metrics histogram, identified by name: it retains the
+// samples in a ring buffer, and subtracts the oldest from the latest to obtain
+// the histogram over the window, from which percentiles are computed. It's not
+// safe for concurrent use.
+type histogramWindow struct {
+	name string // the runtime/metrics name of the cumulative histogram
+	// pool, if set, is where the copies of the samples retained are drawn from
+	// and returned to.
+	pool *histogramPool
+	ring ring.Buffer[timedHistogram]
+	// interval is the histogram over the most recent full window, and elapsed
+	// the time elapsed over it; interval is nil if a full window is yet to be
+	// observed.
+	interval *metrics.Float64Histogram
+	elapsed  time.Duration
+}
+
+// timedHistogram is a sample of a cumulative histogram, and when it was taken.
+type timedHistogram struct {
+	h  *metrics.Float64Histogram
+	at time.Time
+}
+
+// makeHistogramWindow returns a window over the given number of samples of the
+// named cumulative histogram.
+func makeHistogramWindow(name string, samples int) histogramWindow {
+	w := histogramWindow{name: name, ring: ring.MakeBuffer(([]timedHistogram)(nil))}
+	w.resize(samples)
+	return w
+}
+
+// resize the window to span the given number of samples, discarding the ones
+// retained; the window is re-baselined.
+func (w *histogramWindow) resize(samples int) {
+	if samples < 1 {
+		samples = 1 // we need at least one sample to compare against
+	}
+	w.ring.Discard()
+	w.ring.Resize(samples)
+	w.interval, w.elapsed = nil, 0
+}
+
+// reset discards the samples retained, re-baselining the window.
+func (w *histogramWindow) reset() {
+	w.resize(w.ring.Cap())
+}
+
+// record the given cumulative sample, taken at the given time. It returns true
+// if a full window has been observed, in which case interval and elapsed are
+// updated to reflect it. The window retains a copy of the sample; the caller
+// is free to reuse it.
+func (w *histogramWindow) record(cumulative *metrics.Float64Histogram, at time.Time) bool {
+	cumulative = w.pool.clone(cumulative)
+	var oldest timedHistogram
+	var ok bool
+	if w.ring.Len() == w.ring.Cap() { // no more room, clear out the oldest
+		oldest, ok = w.ring.GetLast(), true
+		w.ring.RemoveLast()
+	}
+	w.ring.AddFirst(timedHistogram{h: cumulative, at: at})
+	if !ok {
+		return false
+	}
+	w.interval = sub(cumulative, oldest.h)
+	w.elapsed = at.Sub(oldest.at)
+	w.pool.release(oldest.h)
+	return true
+}
+
+// percentiles returns the given percentiles of the histogram over the most
+// recent full window, in the order requested, or false if a full window is yet
+// to be observed. They're zero if the window is empty.
+func (w *histogramWindow) percentiles(ps []float64) ([]time.Duration, bool) {
+	if w.interval == nil {
+		return nil, false
+	}
+	// An empty window has no percentiles to speak of, but it's a window
+	// nonetheless: there were no events, and we report zeroes.
+	res := make([]time.Duration, len(ps))
+	values, _ := percentiles(w.interval, ps)
+	for i, p := range values {
+		res[i] = SecondsToDuration(p)
+	}
+	return res, true
+}
+
+// counterWindow computes the increase of a cumulative runtime/metrics counter
+// over a sliding window of samples, like histogramWindow does for histograms.
+// It's not safe for concurrent use.
+type counterWindow struct {
+	ring ring.Buffer[timedCounter]
+	// increase is the increase over the most recent full window, and elapsed
+	// the time elapsed over it; full is unset if a full window is yet to be
+	// observed.
+	increase float64
+	elapsed  time.Duration
+	full     bool
+}
+
+// timedCounter is a sample of a cumulative counter, and when it was taken.
+type timedCounter struct {
+	v  float64
+	at time.Time
+}
+
+// makeCounterWindow returns a window over the given number of samples of a
+// cumulative counter.
+func makeCounterWindow(samples int) counterWindow {
+	w := counterWindow{ring: ring.MakeBuffer(([]timedCounter)(nil))}
+	w.resize(samples)
+	return w
+}
+
+// resize the window to span the given number of samples, discarding the ones
+// retained; the window is re-baselined.
+func (w *counterWindow) resize(samples int) {
+	if samples < 1 {
+		samples = 1 // we need at least one sample to compare against
+	}
+	w.ring.Discard()
+	w.ring.Resize(samples)
+	w.increase, w.elapsed, w.full = 0, 0, false
+}
+
+// reset discards the samples retained, re-baselining the window.
+func (w *counterWindow) reset() {
+	w.resize(w.ring.Cap())
+}
+
+// record the given cumulative sample, taken at the given time. It returns true
+// if a full window has been observed, in which case increase and elapsed are
+// updated to reflect it.
+func (w *counterWindow) record(cumulative float64, at time.Time) bool {
+	var oldest timedCounter
+	var ok bool
+	if w.ring.Len() == w.ring.Cap() { // no more room, clear out the oldest
+		oldest, ok = w.ring.GetLast(), true
+		w.ring.RemoveLast()
+	}
+	w.ring.AddFirst(timedCounter{v: cumulative, at: at})
+	if !ok {
+		return false
+	}
+	w.increase = subCounter(cumulative, oldest.v)
+	w.elapsed = at.Sub(oldest.at)
+	w.full = true
+	return true
+}
+
+// maxPooledHistograms bounds the number of histograms a histogramPool retains.
+// In steady state, a window releases a histogram every time it retains one, so
+// few are ever needed.
+const maxPooledHistograms = 4
+
+// histogramPool recycles the copies of cumulative histograms retained by the
+// sampler, as they're evicted, such that retaining a sample every tick doesn't
+// allocate. A nil pool is valid, and doesn't recycle. It's not safe for
+// concurrent use.
+type histogramPool struct {
+	free []*metrics.Float64Histogram
+}
+
+// clone returns a copy of the given histogram, reusing a released one if its
+// layout matches.
+func (p *histogramPool) clone(h *metrics.Float64Histogram) *metrics.Float64Histogram {
+	if p == nil || len(p.free) == 0 {
+		return clone(h)
+	}
+	res := p.free[len(p.free)-1]
+	p.free[len(p.free)-1] = nil
+	p.free = p.free[:len(p.free)-1]
+	if len(res.Counts) != len(h.Counts) || len(res.Buckets) != len(h.Buckets) {
+		return clone(h) // the layout changed; let the stale one be collected
+	}
+	copy(res.Counts, h.Counts)
+	copy(res.Buckets, h.Buckets)
+	return res
+}
+
+// release returns the given histogram to the pool. It must have been returned
+// by clone, and no longer be referenced.
+func (p *histogramPool) release(h *metrics.Float64Histogram) {
+	if p == nil || h == nil || len(p.free) >= maxPooledHistograms {
+		return
+	}
+	p.free = append(p.free, h)
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import "time"
+
+// WindowConfig is the configuration of the window a sample was computed over.
+type WindowConfig struct {
+	// Period is the sample period in effect; see Sample.Period.
+	Period time.Duration
+	// Duration is the nominal duration of the window: the sample duration in
+	// effect (scheduler_latency.sample_duration, as clamped against the
+	// period), or that of the listener's window if it requested its own.
+	Duration time.Duration
+	// Samples is the number of sample periods the window spans once full (one
+	// for final samples); provisional windows span fewer.
+	Samples int
+}
+
+// config returns the configuration of the window, at the given period.
+func (w window) config(period time.Duration) WindowConfig {
+	return WindowConfig{Period: period, Duration: w.duration, Samples: w.samples}
+}
+
+// markReconfiguredLocked is called as the sample period or duration in effect
+// change, the window having been discarded. Windows are flagged as
+// reconfigured (see Sample.Reconfigured) until a full one of the new
+// configuration is computed, as is the snapshot served by Latest, still computed
+// under the previous configuration. It's a no-op for the initial configuration.
+func (s *sampler) markReconfiguredLocked(previousPeriod time.Duration) {
+	if previousPeriod == 0 {
+		return // we're yet to be configured
+	}
+	s.mu.reconfigured = true
+	if s.standalone {
+		return
+	}
+	if snap := latest.Load(); snap != nil && !snap.Degraded {
+		marked := *snap
+		marked.Reconfigured = true
+		latest.Store(&marked)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSampleReconfigured verifies that samples are tagged with the
+// configuration of their window, and flagged as reconfigured from a change in
+// the sample period or duration until a full window of the new configuration
+// is delivered, as the settings change mid-stream; so is the snapshot served
+// by Latest, until it's replaced.
+func TestSampleReconfigured(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSampler(t, samplePeriod.Default(), time.Second, busySample())
+	st, clock := s.st, s.clock
+	sampleDuration.Override(ctx, &st.SV, time.Second)
+	getPeriod := s.watchSettings(ctx, st, func(time.Duration) {}, nil /* active */)
+	var own, longer sampleListener
+	s.addListener(&own)
+	s.addListener(WithWindowDuration(&longer, 2*time.Second))
+
+	// delivery is what's checked of every sample delivered.
+	type delivery struct {
+		provisional, reconfigured bool
+		window                    WindowConfig
+	}
+	// deliveries returns the samples delivered to the given listener since
+	// the given number of provisional and full ones were.
+	deliveries := func(l *sampleListener, provisional, samples int) (ds []delivery) {
+		// Provisional samples precede full ones.
+		for _, sample := range append(l.provisional[provisional:], l.samples[samples:]...) {
+			ds = append(ds, delivery{sample.Provisional, sample.Reconfigured, sample.Window})
+		}
+		return ds
+	}
+	// tick ticks n times, returning the samples delivered to both listeners.
+	tick := func(n int) (ownDeliveries, longerDeliveries []delivery) {
+		ownProvisional, ownSamples := len(own.provisional), len(own.samples)
+		longerProvisional, longerSamples := len(longer.provisional), len(longer.samples)
+		for i := 0; i < n; i++ {
+			clock.Advance(getPeriod())
+			s.sampleOnTickAndInvokeCallbacks(ctx, getPeriod())
+		}
+		return deliveries(&own, ownProvisional, ownSamples),
+			deliveries(&longer, longerProvisional, longerSamples)
+	}
+	// warmup returns the deliveries expected while a window of the given
+	// configuration fills up, and once full.
+	warmup := func(window WindowConfig, reconfigured bool, full int) []delivery {
+		var ds []delivery
+		for i := 1; i < window.Samples; i++ {
+			ds = append(ds, delivery{provisional: true, reconfigured: reconfigured, window: window})
+		}
+		for i := 0; i < full; i++ {
+			ds = append(ds, delivery{window: window})
+		}
+		return ds
+	}
+	requireLatest := func(reconfigured bool, window WindowConfig) {
+		t.Helper()
+		snap, ok := Latest()
+		require.True(t, ok)
+		require.Equal(t, reconfigured, snap.Reconfigured)
+		require.Equal(t, window, snap.Window)
+	}
+
+	// Starting isn't reconfiguring: the provisional samples delivered while
+	// the window fills up aren't flagged.
+	initial := WindowConfig{Period: 100 * time.Millisecond, Duration: time.Second, Samples: 10}
+	ownDeliveries, _ := tick(11)
+	require.Equal(t, warmup(initial, false /* reconfigured */, 1), ownDeliveries)
+	requireLatest(false, initial)
+
+	// Lengthening the duration discards the window: the snapshot is flagged
+	// as computed under the previous configuration until replaced, and so are
+	// the provisional samples delivered meanwhile.
+	sampleDuration.Override(ctx, &st.SV, 2*time.Second)
+	requireLatest(true, initial)
+	longerDuration := WindowConfig{Period: 100 * time.Millisecond, Duration: 2 * time.Second, Samples: 20}
+	ownDeliveries, _ = tick(20)
+	require.Equal(t, warmup(longerDuration, true /* reconfigured */, 0), ownDeliveries)
+	requireLatest(true, initial)
+	ownDeliveries, _ = tick(2)
+	require.Equal(t, []delivery{{window: longerDuration}, {window: longerDuration}}, ownDeliveries)
+	requireLatest(false, longerDuration)
+
+	// So does changing the period.
+	samplePeriod.Override(ctx, &st.SV, 500*time.Millisecond)
+	requireLatest(true, longerDuration)
+	longerPeriod := WindowConfig{Period: 500 * time.Millisecond, Duration: 2 * time.Second, Samples: 4}
+	ownDeliveries, longerDeliveries := tick(5)
+	require.Equal(t, warmup(longerPeriod, true /* reconfigured */, 1), ownDeliveries)
+	require.Equal(t, warmup(longerPeriod, true /* reconfigured */, 1), longerDeliveries)
+	requireLatest(false, longerPeriod)
+
+	// Shortening the duration: the listener with a window of its own, now
+	// longer than the sampler's, is delivered flagged samples for longer.
+	sampleDuration.Override(ctx, &st.SV, time.Second)
+	shorterDuration := WindowConfig{Period: 500 * time.Millisecond, Duration: time.Second, Samples: 2}
+	ownDeliveries, longerDeliveries = tick(5)
+	require.Equal(t, warmup(shorterDuration, true /* reconfigured */, 3), ownDeliveries)
+	// Its window's configuration is unchanged; it was discarded nonetheless.
+	require.Equal(t, warmup(longerPeriod, true /* reconfigured */, 1), longerDeliveries)
+	requireLatest(false, shorterDuration)
+
+	// Re-baselining for other reasons isn't reconfiguring.
+	clock.Advance(time.Minute)
+	ownDeliveries, _ = tick(3)
+	require.Equal(t, warmup(shorterDuration, false /* reconfigured */, 1), ownDeliveries)
+	requireLatest(false, shorterDuration)
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package schedulerlatency
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramWindow(t *testing.T) {
+	// Buckets: [0, 1ms), [1ms, 2ms), [2ms, +Inf).
+	cumulative := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 0},
+		Buckets: []float64{0, 0.001, 0.002, math.Inf(+1)},
+	}
+	now := timeutil.Unix(0, 0)
+	record := func(w *histogramWindow, counts ...uint64) bool {
+		for i := range counts {
+			cumulative.Counts[i] += counts[i]
+		}
+		now = now.Add(time.Second)
+		return w.record(clone(cumulative), now)
+	}
+	ps := []float64{0.5, 0.99}
+
+	w := makeHistogramWindow("/test/histogram:seconds", 2)
+	require.Equal(t, "/test/histogram:seconds", w.name)
+	_, ok := w.percentiles(ps)
+	require.False(t, ok)
+
+	require.False(t, record(&w, 100, 0, 0))
+	require.False(t, record(&w, 100, 0, 0)) // we need a full window
+	require.True(t, record(&w, 0, 100, 0))
+	res, ok := w.percentiles(ps)
+	require.True(t, ok)
+	// The window spans the last two samples: 100 in each of the first two
+	// buckets.
+	require.Equal(t, []uint64{100, 100, 0}, w.interval.Counts)
+	require.Equal(t, 2*time.Second, w.elapsed)
+	require.Equal(t, []time.Duration{time.Millisecond, 1980 * time.Microsecond}, res)
+
+	// The window slides: the oldest sample ages out.
+	require.True(t, record(&w, 0, 100, 0))
+	require.Equal(t, []uint64{0, 200, 0}, w.interval.Counts)
+	res, ok = w.percentiles(ps)
+	require.True(t, ok)
+	require.Equal(t, []time.Duration{1500 * time.Microsecond, 1990 * time.Microsecond}, res)
+
+	// Resizing or resetting re-baselines the window.
+	w.resize(1)
+	_, ok = w.percentiles(ps)
+	require.False(t, ok)
+	require.False(t, record(&w, 0, 0, 100))
+	require.True(t, record(&w, 0, 0, 100))
+	require.Equal(t, []uint64{0, 0, 100}, w.interval.Counts)
+	require.Equal(t, time.Second, w.elapsed)
+	// Empty windows have zero percentiles.
+	require.True(t, record(&w, 0, 0, 0))
+	res, ok = w.percentiles(ps)
+	require.True(t, ok)
+	require.Equal(t, []time.Duration{0, 0}, res)
+	w.reset()
+	require.Equal(t, 1, w.ring.Cap())
+	_, ok = w.percentiles(ps)
+	require.False(t, ok)
+	require.False(t, record(&w, 0, 0, 100))
+}
+
+// TestHistogramWindowRetainsCopies verifies that the window retains copies of
+// the samples recorded, unaffected by mutations of the originals, and that the
+// copies are recycled through the pool as they're evicted.
+func TestHistogramWindowRetainsCopies(t *testing.T) {
+	var pool histogramPool
+	w := makeHistogramWindow("/test/histogram:seconds", 1)
+	w.pool = &pool
+	cumulative := &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}
+	now := timeutil.Unix(0, 0)
+
+	cumulative.Counts[0] = 10
+	require.False(t, w.record(cumulative, now))
+	retained := w.ring.GetFirst().h
+	require.NotSame(t, cumulative, retained)
+	cumulative.Counts[0], cumulative.Buckets[1] = 0, 2 // scribble over the original
+	require.Equal(t, []uint64{10}, retained.Counts)
+	require.Equal(t, []float64{0, 1}, retained.Buckets)
+
+	cumulative.Counts[0], cumulative.Buckets[1] = 30, 1
+	require.True(t, w.record(cumulative, now.Add(time.Second)))
+	require.Equal(t, []uint64{20}, w.interval.Counts)
+	// The evicted copy was released, and is reused for the next sample.
+	require.Equal(t, []*metrics.Float64Histogram{retained}, pool.free)
+	cumulative.Counts[0] = 60
+	require.True(t, w.record(cumulative, now.Add(2*time.Second)))
+	require.Same(t, retained, w.ring.GetFirst().h)
+	require.Equal(t, []uint64{30}, w.interval.Counts)
+}
+
+func TestHistogramPool(t *testing.T) {
+	h := &metrics.Float64Histogram{Counts: []uint64{1, 2}, Buckets: []float64{0, 1, 2}}
+
+	// A nil pool clones, and discards what's released.
+	var nilPool *histogramPool
+	cloned := nilPool.clone(h)
+	require.NotSame(t, h, cloned)
+	require.Equal(t, h, cloned)
+	nilPool.release(cloned)
+
+	var pool histogramPool
+	released := pool.clone(h)
+	pool.release(released)
+	h.Counts[0] = 3
+	reused := pool.clone(h)
+	require.Same(t, released, reused)
+	require.Equal(t, h, reused)
+	require.Empty(t, pool.free)
+
+	// Histograms with a different layout aren't reused.
+	pool.release(&metrics.Float64Histogram{Counts: []uint64{1}, Buckets: []float64{0, 1}})
+	cloned = pool.clone(h)
+	require.Equal(t, h, cloned)
+	require.Empty(t, pool.free)
+
+	// The pool is bounded.
+	for i := 0; i < 2*maxPooledHistograms; i++ {
+		pool.release(clone(h))
+	}
+	require.Len(t, pool.free, maxPooledHistograms)
+}
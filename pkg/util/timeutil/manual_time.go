@@ -264,7 +264,13 @@ type manualTicker struct {
 
 // Reset is part of the TickerI interface.
 func (t *manualTicker) Reset(duration time.Duration) {
-	panic("not implemented")
+	if duration <= 0 {
+		panic("non-positive interval for Reset")
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	t.duration = duration
+	t.nextTick = t.m.mu.now.Add(duration)
 }
 
 // Stop is part of the TickerI interface.
@@ -165,4 +165,25 @@ func TestManualTime(t *testing.T) {
 		ensureNoSend(t, t1.Ch())
 		ensureNoSend(t, t2.Ch())
 	})
+
+	t.Run("Ticker reset", func(t *testing.T) {
+		mt := timeutil.NewManualTime(t0)
+		advanceTo := func(d time.Duration) {
+			mt.AdvanceTo(t0.Add(d))
+		}
+		t1 := mt.NewTicker(1 * time.Second)
+
+		advanceTo(1500 * time.Millisecond)
+		ensureSend(t, t1.Ch(), 1*time.Second)
+
+		// The next tick is relative to when the ticker is reset.
+		t1.Reset(5 * time.Second)
+		advanceTo(6 * time.Second)
+		ensureNoSend(t, t1.Ch())
+		advanceTo(6500 * time.Millisecond)
+		ensureSend(t, t1.Ch(), 6500*time.Millisecond)
+		advanceTo(11500 * time.Millisecond)
+		ensureSend(t, t1.Ch(), 11500*time.Millisecond)
+		ensureNoSend(t, t1.Ch())
+	})
 }